@@ -19,6 +19,9 @@ import (
 	"github.com/yankeguo/bunker/utils"
 )
 
+// auditActorAuto actor of audit events from consul sync
+const auditActorAuto = "consul"
+
 // Auto auto server registry
 type Auto struct {
 	Config    types.Config
@@ -73,14 +76,24 @@ func (a *Auto) update() {
 		return
 	}
 	a.lastIndex = qm.LastIndex
-	// update database
+	// update database, pinned host keys are left untouched and verified on connect
 	for _, n := range ns {
-		a.db.Assign(map[string]interface{}{
+		before, s := models.Server{}, models.Server{}
+		a.db.First(&before, "name = ?", n.Node)
+		if a.db.Assign(map[string]interface{}{
 			"address": fmt.Sprintf("%s:22", n.Address),
+			"labels":  utils.FormatLabels(n.Meta),
 			"is_auto": utils.True,
-		}).FirstOrCreate(&models.Server{}, map[string]interface{}{
+		}).FirstOrCreate(&s, map[string]interface{}{
 			"name": n.Node,
-		})
+		}).Error != nil {
+			continue
+		}
+		if before.ID == 0 {
+			a.db.Audit(models.AuditActorSystem, auditActorAuto, "", "server.create", "server:"+s.Name, nil, s)
+		} else {
+			a.db.Audit(models.AuditActorSystem, auditActorAuto, "", "server.update", "server:"+s.Name, before, s)
+		}
 	}
 	// delete missing
 	ss := []models.Server{}
@@ -95,7 +108,9 @@ L1:
 				continue L1
 			}
 		}
-		a.db.Delete(&models.Server{}, "name = ?", s.Name)
+		if a.db.Delete(&s).Error == nil {
+			a.db.Audit(models.AuditActorSystem, auditActorAuto, "", "server.destroy", "server:"+s.Name, s, nil)
+		}
 	}
 }
 
@@ -9,9 +9,14 @@
 package bunker
 
 import (
+	"fmt"
+	"log"
+	"os"
 	"strings"
 
+	"github.com/yankeguo/bunker/events"
 	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/sandbox"
 	"github.com/yankeguo/bunker/types"
 	"github.com/yankeguo/bunker/utils"
 	"golang.org/x/crypto/ssh"
@@ -22,11 +27,19 @@ const VERSION = "1.0.0"
 
 // Bunker the bunker server
 type Bunker struct {
-	Config types.Config
-	http   *HTTP
-	sshd   *SSHD
-	auto   *Auto
-	db     *models.DB
+	Config         types.Config
+	http           *HTTP
+	sshd           *SSHD
+	auto           *Auto
+	reaper         *Reaper
+	ldapSync       *LDAPSync
+	indexer        *Indexer
+	db             *models.DB
+	sandboxManager sandbox.Manager
+	sandboxTracker *sandbox.Tracker
+	liveHub        *utils.LiveHub
+	sshLimiter     *utils.Limiter
+	events         *events.Emitter
 }
 
 // NewBunker create a new bunker instance
@@ -40,9 +53,25 @@ func (b *Bunker) ensureDB() (err error) {
 			return
 		}
 	}
+	// audit events are exported through the shared *models.DB
+	if b.events == nil {
+		if b.events, err = events.NewEmitter(b.Config.Events); err != nil {
+			return
+		}
+		b.db.Events = b.events
+	}
 	return
 }
 
+// flushEvents deliver buffered events before a command line action returns, emitter is recreated on next use
+func (b *Bunker) flushEvents() {
+	if b.events != nil {
+		b.events.Close()
+		b.events = nil
+		b.db.Events = nil
+	}
+}
+
 // ListenAndServe run the server
 func (b *Bunker) ListenAndServe() (err error) {
 	if b.http == nil {
@@ -54,14 +83,56 @@ func (b *Bunker) ListenAndServe() (err error) {
 	if b.auto == nil {
 		b.auto = NewAuto(b.Config)
 	}
+	if b.reaper == nil {
+		b.reaper = NewReaper(b.Config)
+	}
+	if b.ldapSync == nil {
+		b.ldapSync = NewLDAPSync(b.Config)
+	}
+	if b.indexer == nil {
+		b.indexer = NewIndexer(b.Config)
+	}
 	if err = b.ensureDB(); err != nil {
 		return
 	}
+	if b.sandboxManager == nil {
+		if b.sandboxManager, err = sandbox.NewManager(b.Config); err != nil {
+			return
+		}
+	}
+	if b.sandboxTracker == nil {
+		b.sandboxTracker = sandbox.NewTracker()
+	}
+	if b.liveHub == nil {
+		b.liveHub = utils.NewLiveHub()
+	}
+	if b.sshLimiter == nil {
+		b.sshLimiter = utils.NewLimiter(b.Config.SSHD.BanThreshold(), b.Config.SSHD.BanWindow(), b.Config.SSHD.BanTime())
+	}
 	// share the same *models.DB
 	b.http.db = b.db
 	b.sshd.db = b.db
 	b.auto.db = b.db
-	return utils.RunServers(b.http, b.sshd, b.auto)
+	b.ldapSync.db = b.db
+	b.indexer.db = b.db
+	// share the same sandbox.Manager and sandbox.Tracker
+	b.http.sandboxManager = b.sandboxManager
+	b.http.sandboxTracker = b.sandboxTracker
+	b.sshd.sandboxManager = b.sandboxManager
+	b.sshd.sandboxTracker = b.sandboxTracker
+	b.reaper.sandboxManager = b.sandboxManager
+	b.reaper.sandboxTracker = b.sandboxTracker
+	b.ldapSync.sandboxManager = b.sandboxManager
+	// share the same utils.LiveHub
+	b.http.liveHub = b.liveHub
+	b.sshd.liveHub = b.liveHub
+	// share the same utils.Limiter of sshd
+	b.http.sshLimiter = b.sshLimiter
+	b.sshd.limiter = b.sshLimiter
+	// share the same events.Emitter
+	b.http.events = b.events
+	b.sshd.events = b.events
+	return utils.RunServers(b.http, b.sshd, b.auto, b.reaper, b.ldapSync, b.indexer)
 }
 
 // Migrate the database
@@ -85,6 +156,7 @@ func (b *Bunker) CreateUser(option CreateUserOption) (err error) {
 	if err = b.ensureDB(); err != nil {
 		return
 	}
+	defer b.flushEvents()
 	// create user
 	u := &models.User{
 		Account: option.Account,
@@ -96,6 +168,7 @@ func (b *Bunker) CreateUser(option CreateUserOption) (err error) {
 	if err = b.db.Create(u).Error; err != nil {
 		return
 	}
+	b.audit("user.create", "user:"+u.Account, nil, u)
 	// create public key
 	if len(option.PublicKey) > 0 {
 		var p ssh.PublicKey
@@ -110,6 +183,7 @@ func (b *Bunker) CreateUser(option CreateUserOption) (err error) {
 		if err = b.db.Create(k).Error; err != nil {
 			return
 		}
+		b.audit("key.create", fmt.Sprintf("key:%d", k.ID), nil, k)
 	}
 	return
 }
@@ -125,13 +199,17 @@ func (b *Bunker) ChangePassword(option ChangePasswordOption) (err error) {
 	if err = b.ensureDB(); err != nil {
 		return
 	}
+	defer b.flushEvents()
 	u := &models.User{}
 	if err = u.SetPassword(option.Password); err != nil {
 		return
 	}
-	err = b.db.Model(models.User{}).Where("account = ?", option.Account).Update(map[string]interface{}{
+	if err = b.db.Model(models.User{}).Where("account = ?", option.Account).Update(map[string]interface{}{
 		"password_digest": u.PasswordDigest,
-	}).Error
+	}).Error; err != nil {
+		return
+	}
+	b.audit("user.change-password", "user:"+option.Account, nil, nil)
 	return
 }
 
@@ -139,6 +217,7 @@ func (b *Bunker) ChangePassword(option ChangePasswordOption) (err error) {
 type CreateServerOption struct {
 	Name    string
 	Address string
+	Labels  string // optional labels, "k1=v1,k2=v2"
 }
 
 // CreateServer create a server
@@ -146,17 +225,72 @@ func (b *Bunker) CreateServer(option CreateServerOption) (err error) {
 	if err = b.ensureDB(); err != nil {
 		return
 	}
+	defer b.flushEvents()
+	var ls map[string]string
+	if ls, err = utils.ParseLabels(option.Labels); err != nil {
+		return
+	}
+	before, s := models.Server{}, models.Server{}
+	b.db.First(&before, "name = ?", option.Name)
 	if err = b.db.Assign(map[string]interface{}{
 		"address": option.Address,
-	}).FirstOrCreate(&models.Server{}, map[string]interface{}{
+		"labels":  utils.FormatLabels(ls),
+	}).FirstOrCreate(&s, map[string]interface{}{
 		"name": option.Name,
 	}).Error; err != nil {
 		return
 	}
+	if before.ID == 0 {
+		b.audit("server.create", "server:"+s.Name, nil, s)
+	} else {
+		b.audit("server.update", "server:"+s.Name, before, s)
+	}
 	return
 }
 
+// ListPendingHostKeys list servers with changed host key waiting for approval
+func (b *Bunker) ListPendingHostKeys() (ss []models.Server, err error) {
+	if err = b.ensureDB(); err != nil {
+		return
+	}
+	ss = []models.Server{}
+	err = b.db.Order("name ASC").Find(&ss, "pending_host_key_fingerprint != ?", "").Error
+	return
+}
+
+// AcceptHostKeyOption option to accept a changed host key
+type AcceptHostKeyOption struct {
+	Name string
+}
+
+// AcceptHostKey accept the pending host key of a server
+func (b *Bunker) AcceptHostKey(option AcceptHostKeyOption) (err error) {
+	if err = b.ensureDB(); err != nil {
+		return
+	}
+	defer b.flushEvents()
+	s := models.Server{}
+	if err = b.db.First(&s, "name = ?", option.Name).Error; err != nil {
+		return
+	}
+	before := s
+	if err = b.db.AcceptServerHostKey(&s); err != nil {
+		return
+	}
+	b.audit("server.accept-host-key", "server:"+s.Name, before, s)
+	return
+}
+
+// audit record a audit event of command line
+func (b *Bunker) audit(action string, target string, before interface{}, after interface{}) {
+	if err := b.db.Audit(models.AuditActorCLI, os.Getenv("USER"), "", action, target, before, after); err != nil {
+		log.Println("Audit:", err)
+	}
+}
+
 // Shutdown the internal servers
 func (b *Bunker) Shutdown() (err error) {
-	return utils.ShutdownServers(b.http, b.sshd)
+	err = utils.ShutdownServers(b.http, b.sshd, b.reaper, b.ldapSync, b.indexer)
+	b.events.Close()
+	return
 }
@@ -9,11 +9,13 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 
 	"github.com/yankeguo/bunker"
+	"github.com/yankeguo/bunker/models"
 	"github.com/yankeguo/bunker/types"
 	"github.com/yankeguo/bunker/utils"
 	"landzero.net/x/flag/cli"
@@ -109,6 +111,10 @@ var createServerCommand = cli.Command{
 			Name:  "address",
 			Usage: "IP:PORT of server",
 		},
+		cli.StringFlag{
+			Name:  "labels",
+			Usage: "labels of server, k1=v1,k2=v2",
+		},
 	},
 	Action: func(ctx *cli.Context) (err error) {
 		var b *bunker.Bunker
@@ -118,6 +124,46 @@ var createServerCommand = cli.Command{
 		return b.CreateServer(bunker.CreateServerOption{
 			Name:    ctx.String("name"),
 			Address: ctx.String("address"),
+			Labels:  ctx.String("labels"),
+		})
+	},
+}
+
+var pendingHostKeysCommand = cli.Command{
+	Name:  "pending-host-keys",
+	Usage: "list servers with changed host key",
+	Action: func(ctx *cli.Context) (err error) {
+		var b *bunker.Bunker
+		if b, err = createBunker(ctx); err != nil {
+			return
+		}
+		var ss []models.Server
+		if ss, err = b.ListPendingHostKeys(); err != nil {
+			return
+		}
+		for _, s := range ss {
+			fmt.Printf("%s\t%s\tpinned: %s\tpending: %s\n", s.Name, s.Address, s.HostKeyFingerprint, s.PendingHostKeyFingerprint)
+		}
+		return
+	},
+}
+
+var acceptHostKeyCommand = cli.Command{
+	Name:  "accept-host-key",
+	Usage: "accept the changed host key of a server",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "name",
+			Usage: "name of server",
+		},
+	},
+	Action: func(ctx *cli.Context) (err error) {
+		var b *bunker.Bunker
+		if b, err = createBunker(ctx); err != nil {
+			return
+		}
+		return b.AcceptHostKey(bunker.AcceptHostKeyOption{
+			Name: ctx.String("name"),
 		})
 	},
 }
@@ -161,6 +207,8 @@ func main() {
 		createUserCommand,
 		createServerCommand,
 		changePasswordCommand,
+		pendingHostKeysCommand,
+		acceptHostKeyCommand,
 	}
 	err := app.Run(os.Args)
 	if err != nil {
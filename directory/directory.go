@@ -0,0 +1,88 @@
+/**
+ * directory/directory.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package directory
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/yankeguo/bunker/types"
+)
+
+// Entry user entry in directory
+type Entry struct {
+	DN      string   // distinguished name
+	Account string   // account name, lower cased
+	Groups  []string // dns of groups the user belongs to
+}
+
+// Membership bunker status of a directory entry
+type Membership struct {
+	IsAdmin *bool    // member of any admin group, nil if no admin group configured
+	Groups  []string // bunker group names, sorted
+}
+
+// normalizeDN dns are compared case insensitively, spaces after separators are ignored
+func normalizeDN(dn string) string {
+	ps := strings.Split(dn, ",")
+	for i, p := range ps {
+		ps[i] = strings.ToLower(strings.TrimSpace(p))
+	}
+	return strings.Join(ps, ",")
+}
+
+// Resolve map directory groups of entry to admin status and bunker groups
+func Resolve(cfg types.LDAPConfig, e Entry) (m Membership) {
+	gs := map[string]bool{}
+	for _, g := range e.Groups {
+		gs[normalizeDN(g)] = true
+	}
+	if len(cfg.AdminGroups) > 0 {
+		isAdmin := false
+		for _, g := range cfg.AdminGroups {
+			if gs[normalizeDN(g)] {
+				isAdmin = true
+			}
+		}
+		m.IsAdmin = &isAdmin
+	}
+	m.Groups = []string{}
+	for dn, name := range cfg.Groups {
+		if gs[normalizeDN(dn)] {
+			m.Groups = append(m.Groups, name)
+		}
+	}
+	sort.Strings(m.Groups)
+	return
+}
+
+// ManagedGroups bunker groups whose membership is managed by directory, sorted
+func ManagedGroups(cfg types.LDAPConfig) []string {
+	ns := []string{}
+	for _, name := range cfg.Groups {
+		ns = append(ns, name)
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+// Missing accounts of local directory users that no longer exist in directory
+func Missing(local []string, es []Entry) []string {
+	rs := map[string]bool{}
+	for _, e := range es {
+		rs[e.Account] = true
+	}
+	ms := []string{}
+	for _, a := range local {
+		if !rs[a] {
+			ms = append(ms, a)
+		}
+	}
+	return ms
+}
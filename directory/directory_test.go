@@ -0,0 +1,55 @@
+/**
+ *Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ *This software is released under the MIT License.
+ *https://opensource.org/licenses/MIT
+ */
+
+package directory
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yankeguo/bunker/types"
+)
+
+func TestResolve(t *testing.T) {
+	cfg := types.LDAPConfig{
+		AdminGroups: []string{"cn=admins,ou=groups,dc=example,dc=com"},
+		Groups: map[string]string{
+			"cn=ops,ou=groups,dc=example,dc=com": "ops",
+			"cn=dev,ou=groups,dc=example,dc=com": "dev",
+			"cn=qa,ou=groups,dc=example,dc=com":  "qa",
+		},
+	}
+	m := Resolve(cfg, Entry{Account: "alice", Groups: []string{
+		"CN=Admins, OU=Groups, DC=example, DC=com",
+		"cn=ops,ou=groups,dc=example,dc=com",
+		"cn=dev,ou=groups,dc=example,dc=com",
+	}})
+	if m.IsAdmin == nil || !*m.IsAdmin {
+		t.Error("should be admin", m)
+	}
+	if !reflect.DeepEqual(m.Groups, []string{"dev", "ops"}) {
+		t.Error("unexpected groups", m.Groups)
+	}
+	m = Resolve(cfg, Entry{Account: "bob"})
+	if m.IsAdmin == nil || *m.IsAdmin || len(m.Groups) != 0 {
+		t.Error("should not be admin or in any group", m)
+	}
+	cfg.AdminGroups = nil
+	if m = Resolve(cfg, Entry{Account: "bob"}); m.IsAdmin != nil {
+		t.Error("admin status should not be managed", m)
+	}
+	if !reflect.DeepEqual(ManagedGroups(cfg), []string{"dev", "ops", "qa"}) {
+		t.Error("unexpected managed groups", ManagedGroups(cfg))
+	}
+}
+
+func TestMissing(t *testing.T) {
+	ms := Missing([]string{"alice", "bob", "carol"}, []Entry{{Account: "alice"}, {Account: "carol"}, {Account: "dave"}})
+	if !reflect.DeepEqual(ms, []string{"bob"}) {
+		t.Error("unexpected missing", ms)
+	}
+}
@@ -0,0 +1,160 @@
+/**
+ * directory/ldap.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package directory
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/yankeguo/bunker/types"
+)
+
+var (
+	// ErrInvalidCredentials account not found in directory or wrong password
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// LDAPPageSize page size of user listing, active directory limits results to 1000 by default
+const LDAPPageSize = 500
+
+// LDAP ldap / active directory client
+type LDAP struct {
+	Config types.LDAPConfig
+}
+
+// NewLDAP create a ldap client
+func NewLDAP(cfg types.LDAPConfig) *LDAP {
+	return &LDAP{Config: cfg}
+}
+
+func (l *LDAP) userFilter() string {
+	if len(l.Config.UserFilter) > 0 {
+		return l.Config.UserFilter
+	}
+	return "(objectClass=person)"
+}
+
+func (l *LDAP) accountAttr() string {
+	if len(l.Config.AccountAttr) > 0 {
+		return l.Config.AccountAttr
+	}
+	return "uid"
+}
+
+func (l *LDAP) groupAttr() string {
+	if len(l.Config.GroupAttr) > 0 {
+		return l.Config.GroupAttr
+	}
+	return "memberOf"
+}
+
+// dial connect and bind as service account
+func (l *LDAP) dial() (c *ldap.Conn, err error) {
+	var u *url.URL
+	if u, err = url.Parse(l.Config.URL); err != nil {
+		return
+	}
+	tc := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: l.Config.SkipVerify}
+	if c, err = ldap.DialURL(l.Config.URL, ldap.DialWithTLSConfig(tc)); err != nil {
+		return
+	}
+	c.SetTimeout(time.Second * 10)
+	if l.Config.StartTLS && u.Scheme == "ldap" {
+		if err = c.StartTLS(tc); err != nil {
+			c.Close()
+			return
+		}
+	}
+	if len(l.Config.BindDN) > 0 {
+		if err = c.Bind(l.Config.BindDN, l.Config.BindPassword); err != nil {
+			c.Close()
+			return
+		}
+	}
+	return
+}
+
+func (l *LDAP) search(c *ldap.Conn, filter string) ([]*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		l.Config.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		filter,
+		[]string{"dn", l.accountAttr(), l.groupAttr()},
+		nil,
+	)
+	res, err := c.SearchWithPaging(req, LDAPPageSize)
+	if err != nil {
+		return nil, err
+	}
+	return res.Entries, nil
+}
+
+func (l *LDAP) toEntry(e *ldap.Entry) Entry {
+	return Entry{
+		DN:      e.DN,
+		Account: strings.ToLower(e.GetAttributeValue(l.accountAttr())),
+		Groups:  e.GetAttributeValues(l.groupAttr()),
+	}
+}
+
+// Authenticate find active user by account and verify password by binding as the user
+func (l *LDAP) Authenticate(account, password string) (e Entry, err error) {
+	// empty password is an unauthenticated bind, which always succeeds
+	if len(account) == 0 || len(password) == 0 {
+		err = ErrInvalidCredentials
+		return
+	}
+	var c *ldap.Conn
+	if c, err = l.dial(); err != nil {
+		return
+	}
+	defer c.Close()
+	var es []*ldap.Entry
+	filter := fmt.Sprintf("(&%s(%s=%s))", l.userFilter(), l.accountAttr(), ldap.EscapeFilter(account))
+	if es, err = l.search(c, filter); err != nil {
+		return
+	}
+	if len(es) != 1 {
+		err = ErrInvalidCredentials
+		return
+	}
+	if err = c.Bind(es[0].DN, password); err != nil {
+		err = ErrInvalidCredentials
+		return
+	}
+	e = l.toEntry(es[0])
+	return
+}
+
+// List list all active users in directory
+func (l *LDAP) List() (out []Entry, err error) {
+	var c *ldap.Conn
+	if c, err = l.dial(); err != nil {
+		return
+	}
+	defer c.Close()
+	var es []*ldap.Entry
+	if es, err = l.search(c, l.userFilter()); err != nil {
+		return
+	}
+	out = make([]Entry, 0, len(es))
+	for _, e := range es {
+		if ee := l.toEntry(e); len(ee.Account) > 0 {
+			out = append(out, ee)
+		}
+	}
+	return
+}
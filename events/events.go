@@ -0,0 +1,233 @@
+/**
+ * events/events.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package events
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yankeguo/bunker/types"
+)
+
+const (
+	// TypeLogin user signed in to web or connected to sshd
+	TypeLogin = "login"
+	// TypeAuthFailure authentication failed on web or sshd
+	TypeAuthFailure = "auth-failure"
+	// TypeSessionStart ssh, sandbox or tunnel session started
+	TypeSessionStart = "session-start"
+	// TypeSessionEnd ssh, sandbox or tunnel session ended
+	TypeSessionEnd = "session-end"
+	// TypeGrantChange grant created, updated or destroyed
+	TypeGrantChange = "grant-change"
+	// TypeGrantRequest grant request created, cancelled, approved or denied
+	TypeGrantRequest = "grant-request"
+	// TypeAudit other audited administrative action
+	TypeAudit = "audit"
+
+	// maxBatchSize max events delivered to a sink at once
+	maxBatchSize = 100
+	// maxRetryInterval max interval between attempts to deliver
+	maxRetryInterval = time.Second * 30
+	// closeTimeout max time to flush buffered events on close
+	closeTimeout = time.Second * 5
+)
+
+// Event a security event
+type Event struct {
+	Time       time.Time         `json:"time"`
+	Host       string            `json:"host"`                 // hostname of bunker
+	Type       string            `json:"type"`                 // TypeXXX
+	User       string            `json:"user,omitempty"`       // account of user, or login name for failed authentication
+	SourceIP   string            `json:"sourceIp,omitempty"`   // ip of client
+	Server     string            `json:"server,omitempty"`     // name of target server, empty for sandbox
+	TargetUser string            `json:"targetUser,omitempty"` // user on target server
+	SessionID  uint              `json:"sessionId,omitempty"`  // id of session
+	Message    string            `json:"message"`              // human readable description
+	Fields     map[string]string `json:"fields,omitempty"`     // extra fields, e.g. "action", "reason"
+}
+
+// Sink destination of events
+type Sink interface {
+	// Name name of sink for logging
+	Name() string
+	// Send deliver events in order, returns number of events delivered
+	Send(es []Event) (int, error)
+	// Close release resources
+	Close() error
+}
+
+// worker delivers buffered events to a sink
+type worker struct {
+	sink     Sink
+	queue    chan Event
+	stop     chan bool
+	done     chan bool
+	attempts int
+	dropped  uint64
+}
+
+func (w *worker) run() {
+	defer close(w.done)
+	for e := range w.queue {
+		batch := []Event{e}
+	L:
+		for len(batch) < maxBatchSize {
+			select {
+			case e, ok := <-w.queue:
+				if !ok {
+					break L
+				}
+				batch = append(batch, e)
+			default:
+				break L
+			}
+		}
+		w.deliver(batch)
+	}
+	w.sink.Close()
+}
+
+// deliver send batch with retries, undelivered events are dropped after max attempts
+func (w *worker) deliver(batch []Event) {
+	interval := time.Second
+	for i := 1; ; i++ {
+		n, err := w.sink.Send(batch)
+		if batch = batch[n:]; err == nil && len(batch) == 0 {
+			return
+		}
+		if err == nil {
+			err = errors.New("partially delivered")
+		}
+		if i >= w.attempts {
+			log.Printf("Events: %d events dropped by %s sink: %s", len(batch), w.sink.Name(), err.Error())
+			return
+		}
+		select {
+		case <-w.stop:
+			// closing, one more attempt without waiting
+			i = w.attempts - 1
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > maxRetryInterval {
+			interval = maxRetryInterval
+		}
+	}
+}
+
+// Emitter delivers events to sinks in background, a slow sink never blocks the caller
+type Emitter struct {
+	host    string
+	lock    sync.RWMutex
+	closed  bool
+	workers []*worker
+}
+
+// NewEmitter create emitter with sinks enabled in config, sinks connect lazily
+func NewEmitter(cfg types.EventsConfig) (*Emitter, error) {
+	sinks := []Sink{}
+	if cfg.Syslog.Enable {
+		s, err := NewSyslogSink(cfg.Syslog)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.File.Enable {
+		s, err := NewFileSink(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.Webhook.Enable {
+		s, err := NewWebhookSink(cfg.Webhook)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return newEmitter(sinks, cfg.BufferSize(), cfg.MaxAttempts()), nil
+}
+
+func newEmitter(sinks []Sink, buffer int, attempts int) *Emitter {
+	host, _ := os.Hostname()
+	e := &Emitter{host: host}
+	for _, s := range sinks {
+		w := &worker{
+			sink:     s,
+			queue:    make(chan Event, buffer),
+			stop:     make(chan bool),
+			done:     make(chan bool),
+			attempts: attempts,
+		}
+		e.workers = append(e.workers, w)
+		go w.run()
+	}
+	return e
+}
+
+// Emit queue event to all sinks, never blocks, event is dropped for sinks falling behind, nil emitter is a no-op
+func (e *Emitter) Emit(ev Event) {
+	if e == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if len(ev.Host) == 0 {
+		ev.Host = e.host
+	}
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return
+	}
+	for _, w := range e.workers {
+		select {
+		case w.queue <- ev:
+		default:
+			// log the first drop and every 100 drops after
+			if n := atomic.AddUint64(&w.dropped, 1); n%100 == 1 {
+				log.Printf("Events: %s sink is falling behind, %d events dropped", w.sink.Name(), n)
+			}
+		}
+	}
+}
+
+// Close flush buffered events and close all sinks, waits at most 5 seconds
+func (e *Emitter) Close() error {
+	if e == nil {
+		return nil
+	}
+	e.lock.Lock()
+	if e.closed {
+		e.lock.Unlock()
+		return nil
+	}
+	e.closed = true
+	for _, w := range e.workers {
+		close(w.stop)
+		close(w.queue)
+	}
+	e.lock.Unlock()
+	timeout := time.After(closeTimeout)
+	for _, w := range e.workers {
+		select {
+		case <-w.done:
+		case <-timeout:
+			return errors.New("timeout flushing events")
+		}
+	}
+	return nil
+}
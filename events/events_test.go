@@ -0,0 +1,122 @@
+/**
+ *Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ *This software is released under the MIT License.
+ *https://opensource.org/licenses/MIT
+ */
+
+package events
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yankeguo/bunker/types"
+)
+
+func TestFormatRFC5424(t *testing.T) {
+	e := Event{
+		Time:     time.Date(2018, 3, 1, 10, 20, 30, 0, time.UTC),
+		Host:     "bunker host",
+		Type:     TypeAuthFailure,
+		User:     "root@db01",
+		SourceIP: "10.0.0.1",
+		Message:  "authentication failed",
+		Fields:   map[string]string{"reason": `bad "key"]`},
+	}
+	m := FormatRFC5424(e, 4, "bunker", 42)
+	w := `<36>1 2018-03-01T10:20:30.000000Z bunkerhost bunker 42 auth-failure [bunker@32473 reason="bad \"key\"\]" sourceIp="10.0.0.1" type="auth-failure" user="root@db01"] authentication failed`
+	if m != w {
+		t.Errorf("invalid message\n%s\n%s", m, w)
+	}
+}
+
+type testSink struct {
+	lock   sync.Mutex
+	fails  int
+	block  chan bool
+	events []Event
+}
+
+func (s *testSink) Name() string { return "test" }
+
+func (s *testSink) Send(es []Event) (int, error) {
+	if s.block != nil {
+		<-s.block
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.fails > 0 {
+		s.fails--
+		return 0, errors.New("failed")
+	}
+	s.events = append(s.events, es...)
+	return len(es), nil
+}
+
+func (s *testSink) Close() error { return nil }
+
+func TestEmitterRetry(t *testing.T) {
+	s := &testSink{fails: 1}
+	e := newEmitter([]Sink{s}, 10, 3)
+	e.Emit(Event{Type: TypeLogin, User: "alice"})
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.events) != 1 || s.events[0].User != "alice" || s.events[0].Time.IsZero() {
+		t.Error("event not delivered", s.events)
+	}
+	// emit after close is ignored
+	e.Emit(Event{Type: TypeLogin})
+}
+
+func TestEmitterNonBlocking(t *testing.T) {
+	s := &testSink{block: make(chan bool)}
+	e := newEmitter([]Sink{s}, 2, 1)
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 10; i++ {
+			e.Emit(Event{Type: TypeSessionStart})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("emit blocked by slow sink")
+	}
+	close(s.block)
+	e.Close()
+	if len(s.events) == 0 || len(s.events) > 3 {
+		t.Error("unexpected delivered events", len(s.events))
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bunker-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "events.log")
+	s, _ := NewFileSink(types.FileSinkConfig{Path: p})
+	if _, err = s.Send([]Event{{Type: TypeLogin}}); err != nil {
+		t.Fatal(err)
+	}
+	// rotated
+	os.Rename(p, p+".1")
+	if _, err = s.Send([]Event{{Type: TypeSessionEnd}, {Type: TypeSessionEnd}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	buf, _ := ioutil.ReadFile(p)
+	if n := strings.Count(string(buf), "\n"); n != 2 || !strings.Contains(string(buf), `"type":"session-end"`) {
+		t.Error("invalid file content", string(buf))
+	}
+}
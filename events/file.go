@@ -0,0 +1,79 @@
+/**
+ * events/file.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/yankeguo/bunker/types"
+)
+
+// FileSink appends events to a file as json lines
+type FileSink struct {
+	path string
+	file *os.File
+}
+
+// NewFileSink create a file sink
+func NewFileSink(cfg types.FileSinkConfig) (*FileSink, error) {
+	if len(cfg.Path) == 0 {
+		return nil, fmt.Errorf("events file path is required")
+	}
+	return &FileSink{path: cfg.Path}, nil
+}
+
+// Name implements Sink
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+// open open the file, reopen it if moved or removed by log rotation
+func (s *FileSink) open() (err error) {
+	if s.file != nil {
+		fi, err1 := s.file.Stat()
+		pi, err2 := os.Stat(s.path)
+		if err1 == nil && err2 == nil && os.SameFile(fi, pi) {
+			return
+		}
+		s.file.Close()
+		s.file = nil
+	}
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	return
+}
+
+// Send implements Sink
+func (s *FileSink) Send(es []Event) (n int, err error) {
+	if err = s.open(); err != nil {
+		return
+	}
+	for _, e := range es {
+		var buf []byte
+		if buf, err = json.Marshal(e); err != nil {
+			return
+		}
+		if _, err = s.file.Write(append(buf, '\n')); err != nil {
+			s.file.Close()
+			s.file = nil
+			return
+		}
+		n++
+	}
+	return
+}
+
+// Close implements Sink
+func (s *FileSink) Close() error {
+	if s.file != nil {
+		return s.file.Close()
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+/**
+ * events/syslog.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package events
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yankeguo/bunker/types"
+)
+
+const (
+	// syslogSDID structured data id of events, 32473 is the private enterprise number reserved for documentation
+	syslogSDID = "bunker@32473"
+	// syslogTimeout timeout of dialing and writing
+	syslogTimeout = time.Second * 10
+
+	syslogSeverityWarning = 4
+	syslogSeverityNotice  = 5
+	syslogSeverityInfo    = 6
+)
+
+// syslogFacilities facility codes by name
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"authpriv": 10,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// syslogSeverity severity of event type
+func syslogSeverity(typ string) int {
+	switch typ {
+	case TypeAuthFailure:
+		return syslogSeverityWarning
+	case TypeLogin, TypeGrantChange, TypeGrantRequest, TypeAudit:
+		return syslogSeverityNotice
+	}
+	return syslogSeverityInfo
+}
+
+// syslogEscape escape '"', '\' and ']' in structured data param value
+func syslogEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
+
+// syslogHeaderField printable header field without spaces, "-" for empty, truncated to max
+func syslogHeaderField(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= 32 || r >= 127 {
+			return -1
+		}
+		return r
+	}, s)
+	if len(s) == 0 {
+		return "-"
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	return s
+}
+
+// FormatRFC5424 format event as a rfc 5424 syslog message, fields are sent as structured data
+func FormatRFC5424(e Event, facility int, appName string, procID int) string {
+	params := map[string]string{}
+	for k, v := range e.Fields {
+		params[k] = v
+	}
+	params["type"] = e.Type
+	if len(e.User) > 0 {
+		params["user"] = e.User
+	}
+	if len(e.SourceIP) > 0 {
+		params["sourceIp"] = e.SourceIP
+	}
+	if len(e.Server) > 0 {
+		params["server"] = e.Server
+	}
+	if len(e.TargetUser) > 0 {
+		params["targetUser"] = e.TargetUser
+	}
+	if e.SessionID > 0 {
+		params["sessionId"] = strconv.FormatUint(uint64(e.SessionID), 10)
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sd := &strings.Builder{}
+	sd.WriteString("[" + syslogSDID)
+	for _, k := range keys {
+		// param name is limited to 32 printable chars, excluding '=', ' ', ']' and '"'
+		n := strings.Map(func(r rune) rune {
+			if r <= 32 || r >= 127 || r == '=' || r == ']' || r == '"' {
+				return -1
+			}
+			return r
+		}, k)
+		if len(n) == 0 || len(n) > 32 {
+			continue
+		}
+		fmt.Fprintf(sd, ` %s="%s"`, n, syslogEscape(params[k]))
+	}
+	sd.WriteString("]")
+	return fmt.Sprintf(
+		"<%d>1 %s %s %s %d %s %s %s",
+		facility*8+syslogSeverity(e.Type),
+		e.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(e.Host, 255),
+		syslogHeaderField(appName, 48),
+		procID,
+		syslogHeaderField(e.Type, 32),
+		sd.String(),
+		e.Message,
+	)
+}
+
+// SyslogSink sends rfc 5424 messages over udp, or tcp with octet-counting framing
+type SyslogSink struct {
+	network  string
+	address  string
+	facility int
+	appName  string
+	conn     net.Conn
+}
+
+// NewSyslogSink create a syslog sink
+func NewSyslogSink(cfg types.SyslogSinkConfig) (*SyslogSink, error) {
+	s := &SyslogSink{
+		network: strings.ToLower(cfg.Network),
+		address: cfg.Address,
+		appName: cfg.AppName,
+	}
+	if len(s.network) == 0 {
+		s.network = "udp"
+	}
+	if s.network != "udp" && s.network != "tcp" {
+		return nil, fmt.Errorf("unsupported syslog network %s", cfg.Network)
+	}
+	if len(s.address) == 0 {
+		return nil, fmt.Errorf("syslog address is required")
+	}
+	if len(s.appName) == 0 {
+		s.appName = "bunker"
+	}
+	f := strings.ToLower(cfg.Facility)
+	if len(f) == 0 {
+		f = "auth"
+	}
+	var ok bool
+	if s.facility, ok = syslogFacilities[f]; !ok {
+		return nil, fmt.Errorf("unknown syslog facility %s", cfg.Facility)
+	}
+	return s, nil
+}
+
+// Name implements Sink
+func (s *SyslogSink) Name() string {
+	return "syslog"
+}
+
+// Send implements Sink, connection is reestablished on failure
+func (s *SyslogSink) Send(es []Event) (n int, err error) {
+	if s.conn == nil {
+		if s.conn, err = net.DialTimeout(s.network, s.address, syslogTimeout); err != nil {
+			s.conn = nil
+			return
+		}
+	}
+	for _, e := range es {
+		msg := FormatRFC5424(e, s.facility, s.appName, os.Getpid())
+		if s.network == "tcp" {
+			msg = fmt.Sprintf("%d %s", len(msg), msg)
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if _, err = s.conn.Write([]byte(msg)); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return
+		}
+		n++
+	}
+	return
+}
+
+// Close implements Sink
+func (s *SyslogSink) Close() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+/**
+ * events/webhook.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/yankeguo/bunker/types"
+)
+
+// WebhookSink posts events to a http endpoint as json lines
+type WebhookSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// NewWebhookSink create a webhook sink
+func NewWebhookSink(cfg types.WebhookSinkConfig) (*WebhookSink, error) {
+	if len(cfg.URL) == 0 {
+		return nil, fmt.Errorf("events webhook url is required")
+	}
+	return &WebhookSink{
+		url:     cfg.URL,
+		headers: cfg.Headers,
+		client:  &http.Client{Timeout: cfg.RequestTimeout()},
+	}, nil
+}
+
+// Name implements Sink
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+// Send implements Sink, the whole batch is posted in one request, any 2xx status is treated as delivered
+func (s *WebhookSink) Send(es []Event) (n int, err error) {
+	body := &bytes.Buffer{}
+	enc := json.NewEncoder(body)
+	for _, e := range es {
+		if err = enc.Encode(e); err != nil {
+			return
+		}
+	}
+	var req *http.Request
+	if req, err = http.NewRequest(http.MethodPost, s.url, body); err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	var res *http.Response
+	if res, err = s.client.Do(req); err != nil {
+		return
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		err = fmt.Errorf("webhook responded with status %d", res.StatusCode)
+		return
+	}
+	return len(es), nil
+}
+
+// Close implements Sink
+func (s *WebhookSink) Close() error {
+	return nil
+}
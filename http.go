@@ -12,11 +12,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 
+	"github.com/yankeguo/bunker/events"
 	"github.com/yankeguo/bunker/models"
 	"github.com/yankeguo/bunker/routes"
+	"github.com/yankeguo/bunker/sandbox"
 	"github.com/yankeguo/bunker/types"
+	"github.com/yankeguo/bunker/utils"
+	"golang.org/x/crypto/ssh"
 	"landzero.net/x/net/web"
 	"landzero.net/x/net/web/cache"
 	"landzero.net/x/net/web/captcha"
@@ -31,10 +36,17 @@ var (
 
 // HTTP http server of bunker
 type HTTP struct {
-	Config types.Config // config
-	server *http.Server // core http.Server
-	web    *web.Web     // landzero.net/x/net/web instance
-	db     *models.DB   // models.DB
+	Config         types.Config     // config
+	server         *http.Server     // core http.Server
+	web            *web.Web         // landzero.net/x/net/web instance
+	db             *models.DB       // models.DB
+	sandboxManager sandbox.Manager  // sandbox.Manager
+	sandboxTracker *sandbox.Tracker // sandbox.Tracker
+	liveHub        *utils.LiveHub   // utils.LiveHub
+	events         *events.Emitter  // events.Emitter
+	sshLimiter     *utils.Limiter   // utils.Limiter of sshd, bans are listed and lifted from web
+	loginBackoff   *utils.Backoff   // utils.Backoff of web login by source ip
+	notifier       *events.Emitter  // events.Emitter notified of grant requests
 }
 
 // NewHTTP create the HTTP server
@@ -52,12 +64,61 @@ func (h *HTTP) ListenAndServe() (err error) {
 			return
 		}
 	}
+	// initialize sandbox manager and tracker if needed
+	if h.sandboxManager == nil {
+		if h.sandboxManager, err = sandbox.NewManager(h.Config); err != nil {
+			return
+		}
+	}
+	if h.sandboxTracker == nil {
+		h.sandboxTracker = sandbox.NewTracker()
+	}
+	if h.liveHub == nil {
+		h.liveHub = utils.NewLiveHub()
+	}
+	if h.sshLimiter == nil {
+		h.sshLimiter = utils.NewLimiter(h.Config.SSHD.BanThreshold(), h.Config.SSHD.BanWindow(), h.Config.SSHD.BanTime())
+	}
+	if h.loginBackoff == nil {
+		h.loginBackoff = utils.NewBackoff(h.Config.Login.BackoffInitial(), h.Config.Login.BackoffLimit())
+	}
+	if h.events == nil {
+		if h.events, err = events.NewEmitter(h.Config.Events); err != nil {
+			return
+		}
+		h.db.Events = h.events
+	}
+	if h.notifier == nil {
+		if h.notifier, err = events.NewEmitter(types.EventsConfig{Webhook: h.Config.GrantRequest.Notify}); err != nil {
+			return
+		}
+	}
+	// load certificate authority if enabled
+	ca := &routes.CA{}
+	if h.Config.CA.Enable {
+		var k []byte
+		if k, err = ioutil.ReadFile(h.Config.CA.PrivateKey); err != nil {
+			return
+		}
+		if ca.Signer, err = ssh.ParsePrivateKey(k); err != nil {
+			return
+		}
+	}
 	// initialize Web if needed
 	if h.web == nil {
 		h.web = web.New()
 		h.web.SetEnv(h.Config.Env)
 		h.web.Map(h.Config)
 		h.web.Map(h.db)
+		h.web.MapTo(h.sandboxManager, (*sandbox.Manager)(nil))
+		h.web.Map(h.sandboxTracker)
+		h.web.Map(h.liveHub)
+		h.web.Map(h.events)
+		h.web.Map(&routes.SSHLimiter{Limiter: h.sshLimiter})
+		h.web.Map(h.loginBackoff)
+		h.web.Map(&routes.GrantRequestNotifier{Emitter: h.notifier})
+		h.web.Map(routes.NewOIDC(h.Config.OIDC, h.Config.Domain))
+		h.web.Map(ca)
 		h.web.Use(web.Logger())
 		h.web.Use(web.Recovery())
 		h.web.Use(web.Static("public", web.StaticOptions{BinFS: h.web.Env() != web.DEV}))
@@ -92,10 +153,11 @@ func (h *HTTP) ListenAndServe() (err error) {
 	return
 }
 
-// Shutdown shutdown the server
+// Shutdown shutdown the server, and flush grant request notifications
 func (h *HTTP) Shutdown() (err error) {
 	if h.server != nil {
-		return h.server.Shutdown(context.Background())
+		err = h.server.Shutdown(context.Background())
 	}
+	h.notifier.Close()
 	return
 }
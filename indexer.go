@@ -0,0 +1,102 @@
+/**
+ * indexer.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package bunker
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/types"
+	"github.com/yankeguo/bunker/utils"
+)
+
+// indexerBatchSize sessions indexed in each run
+const indexerBatchSize = 100
+
+// Indexer extracts transcripts of ended sessions into full-text index
+type Indexer struct {
+	Config   types.Config
+	db       *models.DB
+	stopFlag bool
+	done     chan bool
+}
+
+// NewIndexer new indexer
+func NewIndexer(config types.Config) *Indexer {
+	return &Indexer{Config: config, done: make(chan bool, 1)}
+}
+
+// ListenAndServe implements utils.Server
+func (x *Indexer) ListenAndServe() (err error) {
+	if !x.Config.Transcript.Enable {
+		x.done <- true
+		return
+	}
+	if x.db == nil {
+		if x.db, err = models.NewDB(x.Config); err != nil {
+			x.done <- true
+			return
+		}
+	}
+	interval := x.Config.Transcript.IndexInterval()
+	var last time.Time
+	for {
+		if x.stopFlag {
+			break
+		}
+		if time.Since(last) > interval {
+			x.index()
+			last = time.Now()
+		}
+		time.Sleep(time.Second)
+	}
+	x.done <- true
+	return
+}
+
+func (x *Indexer) index() {
+	ss := []models.Session{}
+	x.db.Where("is_recorded = ? AND is_indexed = ? AND ended_at IS NOT NULL", utils.True, utils.False).Order("id ASC").Limit(indexerBatchSize).Find(&ss)
+	for _, s := range ss {
+		if x.stopFlag {
+			return
+		}
+		if err := x.indexSession(s); err != nil {
+			log.Printf("Indexer: failed to index session %d: %s", s.ID, err.Error())
+		}
+	}
+}
+
+func (x *Indexer) indexSession(s models.Session) (err error) {
+	var ls []utils.TranscriptLine
+	var f *os.File
+	if f, err = os.Open(filepath.Join(x.Config.SSHD.ReplayDir, s.ReplayFile)); err != nil {
+		if os.IsNotExist(err) {
+			// replay file removed, nothing to index
+			return x.db.IndexTranscript(s, nil)
+		}
+		return
+	}
+	defer f.Close()
+	// a truncated replay still yields lines before the corruption
+	if ls, err = utils.ExtractTranscript(f, x.Config.Transcript.MaxLinesPerSession()); err != nil {
+		log.Printf("Indexer: replay of session %d is incomplete: %s", s.ID, err.Error())
+	}
+	return x.db.IndexTranscript(s, ls)
+}
+
+// Shutdown implements utils.Server
+func (x *Indexer) Shutdown() (err error) {
+	x.stopFlag = true
+	<-x.done
+	return
+}
@@ -0,0 +1,126 @@
+/**
+ * ldapsync.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package bunker
+
+import (
+	"log"
+	"time"
+
+	"github.com/yankeguo/bunker/directory"
+	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/sandbox"
+	"github.com/yankeguo/bunker/types"
+)
+
+// LDAPSync periodically syncs users from ldap
+type LDAPSync struct {
+	Config         types.Config
+	client         *directory.LDAP
+	db             *models.DB
+	sandboxManager sandbox.Manager
+	stopFlag       bool
+	done           chan bool
+}
+
+// NewLDAPSync new ldap sync
+func NewLDAPSync(config types.Config) *LDAPSync {
+	return &LDAPSync{Config: config, done: make(chan bool, 1)}
+}
+
+// ListenAndServe implements utils.Server
+func (l *LDAPSync) ListenAndServe() (err error) {
+	if !l.Config.LDAP.Enable || l.Config.LDAP.SyncInterval <= 0 {
+		l.done <- true
+		return
+	}
+	if l.db == nil {
+		if l.db, err = models.NewDB(l.Config); err != nil {
+			l.done <- true
+			return
+		}
+	}
+	if l.sandboxManager == nil {
+		if l.sandboxManager, err = sandbox.NewManager(l.Config); err != nil {
+			l.done <- true
+			return
+		}
+	}
+	if l.client == nil {
+		l.client = directory.NewLDAP(l.Config.LDAP)
+	}
+	interval := time.Minute * time.Duration(l.Config.LDAP.SyncInterval)
+	var last time.Time
+	for {
+		if l.stopFlag {
+			break
+		}
+		if time.Since(last) > interval {
+			l.sync()
+			last = time.Now()
+		}
+		time.Sleep(time.Second)
+	}
+	l.done <- true
+	return
+}
+
+func (l *LDAPSync) sync() {
+	var err error
+	var es []directory.Entry
+	if es, err = l.client.List(); err != nil {
+		log.Println("LDAPSync:", err)
+		return
+	}
+	// an empty result is more likely a misconfigured filter than an empty directory
+	if len(es) == 0 {
+		log.Println("LDAPSync: no user found in directory, skipped")
+		return
+	}
+	// create, unblock and update existing users
+	managed := directory.ManagedGroups(l.Config.LDAP)
+	for _, e := range es {
+		if !models.NamePattern.MatchString(e.Account) {
+			log.Println("LDAPSync: skipped invalid account", e.Account)
+			continue
+		}
+		m := directory.Resolve(l.Config.LDAP, e)
+		if _, err = l.db.SyncDirectoryUser(e.Account, m.IsAdmin, m.Groups, managed); err != nil {
+			log.Println("LDAPSync:", err)
+		}
+	}
+	// block users missing from directory
+	us := []models.User{}
+	if err = l.db.Find(&us, "source = ? AND is_blocked = ?", models.UserSourceLDAP, 0).Error; err != nil {
+		log.Println("LDAPSync:", err)
+		return
+	}
+	as := make([]string, 0, len(us))
+	for _, u := range us {
+		as = append(as, u.Account)
+	}
+	for _, a := range directory.Missing(as, es) {
+		log.Println("LDAPSync: blocking user missing from directory", a)
+		u := models.User{}
+		if l.db.First(&u, "account = ?", a).Error == nil && u.ID > 0 {
+			if err = l.db.BlockDirectoryUser(&u); err != nil {
+				log.Println("LDAPSync:", err)
+			}
+		}
+		if err = l.sandboxManager.Stop(a); err != nil {
+			log.Println("LDAPSync:", err)
+		}
+	}
+}
+
+// Shutdown implements utils.Server
+func (l *LDAPSync) Shutdown() (err error) {
+	l.stopFlag = true
+	<-l.done
+	return
+}
@@ -0,0 +1,96 @@
+/**
+ * models/audit.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/yankeguo/bunker/events"
+)
+
+const (
+	// AuditActorUser actor is a signed in user
+	AuditActorUser = "user"
+	// AuditActorToken actor is a user using api token
+	AuditActorToken = "token"
+	// AuditActorSecret actor is a request authenticated by the global secret
+	AuditActorSecret = "secret"
+	// AuditActorCLI actor is the command line
+	AuditActorCLI = "cli"
+	// AuditActorSystem actor is bunker itself, consul sync or ldap sync
+	AuditActorSystem = "system"
+)
+
+// ErrAuditAppendOnly audit events can not be changed
+var ErrAuditAppendOnly = errors.New("audit events are append-only")
+
+// AuditEvent append-only record of a state change
+type AuditEvent struct {
+	ID        uint      `orm:"primary_key" json:"id"`
+	CreatedAt time.Time `orm:"index" json:"createdAt"`
+	ActorType string    `orm:"not null;index" json:"actorType"` // AuditActorXXX
+	Actor     string    `orm:"index" json:"actor"`              // account of user, or name of system component
+	Action    string    `orm:"not null;index" json:"action"`    // "resource.verb", e.g. "grant.create"
+	Target    string    `orm:"index" json:"target"`             // "resource:key", e.g. "user:alice", "grant:12"
+	Before    string    `orm:"type:text" json:"before"`         // json of target before change, empty if created
+	After     string    `orm:"type:text" json:"after"`          // json of target after change, empty if destroyed
+	Diff      string    `orm:"type:text" json:"diff"`           // changed fields, "field: old -> new" per line
+	SourceIP  string    `orm:"" json:"sourceIp"`                // ip of actor, empty for cli and system
+}
+
+// BeforeUpdate audit events can not be updated
+func (e *AuditEvent) BeforeUpdate() error {
+	return ErrAuditAppendOnly
+}
+
+// BeforeDelete audit events can not be deleted
+func (e *AuditEvent) BeforeDelete() error {
+	return ErrAuditAppendOnly
+}
+
+// ToEvent convert to exported event, grant and grant request actions are exported as events.TypeGrantChange and events.TypeGrantRequest
+func (e AuditEvent) ToEvent() events.Event {
+	ev := events.Event{
+		Time:     e.CreatedAt,
+		Type:     events.TypeAudit,
+		User:     e.Actor,
+		SourceIP: e.SourceIP,
+		Message:  e.Action + " " + e.Target,
+		Fields: map[string]string{
+			"actorType": e.ActorType,
+			"action":    e.Action,
+			"target":    e.Target,
+		},
+	}
+	if strings.HasPrefix(e.Action, "grant.") {
+		ev.Type = events.TypeGrantChange
+	} else if strings.HasPrefix(e.Action, "grant-request.") {
+		ev.Type = events.TypeGrantRequest
+	}
+	if len(e.Diff) > 0 {
+		ev.Fields["diff"] = e.Diff
+	}
+	if len(e.After) > 0 {
+		ev.Fields["after"] = e.After
+	} else if len(e.Before) > 0 {
+		ev.Fields["before"] = e.Before
+	}
+	return ev
+}
+
+// AuditQuery audit event query, empty fields are ignored
+type AuditQuery struct {
+	Actor  string     // actor
+	Action string     // action, "grant." for all grant actions
+	Target string     // target, "user:alice"
+	Since  *time.Time // events after
+	Until  *time.Time // events before
+}
@@ -0,0 +1,34 @@
+/**
+ * models/auth_failure.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package models
+
+import "time"
+
+// AuthFailure rejected ssh authentication attempt of a connection failed to login
+type AuthFailure struct {
+	ID          uint      `orm:"primary_key" json:"id"`
+	CreatedAt   time.Time `orm:"index" json:"createdAt"`
+	SourceIP    string    `orm:"index" json:"sourceIp"`
+	LoginUser   string    `orm:"" json:"loginUser"`                        // user string of ssh login, "targetuser@server" for hops from sandbox
+	Method      string    `orm:"" json:"method"`                           // "publickey", "keyboard-interactive", "password" etc
+	Fingerprint string    `orm:"index" json:"fingerprint"`                 // fingerprint of offered key, empty for other methods
+	UserAccount string    `orm:"index" json:"userAccount"`                 // account owning the key, empty if unknown
+	TargetUser  string    `orm:"" json:"targetUser"`                       // requested target user, hops from sandbox only
+	ServerName  string    `orm:"" json:"serverName"`                       // requested target server, hops from sandbox only
+	Reason      string    `orm:"type:text" json:"reason"`                  // why it is rejected
+	IsDenied    int       `orm:"not null;default:0;index" json:"isDenied"` // user authenticated but has no grant to target
+}
+
+// AuthFailureQuery auth failure query, empty fields are ignored
+type AuthFailureQuery struct {
+	SourceIP    string // source ip
+	UserAccount string // account owning the key
+	IsDenied    bool   // permission denied only
+	Since       *time.Time
+}
@@ -0,0 +1,37 @@
+/**
+ * models/certificate.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package models
+
+import (
+	"time"
+)
+
+// Certificate ssh user certificate issued by bunker, serial of certificate is the id
+type Certificate struct {
+	Model
+	UserID      uint       `orm:"not null;index" json:"userId"`        // user id
+	UserAccount string     `orm:"not null;index" json:"userAccount"`   // user account, the only principal
+	KeyID       string     `orm:"not null" json:"keyId"`               // key id in certificate
+	Fingerprint string     `orm:"not null" json:"fingerprint"`         // fingerprint of certified public key
+	ValidAfter  time.Time  `orm:"not null" json:"validAfter"`          // certificate valid after
+	ValidBefore time.Time  `orm:"not null;index" json:"validBefore"`   // certificate valid before
+	IsRevoked   int        `orm:"not null;default:0" json:"isRevoked"` // is this certificate revoked
+	RevokedAt   *time.Time `orm:"" json:"revokedAt"`                   // revoked at
+	UsedAt      *time.Time `orm:"" json:"usedAt"`                      // last seen at
+}
+
+// Serial serial of certificate
+func (c Certificate) Serial() uint64 {
+	return uint64(c.ID)
+}
+
+// IsExpired check whether certificate is expired
+func (c Certificate) IsExpired() bool {
+	return time.Now().After(c.ValidBefore)
+}
@@ -0,0 +1,57 @@
+/**
+ * models/credential.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package models
+
+import (
+	"errors"
+
+	"github.com/yankeguo/bunker/utils"
+	"landzero.net/x/com"
+)
+
+// Credential login credential of target servers, matched by server name pattern or label selector
+type Credential struct {
+	Model
+	Name          string `orm:"not null;unique_index" json:"name"`        // credential name
+	ServerName    string `orm:"" json:"serverName"`                       // target server name pattern, empty for label selector credential
+	LabelSelector string `orm:"" json:"labelSelector"`                    // target server label selector, "k1=v1,k2!=v2"
+	LoginUser     string `orm:"" json:"loginUser"`                        // user to login, defaults to root, ignored in direct mode
+	PrivateKey    string `orm:"type:text" json:"-"`                       // encrypted private key
+	PublicKey     string `orm:"type:text" json:"publicKey"`               // authorized key of private key, for display
+	Password      string `orm:"type:text" json:"-"`                       // encrypted password
+	IsDirect      int    `orm:"not null;default:0" json:"isDirect"`       // login as target user directly, without sudo
+	Priority      int    `orm:"not null;default:0;index" json:"priority"` // credential with higher priority is used first
+}
+
+// BeforeSave before save callback
+func (c *Credential) BeforeSave() (err error) {
+	if !NamePattern.MatchString(c.Name) {
+		err = errors.New("invalid field credential.name")
+	}
+	return
+}
+
+// MatchServer check whether the credential applies to the server, by label selector or server name pattern
+func (c Credential) MatchServer(s Server) bool {
+	if len(c.LabelSelector) > 0 {
+		return utils.MatchLabelSelector(c.LabelSelector, s.Labels)
+	}
+	return com.MatchAsterisk(c.ServerName, s.Name)
+}
+
+// GetLoginUser user to login for target user, target user is empty for jump hosts
+func (c Credential) GetLoginUser(targetUser string) string {
+	if utils.ToBool(c.IsDirect) && len(targetUser) > 0 {
+		return targetUser
+	}
+	if len(c.LoginUser) == 0 {
+		return "root"
+	}
+	return c.LoginUser
+}
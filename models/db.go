@@ -15,9 +15,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/yankeguo/bunker/events"
 	"github.com/yankeguo/bunker/types"
 	"github.com/yankeguo/bunker/utils"
-	"landzero.net/x/com"
 	"landzero.net/x/database/orm"
 	_ "landzero.net/x/database/sqlite3" // sqlite3 adapter
 )
@@ -38,6 +38,7 @@ type Model struct {
 // DB wrapper for orm.DB
 type DB struct {
 	*orm.DB
+	Events *events.Emitter // audit events are exported if set
 }
 
 // NewDB create a new database from Config struct
@@ -47,19 +48,33 @@ func NewDB(cfg types.Config) (db *DB, err error) {
 		return
 	}
 	d = d.LogMode(cfg.Env != "production")
-	db = &DB{d}
+	db = &DB{DB: d}
 	return
 }
 
 // AutoMigrate automatically migrate all models
 func (w *DB) AutoMigrate() error {
-	return w.DB.AutoMigrate(
+	if err := w.DB.AutoMigrate(
 		Server{},
 		User{},
 		Key{},
 		Grant{},
 		Session{},
-	).Error
+		Group{},
+		GroupMember{},
+		FileTransfer{},
+		Token{},
+		Certificate{},
+		Credential{},
+		TranscriptLine{},
+		AuditEvent{},
+		AuthFailure{},
+		GrantRequest{},
+	).Error; err != nil {
+		return err
+	}
+	// full-text index of transcript lines, docid is id of TranscriptLine
+	return w.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS transcript_lines_fts USING fts4(content)").Error
 }
 
 // Touch update the UsedAt field
@@ -70,27 +85,514 @@ func (w *DB) Touch(ms ...interface{}) {
 	}
 }
 
-// FindUserByLogin find user by account and password
+// FindUserByLogin find local user by account and password
 func (w *DB) FindUserByLogin(account string, password string) (*User, error) {
 	u := User{}
-	if err := w.First(&u, "account = ?", account).Error; err != nil || u.ID == 0 || !u.CheckPassword(password) {
+	if err := w.First(&u, "account = ?", account).Error; err != nil || u.ID == 0 || u.Source != UserSourceLocal || utils.ToBool(u.IsPasswordOff) || !u.CheckPassword(password) {
 		return nil, errors.New("invalid credentials")
 	}
 	return &u, nil
 }
 
+// RecordFailedLogin count a failed web login of account, the account is locked after too many failures within window,
+// nothing happens if account does not exist
+func (w *DB) RecordFailedLogin(account string, ip string, cfg types.LoginConfig) (locked bool, err error) {
+	u := User{}
+	if w.First(&u, "account = ?", account).Error != nil || u.ID == 0 {
+		return
+	}
+	now := time.Now()
+	n := 1
+	if u.LastFailedLoginAt != nil && now.Sub(*u.LastFailedLoginAt) < cfg.LockWindow() {
+		n = u.FailedLogins + 1
+	}
+	attrs := map[string]interface{}{
+		"failed_logins":        n,
+		"last_failed_login_at": now,
+		"last_failed_login_ip": ip,
+	}
+	if max := cfg.LockThreshold(); max > 0 && n >= max && !u.IsLocked() {
+		attrs["failed_logins"] = 0
+		attrs["locked_until"] = now.Add(cfg.LockTime())
+		locked = true
+	}
+	if err = w.Model(&u).UpdateColumns(attrs).Error; err != nil {
+		return
+	}
+	if locked {
+		err = w.Audit(AuditActorSystem, "login", ip, "user.lock", "user:"+u.Account, nil, nil)
+	}
+	return
+}
+
+// BlockDirectoryUser block a directory user missing from directory, it is unblocked by SyncDirectoryUser when back
+func (w *DB) BlockDirectoryUser(u *User) (err error) {
+	before := *u
+	if err = w.Model(u).Updates(map[string]interface{}{
+		"is_blocked":     utils.True,
+		"is_dir_blocked": utils.True,
+	}).Error; err != nil {
+		return
+	}
+	return w.Audit(AuditActorSystem, UserSourceLDAP, "", "user.update", "user:"+u.Account, before, u)
+}
+
+// ResetFailedLogins reset failed web logins after a successful login, last failed login is kept
+func (w *DB) ResetFailedLogins(u *User) error {
+	if u.FailedLogins == 0 {
+		return nil
+	}
+	return w.Model(u).UpdateColumn("failed_logins", 0).Error
+}
+
+// SyncDirectoryUser create a directory user, or unblock it if blocked for missing from directory, blocks set by admin are kept,
+// update admin status if isAdmin is not nil, and update membership of managed groups, groups not managed are left untouched
+func (w *DB) SyncDirectoryUser(account string, isAdmin *bool, groups []string, managed []string) (u User, err error) {
+	before := &User{}
+	if w.First(before, "account = ?", account).Error != nil || before.ID == 0 {
+		before = nil
+	}
+	if err = w.Attrs(map[string]interface{}{
+		"source": UserSourceLDAP,
+	}).FirstOrCreate(&u, map[string]interface{}{
+		"account": account,
+	}).Error; err != nil {
+		return
+	}
+	if u.Source != UserSourceLDAP {
+		err = fmt.Errorf("account %s already exists as local user", account)
+		return
+	}
+	attrs := map[string]interface{}{}
+	if utils.ToBool(u.IsDirBlocked) {
+		attrs["is_blocked"] = 0
+		attrs["is_dir_blocked"] = 0
+	}
+	if isAdmin != nil {
+		attrs["is_admin"] = utils.ToInt(*isAdmin)
+	}
+	if len(attrs) > 0 {
+		if err = w.Model(&u).Updates(attrs).Error; err != nil {
+			return
+		}
+	}
+	if before == nil {
+		w.Audit(AuditActorSystem, UserSourceLDAP, "", "user.create", "user:"+u.Account, nil, u)
+	} else {
+		w.Audit(AuditActorSystem, UserSourceLDAP, "", "user.update", "user:"+u.Account, before, u)
+	}
+	in := map[string]bool{}
+	for _, g := range groups {
+		in[g] = true
+	}
+	for _, name := range managed {
+		g := Group{}
+		if err = w.FirstOrCreate(&g, map[string]interface{}{"name": name}).Error; err != nil {
+			return
+		}
+		m := GroupMember{}
+		w.First(&m, "group_id = ? AND user_id = ?", g.ID, u.ID)
+		if in[name] && m.ID == 0 {
+			m = GroupMember{GroupID: g.ID, UserID: u.ID}
+			if err = w.Create(&m).Error; err != nil {
+				return
+			}
+			w.Audit(AuditActorSystem, UserSourceLDAP, "", "group.add-member", "group:"+g.Name, nil, map[string]interface{}{"memberId": m.ID, "account": u.Account})
+		} else if !in[name] && m.ID != 0 {
+			if err = w.Delete(&m).Error; err != nil {
+				return
+			}
+			w.Audit(AuditActorSystem, UserSourceLDAP, "", "group.remove-member", "group:"+g.Name, map[string]interface{}{"memberId": m.ID, "account": u.Account}, nil)
+		}
+	}
+	return
+}
+
+// FindOrCreateOIDCUser find or create user signed in with openid connect, update admin status if isAdmin is not nil,
+// existing local or ldap user is rejected unless linked by admin, and its admin status is never changed
+func (w *DB) FindOrCreateOIDCUser(account string, isAdmin *bool) (u User, err error) {
+	before := &User{}
+	if w.First(before, "account = ?", account).Error != nil || before.ID == 0 {
+		before = nil
+	}
+	if err = w.Attrs(map[string]interface{}{
+		"source": UserSourceOIDC,
+	}).FirstOrCreate(&u, map[string]interface{}{
+		"account": account,
+	}).Error; err != nil {
+		return
+	}
+	if u.Source != UserSourceOIDC && !utils.ToBool(u.IsOIDCLinked) {
+		err = fmt.Errorf("account %s already exists and is not linked to openid connect", account)
+		return
+	}
+	if utils.ToBool(u.IsBlocked) {
+		err = fmt.Errorf("user %s is blocked", account)
+		return
+	}
+	if isAdmin != nil && u.Source == UserSourceOIDC {
+		if err = w.Model(&u).Update("is_admin", utils.ToInt(*isAdmin)).Error; err != nil {
+			return
+		}
+	}
+	if before == nil {
+		w.Audit(AuditActorSystem, UserSourceOIDC, "", "user.create", "user:"+u.Account, nil, u)
+	} else {
+		w.Audit(AuditActorSystem, UserSourceOIDC, "", "user.update", "user:"+u.Account, before, u)
+	}
+	return
+}
+
+// MaxProxyDepth max number of jump hosts before a server
+const MaxProxyDepth = 8
+
+// FindProxyChain find jump hosts of a server, ordered from the first hop to the last one
+func (w *DB) FindProxyChain(s Server) (chain []Server, err error) {
+	chain = []Server{}
+	seen := map[string]bool{s.Name: true}
+	for len(s.Proxy) > 0 {
+		if seen[s.Proxy] {
+			err = fmt.Errorf("proxy loop detected at server %s", s.Proxy)
+			return
+		}
+		if len(chain) >= MaxProxyDepth {
+			err = fmt.Errorf("too many proxies, max %d", MaxProxyDepth)
+			return
+		}
+		seen[s.Proxy] = true
+		p := Server{}
+		if err = w.First(&p, "name = ?", s.Proxy).Error; err != nil || p.ID == 0 {
+			err = fmt.Errorf("proxy server %s not found", s.Proxy)
+			return
+		}
+		chain = append([]Server{p}, chain...)
+		s = p
+	}
+	return
+}
+
+// IndexTranscript save transcript lines of a session and mark it indexed
+func (w *DB) IndexTranscript(s Session, ls []utils.TranscriptLine) (err error) {
+	tx := w.Begin()
+	for _, l := range ls {
+		t := TranscriptLine{
+			SessionID:   s.ID,
+			UserAccount: s.UserAccount,
+			TargetUser:  s.TargetUser,
+			ServerName:  s.ServerName,
+			At:          s.StartedAt.Add(l.Offset),
+			Offset:      int64(l.Offset / time.Millisecond),
+			Content:     l.Text,
+		}
+		if len(l.Command) > 0 {
+			t.IsCommand = utils.True
+			t.Content = l.Command
+		}
+		if err = tx.Create(&t).Error; err != nil {
+			tx.Rollback()
+			return
+		}
+		if err = tx.Exec("INSERT INTO transcript_lines_fts (docid, content) VALUES (?, ?)", t.ID, t.Content).Error; err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	if err = tx.Model(&s).UpdateColumn("is_indexed", utils.True).Error; err != nil {
+		tx.Rollback()
+		return
+	}
+	return tx.Commit().Error
+}
+
+// SearchTranscripts search transcript lines, newest first
+func (w *DB) SearchTranscripts(q TranscriptQuery, offset int, limit int) (ls []TranscriptLine, count int, err error) {
+	ls = []TranscriptLine{}
+	d := w.Model(&TranscriptLine{})
+	if p := utils.FTSPhrase(q.Phrase); len(p) > 0 {
+		d = d.Where("id IN (SELECT docid FROM transcript_lines_fts WHERE transcript_lines_fts MATCH ?)", p)
+	}
+	if len(q.User) > 0 {
+		d = d.Where("user_account = ?", q.User)
+	}
+	if len(q.Server) > 0 {
+		d = d.Where("server_name = ?", q.Server)
+	}
+	if q.Since != nil {
+		d = d.Where("at >= ?", *q.Since)
+	}
+	if q.Until != nil {
+		d = d.Where("at < ?", *q.Until)
+	}
+	if q.CommandsOnly {
+		d = d.Where("is_command = ?", utils.True)
+	}
+	if err = d.Count(&count).Error; err != nil {
+		return
+	}
+	err = d.Order("at DESC").Order("id DESC").Offset(offset).Limit(limit).Find(&ls).Error
+	return
+}
+
+// Audit record a audit event, before and after are states of target, nil if target not exists,
+// nothing is recorded if both states exist and no field changed
+func (w *DB) Audit(actorType string, actor string, sourceIP string, action string, target string, before interface{}, after interface{}) error {
+	e := AuditEvent{
+		ActorType: actorType,
+		Actor:     actor,
+		Action:    action,
+		Target:    target,
+		SourceIP:  sourceIP,
+	}
+	e.Before, e.After, e.Diff = utils.AuditDiff(before, after)
+	if len(e.Before) > 0 && len(e.After) > 0 && len(e.Diff) == 0 {
+		return nil
+	}
+	if err := w.Create(&e).Error; err != nil {
+		return err
+	}
+	w.Events.Emit(e.ToEvent())
+	return nil
+}
+
+// SearchAuditEvents search audit events, newest first, limit <= 0 for all
+func (w *DB) SearchAuditEvents(q AuditQuery, offset int, limit int) (es []AuditEvent, count int, err error) {
+	es = []AuditEvent{}
+	d := w.Model(&AuditEvent{})
+	if len(q.Actor) > 0 {
+		d = d.Where("actor = ?", q.Actor)
+	}
+	if len(q.Action) > 0 {
+		if strings.HasSuffix(q.Action, ".") {
+			d = d.Where("action LIKE ?", q.Action+"%")
+		} else {
+			d = d.Where("action = ?", q.Action)
+		}
+	}
+	if len(q.Target) > 0 {
+		d = d.Where("target = ?", q.Target)
+	}
+	if q.Since != nil {
+		d = d.Where("created_at >= ?", *q.Since)
+	}
+	if q.Until != nil {
+		d = d.Where("created_at < ?", *q.Until)
+	}
+	if err = d.Count(&count).Error; err != nil {
+		return
+	}
+	d = d.Order("created_at DESC").Order("id DESC")
+	if limit > 0 {
+		d = d.Offset(offset).Limit(limit)
+	}
+	err = d.Find(&es).Error
+	return
+}
+
+// SearchAuthFailures search auth failures, newest first, limit <= 0 for all
+func (w *DB) SearchAuthFailures(q AuthFailureQuery, offset int, limit int) (fs []AuthFailure, count int, err error) {
+	fs = []AuthFailure{}
+	d := w.Model(&AuthFailure{})
+	if len(q.SourceIP) > 0 {
+		d = d.Where("source_ip = ?", q.SourceIP)
+	}
+	if len(q.UserAccount) > 0 {
+		d = d.Where("user_account = ?", q.UserAccount)
+	}
+	if q.IsDenied {
+		d = d.Where("is_denied = ?", utils.True)
+	}
+	if q.Since != nil {
+		d = d.Where("created_at >= ?", *q.Since)
+	}
+	if err = d.Count(&count).Error; err != nil {
+		return
+	}
+	d = d.Order("id DESC")
+	if limit > 0 {
+		d = d.Offset(offset).Limit(limit)
+	}
+	err = d.Find(&fs).Error
+	return
+}
+
+// FindCredential find credential for server, ok is false if no credential matches and master key should be used
+func (w *DB) FindCredential(s Server) (c Credential, ok bool) {
+	cs := []Credential{}
+	w.Order("priority DESC").Order("id ASC").Find(&cs)
+	for _, c = range cs {
+		if c.MatchServer(s) {
+			return c, true
+		}
+	}
+	return Credential{}, false
+}
+
+// IsCertificateRevoked check whether certificate with serial is revoked, unknown serial is treated as revoked
+func (w *DB) IsCertificateRevoked(serial uint64) bool {
+	c := Certificate{}
+	if err := w.First(&c, serial).Error; err != nil || c.ID == 0 {
+		return true
+	}
+	return utils.ToBool(c.IsRevoked)
+}
+
+// RevokeCertificate revoke certificate with id, limited to user if uid is not 0
+func (w *DB) RevokeCertificate(id uint, uid uint) error {
+	q := w.Model(&Certificate{}).Where("id = ?", id)
+	if uid != 0 {
+		q = q.Where("user_id = ?", uid)
+	}
+	return q.Updates(map[string]interface{}{
+		"is_revoked": utils.True,
+		"revoked_at": time.Now(),
+	}).Error
+}
+
+// FindUserByToken find user and token by token secret, expired tokens and blocked users are rejected
+func (w *DB) FindUserByToken(secret string) (*User, *Token, error) {
+	t := Token{}
+	if len(secret) == 0 || w.First(&t, "digest = ?", TokenDigest(secret)).Error != nil || t.ID == 0 || t.IsExpired() {
+		return nil, nil, errors.New("invalid token")
+	}
+	u := User{}
+	if err := w.First(&u, t.UserID).Error; err != nil || u.ID == 0 || utils.ToBool(u.IsBlocked) {
+		return nil, nil, errors.New("invalid token")
+	}
+	w.Touch(&t)
+	return &u, &t, nil
+}
+
+// VerifyUserTOTP verify totp code or recovery code of user, used codes can not be used again
+func (w *DB) VerifyUserTOTP(u *User, code string) bool {
+	// reload user for latest counter and recovery codes
+	if err := w.First(u, u.ID).Error; err != nil || !utils.ToBool(u.IsTOTPEnabled) {
+		return false
+	}
+	// totp code, counter only moves forward
+	if c, ok := utils.ValidateTOTP(u.TOTPSecret, code, time.Now()); ok {
+		return w.Model(&User{}).Where("id = ? AND totp_counter < ?", u.ID, c).UpdateColumn("totp_counter", c).RowsAffected == 1
+	}
+	// recovery code
+	d := RecoveryCodeDigest(code)
+	ds := strings.Split(u.RecoveryCodes, ",")
+	for i, v := range ds {
+		if v != d {
+			continue
+		}
+		rest := strings.Join(append(ds[:i:i], ds[i+1:]...), ",")
+		return w.Model(&User{}).Where("id = ? AND recovery_codes = ?", u.ID, u.RecoveryCodes).UpdateColumn("recovery_codes", rest).RowsAffected == 1
+	}
+	return false
+}
+
+// GetUserGroupIDs get ids of groups the user belongs to
+func (w *DB) GetUserGroupIDs(uid uint) []uint {
+	ids := []uint{}
+	ms := []GroupMember{}
+	w.Find(&ms, "user_id = ?", uid)
+	for _, m := range ms {
+		ids = append(ids, m.GroupID)
+	}
+	return ids
+}
+
+// UserGrants scope grants for user, including grants of groups the user belongs to
+func (w *DB) UserGrants(uid uint) *orm.DB {
+	gids := w.GetUserGroupIDs(uid)
+	if len(gids) == 0 {
+		return w.Where("user_id = ?", uid)
+	}
+	return w.Where("user_id = ? OR group_id IN (?)", uid, gids)
+}
+
 // CheckGrant check target grant
 func (w *DB) CheckGrant(u User, s Server, targetUser string) (err error) {
 	gs := []Grant{}
-	w.Find(&gs, "user_id = ? AND target_user = ? AND (expires_at IS NULL OR expires_at > ?)", u.ID, targetUser, time.Now())
+	w.UserGrants(u.ID).Find(&gs, "type = ? AND target_user = ? AND (expires_at IS NULL OR expires_at > ?)", GrantTypeSSH, targetUser, time.Now())
+	for _, g := range gs {
+		if g.MatchServer(s) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Grant not find")
+}
+
+// CheckForwardGrant check forward grant, typ is GrantTypeForward or GrantTypeRemoteForward
+func (w *DB) CheckForwardGrant(u User, s Server, typ int, host string, port uint32) (err error) {
+	gs := []Grant{}
+	w.UserGrants(u.ID).Find(&gs, "type = ? AND (expires_at IS NULL OR expires_at > ?)", typ, time.Now())
 	for _, g := range gs {
-		if com.MatchAsterisk(g.ServerName, s.Name) {
+		if g.MatchServer(s) && g.MatchForward(host, port) {
 			return nil
 		}
 	}
 	return fmt.Errorf("Grant not find")
 }
 
+// CloseGrantRequest move a pending grant request to status, returns false if it is no longer pending,
+// so concurrent reviews of the same request never both succeed
+func (w *DB) CloseGrantRequest(r *GrantRequest, status int, reviewer string, comment string) (ok bool, err error) {
+	now := time.Now()
+	attrs := map[string]interface{}{
+		"status":           status,
+		"reviewer_account": reviewer,
+		"review_comment":   comment,
+		"reviewed_at":      now,
+	}
+	d := w.Model(&GrantRequest{}).Where("id = ? AND status = ?", r.ID, GrantRequestPending).UpdateColumns(attrs)
+	if err = d.Error; err != nil || d.RowsAffected == 0 {
+		return
+	}
+	r.Status, r.ReviewerAccount, r.ReviewComment, r.ReviewedAt = status, reviewer, comment, &now
+	return true, nil
+}
+
+// VerifyServerHostKey verify host key fingerprint of a server, trust on first use,
+// a changed fingerprint is recorded as pending and rejected until accepted by admin
+func (w *DB) VerifyServerHostKey(id uint, fp string) (err error) {
+	fp = strings.TrimSpace(fp)
+	s := Server{}
+	if err = w.First(&s, id).Error; err != nil || s.ID == 0 {
+		return fmt.Errorf("server with id %d not found", id)
+	}
+	// trust on first use
+	if len(s.HostKeyFingerprint) == 0 {
+		return w.Model(&s).Update(map[string]interface{}{
+			"host_key_fingerprint":         fp,
+			"pending_host_key_fingerprint": "",
+		}).Error
+	}
+	if s.HostKeyFingerprint == fp {
+		return nil
+	}
+	// record changed host key for review
+	if s.PendingHostKeyFingerprint != fp {
+		w.Model(&s).Update(map[string]interface{}{
+			"pending_host_key_fingerprint": fp,
+		})
+	}
+	return fmt.Errorf("host key of server %s changed to %s, waiting for approval", s.Name, fp)
+}
+
+// AcceptServerHostKey accept the pending host key fingerprint of a server
+func (w *DB) AcceptServerHostKey(s *Server) (err error) {
+	if !s.HasPendingHostKey() {
+		return fmt.Errorf("server %s has no pending host key", s.Name)
+	}
+	return w.Model(s).Update(map[string]interface{}{
+		"host_key_fingerprint":         s.PendingHostKeyFingerprint,
+		"pending_host_key_fingerprint": "",
+	}).Error
+}
+
+// ResetServerHostKey clear the pinned host key fingerprint of a server, next connection will be trusted
+func (w *DB) ResetServerHostKey(s *Server) (err error) {
+	return w.Model(s).Update(map[string]interface{}{
+		"host_key_fingerprint":         "",
+		"pending_host_key_fingerprint": "",
+	}).Error
+}
+
 // CountUserSSHKeys count user ssh keys
 func (w *DB) CountUserSSHKeys(u *User) (count uint) {
 	w.Model(&Key{}).Where("user_id = ?", u.ID).Count(&count)
@@ -155,17 +657,17 @@ func (w *DB) GetCombinedGrants(uid uint) []CombinedGrant {
 	ss := []Server{}
 	w.Find(&ss)
 	gs := []Grant{}
-	w.Find(&gs, "user_id = ? AND (expires_at IS NULL OR expires_at > ?)", uid, time.Now())
+	w.UserGrants(uid).Find(&gs, "type = ? AND (expires_at IS NULL OR expires_at > ?)", GrantTypeSSH, time.Now())
 	for _, g := range gs {
 	L2:
 		for _, s := range ss {
-			if com.MatchAsterisk(g.ServerName, s.Name) {
+			if g.MatchServer(s) {
 				for i, o := range out {
-					// found same server same user, update expires_at
-					if o.ServerName == s.Name &&
-						o.TargetUser == g.TargetUser &&
-						o.ExpiresAt != nil && (g.ExpiresAt == nil || g.ExpiresAt.After(*o.ExpiresAt)) {
-						out[i].ExpiresAt = g.ExpiresAt
+					// found same server same user, possibly from user grant and group grant, update expires_at
+					if o.ServerName == s.Name && o.TargetUser == g.TargetUser {
+						if o.ExpiresAt != nil && (g.ExpiresAt == nil || g.ExpiresAt.After(*o.ExpiresAt)) {
+							out[i].ExpiresAt = g.ExpiresAt
+						}
 						continue L2
 					}
 				}
@@ -180,6 +682,17 @@ func (w *DB) GetCombinedGrants(uid uint) []CombinedGrant {
 	return out
 }
 
+// DestroyGroup delete a group with all members and grants
+func (w *DB) DestroyGroup(id uint) (err error) {
+	if err = w.Delete(&GroupMember{}, "group_id = ?", id).Error; err != nil {
+		return
+	}
+	if err = w.Delete(&Grant{}, "group_id = ? AND user_id = ?", id, 0).Error; err != nil {
+		return
+	}
+	return w.Delete(&Group{}, "id = ?", id).Error
+}
+
 // UpdateSandboxPublicKeyForAccount update sandbox public key for user with account
 func (w *DB) UpdateSandboxPublicKeyForAccount(fp string, account string) (err error) {
 	fp = strings.TrimSpace(fp)
@@ -200,11 +713,20 @@ func (w *DB) UpdateSandboxPublicKeyForAccount(fp string, account string) (err er
 	return
 }
 
-// CreateSession create a new session model
-func (w *DB) CreateSession(account string) (s *Session, err error) {
+// CreateSession create a new session model, targetUser and serverName are empty for sandbox session
+func (w *DB) CreateSession(account string, targetUser string, serverName string) (s *Session, err error) {
+	return w.CreateTypedSession(SessionTypeShell, account, targetUser, serverName, "")
+}
+
+// CreateTypedSession create a session with type, forwardAddress is for forward sessions only
+func (w *DB) CreateTypedSession(typ int, account string, targetUser string, serverName string, forwardAddress string) (s *Session, err error) {
 	s = &Session{
-		UserAccount: account,
-		StartedAt:   time.Now(),
+		Type:           typ,
+		ForwardAddress: forwardAddress,
+		UserAccount:    account,
+		TargetUser:     targetUser,
+		ServerName:     serverName,
+		StartedAt:      time.Now(),
 	}
 	if err = w.Create(s).Error; err != nil {
 		return
@@ -10,13 +10,44 @@ package models
 
 import (
 	"time"
+
+	"github.com/yankeguo/bunker/utils"
+	"landzero.net/x/com"
+)
+
+const (
+	// GrantTypeSSH grant to login target user on target server
+	GrantTypeSSH = 0
+	// GrantTypeForward grant to forward local port to host:ports via target server, "direct-tcpip"
+	GrantTypeForward = 1
+	// GrantTypeRemoteForward grant to listen on bind address:ports of target server, "tcpip-forward"
+	GrantTypeRemoteForward = 2
 )
 
 // Grant grant
 type Grant struct {
 	Model
-	UserID     uint       `orm:"not null;index" json:"userId"`     // user id or usergroup id
-	ServerName string     `orm:"not null;index" json:"serverName"` // target server name
-	TargetUser string     `orm:"not null;index" json:"targetUser"` // target user
-	ExpiresAt  *time.Time `orm:"index" json:"expiresAt"`           // grant expires at
+	UserID        uint       `orm:"not null;index" json:"userId"`              // user id, 0 for group grant
+	GroupID       uint       `orm:"not null;default:0;index" json:"groupId"`   // group id, 0 for user grant
+	Type          int        `orm:"not null;default:0;index" json:"type"`      // grant type, GrantTypeXXX
+	ServerName    string     `orm:"not null;index" json:"serverName"`          // target server name, empty for label selector grant
+	LabelSelector string     `orm:"" json:"labelSelector"`                     // target server label selector, "k1=v1,k2!=v2"
+	TargetUser    string     `orm:"not null;index" json:"targetUser"`          // target user, empty for forward grant
+	ForwardHost   string     `orm:"" json:"forwardHost"`                       // forward host pattern, or bind address pattern for remote forward
+	ForwardPorts  string     `orm:"" json:"forwardPorts"`                      // forward port ranges, "22,8000-8100"
+	ExpiresAt     *time.Time `orm:"index" json:"expiresAt"`                    // grant expires at
+	RequestID     uint       `orm:"not null;default:0;index" json:"requestId"` // approved grant request, 0 if created by admin
+}
+
+// MatchServer check whether the grant targets the server, by label selector or server name pattern
+func (g Grant) MatchServer(s Server) bool {
+	if len(g.LabelSelector) > 0 {
+		return utils.MatchLabelSelector(g.LabelSelector, s.Labels)
+	}
+	return com.MatchAsterisk(g.ServerName, s.Name)
+}
+
+// MatchForward check whether the forward grant allows host and port
+func (g Grant) MatchForward(host string, port uint32) bool {
+	return com.MatchAsterisk(g.ForwardHost, host) && utils.MatchPortRanges(g.ForwardPorts, port)
 }
@@ -0,0 +1,43 @@
+/**
+ * models/grant_request.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package models
+
+import "time"
+
+const (
+	// GrantRequestPending request waiting for review
+	GrantRequestPending = 0
+	// GrantRequestApproved request approved, a grant is created
+	GrantRequestApproved = 1
+	// GrantRequestDenied request denied by reviewer
+	GrantRequestDenied = 2
+	// GrantRequestCancelled request cancelled by requester
+	GrantRequestCancelled = 3
+)
+
+// GrantRequest request of a user for a time-bound ssh grant, reviewed by approvers
+type GrantRequest struct {
+	Model
+	UserID          uint       `orm:"not null;index" json:"userId"`           // requester id
+	UserAccount     string     `orm:"not null;index" json:"userAccount"`      // requester account
+	ServerName      string     `orm:"not null" json:"serverName"`             // target server name pattern
+	TargetUser      string     `orm:"not null" json:"targetUser"`             // target user
+	Duration        int        `orm:"not null" json:"duration"`               // hours the grant lasts after approval
+	Justification   string     `orm:"type:text" json:"justification"`         // why access is needed
+	Status          int        `orm:"not null;default:0;index" json:"status"` // GrantRequestXXX
+	ReviewerAccount string     `orm:"" json:"reviewerAccount"`                // account approved or denied the request
+	ReviewComment   string     `orm:"type:text" json:"reviewComment"`         // comment of reviewer
+	ReviewedAt      *time.Time `orm:"" json:"reviewedAt"`                     // reviewed or cancelled at
+	GrantID         uint       `orm:"not null;default:0" json:"grantId"`      // grant created by approval
+}
+
+// IsPending check whether the request is waiting for review
+func (r GrantRequest) IsPending() bool {
+	return r.Status == GrantRequestPending
+}
@@ -0,0 +1,34 @@
+/**
+ * models/group.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package models
+
+import (
+	"errors"
+)
+
+// Group user group, grants of a group apply to all members
+type Group struct {
+	Model
+	Name string `orm:"not null;unique_index" json:"name"` // group name
+}
+
+// BeforeSave before save callback
+func (g *Group) BeforeSave() (err error) {
+	if !NamePattern.MatchString(g.Name) {
+		err = errors.New("invalid field group.name")
+	}
+	return
+}
+
+// GroupMember membership of user in group
+type GroupMember struct {
+	Model
+	GroupID uint `orm:"not null;unique_index:idx_group_member" json:"groupId"` // group id
+	UserID  uint `orm:"not null;unique_index:idx_group_member" json:"userId"`  // user id
+}
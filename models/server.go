@@ -11,6 +11,8 @@ package models
 import (
 	"errors"
 	"time"
+
+	"github.com/yankeguo/bunker/utils"
 )
 
 // Server server model
@@ -20,12 +22,36 @@ type Server struct {
 	Address string     `orm:"not null;" json:"address"`          // host:ip of ssh port
 	UsedAt  *time.Time `orm:"" json:"usedAt"`                    // last used at
 	IsAuto  int        `orm:"not null;default:0" json:"isAuto"`  // is consul
+	Labels  string     `orm:"type:text" json:"labels"`           // labels, "k1=v1,k2=v2"
+	Proxy   string     `orm:"" json:"proxy"`                     // name of server to jump through, empty for direct connection
+
+	HostKeyFingerprint        string `orm:"" json:"hostKeyFingerprint"`        // pinned host key fingerprint, trusted on first use
+	PendingHostKeyFingerprint string `orm:"" json:"pendingHostKeyFingerprint"` // changed host key fingerprint, waiting for admin approval
+}
+
+// HasPendingHostKey whether this server has a changed host key waiting for approval
+func (s Server) HasPendingHostKey() bool {
+	return len(s.PendingHostKeyFingerprint) > 0
 }
 
 // BeforeSave before save callback
 func (s *Server) BeforeSave() (err error) {
 	if !NamePattern.MatchString(s.Name) {
 		err = errors.New("invalid field server.name")
+		return
+	}
+	if _, err = utils.ParseLabels(s.Labels); err != nil {
+		err = errors.New("invalid field server.labels")
+		return
+	}
+	if len(s.Proxy) > 0 && (!NamePattern.MatchString(s.Proxy) || s.Proxy == s.Name) {
+		err = errors.New("invalid field server.proxy")
 	}
 	return
 }
+
+// GetLabels get labels as map, invalid labels are ignored
+func (s Server) GetLabels() map[string]string {
+	ls, _ := utils.ParseLabels(s.Labels)
+	return ls
+}
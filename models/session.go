@@ -14,15 +14,45 @@ import (
 	"time"
 )
 
+const (
+	// SessionTypeShell shell, exec or sftp session
+	SessionTypeShell = 0
+	// SessionTypeForward local port forwarding tunnel, "direct-tcpip"
+	SessionTypeForward = 1
+	// SessionTypeRemoteForward remote port forwarding tunnel, "forwarded-tcpip"
+	SessionTypeRemoteForward = 2
+)
+
 // Session recorded ssh session
 type Session struct {
 	Model
-	UserAccount string     `orm:"index" json:"userAccount"`
-	Command     string     `orm:"" json:"command"`
-	StartedAt   time.Time  `orm:"index" json:"startedAt"`
-	EndedAt     *time.Time `orm:"index" json:"endedAt"`
-	IsRecorded  int        `orm:"not null;default:0" json:"isRecorded"`
-	ReplayFile  string     `orm:"" json:"-"`
+	Type           int        `orm:"not null;default:0;index" json:"type"` // session type, SessionTypeXXX
+	UserAccount    string     `orm:"index" json:"userAccount"`
+	TargetUser     string     `orm:"index" json:"targetUser"` // target user, empty for sandbox session
+	ServerName     string     `orm:"index" json:"serverName"` // target server name, empty for sandbox session
+	Command        string     `orm:"" json:"command"`
+	ForwardAddress string     `orm:"" json:"forwardAddress"`             // "host:port" for forward session
+	BytesIn        int64      `orm:"not null;default:0" json:"bytesIn"`  // bytes from user, forward session only
+	BytesOut       int64      `orm:"not null;default:0" json:"bytesOut"` // bytes to user, forward session only
+	StartedAt      time.Time  `orm:"index" json:"startedAt"`
+	EndedAt        *time.Time `orm:"index" json:"endedAt"`
+	IsRecorded     int        `orm:"not null;default:0" json:"isRecorded"`
+	IsAgentUsed    int        `orm:"not null;default:0" json:"isAgentUsed"`         // ssh agent forwarded into sandbox
+	CertificateID  uint       `orm:"not null;default:0;index" json:"certificateId"` // serial of certificate used to connect, 0 for keys
+	ExitCode       *int       `orm:"" json:"exitCode"`                              // exit code of command, nil if unknown
+	ExitSignal     string     `orm:"" json:"exitSignal"`                            // name of the signal killed the command, "INT", "TERM"
+	ReplayFile     string     `orm:"" json:"-"`
+	IsIndexed      int        `orm:"not null;default:0;index" json:"isIndexed"` // transcript extracted for full-text search
+}
+
+// IsForward whether this session is a port forwarding tunnel
+func (s Session) IsForward() bool {
+	return s.Type == SessionTypeForward || s.Type == SessionTypeRemoteForward
+}
+
+// IsSandbox whether this session is a sandbox session
+func (s Session) IsSandbox() bool {
+	return len(s.ServerName) == 0
 }
 
 // GenerateReplayFile generate replay file
@@ -0,0 +1,53 @@
+/**
+ * models/token.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// TokenPrefix prefix of api token secret, for recognizing leaked tokens
+const TokenPrefix = "bkr_"
+
+// Token personal access token for api
+type Token struct {
+	Model
+	UserID     uint       `orm:"not null;index" json:"userId"`         // user id
+	Name       string     `orm:"not null" json:"name"`                 // name for this token, for memorize
+	Digest     string     `orm:"not null;unique_index" json:"-"`       // sha256 digest of token secret
+	Hint       string     `orm:"not null" json:"hint"`                 // leading characters of token secret, for display
+	IsReadOnly int        `orm:"not null;default:0" json:"isReadOnly"` // token can only be used for GET requests
+	IsAdmin    int        `orm:"not null;default:0" json:"isAdmin"`    // token can be used for admin apis, if user is admin
+	ExpiresAt  *time.Time `orm:"index" json:"expiresAt"`               // token expires at, nil for never
+	UsedAt     *time.Time `orm:"" json:"usedAt"`                       // last seen at
+}
+
+// IsExpired check whether token is expired
+func (t Token) IsExpired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// TokenDigest digest of token secret
+func TokenDigest(secret string) string {
+	s := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(s[:])
+}
+
+// NewTokenSecret generate a new token secret, only digest is stored
+func NewTokenSecret() (secret string, err error) {
+	buf := make([]byte, 20)
+	if _, err = rand.Read(buf); err != nil {
+		return
+	}
+	secret = TokenPrefix + hex.EncodeToString(buf)
+	return
+}
@@ -0,0 +1,34 @@
+/**
+ * models/transcript.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package models
+
+import "time"
+
+// TranscriptLine a line of session transcript, content is indexed in "transcript_lines_fts" for full-text search
+type TranscriptLine struct {
+	ID          uint      `orm:"primary_key" json:"id"`
+	SessionID   uint      `orm:"not null;index" json:"sessionId"`
+	UserAccount string    `orm:"index" json:"userAccount"`
+	TargetUser  string    `orm:"" json:"targetUser"`                        // target user, empty for sandbox session
+	ServerName  string    `orm:"index" json:"serverName"`                   // target server name, empty for sandbox session
+	At          time.Time `orm:"index" json:"at"`                           // time the line was printed
+	Offset      int64     `orm:"not null;default:0" json:"offset"`          // milliseconds since start of replay
+	IsCommand   int       `orm:"not null;default:0;index" json:"isCommand"` // typed command after shell prompt
+	Content     string    `orm:"type:text" json:"content"`                  // command if IsCommand, otherwise output text
+}
+
+// TranscriptQuery transcript search query, empty fields are ignored
+type TranscriptQuery struct {
+	Phrase       string     // phrase to match
+	User         string     // user account
+	Server       string     // target server name
+	Since        *time.Time // lines printed after
+	Until        *time.Time // lines printed before
+	CommandsOnly bool       // only typed commands
+}
@@ -0,0 +1,23 @@
+/**
+ * transfer.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package models
+
+// FileTransfer file transfer recorded in a sftp or scp session
+type FileTransfer struct {
+	Model
+	SessionID   uint   `orm:"not null;index" json:"sessionId"`
+	UserAccount string `orm:"index" json:"userAccount"`
+	TargetUser  string `orm:"" json:"targetUser"`
+	ServerName  string `orm:"index" json:"serverName"`
+	Operation   string `orm:"index" json:"operation"` // "read", "write", "remove", "rename", "mkdir", "scp" etc
+	Path        string `orm:"type:text" json:"path"`
+	NewPath     string `orm:"type:text" json:"newPath"` // for "rename", "symlink" and "link"
+	Bytes       int64  `orm:"not null;default:0" json:"bytes"`
+	Error       string `orm:"type:text" json:"error"`
+}
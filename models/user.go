@@ -9,22 +9,55 @@
 package models
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"strings"
 	"time"
 
+	"github.com/yankeguo/bunker/types"
+	"github.com/yankeguo/bunker/utils"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // User user model
 type User struct {
 	Model
-	Account        string     `orm:"not null;unique_index" json:"account"` // account name
-	PasswordDigest string     `orm:"not null;type:text" json:"-"`          // digest of password
-	IsAdmin        int        `orm:"not null;default:0" json:"isAdmin"`    // is this user system admin
-	IsBlocked      int        `orm:"not null;default:0" json:"isBlocked"`  // is this user blocked
-	UsedAt         *time.Time `orm:"" json:"usedAt"`                       // last seen at
+	Account        string     `orm:"not null;unique_index" json:"account"`     // account name
+	PasswordDigest string     `orm:"not null;type:text" json:"-"`              // digest of password
+	IsAdmin        int        `orm:"not null;default:0" json:"isAdmin"`        // is this user system admin
+	IsBlocked      int        `orm:"not null;default:0" json:"isBlocked"`      // is this user blocked
+	IsDirBlocked   int        `orm:"not null;default:0" json:"isDirBlocked"`   // is the block set by ldap sync for missing from directory, cleared when back
+	IsAgentAllowed int        `orm:"not null;default:0" json:"isAgentAllowed"` // is ssh agent forwarding into sandbox allowed
+	SandboxCPUs    float64    `orm:"not null;default:0" json:"sandboxCpus"`    // sandbox cpus override, 0 for default
+	SandboxMemory  int64      `orm:"not null;default:0" json:"sandboxMemory"`  // sandbox memory override in MB, 0 for default
+	SandboxPids    int64      `orm:"not null;default:0" json:"sandboxPids"`    // sandbox pids override, 0 for default
+	SandboxDisk    int64      `orm:"not null;default:0" json:"sandboxDisk"`    // sandbox disk override in MB, 0 for default
+	IsTOTPEnabled  int        `orm:"not null;default:0" json:"isTotpEnabled"`  // is two-factor authentication enabled
+	TOTPSecret     string     `orm:"" json:"-"`                                // base32 totp secret
+	TOTPCounter    int64      `orm:"not null;default:0" json:"-"`              // last used totp counter, prevents replay
+	RecoveryCodes  string     `orm:"type:text" json:"-"`                       // digests of unused recovery codes, comma separated
+	Source         string     `orm:"index" json:"source"`                      // where the user comes from, UserSourceXXX
+	IsPasswordOff  int        `orm:"not null;default:0" json:"isPasswordOff"`  // is local password login disabled for this user
+	IsOIDCLinked   int        `orm:"not null;default:0" json:"isOidcLinked"`   // is openid connect login allowed for a local or ldap user, linked by admin
+	UsedAt         *time.Time `orm:"" json:"usedAt"`                           // last seen at
+
+	FailedLogins      int        `orm:"not null;default:0" json:"failedLogins"` // consecutive failed web logins within failure window
+	LastFailedLoginAt *time.Time `orm:"" json:"lastFailedLoginAt"`              // last failed web login, kept after successful login
+	LastFailedLoginIP string     `orm:"" json:"lastFailedLoginIp"`              // source ip of last failed web login
+	LockedUntil       *time.Time `orm:"" json:"lockedUntil"`                    // web login is locked until, for too many failed logins
 }
 
+const (
+	// UserSourceLocal user created in bunker, authenticated by password digest
+	UserSourceLocal = ""
+	// UserSourceLDAP user synced from ldap, authenticated by directory
+	UserSourceLDAP = "ldap"
+	// UserSourceOIDC user created on first openid connect login
+	UserSourceOIDC = "oidc"
+)
+
 // BeforeSave before save callback
 func (u *User) BeforeSave() (err error) {
 	if !NamePattern.MatchString(u.Account) {
@@ -44,6 +77,58 @@ func (u *User) SetPassword(p string) (err error) {
 	return
 }
 
+// IsLocked whether web login is locked for too many failed logins
+func (u User) IsLocked() bool {
+	return u.LockedUntil != nil && time.Now().Before(*u.LockedUntil)
+}
+
+// SandboxLimits returns sandbox limits of user, falls back to defaults in config
+func (u User) SandboxLimits(cfg types.Config) types.SandboxLimits {
+	return cfg.Sandbox.Limits.Merge(types.SandboxLimits{
+		CPUs:   u.SandboxCPUs,
+		Memory: u.SandboxMemory,
+		Pids:   u.SandboxPids,
+		Disk:   u.SandboxDisk,
+	})
+}
+
+// IsTOTPRequired check whether user must enroll two-factor authentication before continuing
+func (u User) IsTOTPRequired(cfg types.Config) bool {
+	return !utils.ToBool(u.IsTOTPEnabled) && cfg.TOTP.IsEnforced(utils.ToBool(u.IsAdmin))
+}
+
+// RecoveryCodeDigest digest of recovery code, dashes and case are ignored
+func RecoveryCodeDigest(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	s := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(s[:])
+}
+
+// NewRecoveryCodes generate n recovery codes, returns codes and comma separated digests for storage
+func NewRecoveryCodes(n int) (codes []string, digests string, err error) {
+	ds := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		buf := make([]byte, 5)
+		if _, err = rand.Read(buf); err != nil {
+			return
+		}
+		c := hex.EncodeToString(buf)
+		c = c[:5] + "-" + c[5:]
+		codes = append(codes, c)
+		ds = append(ds, RecoveryCodeDigest(c))
+	}
+	digests = strings.Join(ds, ",")
+	return
+}
+
+// CountRecoveryCodes count unused recovery codes
+func (u User) CountRecoveryCodes() int {
+	if len(u.RecoveryCodes) == 0 {
+		return 0
+	}
+	return len(strings.Split(u.RecoveryCodes, ","))
+}
+
 // CheckPassword check password
 func (u *User) CheckPassword(p string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(u.PasswordDigest), []byte(p)) == nil
@@ -0,0 +1,91 @@
+/**
+ * reaper.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package bunker
+
+import (
+	"log"
+	"time"
+
+	"github.com/yankeguo/bunker/sandbox"
+	"github.com/yankeguo/bunker/types"
+)
+
+// Reaper stops idle sandboxes
+type Reaper struct {
+	Config         types.Config
+	sandboxManager sandbox.Manager
+	sandboxTracker *sandbox.Tracker
+	stopFlag       bool
+	done           chan bool
+}
+
+// NewReaper new reaper
+func NewReaper(config types.Config) *Reaper {
+	return &Reaper{Config: config, done: make(chan bool, 1)}
+}
+
+// ListenAndServe implements utils.Server
+func (r *Reaper) ListenAndServe() (err error) {
+	if r.Config.Sandbox.IdleTimeout <= 0 {
+		r.done <- true
+		return
+	}
+	if r.sandboxManager == nil {
+		if r.sandboxManager, err = sandbox.NewManager(r.Config); err != nil {
+			r.done <- true
+			return
+		}
+	}
+	if r.sandboxTracker == nil {
+		r.sandboxTracker = sandbox.NewTracker()
+	}
+	var last time.Time
+	for {
+		if r.stopFlag {
+			break
+		}
+		if time.Since(last) > time.Second*30 {
+			r.reap()
+			last = time.Now()
+		}
+		time.Sleep(time.Second)
+	}
+	r.done <- true
+	return
+}
+
+func (r *Reaper) reap() {
+	var err error
+	var ss []sandbox.State
+	if ss, err = r.sandboxManager.List(); err != nil {
+		log.Println("Reaper:", err)
+		return
+	}
+	timeout := time.Minute * time.Duration(r.Config.Sandbox.IdleTimeout)
+	for _, s := range ss {
+		if !s.IsRunning {
+			continue
+		}
+		conns, last := r.sandboxTracker.Activity(s.Account)
+		if conns > 0 || time.Since(last) < timeout {
+			continue
+		}
+		log.Println("Reaper: stopping idle sandbox", s.Name)
+		if err = r.sandboxManager.Stop(s.Account); err != nil {
+			log.Println("Reaper:", err)
+		}
+	}
+}
+
+// Shutdown implements utils.Server
+func (r *Reaper) Shutdown() (err error) {
+	r.stopFlag = true
+	<-r.done
+	return
+}
@@ -0,0 +1,83 @@
+/**
+ * routes/api.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/utils"
+	"landzero.net/x/net/web"
+)
+
+// APIAuth api auth result, injected by MustAPIToken
+type APIAuth struct {
+	User  *models.User
+	Token *models.Token
+}
+
+// IsAdmin token is admin token and user is admin
+func (a *APIAuth) IsAdmin() bool {
+	return utils.ToBool(a.Token.IsAdmin) && utils.ToBool(a.User.IsAdmin)
+}
+
+// APIError render a json error
+func APIError(ctx *web.Context, code int, msg string) {
+	ctx.JSON(code, map[string]interface{}{"error": msg})
+}
+
+// APIDecode decode json request body, renders error and returns false if failed
+func APIDecode(ctx *web.Context, v interface{}) bool {
+	buf, err := ctx.Req.Body().Bytes()
+	if err == nil {
+		err = json.Unmarshal(buf, v)
+	}
+	if err != nil {
+		APIError(ctx, http.StatusBadRequest, "invalid json body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// apiTokenFromRequest extract token secret from "Authorization: Bearer xxx" or "Authorization: token xxx"
+func apiTokenFromRequest(r *http.Request) string {
+	h := strings.TrimSpace(r.Header.Get("Authorization"))
+	if i := strings.Index(h, " "); i > 0 {
+		switch strings.ToLower(h[:i]) {
+		case "bearer", "token":
+			return strings.TrimSpace(h[i+1:])
+		}
+	}
+	return ""
+}
+
+// MustAPIToken requires a valid api token, read-only tokens are limited to GET requests, admin token is required if admin is set
+func MustAPIToken(admin bool) web.Handler {
+	return func(ctx *web.Context, db *models.DB) {
+		u, t, err := db.FindUserByToken(apiTokenFromRequest(ctx.Req.Request))
+		if err != nil {
+			APIError(ctx, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if utils.ToBool(t.IsReadOnly) && ctx.Req.Method != http.MethodGet && ctx.Req.Method != http.MethodHead {
+			APIError(ctx, http.StatusForbidden, "read-only token")
+			return
+		}
+		a := &APIAuth{User: u, Token: t}
+		if admin && !a.IsAdmin() {
+			APIError(ctx, http.StatusForbidden, "admin token required")
+			return
+		}
+		ctx.Map(a)
+		mapAuditor(ctx, db, func() (string, string) { return models.AuditActorToken, u.Account })
+		ctx.Next()
+	}
+}
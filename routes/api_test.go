@@ -0,0 +1,30 @@
+/**
+ *Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ *This software is released under the MIT License.
+ *https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAPITokenFromRequest(t *testing.T) {
+	cases := map[string]string{
+		"Bearer bkr_abc": "bkr_abc",
+		"token bkr_abc ": "bkr_abc",
+		"Basic dXNlcjpw": "",
+		"bkr_abc":        "",
+		"":               "",
+	}
+	for h, exp := range cases {
+		r, _ := http.NewRequest(http.MethodGet, "/api/v1/user", nil)
+		r.Header.Set("Authorization", h)
+		if out := apiTokenFromRequest(r); out != exp {
+			t.Errorf("%q: expected %q, got %q", h, exp, out)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+/**
+ * routes/audit.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/yankeguo/bunker/models"
+	"landzero.net/x/net/web"
+)
+
+// Auditor record audit events on behalf of the actor of current request, injected by Authenticator, MustAPIToken and MustSecret
+type Auditor struct {
+	db       *models.DB
+	sourceIP string
+	actor    func() (typ string, name string)
+}
+
+// Record record a audit event, before and after are states of target, nil if target not exists
+func (au *Auditor) Record(action string, target string, before interface{}, after interface{}) {
+	typ, name := au.actor()
+	if err := au.db.Audit(typ, name, au.sourceIP, action, target, before, after); err != nil {
+		log.Printf("Audit: failed to record %s on %s by %s: %s", action, target, name, err.Error())
+	}
+}
+
+// mapAuditor map a Auditor with actor to context
+func mapAuditor(ctx *web.Context, db *models.DB, actor func() (string, string)) {
+	ctx.Map(&Auditor{db: db, sourceIP: ctx.RemoteAddr(), actor: actor})
+}
+
+// auditTarget format audit target, "user:alice", "grant:12"
+func auditTarget(kind string, key interface{}) string {
+	return fmt.Sprintf("%s:%v", kind, key)
+}
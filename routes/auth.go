@@ -11,6 +11,7 @@ package routes
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/yankeguo/bunker/models"
 	"github.com/yankeguo/bunker/types"
@@ -19,10 +20,22 @@ import (
 	"landzero.net/x/net/web/session"
 )
 
+const (
+	// AuthMethodPassword signed in with local password
+	AuthMethodPassword = "password"
+	// AuthMethodLDAP signed in with ldap password
+	AuthMethodLDAP = "ldap"
+	// AuthMethodOIDC signed in with openid connect
+	AuthMethodOIDC = "oidc"
+	// AuthMethodTOTPSuffix appended to method if second factor is verified
+	AuthMethodTOTPSuffix = "+totp"
+)
+
 // Auth auth result
 type Auth interface {
 	User() *models.User
-	SetUser(u *models.User)
+	Method() string
+	SetUser(u *models.User, method string)
 	SignedIn() bool
 	SignedInAsAdmin() bool
 }
@@ -52,20 +65,30 @@ func (a *auth) User() *models.User {
 			a.user = &u
 		} else {
 			// clear user_id if failed to find
-			a.SetUser(nil)
+			a.SetUser(nil, "")
 		}
 	}
 	a.fetched = true
 	return a.user
 }
 
-func (a *auth) SetUser(u *models.User) {
+func (a *auth) Method() string {
+	if a.User() == nil {
+		return ""
+	}
+	m, _ := a.sess.Get("auth_method").(string)
+	return m
+}
+
+func (a *auth) SetUser(u *models.User, method string) {
 	a.fetched = true
 	a.user = u
 	if u == nil {
 		a.sess.Delete("user_id")
+		a.sess.Delete("auth_method")
 	} else {
 		a.sess.Set("user_id", fmt.Sprintf("%d", u.ID))
+		a.sess.Set("auth_method", method)
 	}
 }
 
@@ -76,6 +99,12 @@ func Authenticator() web.Handler {
 		// inject
 		ctx.Data["Auth"] = a
 		ctx.MapTo(a, (*Auth)(nil))
+		mapAuditor(ctx, db, func() (string, string) {
+			if u := a.User(); u != nil {
+				return models.AuditActorUser, u.Account
+			}
+			return models.AuditActorUser, ""
+		})
 		ctx.Next()
 	}
 }
@@ -91,11 +120,22 @@ func MustNotSignedIn() web.Handler {
 	}
 }
 
+// mustEnrollTOTP check whether signed in user must enroll two-factor authentication first
+func mustEnrollTOTP(a Auth, ctx *web.Context, cfg types.Config) bool {
+	if !a.User().IsTOTPRequired(cfg) {
+		return false
+	}
+	p := ctx.Req.URL.Path
+	return p != "/logout" && !strings.HasPrefix(p, "/settings/totp")
+}
+
 // MustSignedIn requires signed in
 func MustSignedIn() web.Handler {
-	return func(a Auth, ctx *web.Context) {
+	return func(a Auth, ctx *web.Context, cfg types.Config) {
 		if !a.SignedIn() {
 			ctx.Redirect("/login", http.StatusTemporaryRedirect)
+		} else if mustEnrollTOTP(a, ctx, cfg) {
+			ctx.Redirect("/settings/totp", http.StatusTemporaryRedirect)
 		} else {
 			ctx.Next()
 		}
@@ -104,13 +144,15 @@ func MustSignedIn() web.Handler {
 
 // MustSignedInAsAdmin requires signed in as admin
 func MustSignedInAsAdmin() web.Handler {
-	return func(a Auth, ctx *web.Context) {
+	return func(a Auth, ctx *web.Context, cfg types.Config) {
 		if !a.SignedInAsAdmin() {
 			if a.SignedIn() {
 				ctx.Redirect("/", http.StatusTemporaryRedirect)
 			} else {
 				ctx.Redirect("/login", http.StatusTemporaryRedirect)
 			}
+		} else if mustEnrollTOTP(a, ctx, cfg) {
+			ctx.Redirect("/settings/totp", http.StatusTemporaryRedirect)
 		} else {
 			ctx.Next()
 		}
@@ -119,11 +161,12 @@ func MustSignedInAsAdmin() web.Handler {
 
 // MustSecret must assign secret
 func MustSecret() web.Handler {
-	return func(ctx *web.Context, cfg types.Config) {
+	return func(ctx *web.Context, cfg types.Config, db *models.DB) {
 		if cfg.Secret != ctx.Req.URL.Query().Get("secret") {
 			ctx.PlainText(403, []byte("invalid secret"))
 			return
 		}
+		mapAuditor(ctx, db, func() (string, string) { return models.AuditActorSecret, "" })
 		ctx.Next()
 	}
 }
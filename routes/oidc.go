@@ -0,0 +1,146 @@
+/**
+ * routes/oidc.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/yankeguo/bunker/types"
+	"golang.org/x/oauth2"
+)
+
+// OIDC openid connect client, provider is discovered on first use
+type OIDC struct {
+	Config   types.OIDCConfig
+	Domain   string
+	lock     sync.Mutex
+	provider *oidc.Provider
+}
+
+// NewOIDC create a openid connect client
+func NewOIDC(cfg types.OIDCConfig, domain string) *OIDC {
+	return &OIDC{Config: cfg, Domain: domain}
+}
+
+func (o *OIDC) getProvider(ctx context.Context) (p *oidc.Provider, err error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.provider == nil {
+		if o.provider, err = oidc.NewProvider(ctx, o.Config.Issuer); err != nil {
+			return
+		}
+	}
+	p = o.provider
+	return
+}
+
+func (o *OIDC) oauth2Config(p *oidc.Provider) *oauth2.Config {
+	redirectURL := o.Config.RedirectURL
+	if len(redirectURL) == 0 {
+		redirectURL = strings.TrimRight(o.Domain, "/") + "/login/oidc/callback"
+	}
+	return &oauth2.Config{
+		ClientID:     o.Config.ClientID,
+		ClientSecret: o.Config.ClientSecret,
+		RedirectURL:  redirectURL,
+		Endpoint:     p.Endpoint(),
+		Scopes:       append([]string{oidc.ScopeOpenID}, o.Config.Scopes...),
+	}
+}
+
+// AuthCodeURL url of provider to redirect to
+func (o *OIDC) AuthCodeURL(ctx context.Context, state, nonce string) (string, error) {
+	p, err := o.getProvider(ctx)
+	if err != nil {
+		return "", err
+	}
+	return o.oauth2Config(p).AuthCodeURL(state, oidc.Nonce(nonce)), nil
+}
+
+// Exchange exchange code for id token, verify it and returns claims
+func (o *OIDC) Exchange(ctx context.Context, code, nonce string) (claims map[string]interface{}, err error) {
+	var p *oidc.Provider
+	if p, err = o.getProvider(ctx); err != nil {
+		return
+	}
+	var t *oauth2.Token
+	if t, err = o.oauth2Config(p).Exchange(ctx, code); err != nil {
+		return
+	}
+	raw, ok := t.Extra("id_token").(string)
+	if !ok {
+		err = errors.New("no id_token in token response")
+		return
+	}
+	var it *oidc.IDToken
+	if it, err = p.Verifier(&oidc.Config{ClientID: o.Config.ClientID}).Verify(ctx, raw); err != nil {
+		return
+	}
+	if it.Nonce != nonce {
+		err = errors.New("invalid nonce in id_token")
+		return
+	}
+	err = it.Claims(&claims)
+	return
+}
+
+// OIDCAccount map claims to account name
+func OIDCAccount(cfg types.OIDCConfig, claims map[string]interface{}) (string, error) {
+	name := cfg.AccountClaim
+	if len(name) == 0 {
+		name = "preferred_username"
+	}
+	a, _ := claims[name].(string)
+	if cfg.StripDomain {
+		if i := strings.Index(a, "@"); i >= 0 {
+			a = a[:i]
+		}
+	}
+	a = strings.ToLower(strings.TrimSpace(a))
+	if len(a) == 0 {
+		return "", fmt.Errorf("missing claim %s", name)
+	}
+	return a, nil
+}
+
+// OIDCIsAdmin check admin groups in claims, nil if no admin group configured
+func OIDCIsAdmin(cfg types.OIDCConfig, claims map[string]interface{}) *bool {
+	if len(cfg.AdminGroups) == 0 {
+		return nil
+	}
+	name := cfg.GroupsClaim
+	if len(name) == 0 {
+		name = "groups"
+	}
+	gs := []string{}
+	switch v := claims[name].(type) {
+	case string:
+		gs = append(gs, v)
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				gs = append(gs, s)
+			}
+		}
+	}
+	isAdmin := false
+	for _, g := range gs {
+		for _, ag := range cfg.AdminGroups {
+			if g == ag {
+				isAdmin = true
+			}
+		}
+	}
+	return &isAdmin
+}
@@ -0,0 +1,49 @@
+/**
+ *Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ *This software is released under the MIT License.
+ *https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"testing"
+
+	"github.com/yankeguo/bunker/types"
+)
+
+func TestOIDCAccount(t *testing.T) {
+	claims := map[string]interface{}{
+		"preferred_username": "Alice",
+		"email":              "alice.w@example.com",
+	}
+	if a, err := OIDCAccount(types.OIDCConfig{}, claims); err != nil || a != "alice" {
+		t.Error("failed to map preferred_username", a, err)
+	}
+	if a, err := OIDCAccount(types.OIDCConfig{AccountClaim: "email", StripDomain: true}, claims); err != nil || a != "alice.w" {
+		t.Error("failed to map email", a, err)
+	}
+	if _, err := OIDCAccount(types.OIDCConfig{AccountClaim: "sub"}, claims); err == nil {
+		t.Error("should fail on missing claim")
+	}
+}
+
+func TestOIDCIsAdmin(t *testing.T) {
+	claims := map[string]interface{}{
+		"groups": []interface{}{"dev", "ops"},
+		"role":   "admin",
+	}
+	if OIDCIsAdmin(types.OIDCConfig{}, claims) != nil {
+		t.Error("admin status should not be managed")
+	}
+	if v := OIDCIsAdmin(types.OIDCConfig{AdminGroups: []string{"ops"}}, claims); v == nil || !*v {
+		t.Error("should be admin")
+	}
+	if v := OIDCIsAdmin(types.OIDCConfig{AdminGroups: []string{"sre"}}, claims); v == nil || *v {
+		t.Error("should not be admin")
+	}
+	if v := OIDCIsAdmin(types.OIDCConfig{GroupsClaim: "role", AdminGroups: []string{"admin"}}, claims); v == nil || !*v {
+		t.Error("should be admin with string claim")
+	}
+}
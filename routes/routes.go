@@ -23,6 +23,10 @@ func Mount(w *web.Web) {
 	w.Get("/", MustSignedIn(), GetIndex).Name("index")
 	w.Get("/login", MustNotSignedIn(), GetLogin).Name("login")
 	w.Post("/login", MustNotSignedIn(), csrf.Validate, binding.Form(LoginForm{}), PostLogin)
+	w.Get("/login/totp", MustNotSignedIn(), GetLoginTOTP).Name("login-totp")
+	w.Get("/login/oidc", MustNotSignedIn(), GetLoginOIDC).Name("login-oidc")
+	w.Get("/login/oidc/callback", MustNotSignedIn(), GetLoginOIDCCallback)
+	w.Post("/login/totp", MustNotSignedIn(), csrf.Validate, binding.Form(TOTPForm{}), PostLoginTOTP)
 	w.Post("/logout", MustSignedIn(), csrf.Validate, PostLogout)
 	/* settings */
 	w.Get("/settings/profile", MustSignedIn(), GetSettingsProfile).Name("profile")
@@ -32,33 +36,114 @@ func Mount(w *web.Web) {
 	w.Get("/settings/ssh-keys/new", MustSignedIn(), GetSettingsSSHKeysNew).Name("new-ssh-key")
 	w.Post("/settings/ssh-keys", MustSignedIn(), csrf.Validate, binding.Form(SSHKeyCreateForm{}), PostSettingsSSHKeysCreate)
 	w.Post("/settings/ssh-keys/:id/destroy", MustSignedIn(), csrf.Validate, PostSettingsSSHKeysDestroy).Name("destroy-ssh-key")
+	w.Get("/settings/tokens", MustSignedIn(), GetSettingsTokensIndex).Name("tokens")
+	w.Post("/settings/tokens", MustSignedIn(), csrf.Validate, binding.Form(TokenCreateForm{}), PostSettingsTokensCreate)
+	w.Post("/settings/tokens/:id/destroy", MustSignedIn(), csrf.Validate, PostSettingsTokensDestroy).Name("destroy-token")
+	w.Get("/settings/certificates", MustSignedIn(), GetSettingsCertificates).Name("my-certificates")
+	w.Post("/settings/certificates", MustSignedIn(), csrf.Validate, binding.Form(CertificateCreateForm{}), PostSettingsCertificatesCreate)
+	w.Post("/settings/certificates/:id/revoke", MustSignedIn(), csrf.Validate, PostSettingsCertificateRevoke)
+	w.Get("/settings/totp", MustSignedIn(), GetSettingsTOTP).Name("totp")
+	w.Post("/settings/totp/enable", MustSignedIn(), csrf.Validate, binding.Form(TOTPForm{}), PostSettingsTOTPEnable)
+	w.Post("/settings/totp/recovery-codes", MustSignedIn(), csrf.Validate, binding.Form(TOTPForm{}), PostSettingsTOTPRecoveryCodes)
+	w.Post("/settings/totp/disable", MustSignedIn(), csrf.Validate, binding.Form(TOTPForm{}), PostSettingsTOTPDisable)
 	/* servers */
 	w.Get("/servers", MustSignedInAsAdmin(), GetServersIndex).Name("servers")
 	w.Get("/servers/new", MustSignedInAsAdmin(), GetServersNew).Name("new-server")
 	w.Get("/servers/master-key", MustSignedInAsAdmin(), GetMasterKey).Name("master-key")
+	w.Get("/servers/host-keys", MustSignedInAsAdmin(), GetServerHostKeys).Name("host-keys")
+	w.Get("/servers/credentials", MustSignedInAsAdmin(), GetCredentialsIndex).Name("credentials")
+	w.Post("/servers/credentials", MustSignedInAsAdmin(), csrf.Validate, binding.Form(CredentialCreateForm{}), PostCredentialCreate)
+	w.Post("/servers/credentials/:id/destroy", MustSignedInAsAdmin(), csrf.Validate, PostCredentialDestroy).Name("destroy-credential")
 	w.Post("/servers", MustSignedInAsAdmin(), csrf.Validate, binding.Form(ServerCreateForm{}), PostServerCreate)
 	w.Get("/servers/:id/edit", MustSignedInAsAdmin(), GetServerEdit).Name("edit-server")
 	w.Post("/servers/:id/update", MustSignedInAsAdmin(), csrf.Validate, binding.Form(ServerCreateForm{}), PostServerUpdate).Name("update-server")
 	w.Post("/servers/:id/destroy", MustSignedInAsAdmin(), csrf.Validate, PostServerDestroy).Name("destroy-server")
+	w.Post("/servers/:id/host-key/accept", MustSignedInAsAdmin(), csrf.Validate, PostServerHostKeyAccept).Name("accept-host-key")
+	w.Post("/servers/:id/host-key/reset", MustSignedInAsAdmin(), csrf.Validate, PostServerHostKeyReset).Name("reset-host-key")
 	/* users */
 	w.Get("/users", MustSignedInAsAdmin(), GetUsersIndex).Name("users")
 	w.Get("/users/new", MustSignedInAsAdmin(), GetUsersNew).Name("new-user")
 	w.Post("/users", MustSignedInAsAdmin(), csrf.Validate, binding.Form(UserAddForm{}), PostUsersCreate)
 	w.Post("/users/:id/update", MustSignedInAsAdmin(), csrf.Validate, binding.Form(UserUpdateForm{}), PostUserUpdate).Name("update-user")
+	w.Get("/users/:id/sandbox-limits", MustSignedInAsAdmin(), GetUserSandboxLimits).Name("user-sandbox-limits")
+	w.Post("/users/:id/sandbox-limits", MustSignedInAsAdmin(), csrf.Validate, binding.Form(UserSandboxLimitsForm{}), PostUserSandboxLimits)
 	/* grants */
 	w.Get("/users/:userid/grants", MustSignedInAsAdmin(), GetGrantsIndex).Name("user-grants")
 	w.Post("/users/:userid/grants", MustSignedInAsAdmin(), csrf.Validate, binding.Form(GrantCreateForm{}), PostGrantsCreate)
 	w.Post("/users/:userid/grants/:id/destroy", MustSignedInAsAdmin(), csrf.Validate, PostGrantDestroy).Name("user-destroy-grant")
+	/* grant requests */
+	w.Get("/grant-requests", MustSignedIn(), GetGrantRequestsIndex).Name("grant-requests")
+	w.Post("/grant-requests", MustSignedIn(), csrf.Validate, binding.Form(GrantRequestForm{}), PostGrantRequestsCreate)
+	w.Post("/grant-requests/:id/cancel", MustSignedIn(), csrf.Validate, PostGrantRequestCancel).Name("cancel-grant-request")
+	w.Get("/grant-requests/review", MustSignedIn(), GetGrantRequestsReview).Name("review-grant-requests")
+	w.Post("/grant-requests/:id/approve", MustSignedIn(), csrf.Validate, binding.Form(GrantRequestReviewForm{}), PostGrantRequestApprove).Name("approve-grant-request")
+	w.Post("/grant-requests/:id/deny", MustSignedIn(), csrf.Validate, binding.Form(GrantRequestReviewForm{}), PostGrantRequestDeny).Name("deny-grant-request")
+	/* groups */
+	w.Get("/groups", MustSignedInAsAdmin(), GetGroupsIndex).Name("groups")
+	w.Post("/groups", MustSignedInAsAdmin(), csrf.Validate, binding.Form(GroupCreateForm{}), PostGroupsCreate)
+	w.Post("/groups/:id/destroy", MustSignedInAsAdmin(), csrf.Validate, PostGroupDestroy).Name("destroy-group")
+	w.Get("/groups/:id/members", MustSignedInAsAdmin(), GetGroupMembersIndex).Name("group-members")
+	w.Post("/groups/:id/members", MustSignedInAsAdmin(), csrf.Validate, binding.Form(GroupMemberCreateForm{}), PostGroupMembersCreate)
+	w.Post("/groups/:id/members/:memberid/destroy", MustSignedInAsAdmin(), csrf.Validate, PostGroupMemberDestroy).Name("destroy-group-member")
+	/* group grants */
+	w.Get("/groups/:groupid/grants", MustSignedInAsAdmin(), GetGroupGrantsIndex).Name("group-grants")
+	w.Post("/groups/:groupid/grants", MustSignedInAsAdmin(), csrf.Validate, binding.Form(GrantCreateForm{}), PostGroupGrantsCreate)
+	w.Post("/groups/:groupid/grants/:id/destroy", MustSignedInAsAdmin(), csrf.Validate, PostGroupGrantDestroy).Name("group-destroy-grant")
 	/* hints */
 	w.Get("/api/hints/users", MustSignedInAsAdmin(), GetUserHints)
 	w.Get("/api/hints/servers", MustSignedInAsAdmin(), GetServerHints)
 	w.Get("/api/hints/target-users", MustSignedInAsAdmin(), GetTargetUserHints)
-	/* import */
+	/* import, deprecated, use /api/v1/servers/import with an admin token instead */
 	w.Post("/api/import/ssh_config", MustSecret(), PostImportSSHConfig)
+	/* api v1, authenticated by personal access token */
+	w.Get("/api/v1/user", MustAPIToken(false), GetAPIUser)
+	w.Get("/api/v1/user/keys", MustAPIToken(false), GetAPIKeys)
+	w.Post("/api/v1/user/keys", MustAPIToken(false), PostAPIKeys)
+	w.Delete("/api/v1/user/keys/:id", MustAPIToken(false), DeleteAPIKey)
+	w.Get("/api/v1/users", MustAPIToken(true), GetAPIUsers)
+	w.Post("/api/v1/users", MustAPIToken(true), PostAPIUsers)
+	w.Get("/api/v1/users/:account", MustAPIToken(true), GetAPIUser)
+	w.Patch("/api/v1/users/:account", MustAPIToken(true), PatchAPIUser)
+	w.Get("/api/v1/users/:account/keys", MustAPIToken(true), GetAPIKeys)
+	w.Post("/api/v1/users/:account/keys", MustAPIToken(true), PostAPIKeys)
+	w.Delete("/api/v1/users/:account/keys/:id", MustAPIToken(true), DeleteAPIKey)
+	w.Get("/api/v1/servers", MustAPIToken(true), GetAPIServers)
+	w.Post("/api/v1/servers/import", MustAPIToken(true), PostImportSSHConfig)
+	w.Get("/api/v1/servers/:name", MustAPIToken(true), GetAPIServer)
+	w.Put("/api/v1/servers/:name", MustAPIToken(true), PutAPIServer)
+	w.Delete("/api/v1/servers/:name", MustAPIToken(true), DeleteAPIServer)
+	w.Get("/api/v1/grants", MustAPIToken(true), GetAPIGrants)
+	w.Post("/api/v1/grants", MustAPIToken(true), PostAPIGrants)
+	w.Delete("/api/v1/grants/:id", MustAPIToken(true), DeleteAPIGrant)
+	w.Get("/api/v1/sessions", MustAPIToken(true), GetAPISessions)
+	w.Get("/api/v1/sessions/:id", MustAPIToken(true), GetAPISession)
+	w.Get("/api/v1/sessions/:id/replay", MustAPIToken(true), GetAPISessionReplay)
+	w.Get("/api/v1/transcripts", MustAPIToken(true), GetAPITranscripts)
+	w.Get("/api/v1/audit", MustAPIToken(true), GetAPIAudit)
+	w.Get("/api/v1/auth-failures", MustAPIToken(true), GetAPIAuthFailures)
 	/* sessions */
 	w.Get("/sessions", MustSignedInAsAdmin(), GetSessionsIndex).Name("sessions")
 	w.Get("/sessions/:id/file", MustSignedInAsAdmin(), GetSessionFile).Name("session-file")
 	w.Get("/sessions/:id/replay", MustSignedInAsAdmin(), GetSessionReplay).Name("session-replay")
+	w.Get("/sessions/:id/live", MustSignedInAsAdmin(), GetSessionLive).Name("session-live")
+	w.Get("/sessions/:id/live/stream", MustSignedInAsAdmin(), GetSessionLiveStream).Name("session-live-stream")
+	w.Post("/sessions/:id/terminate", MustSignedInAsAdmin(), csrf.Validate, PostSessionTerminate).Name("terminate-session")
+	w.Post("/sessions/:id/message", MustSignedInAsAdmin(), csrf.Validate, binding.Form(SessionMessageForm{}), PostSessionMessage).Name("session-message")
+	w.Get("/transfers", MustSignedInAsAdmin(), GetTransfersIndex).Name("transfers")
+	w.Get("/transcripts", MustSignedInAsAdmin(), GetTranscriptsIndex).Name("transcripts")
+	/* sandboxes */
+	w.Get("/sandboxes", MustSignedInAsAdmin(), GetSandboxesIndex).Name("sandboxes")
+	w.Post("/sandboxes/:account/stop", MustSignedInAsAdmin(), csrf.Validate, PostSandboxStop).Name("stop-sandbox")
+	w.Post("/sandboxes/:account/reset", MustSignedInAsAdmin(), csrf.Validate, PostSandboxReset).Name("reset-sandbox")
+	w.Post("/sandboxes/:account/destroy", MustSignedInAsAdmin(), csrf.Validate, binding.Form(SandboxDestroyForm{}), PostSandboxDestroy).Name("destroy-sandbox")
+	/* certificates */
+	w.Get("/certificates", MustSignedInAsAdmin(), GetCertificatesIndex).Name("certificates")
+	w.Post("/certificates/:id/revoke", MustSignedInAsAdmin(), csrf.Validate, PostCertificateRevoke).Name("revoke-certificate")
+	/* audit */
+	w.Get("/audit", MustSignedInAsAdmin(), GetAuditIndex).Name("audit")
+	w.Get("/audit/export", MustSignedInAsAdmin(), GetAuditExport).Name("audit-export")
+	w.Get("/auth-failures", MustSignedInAsAdmin(), GetAuthFailuresIndex).Name("auth-failures")
+	w.Post("/auth-failures/bans/lift", MustSignedInAsAdmin(), csrf.Validate, binding.Form(BanLiftForm{}), PostBanLift).Name("lift-ban")
 }
 
 // GeneralFilter the general filter
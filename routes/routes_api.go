@@ -0,0 +1,433 @@
+/**
+ * routes/routes_api.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/sandbox"
+	"github.com/yankeguo/bunker/types"
+	"github.com/yankeguo/bunker/utils"
+	"landzero.net/x/net/web"
+)
+
+// APIPerPage default items per page of api
+const APIPerPage = 50
+
+// APIMaxPerPage max items per page of api
+const APIMaxPerPage = 500
+
+// apiPage get 0-based page and per page from query "page" (1-based) and "per_page"
+func apiPage(ctx *web.Context) (page int, perPage int) {
+	if page = ctx.QueryInt("page"); page < 1 {
+		page = 1
+	}
+	if perPage = ctx.QueryInt("per_page"); perPage < 1 {
+		perPage = APIPerPage
+	}
+	if perPage > APIMaxPerPage {
+		perPage = APIMaxPerPage
+	}
+	return page - 1, perPage
+}
+
+// apiTargetUser user of ":account" for admin apis, or current user
+func apiTargetUser(ctx *web.Context, db *models.DB, a *APIAuth) *models.User {
+	account := ctx.Params(":account")
+	if len(account) == 0 {
+		return a.User
+	}
+	u := models.User{}
+	if db.First(&u, "account = ?", account).Error != nil || u.ID == 0 {
+		APIError(ctx, http.StatusNotFound, "user not found")
+		return nil
+	}
+	return &u
+}
+
+// GetAPIUser get current user, or user of ":account"
+func GetAPIUser(ctx *web.Context, db *models.DB, a *APIAuth) {
+	u := apiTargetUser(ctx, db, a)
+	if u == nil {
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{"user": u})
+}
+
+// GetAPIUsers list users
+func GetAPIUsers(ctx *web.Context, db *models.DB) {
+	us := []models.User{}
+	db.Order("id ASC").Find(&us)
+	ctx.JSON(http.StatusOK, map[string]interface{}{"users": us})
+}
+
+// APIUserCreateRequest create user request
+type APIUserCreateRequest struct {
+	Account   string `json:"account"`
+	Password  string `json:"password"`
+	IsAdmin   bool   `json:"isAdmin"`
+	PublicKey string `json:"publicKey"` // optional ssh public key
+}
+
+// PostAPIUsers create a user
+func PostAPIUsers(ctx *web.Context, db *models.DB, au *Auditor) {
+	r := APIUserCreateRequest{}
+	if !APIDecode(ctx, &r) {
+		return
+	}
+	var err error
+	var kf SSHKeyCreateForm
+	if _, err = (UserAddForm{Account: r.Account, Password: r.Password, RptPassword: r.Password}).Validate(db); err != nil {
+		APIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(r.PublicKey) > 0 {
+		if kf, err = (SSHKeyCreateForm{PublicKey: r.PublicKey}).Validate(db); err != nil {
+			APIError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	u := &models.User{Account: r.Account, IsAdmin: utils.ToInt(r.IsAdmin)}
+	u.SetPassword(r.Password)
+	if err = db.Create(u).Error; err != nil {
+		APIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	au.Record("user.create", auditTarget("user", u.Account), nil, u)
+	if len(r.PublicKey) > 0 {
+		k := models.Key{UserID: u.ID, Name: kf.Name, Fingerprint: kf.Fingerprint}
+		if db.Create(&k).Error == nil {
+			au.Record("key.create", auditTarget("key", k.ID), nil, k)
+		}
+	}
+	ctx.JSON(http.StatusCreated, map[string]interface{}{"user": u})
+}
+
+// APIUserUpdateRequest update user request, nil fields are left untouched
+type APIUserUpdateRequest struct {
+	IsAdmin        *bool `json:"isAdmin"`
+	IsBlocked      *bool `json:"isBlocked"`
+	IsAgentAllowed *bool `json:"isAgentAllowed"`
+}
+
+// PatchAPIUser update a user
+func PatchAPIUser(ctx *web.Context, db *models.DB, a *APIAuth, au *Auditor, m sandbox.Manager) {
+	u := apiTargetUser(ctx, db, a)
+	if u == nil {
+		return
+	}
+	r := APIUserUpdateRequest{}
+	if !APIDecode(ctx, &r) {
+		return
+	}
+	if u.ID == a.User.ID && (r.IsAdmin != nil || r.IsBlocked != nil) {
+		APIError(ctx, http.StatusBadRequest, "cannot change admin or blocked of current user")
+		return
+	}
+	before := *u
+	attrs := map[string]interface{}{}
+	if r.IsAdmin != nil {
+		attrs["is_admin"] = utils.ToInt(*r.IsAdmin)
+	}
+	if r.IsBlocked != nil {
+		attrs["is_blocked"] = utils.ToInt(*r.IsBlocked)
+		// block is owned by admin from now on, ldap sync will not clear it
+		attrs["is_dir_blocked"] = 0
+	}
+	if r.IsAgentAllowed != nil {
+		attrs["is_agent_allowed"] = utils.ToInt(*r.IsAgentAllowed)
+	}
+	if len(attrs) > 0 {
+		if err := db.Model(u).Update(attrs).Error; err != nil {
+			APIError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+		au.Record("user.update", auditTarget("user", u.Account), before, u)
+	}
+	// stop sandbox of blocked user
+	if r.IsBlocked != nil && *r.IsBlocked {
+		m.Stop(u.Account)
+	}
+	db.First(u, u.ID)
+	ctx.JSON(http.StatusOK, map[string]interface{}{"user": u})
+}
+
+// GetAPIKeys list ssh keys of current user, or user of ":account"
+func GetAPIKeys(ctx *web.Context, db *models.DB, a *APIAuth) {
+	u := apiTargetUser(ctx, db, a)
+	if u == nil {
+		return
+	}
+	ks := []models.Key{}
+	db.Order("id ASC").Find(&ks, "user_id = ?", u.ID)
+	ctx.JSON(http.StatusOK, map[string]interface{}{"keys": ks})
+}
+
+// APIKeyCreateRequest create ssh key request
+type APIKeyCreateRequest struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"publicKey"`
+}
+
+// PostAPIKeys add a ssh key to current user, or user of ":account"
+func PostAPIKeys(ctx *web.Context, db *models.DB, a *APIAuth, au *Auditor) {
+	u := apiTargetUser(ctx, db, a)
+	if u == nil {
+		return
+	}
+	r := APIKeyCreateRequest{}
+	if !APIDecode(ctx, &r) {
+		return
+	}
+	f, err := (SSHKeyCreateForm{Name: r.Name, PublicKey: r.PublicKey}).Validate(db)
+	if err != nil {
+		APIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	k := &models.Key{UserID: u.ID, Name: f.Name, Fingerprint: f.Fingerprint}
+	if err = db.Create(k).Error; err != nil {
+		APIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	au.Record("key.create", auditTarget("key", k.ID), nil, k)
+	ctx.JSON(http.StatusCreated, map[string]interface{}{"key": k})
+}
+
+// DeleteAPIKey delete a ssh key of current user, or user of ":account", sandbox key can not be deleted
+func DeleteAPIKey(ctx *web.Context, db *models.DB, a *APIAuth, au *Auditor) {
+	u := apiTargetUser(ctx, db, a)
+	if u == nil {
+		return
+	}
+	k := models.Key{}
+	if db.First(&k, "user_id = ? AND id = ? AND is_sandbox = ?", u.ID, ctx.Params(":id"), utils.False).Error != nil || k.ID == 0 {
+		APIError(ctx, http.StatusNotFound, "key not found")
+		return
+	}
+	if err := db.Delete(&k).Error; err != nil {
+		APIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	au.Record("key.destroy", auditTarget("key", k.ID), k, nil)
+	ctx.JSON(http.StatusOK, map[string]interface{}{"key": k})
+}
+
+// GetAPIServers list servers
+func GetAPIServers(ctx *web.Context, db *models.DB) {
+	ss := []models.Server{}
+	db.Order("name ASC").Find(&ss)
+	ctx.JSON(http.StatusOK, map[string]interface{}{"servers": ss})
+}
+
+// GetAPIServer get a server by name
+func GetAPIServer(ctx *web.Context, db *models.DB) {
+	s := models.Server{}
+	if db.First(&s, "name = ?", ctx.Params(":name")).Error != nil || s.ID == 0 {
+		APIError(ctx, http.StatusNotFound, "server not found")
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{"server": s})
+}
+
+// APIServerRequest create or update server request
+type APIServerRequest struct {
+	Address string `json:"address"`
+	Labels  string `json:"labels"` // "k1=v1,k2=v2"
+	Proxy   string `json:"proxy"`  // name of jump host
+}
+
+// PutAPIServer create or update a server by name, servers from consul can not be updated
+func PutAPIServer(ctx *web.Context, db *models.DB, au *Auditor) {
+	r := APIServerRequest{}
+	if !APIDecode(ctx, &r) {
+		return
+	}
+	f, err := (ServerCreateForm{Name: ctx.Params(":name"), Address: r.Address, Labels: r.Labels, Proxy: r.Proxy}).Validate()
+	if err == nil {
+		err = f.ValidateProxy(db)
+	}
+	if err != nil {
+		APIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	s := models.Server{}
+	db.First(&s, "name = ?", f.Name)
+	if utils.ToBool(s.IsAuto) {
+		APIError(ctx, http.StatusConflict, "server is managed automatically")
+		return
+	}
+	before := s
+	if err = db.Assign(map[string]interface{}{
+		"address": f.Address,
+		"labels":  f.Labels,
+		"proxy":   f.Proxy,
+	}).FirstOrCreate(&s, map[string]interface{}{
+		"name": f.Name,
+	}).Error; err != nil {
+		APIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if before.ID == 0 {
+		au.Record("server.create", auditTarget("server", s.Name), nil, s)
+	} else {
+		au.Record("server.update", auditTarget("server", s.Name), before, s)
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{"server": s})
+}
+
+// DeleteAPIServer delete a server by name, servers from consul can not be deleted
+func DeleteAPIServer(ctx *web.Context, db *models.DB, au *Auditor) {
+	s := models.Server{}
+	if db.First(&s, "name = ? AND is_auto = ?", ctx.Params(":name"), utils.False).Error != nil || s.ID == 0 {
+		APIError(ctx, http.StatusNotFound, "server not found")
+		return
+	}
+	if err := db.Delete(&s).Error; err != nil {
+		APIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	au.Record("server.destroy", auditTarget("server", s.Name), s, nil)
+	ctx.JSON(http.StatusOK, map[string]interface{}{"server": s})
+}
+
+// apiGrantOwner resolve owner ids of grant from "user" or "group"
+func apiGrantOwner(db *models.DB, user string, group string) (userID uint, groupID uint, err error) {
+	if len(user) > 0 {
+		u := models.User{}
+		if db.First(&u, "account = ?", user).Error != nil || u.ID == 0 {
+			err = fmt.Errorf("user %s not found", user)
+		}
+		userID = u.ID
+	} else if len(group) > 0 {
+		g := models.Group{}
+		if db.First(&g, "name = ?", group).Error != nil || g.ID == 0 {
+			err = fmt.Errorf("group %s not found", group)
+		}
+		groupID = g.ID
+	} else {
+		err = fmt.Errorf("user or group is required")
+	}
+	return
+}
+
+// GetAPIGrants list grants of "user" or "group"
+func GetAPIGrants(ctx *web.Context, db *models.DB) {
+	userID, groupID, err := apiGrantOwner(db, ctx.Query("user"), ctx.Query("group"))
+	if err != nil {
+		APIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	gs := []models.Grant{}
+	db.Order("id ASC").Find(&gs, "user_id = ? AND group_id = ?", userID, groupID)
+	ctx.JSON(http.StatusOK, map[string]interface{}{"grants": gs})
+}
+
+// APIGrantRequest create or update grant request, server name containing "=" is treated as label selector
+type APIGrantRequest struct {
+	User         string `json:"user"`  // user account, or
+	Group        string `json:"group"` // group name
+	Type         int    `json:"type"`
+	TargetUser   string `json:"targetUser"`
+	ServerName   string `json:"serverName"`
+	ForwardHost  string `json:"forwardHost"`
+	ForwardPorts string `json:"forwardPorts"`
+	ExpiresIn    int    `json:"expiresIn"` // hours, 0 for never
+}
+
+// PostAPIGrants create or update a grant
+func PostAPIGrants(ctx *web.Context, db *models.DB, au *Auditor) {
+	r := APIGrantRequest{}
+	if !APIDecode(ctx, &r) {
+		return
+	}
+	userID, groupID, err := apiGrantOwner(db, r.User, r.Group)
+	if err != nil {
+		APIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	f := GrantCreateForm{
+		Type:         r.Type,
+		TargetUser:   r.TargetUser,
+		ServerName:   r.ServerName,
+		ForwardHost:  r.ForwardHost,
+		ForwardPorts: r.ForwardPorts,
+		ExpiresIn:    strconv.Itoa(r.ExpiresIn),
+		ExpiresUnit:  "h",
+	}
+	if r.ExpiresIn == 0 {
+		f.ExpiresUnit = "e"
+	}
+	if f, err = f.Validate(); err != nil {
+		APIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	var g models.Grant
+	if g, err = createOrUpdateGrant(db, au, f, userID, groupID); err != nil {
+		APIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{"grant": g})
+}
+
+// DeleteAPIGrant delete a grant
+func DeleteAPIGrant(ctx *web.Context, db *models.DB, au *Auditor) {
+	g := models.Grant{}
+	if db.First(&g, ctx.Params(":id")).Error != nil || g.ID == 0 {
+		APIError(ctx, http.StatusNotFound, "grant not found")
+		return
+	}
+	if err := db.Delete(&g).Error; err != nil {
+		APIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	au.Record("grant.destroy", auditTarget("grant", g.ID), g, nil)
+	ctx.JSON(http.StatusOK, map[string]interface{}{"grant": g})
+}
+
+// GetAPISessions list sessions, newest first, optionally filtered by "user" and "server"
+func GetAPISessions(ctx *web.Context, db *models.DB) {
+	page, perPage := apiPage(ctx)
+	q := db.Model(&models.Session{})
+	if user := strings.TrimSpace(ctx.Query("user")); len(user) > 0 {
+		q = q.Where("user_account = ?", user)
+	}
+	if server := strings.TrimSpace(ctx.Query("server")); len(server) > 0 {
+		q = q.Where("server_name = ?", server)
+	}
+	var count int
+	q.Count(&count)
+	ss := []models.Session{}
+	q.Order("id DESC").Offset(page * perPage).Limit(perPage).Find(&ss)
+	ctx.JSON(http.StatusOK, map[string]interface{}{"sessions": ss, "total": count})
+}
+
+// GetAPISession get a session
+func GetAPISession(ctx *web.Context, db *models.DB) {
+	s := models.Session{}
+	if db.First(&s, ctx.Params(":id")).Error != nil || s.ID == 0 {
+		APIError(ctx, http.StatusNotFound, "session not found")
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{"session": s})
+}
+
+// GetAPISessionReplay download replay file of a session, gzipped rec format
+func GetAPISessionReplay(ctx *web.Context, db *models.DB, cfg types.Config) {
+	s := models.Session{}
+	if db.First(&s, ctx.Params(":id")).Error != nil || s.ID == 0 || !utils.ToBool(s.IsRecorded) {
+		APIError(ctx, http.StatusNotFound, "replay not found")
+		return
+	}
+	ctx.ServeFile(filepath.Join(cfg.SSHD.ReplayDir, s.ReplayFile), fmt.Sprintf("session-%d.rec.gz", s.ID))
+}
@@ -0,0 +1,139 @@
+/**
+ * routes/routes_audit.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yankeguo/bunker/models"
+	"landzero.net/x/net/web"
+)
+
+// AuditEventsPerPage audit events per page
+const AuditEventsPerPage = 50
+
+// AuditEventItem audit event item
+type AuditEventItem struct {
+	ID        uint
+	CreatedAt string
+	ActorType string
+	Actor     string
+	Action    string
+	Target    string
+	Diff      []string
+	SourceIP  string
+}
+
+// auditQuery parse audit query from "actor", "action", "target", "since" and "until"
+func auditQuery(ctx *web.Context) (q models.AuditQuery, err error) {
+	q.Actor = strings.TrimSpace(ctx.Query("actor"))
+	q.Action = strings.TrimSpace(ctx.Query("action"))
+	q.Target = strings.TrimSpace(ctx.Query("target"))
+	if q.Since, err = ParseQueryTime(ctx.Query("since"), false); err != nil {
+		return
+	}
+	q.Until, err = ParseQueryTime(ctx.Query("until"), true)
+	return
+}
+
+// GetAuditIndex list audit events
+func GetAuditIndex(ctx *web.Context, db *models.DB) {
+	ctx.Data["NavClass_Audit"] = "active"
+	for _, k := range []string{"actor", "action", "target", "since", "until"} {
+		ctx.Data["Query_"+k] = ctx.Query(k)
+	}
+	ctx.Data["ExportURL"] = AppendQuery(
+		ctx.URLFor("audit-export"),
+		"actor", ctx.Query("actor"),
+		"action", ctx.Query("action"),
+		"target", ctx.Query("target"),
+		"since", ctx.Query("since"),
+		"until", ctx.Query("until"),
+	)
+	q, err := auditQuery(ctx)
+	if err != nil {
+		ctx.Data["SearchError"] = err.Error()
+		ctx.HTML(http.StatusOK, "audit/index")
+		return
+	}
+	// calculate page 0 based
+	var page int
+	if page, err = strconv.Atoi(ctx.Query("page")); err != nil || page < 1 {
+		page = 0
+	} else {
+		page = page - 1
+	}
+	es, count, err := db.SearchAuditEvents(q, page*AuditEventsPerPage, AuditEventsPerPage)
+	if err != nil {
+		ctx.Data["SearchError"] = err.Error()
+	}
+	ctx.Data["Pagination"] = CreatePagination(count, AuditEventsPerPage, page, AppendQuery(
+		ctx.URLFor("audit"),
+		"actor", q.Actor,
+		"action", q.Action,
+		"target", q.Target,
+		"since", ctx.Query("since"),
+		"until", ctx.Query("until"),
+	))
+	ctx.Data["Count"] = count
+	items := []AuditEventItem{}
+	for _, e := range es {
+		item := AuditEventItem{
+			ID:        e.ID,
+			CreatedAt: PrettyTime(&e.CreatedAt),
+			ActorType: e.ActorType,
+			Actor:     e.Actor,
+			Action:    e.Action,
+			Target:    e.Target,
+			SourceIP:  e.SourceIP,
+		}
+		if len(e.Diff) > 0 {
+			item.Diff = strings.Split(e.Diff, "\n")
+		}
+		items = append(items, item)
+	}
+	ctx.Data["AuditEvents"] = items
+	ctx.HTML(http.StatusOK, "audit/index")
+}
+
+// GetAuditExport download all matching audit events as json, filtered by "actor", "action", "target", "since" and "until"
+func GetAuditExport(ctx *web.Context, db *models.DB) {
+	q, err := auditQuery(ctx)
+	if err != nil {
+		APIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	es, count, err := db.SearchAuditEvents(q, 0, 0)
+	if err != nil {
+		APIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.Resp.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="bunker-audit-%s.json"`, time.Now().Format("20060102150405")))
+	ctx.JSON(http.StatusOK, map[string]interface{}{"auditEvents": es, "total": count})
+}
+
+// GetAPIAudit list audit events, newest first, filtered by "actor", "action", "target", "since" and "until"
+func GetAPIAudit(ctx *web.Context, db *models.DB) {
+	q, err := auditQuery(ctx)
+	if err != nil {
+		APIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, perPage := apiPage(ctx)
+	es, count, err := db.SearchAuditEvents(q, page*perPage, perPage)
+	if err != nil {
+		APIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{"auditEvents": es, "total": count})
+}
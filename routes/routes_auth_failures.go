@@ -0,0 +1,181 @@
+/**
+ * routes/routes_auth_failures.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/utils"
+	"landzero.net/x/net/web"
+	"landzero.net/x/net/web/session"
+)
+
+// AuthFailuresPerPage auth failures per page
+const AuthFailuresPerPage = 50
+
+// RecentDeniedDays days of permission denied attempts shown to user
+const RecentDeniedDays = 7
+
+// SSHLimiter failed login limiter of sshd, keys are "ip:x.x.x.x" and "account:xxx"
+type SSHLimiter struct {
+	*utils.Limiter
+}
+
+// AuthFailureItem auth failure item
+type AuthFailureItem struct {
+	ID          uint
+	CreatedAt   string
+	SourceIP    string
+	LoginUser   string
+	Method      string
+	Fingerprint string
+	UserAccount string
+	TargetUser  string
+	ServerName  string
+	Target      string
+	Reason      string
+	IsDenied    bool
+}
+
+// BanItem temporary ban item
+type BanItem struct {
+	Key      string
+	Failures int
+	Until    string
+}
+
+// BanLiftForm form to lift a ban
+type BanLiftForm struct {
+	Key string `form:"key"`
+}
+
+// authFailureQuery parse auth failure query from "ip", "account", "denied" and "since"
+func authFailureQuery(ctx *web.Context) (q models.AuthFailureQuery, err error) {
+	q.SourceIP = strings.TrimSpace(ctx.Query("ip"))
+	q.UserAccount = strings.TrimSpace(ctx.Query("account"))
+	q.IsDenied = ctx.Query("denied") == "1"
+	q.Since, err = ParseQueryTime(ctx.Query("since"), false)
+	return
+}
+
+// createAuthFailureItem create item for display
+func createAuthFailureItem(f models.AuthFailure) AuthFailureItem {
+	item := AuthFailureItem{
+		ID:          f.ID,
+		CreatedAt:   PrettyTime(&f.CreatedAt),
+		SourceIP:    f.SourceIP,
+		LoginUser:   f.LoginUser,
+		Method:      f.Method,
+		Fingerprint: f.Fingerprint,
+		UserAccount: f.UserAccount,
+		TargetUser:  f.TargetUser,
+		ServerName:  f.ServerName,
+		Reason:      f.Reason,
+		IsDenied:    utils.ToBool(f.IsDenied),
+	}
+	if len(f.ServerName) > 0 {
+		item.Target = fmt.Sprintf("%s@%s", f.TargetUser, f.ServerName)
+	}
+	return item
+}
+
+// GetAuthFailuresIndex list failed ssh logins and active bans
+func GetAuthFailuresIndex(ctx *web.Context, db *models.DB, sl *SSHLimiter) {
+	ctx.Data["NavClass_Audit"] = "active"
+	for _, k := range []string{"ip", "account", "denied", "since"} {
+		ctx.Data["Query_"+k] = ctx.Query(k)
+	}
+	bs := []BanItem{}
+	for _, b := range sl.Bans() {
+		bs = append(bs, BanItem{
+			Key:      b.Key,
+			Failures: b.Failures,
+			Until:    PrettyTime(&b.Until),
+		})
+	}
+	ctx.Data["Bans"] = bs
+	q, err := authFailureQuery(ctx)
+	if err != nil {
+		ctx.Data["SearchError"] = err.Error()
+		ctx.HTML(http.StatusOK, "audit/auth-failures")
+		return
+	}
+	// calculate page 0 based
+	var page int
+	if page, err = strconv.Atoi(ctx.Query("page")); err != nil || page < 1 {
+		page = 0
+	} else {
+		page = page - 1
+	}
+	fs, count, err := db.SearchAuthFailures(q, page*AuthFailuresPerPage, AuthFailuresPerPage)
+	if err != nil {
+		ctx.Data["SearchError"] = err.Error()
+	}
+	ctx.Data["Pagination"] = CreatePagination(count, AuthFailuresPerPage, page, AppendQuery(
+		ctx.URLFor("auth-failures"),
+		"ip", q.SourceIP,
+		"account", q.UserAccount,
+		"denied", ctx.Query("denied"),
+		"since", ctx.Query("since"),
+	))
+	ctx.Data["Count"] = count
+	items := []AuthFailureItem{}
+	for _, f := range fs {
+		items = append(items, createAuthFailureItem(f))
+	}
+	ctx.Data["AuthFailures"] = items
+	ctx.HTML(http.StatusOK, "audit/auth-failures")
+}
+
+// PostBanLift lift a temporary ban
+func PostBanLift(ctx *web.Context, f BanLiftForm, sl *SSHLimiter, au *Auditor, fl *session.Flash) {
+	defer ctx.Redirect(ctx.URLFor("auth-failures"))
+	if !sl.Unban(f.Key) {
+		fl.Error("封禁不存在或已过期")
+		return
+	}
+	au.Record("ban.lift", auditTarget("ban", f.Key), nil, nil)
+	fl.Success("已解除封禁 " + f.Key)
+}
+
+// recentDeniedItems recent permission denied attempts of user, to find out grants missing
+func recentDeniedItems(db *models.DB, account string) []AuthFailureItem {
+	since := time.Now().AddDate(0, 0, -RecentDeniedDays)
+	fs, _, _ := db.SearchAuthFailures(models.AuthFailureQuery{
+		UserAccount: account,
+		IsDenied:    true,
+		Since:       &since,
+	}, 0, 10)
+	items := []AuthFailureItem{}
+	for _, f := range fs {
+		items = append(items, createAuthFailureItem(f))
+	}
+	return items
+}
+
+// GetAPIAuthFailures list failed ssh logins, newest first, filtered by "ip", "account", "denied" and "since"
+func GetAPIAuthFailures(ctx *web.Context, db *models.DB) {
+	q, err := authFailureQuery(ctx)
+	if err != nil {
+		APIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, perPage := apiPage(ctx)
+	fs, count, err := db.SearchAuthFailures(q, page*perPage, perPage)
+	if err != nil {
+		APIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{"authFailures": fs, "total": count})
+}
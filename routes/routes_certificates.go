@@ -0,0 +1,211 @@
+/**
+ * routes/routes_certificates.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/types"
+	"github.com/yankeguo/bunker/utils"
+	"golang.org/x/crypto/ssh"
+	"landzero.net/x/net/web"
+	"landzero.net/x/net/web/session"
+)
+
+// CA ssh certificate authority for issuing user certificates, Signer is nil if disabled
+type CA struct {
+	Signer ssh.Signer
+}
+
+// CertificateItem certificate item
+type CertificateItem struct {
+	ID          uint
+	User        string
+	KeyID       string
+	Fingerprint string
+	ValidAfter  string
+	ValidBefore string
+	IsExpired   bool
+	IsRevoked   bool
+	RevokedAt   string
+	UsedAt      string
+}
+
+// CertificatesPerPage certificates per page
+const CertificatesPerPage = 50
+
+func certificateItems(cs []models.Certificate) []CertificateItem {
+	out := []CertificateItem{}
+	for _, c := range cs {
+		out = append(out, CertificateItem{
+			ID:          c.ID,
+			User:        c.UserAccount,
+			KeyID:       c.KeyID,
+			Fingerprint: c.Fingerprint,
+			ValidAfter:  PrettyTime(&c.ValidAfter),
+			ValidBefore: PrettyTime(&c.ValidBefore),
+			IsExpired:   c.IsExpired(),
+			IsRevoked:   utils.ToBool(c.IsRevoked),
+			RevokedAt:   PrettyTime(c.RevokedAt),
+			UsedAt:      TimeAgo(c.UsedAt),
+		})
+	}
+	return out
+}
+
+// canIssueCertificate certificates are only issued to sessions passed second factor
+func canIssueCertificate(a Auth) bool {
+	return strings.HasSuffix(a.Method(), AuthMethodTOTPSuffix)
+}
+
+// GetSettingsCertificates get certificates of current user
+func GetSettingsCertificates(ctx *web.Context, a Auth, db *models.DB, ca *CA, cfg types.Config) {
+	ctx.Data["SideClass_Certificates"] = "active"
+	ctx.Data["IsEnabled"] = ca.Signer != nil
+	ctx.Data["CanIssue"] = canIssueCertificate(a)
+	ctx.Data["DefaultTTL"] = int(cfg.CA.DefaultTTL().Hours())
+	ctx.Data["MaxTTL"] = int(cfg.CA.MaxValidity().Hours())
+	cs := []models.Certificate{}
+	db.Order("id DESC").Limit(CertificatesPerPage).Find(&cs, "user_id = ?", a.User().ID)
+	ctx.Data["Certificates"] = certificateItems(cs)
+	ctx.HTML(http.StatusOK, "settings/certificates")
+}
+
+// CertificateCreateForm certificate create form
+type CertificateCreateForm struct {
+	PublicKey string `form:"public_key"`
+	TTL       string `form:"ttl"`
+}
+
+// Validate validate the form
+func (f CertificateCreateForm) Validate(cfg types.Config) (pk ssh.PublicKey, ttl time.Duration, err error) {
+	if pk, _, _, _, err = ssh.ParseAuthorizedKey([]byte(f.PublicKey)); err != nil {
+		err = errors.New("公钥格式错误")
+		return
+	}
+	if _, ok := pk.(*ssh.Certificate); ok {
+		err = errors.New("请填写公钥而不是证书")
+		return
+	}
+	ttl = cfg.CA.DefaultTTL()
+	if len(strings.TrimSpace(f.TTL)) > 0 {
+		var h int
+		if h, err = strconv.Atoi(strings.TrimSpace(f.TTL)); err != nil || h <= 0 {
+			err = errors.New("有效期不正确")
+			return
+		}
+		ttl = time.Hour * time.Duration(h)
+	}
+	if ttl > cfg.CA.MaxValidity() {
+		err = fmt.Errorf("有效期不能超过 %d 小时", int(cfg.CA.MaxValidity().Hours()))
+		return
+	}
+	return
+}
+
+// PostSettingsCertificatesCreate issue a certificate for current user, certificate is downloaded as file
+func PostSettingsCertificatesCreate(ctx *web.Context, f CertificateCreateForm, a Auth, au *Auditor, db *models.DB, ca *CA, cfg types.Config, fl *session.Flash) {
+	var err error
+	var pk ssh.PublicKey
+	var ttl time.Duration
+	if ca.Signer == nil {
+		err = errors.New("未开启证书签发")
+	} else if !canIssueCertificate(a) {
+		err = errors.New("请开启两步验证并重新登录后再签发证书")
+	} else {
+		pk, ttl, err = f.Validate(cfg)
+	}
+	if err != nil {
+		fl.Error(err.Error())
+		ctx.Redirect("/settings/certificates")
+		return
+	}
+	u := a.User()
+	now := time.Now()
+	// allow small clock skew between bunker and client
+	mc := &models.Certificate{
+		UserID:      u.ID,
+		UserAccount: u.Account,
+		Fingerprint: ssh.FingerprintSHA256(pk),
+		ValidAfter:  now.Add(-time.Minute * 5),
+		ValidBefore: now.Add(ttl),
+	}
+	if err = db.Create(mc).Error; err != nil {
+		fl.Error(err.Error())
+		ctx.Redirect("/settings/certificates")
+		return
+	}
+	mc.KeyID = fmt.Sprintf("bunker:%s:%d", u.Account, mc.Serial())
+	db.Model(mc).Update("key_id", mc.KeyID)
+	var c *ssh.Certificate
+	if c, err = utils.SignUserCertificate(ca.Signer, pk, mc.Serial(), mc.KeyID, u.Account, mc.ValidAfter, mc.ValidBefore); err != nil {
+		db.RevokeCertificate(mc.ID, 0)
+		fl.Error(err.Error())
+		ctx.Redirect("/settings/certificates")
+		return
+	}
+	log.Printf("CA: issued certificate serial %d to %s, valid before %s", mc.Serial(), u.Account, mc.ValidBefore.Format(time.RFC3339))
+	au.Record("certificate.create", auditTarget("certificate", mc.ID), nil, mc)
+	ctx.Resp.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-cert.pub"`, u.Account))
+	ctx.PlainText(http.StatusOK, ssh.MarshalAuthorizedKey(c))
+}
+
+// PostSettingsCertificateRevoke revoke a certificate of current user
+func PostSettingsCertificateRevoke(ctx *web.Context, a Auth, au *Auditor, db *models.DB, fl *session.Flash) {
+	defer ctx.Redirect("/settings/certificates")
+	id, _ := strconv.Atoi(ctx.Params(":id"))
+	if err := db.RevokeCertificate(uint(id), a.User().ID); err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	au.Record("certificate.revoke", auditTarget("certificate", id), nil, nil)
+	fl.Success("证书已吊销")
+}
+
+// GetCertificatesIndex get all certificates
+func GetCertificatesIndex(ctx *web.Context, db *models.DB) {
+	ctx.Data["NavClass_Certificates"] = "active"
+	var err error
+	// calculate page 0 based
+	var page int
+	if page, err = strconv.Atoi(ctx.Query("page")); err != nil || page < 1 {
+		page = 0
+	} else {
+		page = page - 1
+	}
+	// total count
+	var count int
+	db.Model(&models.Certificate{}).Count(&count)
+	// create pagination
+	ctx.Data["Pagination"] = CreatePagination(count, CertificatesPerPage, page, ctx.URLFor("certificates"))
+	// data
+	cs := []models.Certificate{}
+	db.Order("id DESC").Offset(page * CertificatesPerPage).Limit(CertificatesPerPage).Find(&cs)
+	ctx.Data["Certificates"] = certificateItems(cs)
+	ctx.HTML(http.StatusOK, "certificates/index")
+}
+
+// PostCertificateRevoke revoke any certificate
+func PostCertificateRevoke(ctx *web.Context, au *Auditor, db *models.DB, fl *session.Flash) {
+	defer ctx.Redirect(ctx.URLFor("certificates"))
+	id, _ := strconv.Atoi(ctx.Params(":id"))
+	if err := db.RevokeCertificate(uint(id), 0); err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	au.Record("certificate.revoke", auditTarget("certificate", id), nil, nil)
+	fl.Success("证书已吊销")
+}
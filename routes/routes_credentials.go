@@ -0,0 +1,187 @@
+/**
+ * routes/routes_credentials.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/types"
+	"github.com/yankeguo/bunker/utils"
+	"golang.org/x/crypto/ssh"
+	"landzero.net/x/net/web"
+	"landzero.net/x/net/web/session"
+)
+
+// CredentialItem credential item
+type CredentialItem struct {
+	ID            uint
+	Name          string
+	ServerName    string
+	LabelSelector string
+	LoginUser     string
+	PublicKey     string
+	HasPassword   bool
+	IsDirect      bool
+	Priority      int
+	UpdatedAt     string
+}
+
+func credentialItems(cs []models.Credential) []CredentialItem {
+	out := []CredentialItem{}
+	for _, c := range cs {
+		loginUser := c.LoginUser
+		if len(loginUser) == 0 {
+			loginUser = "root"
+		}
+		out = append(out, CredentialItem{
+			ID:            c.ID,
+			Name:          c.Name,
+			ServerName:    c.ServerName,
+			LabelSelector: c.LabelSelector,
+			LoginUser:     loginUser,
+			PublicKey:     c.PublicKey,
+			HasPassword:   len(c.Password) > 0,
+			IsDirect:      utils.ToBool(c.IsDirect),
+			Priority:      c.Priority,
+			UpdatedAt:     TimeAgo(&c.UpdatedAt),
+		})
+	}
+	return out
+}
+
+// GetCredentialsIndex get all credentials
+func GetCredentialsIndex(ctx *web.Context, db *models.DB) {
+	ctx.Data["NavClass_Servers"] = "active"
+	ctx.Data["SideClass_Credentials"] = "active"
+	cs := []models.Credential{}
+	db.Order("priority DESC").Order("id ASC").Find(&cs)
+	ctx.Data["Credentials"] = credentialItems(cs)
+	ctx.HTML(http.StatusOK, "servers/credentials")
+}
+
+// CredentialCreateForm credential create form, server_name containing "=" is treated as label selector,
+// a ed25519 key is generated if neither private_key nor password is provided
+type CredentialCreateForm struct {
+	Name          string `form:"name"`
+	ServerName    string `form:"server_name"`
+	LabelSelector string `form:"-"`
+	LoginUser     string `form:"login_user"`
+	IsDirect      bool   `form:"is_direct"`
+	Priority      int    `form:"priority"`
+	PrivateKey    string `form:"private_key"`
+	Password      string `form:"password"`
+}
+
+// Validate validate
+func (f CredentialCreateForm) Validate() (CredentialCreateForm, error) {
+	f.Name = strings.TrimSpace(f.Name)
+	f.ServerName = strings.TrimSpace(f.ServerName)
+	f.LoginUser = strings.TrimSpace(f.LoginUser)
+	f.PrivateKey = strings.TrimSpace(f.PrivateKey)
+	if !models.NamePattern.MatchString(f.Name) {
+		return f, errors.New("凭据名称不符合规则")
+	}
+	if strings.Contains(f.ServerName, "=") {
+		sel, err := utils.ParseLabelSelector(f.ServerName)
+		if err != nil {
+			return f, errors.New("标签选择器不符合规则")
+		}
+		f.LabelSelector = sel.String()
+		f.ServerName = ""
+	} else if !models.WildcardPattern.MatchString(f.ServerName) {
+		return f, errors.New("服务器名称不符合规则")
+	}
+	if f.IsDirect {
+		f.LoginUser = ""
+	} else if len(f.LoginUser) > 0 && !models.NamePattern.MatchString(f.LoginUser) {
+		return f, errors.New("登录账户不符合规则")
+	}
+	if len(f.PrivateKey) > 0 {
+		f.PrivateKey = f.PrivateKey + "\n"
+	}
+	return f, nil
+}
+
+// generateCredentialKey generate a ed25519 private key in openssh format
+func generateCredentialKey(name string) (string, error) {
+	_, k, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	var b *pem.Block
+	if b, err = ssh.MarshalPrivateKey(k, "bunker:"+name); err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(b)), nil
+}
+
+// PostCredentialCreate create a credential
+func PostCredentialCreate(ctx *web.Context, f CredentialCreateForm, au *Auditor, db *models.DB, cfg types.Config, fl *session.Flash) {
+	defer ctx.Redirect(ctx.URLFor("credentials"))
+	var err error
+	if f, err = f.Validate(); err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	if len(f.PrivateKey) == 0 && len(f.Password) == 0 {
+		if f.PrivateKey, err = generateCredentialKey(f.Name); err != nil {
+			fl.Error(err.Error())
+			return
+		}
+	}
+	c := models.Credential{
+		Name:          f.Name,
+		ServerName:    f.ServerName,
+		LabelSelector: f.LabelSelector,
+		LoginUser:     f.LoginUser,
+		IsDirect:      utils.ToInt(f.IsDirect),
+		Priority:      f.Priority,
+	}
+	if len(f.PrivateKey) > 0 {
+		var s ssh.Signer
+		if s, err = ssh.ParsePrivateKey([]byte(f.PrivateKey)); err != nil {
+			fl.Error("私钥格式错误，不支持带密码的私钥")
+			return
+		}
+		c.PublicKey = string(ssh.MarshalAuthorizedKey(s.PublicKey()))
+		if c.PrivateKey, err = utils.EncryptString(cfg.Secret, f.PrivateKey); err != nil {
+			fl.Error(err.Error())
+			return
+		}
+	}
+	if c.Password, err = utils.EncryptString(cfg.Secret, f.Password); err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	if err = db.Create(&c).Error; err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	au.Record("credential.create", auditTarget("credential", c.Name), nil, c)
+	fl.Success(fmt.Sprintf("添加凭据 %s 成功", c.Name))
+}
+
+// PostCredentialDestroy destroy a credential
+func PostCredentialDestroy(ctx *web.Context, au *Auditor, db *models.DB) {
+	defer ctx.Redirect(ctx.URLFor("credentials"))
+	c := models.Credential{}
+	if err := db.First(&c, ctx.Params(":id")).Error; err != nil || c.ID == 0 {
+		return
+	}
+	if err := db.Delete(&c).Error; err == nil {
+		au.Record("credential.destroy", auditTarget("credential", c.Name), c, nil)
+	}
+}
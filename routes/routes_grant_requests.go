@@ -0,0 +1,387 @@
+/**
+ * routes/routes_grant_requests.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yankeguo/bunker/events"
+	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/types"
+	"github.com/yankeguo/bunker/utils"
+	"landzero.net/x/net/web"
+	"landzero.net/x/net/web/session"
+)
+
+// MaxJustificationLength max characters of justification and review comment
+const MaxJustificationLength = 1000
+
+// RecentReviewedDays days of reviewed grant requests shown to approvers
+const RecentReviewedDays = 7
+
+// GrantRequestNotifier emitter notified when grant requests are created or reviewed, configured by [grant_request.notify]
+type GrantRequestNotifier struct {
+	*events.Emitter
+}
+
+// GrantRequestItem grant request item
+type GrantRequestItem struct {
+	ID              uint
+	UserAccount     string
+	ServerName      string
+	TargetUser      string
+	Duration        int
+	Justification   string
+	Status          string
+	StatusClass     string
+	IsPending       bool
+	ReviewerAccount string
+	ReviewComment   string
+	ReviewedAt      string
+	CreatedAt       string
+	GrantID         uint
+}
+
+// GrantRequestStatusName display name and label class of grant request status
+func GrantRequestStatusName(s int) (string, string) {
+	switch s {
+	case models.GrantRequestApproved:
+		return "已批准", "success"
+	case models.GrantRequestDenied:
+		return "已拒绝", "danger"
+	case models.GrantRequestCancelled:
+		return "已撤销", "default"
+	}
+	return "待审批", "warning"
+}
+
+// createGrantRequestItems create grant request items for display
+func createGrantRequestItems(rs []models.GrantRequest) []GrantRequestItem {
+	items := []GrantRequestItem{}
+	for _, r := range rs {
+		item := GrantRequestItem{
+			ID:              r.ID,
+			UserAccount:     r.UserAccount,
+			ServerName:      r.ServerName,
+			TargetUser:      r.TargetUser,
+			Duration:        r.Duration,
+			Justification:   r.Justification,
+			IsPending:       r.IsPending(),
+			ReviewerAccount: r.ReviewerAccount,
+			ReviewComment:   r.ReviewComment,
+			ReviewedAt:      TimeAgo(r.ReviewedAt),
+			CreatedAt:       TimeAgo(&r.CreatedAt),
+			GrantID:         r.GrantID,
+		}
+		item.Status, item.StatusClass = GrantRequestStatusName(r.Status)
+		items = append(items, item)
+	}
+	return items
+}
+
+// canReviewGrantRequests check whether user is admin, a configured approver or member of a configured approver group
+func canReviewGrantRequests(db *models.DB, cfg types.Config, u *models.User) bool {
+	if utils.ToBool(u.IsAdmin) {
+		return true
+	}
+	for _, a := range cfg.GrantRequest.Approvers {
+		if a == u.Account {
+			return true
+		}
+	}
+	if len(cfg.GrantRequest.ApproverGroups) == 0 {
+		return false
+	}
+	gids := db.GetUserGroupIDs(u.ID)
+	if len(gids) == 0 {
+		return false
+	}
+	var count uint
+	db.Model(&models.Group{}).Where("id IN (?) AND name IN (?)", gids, cfg.GrantRequest.ApproverGroups).Count(&count)
+	return count > 0
+}
+
+// pendingGrantRequestItems pending grant requests, oldest first
+func pendingGrantRequestItems(db *models.DB) []GrantRequestItem {
+	rs := []models.GrantRequest{}
+	db.Order("id ASC").Find(&rs, "status = ?", models.GrantRequestPending)
+	return createGrantRequestItems(rs)
+}
+
+// notifyGrantRequest notify grant request created or reviewed, action is "create", "cancel", "approve" or "deny"
+func notifyGrantRequest(n *GrantRequestNotifier, r models.GrantRequest, action string) {
+	if n == nil {
+		return
+	}
+	status, _ := GrantRequestStatusName(r.Status)
+	msg := fmt.Sprintf("%s 申请访问 %s@%s %d 小时: %s", r.UserAccount, r.TargetUser, r.ServerName, r.Duration, status)
+	if len(r.ReviewerAccount) > 0 && action != "cancel" {
+		msg = msg + ", 审批人 " + r.ReviewerAccount
+	}
+	n.Emit(events.Event{
+		Type:       events.TypeGrantRequest,
+		User:       r.UserAccount,
+		Server:     r.ServerName,
+		TargetUser: r.TargetUser,
+		Message:    msg,
+		Fields: map[string]string{
+			"action":        action,
+			"id":            strconv.FormatUint(uint64(r.ID), 10),
+			"duration":      strconv.Itoa(r.Duration),
+			"justification": r.Justification,
+			"reviewer":      r.ReviewerAccount,
+			"comment":       r.ReviewComment,
+		},
+	})
+}
+
+// GrantRequestForm grant request form
+type GrantRequestForm struct {
+	ServerName    string `form:"server_name"`
+	TargetUser    string `form:"target_user"`
+	Duration      int    `form:"duration"` // hours
+	Justification string `form:"justification"`
+}
+
+// Validate validate form, server name pattern must match at least one server
+func (f GrantRequestForm) Validate(db *models.DB, cfg types.Config, u *models.User) (GrantRequestForm, error) {
+	f.ServerName = strings.TrimSpace(f.ServerName)
+	f.TargetUser = strings.TrimSpace(f.TargetUser)
+	f.Justification = strings.TrimSpace(f.Justification)
+	if len(f.ServerName) == 0 || !models.WildcardPattern.MatchString(f.ServerName) {
+		return f, errors.New("服务器名称不符合规则")
+	}
+	if !models.NamePattern.MatchString(f.TargetUser) {
+		return f, errors.New("账户名称不符合规则")
+	}
+	if f.Duration < 1 || f.Duration > cfg.GrantRequest.MaxHours() {
+		return f, fmt.Errorf("申请时长应在 1 到 %d 小时之间", cfg.GrantRequest.MaxHours())
+	}
+	if len(f.Justification) == 0 {
+		return f, errors.New("申请理由不能为空")
+	}
+	if utf8.RuneCountInString(f.Justification) > MaxJustificationLength {
+		return f, fmt.Errorf("申请理由不能超过 %d 个字符", MaxJustificationLength)
+	}
+	matched := false
+	ss := []models.Server{}
+	db.Find(&ss)
+	for _, s := range ss {
+		if (models.Grant{ServerName: f.ServerName}).MatchServer(s) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return f, errors.New("没有匹配的服务器")
+	}
+	var count uint
+	db.Model(&models.GrantRequest{}).Where(
+		"user_id = ? AND server_name = ? AND target_user = ? AND status = ?",
+		u.ID, f.ServerName, f.TargetUser, models.GrantRequestPending,
+	).Count(&count)
+	if count > 0 {
+		return f, errors.New("已有相同的申请正在等待审批")
+	}
+	return f, nil
+}
+
+// GrantRequestReviewForm grant request review form
+type GrantRequestReviewForm struct {
+	Comment string `form:"comment"`
+}
+
+// GetGrantRequestsIndex list grant requests of current user, form is prefilled by "server" and "user" query
+func GetGrantRequestsIndex(ctx *web.Context, a Auth, db *models.DB, cfg types.Config) {
+	ctx.Data["NavClass_GrantRequests"] = "active"
+	ctx.Data["Query_server"] = ctx.Query("server")
+	ctx.Data["Query_user"] = ctx.Query("user")
+	ctx.Data["MaxHours"] = cfg.GrantRequest.MaxHours()
+	ctx.Data["CanReview"] = canReviewGrantRequests(db, cfg, a.User())
+	rs := []models.GrantRequest{}
+	db.Order("id DESC").Limit(100).Find(&rs, "user_id = ?", a.User().ID)
+	ctx.Data["GrantRequests"] = createGrantRequestItems(rs)
+	ctx.HTML(http.StatusOK, "grant-requests/index")
+}
+
+// PostGrantRequestsCreate create a grant request
+func PostGrantRequestsCreate(ctx *web.Context, a Auth, f GrantRequestForm, au *Auditor, n *GrantRequestNotifier, fl *session.Flash, db *models.DB, cfg types.Config) {
+	defer ctx.Redirect(ctx.URLFor("grant-requests"))
+	var err error
+	if f, err = f.Validate(db, cfg, a.User()); err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	r := models.GrantRequest{
+		UserID:        a.User().ID,
+		UserAccount:   a.User().Account,
+		ServerName:    f.ServerName,
+		TargetUser:    f.TargetUser,
+		Duration:      f.Duration,
+		Justification: f.Justification,
+		Status:        models.GrantRequestPending,
+	}
+	if err = db.Create(&r).Error; err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	au.Record("grant-request.create", auditTarget("grant-request", r.ID), nil, r)
+	notifyGrantRequest(n, r, "create")
+	fl.Success("申请已提交，请等待审批")
+}
+
+// PostGrantRequestCancel cancel a pending grant request of current user
+func PostGrantRequestCancel(ctx *web.Context, a Auth, au *Auditor, n *GrantRequestNotifier, fl *session.Flash, db *models.DB) {
+	defer ctx.Redirect(ctx.URLFor("grant-requests"))
+	r := models.GrantRequest{}
+	if err := db.First(&r, "user_id = ? AND id = ?", a.User().ID, ctx.Params(":id")).Error; err != nil || r.ID == 0 {
+		fl.Error("无法找到申请")
+		return
+	}
+	before := r
+	if ok, err := db.CloseGrantRequest(&r, models.GrantRequestCancelled, "", ""); err != nil {
+		fl.Error(err.Error())
+		return
+	} else if !ok {
+		fl.Error("申请已经被处理")
+		return
+	}
+	au.Record("grant-request.cancel", auditTarget("grant-request", r.ID), before, r)
+	notifyGrantRequest(n, r, "cancel")
+	fl.Success("申请已撤销")
+}
+
+// GetGrantRequestsReview list pending and recently reviewed grant requests for approvers
+func GetGrantRequestsReview(ctx *web.Context, a Auth, fl *session.Flash, db *models.DB, cfg types.Config) {
+	if !canReviewGrantRequests(db, cfg, a.User()) {
+		fl.Error("没有审批权限")
+		ctx.Redirect(ctx.URLFor("grant-requests"))
+		return
+	}
+	ctx.Data["NavClass_GrantRequests"] = "active"
+	ctx.Data["PendingGrantRequests"] = pendingGrantRequestItems(db)
+	since := time.Now().AddDate(0, 0, -RecentReviewedDays)
+	rs := []models.GrantRequest{}
+	db.Order("reviewed_at DESC").Limit(100).Find(&rs, "status <> ? AND reviewed_at >= ?", models.GrantRequestPending, since)
+	ctx.Data["ReviewedGrantRequests"] = createGrantRequestItems(rs)
+	ctx.HTML(http.StatusOK, "grant-requests/review")
+}
+
+// findReviewableGrantRequest find grant request for review by current user, approvers can not review their own requests
+func findReviewableGrantRequest(ctx *web.Context, a Auth, db *models.DB, cfg types.Config) (r models.GrantRequest, err error) {
+	if !canReviewGrantRequests(db, cfg, a.User()) {
+		err = errors.New("没有审批权限")
+		return
+	}
+	if err = db.First(&r, ctx.Params(":id")).Error; err != nil || r.ID == 0 {
+		err = errors.New("无法找到申请")
+		return
+	}
+	if r.UserID == a.User().ID {
+		err = errors.New("不能审批自己的申请")
+		return
+	}
+	return
+}
+
+// PostGrantRequestApprove approve a grant request, a ssh grant expiring after requested duration is created for requester
+func PostGrantRequestApprove(ctx *web.Context, a Auth, f GrantRequestReviewForm, au *Auditor, n *GrantRequestNotifier, fl *session.Flash, db *models.DB, cfg types.Config) {
+	defer ctx.Redirect(ctx.URLFor("review-grant-requests"))
+	f.Comment = strings.TrimSpace(f.Comment)
+	if utf8.RuneCountInString(f.Comment) > MaxJustificationLength {
+		fl.Error(fmt.Sprintf("审批意见不能超过 %d 个字符", MaxJustificationLength))
+		return
+	}
+	r, err := findReviewableGrantRequest(ctx, a, db, cfg)
+	if err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	u := models.User{}
+	if err = db.First(&u, r.UserID).Error; err != nil || u.ID == 0 {
+		fl.Error("无法找到申请人")
+		return
+	}
+	before := r
+	// claim the request first, concurrent reviews fail here
+	var ok bool
+	if ok, err = db.CloseGrantRequest(&r, models.GrantRequestApproved, a.User().Account, f.Comment); err != nil {
+		fl.Error(err.Error())
+		return
+	} else if !ok {
+		fl.Error("申请已经被处理")
+		return
+	}
+	gf := GrantCreateForm{
+		Type:        models.GrantTypeSSH,
+		TargetUser:  r.TargetUser,
+		ServerName:  r.ServerName,
+		ExpiresIn:   strconv.Itoa(r.Duration),
+		ExpiresUnit: "h",
+		RequestID:   r.ID,
+	}
+	// an existing grant lasting longer is kept as is, approval never shortens access
+	g := models.Grant{}
+	db.First(&g, "user_id = ? AND group_id = ? AND type = ? AND server_name = ? AND label_selector = ? AND target_user = ?",
+		u.ID, 0, models.GrantTypeSSH, r.ServerName, "", r.TargetUser)
+	if g.ID == 0 || (g.ExpiresAt != nil && g.ExpiresAt.Before(time.Now().Add(time.Hour*time.Duration(r.Duration)))) {
+		if gf, err = gf.Validate(); err == nil {
+			g, err = createOrUpdateGrant(db, au, gf, u.ID, 0)
+		}
+	}
+	if err != nil {
+		// release the request for another review
+		db.Model(&r).UpdateColumns(map[string]interface{}{
+			"status":           models.GrantRequestPending,
+			"reviewer_account": "",
+			"review_comment":   "",
+			"reviewed_at":      nil,
+		})
+		fl.Error(err.Error())
+		return
+	}
+	db.Model(&r).UpdateColumn("grant_id", g.ID)
+	r.GrantID = g.ID
+	au.Record("grant-request.approve", auditTarget("grant-request", r.ID), before, r)
+	notifyGrantRequest(n, r, "approve")
+	fl.Success(fmt.Sprintf("已批准 %s 访问 %s@%s %d 小时", r.UserAccount, r.TargetUser, r.ServerName, r.Duration))
+}
+
+// PostGrantRequestDeny deny a grant request
+func PostGrantRequestDeny(ctx *web.Context, a Auth, f GrantRequestReviewForm, au *Auditor, n *GrantRequestNotifier, fl *session.Flash, db *models.DB, cfg types.Config) {
+	defer ctx.Redirect(ctx.URLFor("review-grant-requests"))
+	f.Comment = strings.TrimSpace(f.Comment)
+	if utf8.RuneCountInString(f.Comment) > MaxJustificationLength {
+		fl.Error(fmt.Sprintf("审批意见不能超过 %d 个字符", MaxJustificationLength))
+		return
+	}
+	r, err := findReviewableGrantRequest(ctx, a, db, cfg)
+	if err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	before := r
+	var ok bool
+	if ok, err = db.CloseGrantRequest(&r, models.GrantRequestDenied, a.User().Account, f.Comment); err != nil {
+		fl.Error(err.Error())
+		return
+	} else if !ok {
+		fl.Error("申请已经被处理")
+		return
+	}
+	au.Record("grant-request.deny", auditTarget("grant-request", r.ID), before, r)
+	notifyGrantRequest(n, r, "deny")
+	fl.Success(fmt.Sprintf("已拒绝 %s 的申请", r.UserAccount))
+}
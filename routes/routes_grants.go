@@ -17,6 +17,7 @@ import (
 	"time"
 
 	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/utils"
 	"landzero.net/x/database/orm"
 	"landzero.net/x/net/web"
 	"landzero.net/x/net/web/session"
@@ -24,12 +25,50 @@ import (
 
 // GrantItem grant item
 type GrantItem struct {
-	ID         uint
-	ServerName string
-	TargetUser string
-	ExpiresAt  string
-	IsExpired  bool
-	UpdatedAt  string
+	ID            uint
+	Type          string
+	ServerName    string
+	LabelSelector string
+	TargetUser    string
+	ForwardHost   string
+	ForwardPorts  string
+	ExpiresAt     string
+	IsExpired     bool
+	UpdatedAt     string
+	RequestID     uint
+}
+
+// createGrantItems create grant items from grants
+func createGrantItems(gs []models.Grant) []GrantItem {
+	ti := make([]GrantItem, 0)
+	n := time.Now()
+	for _, g := range gs {
+		ti = append(ti, GrantItem{
+			ID:            g.ID,
+			Type:          GrantTypeName(g.Type),
+			ServerName:    g.ServerName,
+			LabelSelector: g.LabelSelector,
+			TargetUser:    g.TargetUser,
+			ForwardHost:   g.ForwardHost,
+			ForwardPorts:  g.ForwardPorts,
+			ExpiresAt:     TimeAgo(g.ExpiresAt),
+			IsExpired:     (g.ExpiresAt != nil && n.After(*g.ExpiresAt)),
+			UpdatedAt:     TimeAgo(&g.UpdatedAt),
+			RequestID:     g.RequestID,
+		})
+	}
+	return ti
+}
+
+// GrantTypeName display name of grant type
+func GrantTypeName(t int) string {
+	switch t {
+	case models.GrantTypeForward:
+		return "端口转发"
+	case models.GrantTypeRemoteForward:
+		return "远程转发"
+	}
+	return "登录"
 }
 
 // GetGrantsIndex get grants index
@@ -41,31 +80,56 @@ func GetGrantsIndex(ctx *web.Context, db *models.DB, fl *session.Flash) {
 		ctx.Redirect(ctx.URLFor("users"))
 		return
 	}
-	ctx.Data["User"] = u
+	ctx.Data["NavClass_Users"] = "active"
+	ctx.Data["SideClass_Index"] = "active"
+	ctx.Data["GrantsParentName"] = "所有用户"
+	ctx.Data["GrantsParentURL"] = ctx.URLFor("users")
+	ctx.Data["GrantsOwner"] = u.Account
+	ctx.Data["GrantsURL"] = ctx.URLFor("user-grants", ":userid", fmt.Sprintf("%d", u.ID))
 	gs := []models.Grant{}
 	db.Where("user_id = ?", u.ID).Find(&gs)
-	ti := make([]GrantItem, 0)
-	n := time.Now()
-	for _, g := range gs {
-		ti = append(ti, GrantItem{
-			ID:         g.ID,
-			ServerName: g.ServerName,
-			TargetUser: g.TargetUser,
-			ExpiresAt:  TimeAgo(g.ExpiresAt),
-			IsExpired:  (g.ExpiresAt != nil && n.After(*g.ExpiresAt)),
-			UpdatedAt:  TimeAgo(&g.UpdatedAt),
-		})
+	ctx.Data["Grants"] = createGrantItems(gs)
+	// groups the user belongs to
+	groups := []models.Group{}
+	if gids := db.GetUserGroupIDs(u.ID); len(gids) > 0 {
+		db.Order("name ASC").Where("id IN (?)", gids).Find(&groups)
 	}
-	ctx.Data["Grants"] = ti
+	ctx.Data["Groups"] = groups
 	ctx.HTML(200, "grants/index")
 }
 
-// GrantCreateForm grant add form
+// GetGroupGrantsIndex get group grants index
+func GetGroupGrantsIndex(ctx *web.Context, db *models.DB, fl *session.Flash) {
+	var err error
+	g := models.Group{}
+	if err = db.First(&g, ctx.Params(":groupid")).Error; err != nil {
+		fl.Error(fmt.Sprintf("无法找到分组"))
+		ctx.Redirect(ctx.URLFor("groups"))
+		return
+	}
+	ctx.Data["NavClass_Users"] = "active"
+	ctx.Data["SideClass_Groups"] = "active"
+	ctx.Data["GrantsParentName"] = "所有分组"
+	ctx.Data["GrantsParentURL"] = ctx.URLFor("groups")
+	ctx.Data["GrantsOwner"] = g.Name
+	ctx.Data["GrantsURL"] = ctx.URLFor("group-grants", ":groupid", fmt.Sprintf("%d", g.ID))
+	gs := []models.Grant{}
+	db.Where("group_id = ? AND user_id = ?", g.ID, 0).Find(&gs)
+	ctx.Data["Grants"] = createGrantItems(gs)
+	ctx.HTML(200, "grants/index")
+}
+
+// GrantCreateForm grant add form, server_name containing "=" is treated as label selector
 type GrantCreateForm struct {
-	TargetUser  string `form:"target_user"`
-	ServerName  string `form:"server_name"`
-	ExpiresIn   string `form:"expires_in"`
-	ExpiresUnit string `form:"expires_unit"`
+	Type          int    `form:"type"`
+	TargetUser    string `form:"target_user"`
+	ServerName    string `form:"server_name"`
+	LabelSelector string `form:"-"`
+	ForwardHost   string `form:"forward_host"`
+	ForwardPorts  string `form:"forward_ports"`
+	ExpiresIn     string `form:"expires_in"`
+	ExpiresUnit   string `form:"expires_unit"`
+	RequestID     uint   `form:"-"` // approved grant request, 0 if created by admin
 }
 
 // Validate validate
@@ -78,11 +142,35 @@ func (f GrantCreateForm) Validate() (GrantCreateForm, error) {
 	default:
 		return f, errors.New("参数错误 expires_unit")
 	}
-	if !models.WildcardPattern.MatchString(f.ServerName) {
+	if strings.Contains(f.ServerName, "=") {
+		sel, err := utils.ParseLabelSelector(f.ServerName)
+		if err != nil {
+			return f, errors.New("标签选择器不符合规则")
+		}
+		f.LabelSelector = sel.String()
+		f.ServerName = ""
+	} else if !models.WildcardPattern.MatchString(f.ServerName) {
 		return f, errors.New("服务器名称不符合规则")
 	}
-	if !models.NamePattern.MatchString(f.TargetUser) {
-		return f, errors.New("账户名称不符合规则")
+	switch f.Type {
+	case models.GrantTypeSSH:
+		if !models.NamePattern.MatchString(f.TargetUser) {
+			return f, errors.New("账户名称不符合规则")
+		}
+		f.ForwardHost, f.ForwardPorts = "", ""
+	case models.GrantTypeForward, models.GrantTypeRemoteForward:
+		f.TargetUser = ""
+		f.ForwardHost = strings.TrimSpace(f.ForwardHost)
+		if len(f.ForwardHost) == 0 || !models.WildcardPattern.MatchString(f.ForwardHost) {
+			return f, errors.New("转发地址不符合规则")
+		}
+		rs, err := utils.ParsePortRanges(f.ForwardPorts)
+		if err != nil {
+			return f, errors.New("转发端口不符合规则")
+		}
+		f.ForwardPorts = utils.FormatPortRanges(rs)
+	default:
+		return f, errors.New("参数错误 type")
 	}
 	if ei, err := strconv.Atoi(f.ExpiresIn); err != nil || ei < 0 {
 		return f, errors.New("输入的时间无效")
@@ -90,19 +178,8 @@ func (f GrantCreateForm) Validate() (GrantCreateForm, error) {
 	return f, nil
 }
 
-// PostGrantsCreate create or update a grant
-func PostGrantsCreate(ctx *web.Context, f GrantCreateForm, fl *session.Flash, db *models.DB, a Auth) {
-	userID := ctx.Params(":userid")
-	defer ctx.Redirect(ctx.URLFor("user-grants", ":userid", userID))
-
-	var err error
-	if f, err = f.Validate(); err != nil {
-		fl.Error(err.Error())
-		return
-	}
-
-	g := models.Grant{}
-
+// createOrUpdateGrant create or update a grant for owner (user_id or group_id) from form
+func createOrUpdateGrant(db *models.DB, au *Auditor, f GrantCreateForm, userID uint, groupID uint) (g models.Grant, err error) {
 	am := map[string]interface{}{}
 
 	if f.ExpiresUnit == "e" {
@@ -115,21 +192,98 @@ func PostGrantsCreate(ctx *web.Context, f GrantCreateForm, fl *session.Flash, db
 		ei, _ := strconv.Atoi(f.ExpiresIn)
 		am["expires_at"] = time.Now().Add(eu * time.Duration(ei))
 	}
+	// keep link to approved request when grant is renewed or edited by admin
+	if f.RequestID != 0 {
+		am["request_id"] = f.RequestID
+	}
+
+	q := map[string]interface{}{
+		"user_id":        userID,
+		"group_id":       groupID,
+		"type":           f.Type,
+		"server_name":    f.ServerName,
+		"label_selector": f.LabelSelector,
+		"target_user":    f.TargetUser,
+		"forward_host":   f.ForwardHost,
+		"forward_ports":  f.ForwardPorts,
+	}
+
+	before := models.Grant{}
+	db.Where(q).First(&before)
+
+	if err = db.Where(q).Assign(am).FirstOrCreate(&g).Error; err != nil {
+		return
+	}
+	// reload, expires_at may be assigned with expression
+	db.First(&g, g.ID)
+
+	if before.ID == 0 {
+		au.Record("grant.create", auditTarget("grant", g.ID), nil, g)
+	} else {
+		au.Record("grant.update", auditTarget("grant", g.ID), before, g)
+	}
+	return
+}
+
+// PostGrantsCreate create or update a grant
+func PostGrantsCreate(ctx *web.Context, f GrantCreateForm, fl *session.Flash, db *models.DB, au *Auditor) {
+	userID := ctx.Params(":userid")
+	defer ctx.Redirect(ctx.URLFor("user-grants", ":userid", userID))
+
+	var err error
+	if f, err = f.Validate(); err != nil {
+		fl.Error(err.Error())
+		return
+	}
 
 	_userID, _ := strconv.Atoi(userID)
 
-	if err = db.Where(map[string]interface{}{
-		"user_id":     _userID,
-		"server_name": f.ServerName,
-		"target_user": f.TargetUser,
-	}).Assign(am).FirstOrCreate(&g).Error; err != nil {
+	if _, err = createOrUpdateGrant(db, au, f, uint(_userID), 0); err != nil {
+		fl.Error(err.Error())
+	}
+}
+
+// PostGroupGrantsCreate create or update a group grant
+func PostGroupGrantsCreate(ctx *web.Context, f GrantCreateForm, fl *session.Flash, db *models.DB, au *Auditor) {
+	groupID := ctx.Params(":groupid")
+	defer ctx.Redirect(ctx.URLFor("group-grants", ":groupid", groupID))
+
+	var err error
+	if f, err = f.Validate(); err != nil {
+		fl.Error(err.Error())
+		return
+	}
+
+	_groupID, _ := strconv.Atoi(groupID)
+
+	if _, err = createOrUpdateGrant(db, au, f, 0, uint(_groupID)); err != nil {
 		fl.Error(err.Error())
 	}
 }
 
 // PostGrantDestroy destroy a grant
-func PostGrantDestroy(ctx *web.Context, db *models.DB) {
+func PostGrantDestroy(ctx *web.Context, au *Auditor, db *models.DB) {
 	userID := ctx.Params(":userid")
 	defer ctx.Redirect(ctx.URLFor("user-grants", ":userid", userID))
-	db.Delete(&models.Grant{}, "user_id = ? AND id = ?", userID, ctx.Params(":id"))
+	destroyGrant(db, au, "user_id = ? AND id = ?", userID, ctx.Params(":id"))
+}
+
+// PostGroupGrantDestroy destroy a group grant
+func PostGroupGrantDestroy(ctx *web.Context, au *Auditor, db *models.DB) {
+	groupID := ctx.Params(":groupid")
+	defer ctx.Redirect(ctx.URLFor("group-grants", ":groupid", groupID))
+	destroyGrant(db, au, "group_id = ? AND user_id = ? AND id = ?", groupID, 0, ctx.Params(":id"))
+}
+
+// destroyGrant destroy a grant matching conditions
+func destroyGrant(db *models.DB, au *Auditor, where ...interface{}) (ok bool) {
+	g := models.Grant{}
+	if err := db.First(&g, where...).Error; err != nil || g.ID == 0 {
+		return
+	}
+	if err := db.Delete(&g).Error; err != nil {
+		return
+	}
+	au.Record("grant.destroy", auditTarget("grant", g.ID), g, nil)
+	return true
 }
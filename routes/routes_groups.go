@@ -0,0 +1,194 @@
+/**
+ * routes/routes_groups.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/utils"
+	"landzero.net/x/net/web"
+	"landzero.net/x/net/web/session"
+)
+
+// GroupItem group item
+type GroupItem struct {
+	ID          uint
+	Name        string
+	MemberCount uint
+	CreatedAt   string
+}
+
+// GroupMemberItem group member item
+type GroupMemberItem struct {
+	ID        uint
+	UserID    uint
+	Account   string
+	IsBlocked bool
+	CreatedAt string
+}
+
+// GetGroupsIndex show groups
+func GetGroupsIndex(ctx *web.Context, db *models.DB) {
+	ctx.Data["NavClass_Users"] = "active"
+	ctx.Data["SideClass_Groups"] = "active"
+
+	items := []GroupItem{}
+	groups := []models.Group{}
+	db.Order("name ASC").Find(&groups)
+
+	for _, g := range groups {
+		var c uint
+		db.Model(&models.GroupMember{}).Where("group_id = ?", g.ID).Count(&c)
+		items = append(items, GroupItem{
+			ID:          g.ID,
+			Name:        g.Name,
+			MemberCount: c,
+			CreatedAt:   TimeAgo(&g.CreatedAt),
+		})
+	}
+	ctx.Data["Groups"] = items
+
+	ctx.HTML(200, "groups/index")
+}
+
+// GroupCreateForm group create form
+type GroupCreateForm struct {
+	Name string `form:"name"`
+}
+
+// Validate validate the form
+func (f GroupCreateForm) Validate(db *models.DB) (GroupCreateForm, error) {
+	f.Name = strings.TrimSpace(f.Name)
+	if !models.NamePattern.MatchString(f.Name) {
+		return f, errors.New("分组名不符合规则")
+	}
+	var c uint
+	db.Model(&models.Group{}).Where("name = ?", f.Name).Count(&c)
+	if c > 0 {
+		return f, errors.New("分组名已经被占用")
+	}
+	return f, nil
+}
+
+// PostGroupsCreate create a group
+func PostGroupsCreate(ctx *web.Context, f GroupCreateForm, au *Auditor, db *models.DB, fl *session.Flash) {
+	defer ctx.Redirect(ctx.URLFor("groups"))
+	var err error
+	if f, err = f.Validate(db); err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	g := models.Group{Name: f.Name}
+	if err = db.Create(&g).Error; err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	au.Record("group.create", auditTarget("group", g.Name), nil, g)
+	fl.Success(fmt.Sprintf("创建分组 %s 成功", f.Name))
+}
+
+// PostGroupDestroy destroy a group, with members and grants
+func PostGroupDestroy(ctx *web.Context, au *Auditor, db *models.DB, fl *session.Flash) {
+	defer ctx.Redirect(ctx.URLFor("groups"))
+	g := models.Group{}
+	if err := db.First(&g, ctx.Params(":id")).Error; err != nil || g.ID == 0 {
+		fl.Error("无法找到分组")
+		return
+	}
+	if err := db.DestroyGroup(g.ID); err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	au.Record("group.destroy", auditTarget("group", g.Name), g, nil)
+}
+
+// GetGroupMembersIndex show group members
+func GetGroupMembersIndex(ctx *web.Context, db *models.DB, fl *session.Flash) {
+	g := models.Group{}
+	if err := db.First(&g, ctx.Params(":id")).Error; err != nil || g.ID == 0 {
+		fl.Error("无法找到分组")
+		ctx.Redirect(ctx.URLFor("groups"))
+		return
+	}
+	ctx.Data["NavClass_Users"] = "active"
+	ctx.Data["SideClass_Groups"] = "active"
+	ctx.Data["Group"] = g
+
+	items := []GroupMemberItem{}
+	ms := []models.GroupMember{}
+	db.Where("group_id = ?", g.ID).Find(&ms)
+
+	for _, m := range ms {
+		u := models.User{}
+		if db.First(&u, m.UserID).Error != nil || u.ID == 0 {
+			continue
+		}
+		items = append(items, GroupMemberItem{
+			ID:        m.ID,
+			UserID:    u.ID,
+			Account:   u.Account,
+			IsBlocked: utils.ToBool(u.IsBlocked),
+			CreatedAt: TimeAgo(&m.CreatedAt),
+		})
+	}
+	ctx.Data["Members"] = items
+
+	ctx.HTML(200, "groups/members")
+}
+
+// GroupMemberCreateForm group member create form
+type GroupMemberCreateForm struct {
+	Account string `form:"account"`
+}
+
+// PostGroupMembersCreate add a user to group
+func PostGroupMembersCreate(ctx *web.Context, f GroupMemberCreateForm, au *Auditor, db *models.DB, fl *session.Flash) {
+	id := ctx.Params(":id")
+	defer ctx.Redirect(ctx.URLFor("group-members", ":id", id))
+	g := models.Group{}
+	if err := db.First(&g, id).Error; err != nil || g.ID == 0 {
+		fl.Error("无法找到分组")
+		return
+	}
+	u := models.User{}
+	if err := db.First(&u, "account = ?", strings.TrimSpace(f.Account)).Error; err != nil || u.ID == 0 {
+		fl.Error("无法找到用户")
+		return
+	}
+	m := models.GroupMember{}
+	if err := db.FirstOrCreate(&m, map[string]interface{}{
+		"group_id": g.ID,
+		"user_id":  u.ID,
+	}).Error; err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	au.Record("group.add-member", auditTarget("group", g.Name), nil, map[string]interface{}{"memberId": m.ID, "account": u.Account})
+	fl.Success(fmt.Sprintf("已将用户 %s 加入分组 %s", u.Account, g.Name))
+}
+
+// PostGroupMemberDestroy remove a user from group
+func PostGroupMemberDestroy(ctx *web.Context, au *Auditor, db *models.DB) {
+	id := ctx.Params(":id")
+	defer ctx.Redirect(ctx.URLFor("group-members", ":id", id))
+	m := models.GroupMember{}
+	if err := db.First(&m, "group_id = ? AND id = ?", id, ctx.Params(":memberid")).Error; err != nil || m.ID == 0 {
+		return
+	}
+	if err := db.Delete(&m).Error; err != nil {
+		return
+	}
+	g, u := models.Group{}, models.User{}
+	db.First(&g, m.GroupID)
+	db.First(&u, m.UserID)
+	au.Record("group.remove-member", auditTarget("group", g.Name), map[string]interface{}{"memberId": m.ID, "account": u.Account}, nil)
+}
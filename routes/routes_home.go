@@ -11,9 +11,14 @@ package routes
 import (
 	"errors"
 	"fmt"
+	"log"
+	"math"
 
+	"github.com/yankeguo/bunker/directory"
+	"github.com/yankeguo/bunker/events"
 	"github.com/yankeguo/bunker/models"
 	"github.com/yankeguo/bunker/types"
+	"github.com/yankeguo/bunker/utils"
 	"landzero.net/x/net/web"
 	"landzero.net/x/net/web/captcha"
 	"landzero.net/x/net/web/session"
@@ -48,22 +53,92 @@ func GetIndex(ctx *web.Context, r web.Render, a Auth, db *models.DB, cfg types.C
 	}
 
 	ctx.Data["CombinedGrants"] = ci
+	ctx.Data["DeniedAttempts"] = recentDeniedItems(db, a.User().Account)
+	if canReviewGrantRequests(db, cfg, a.User()) {
+		ctx.Data["PendingGrantRequests"] = pendingGrantRequestItems(db)
+	}
 	ctx.HTML(200, "index")
 }
 
 // GetLogin get login page
-func GetLogin(ctx *web.Context) {
+func GetLogin(ctx *web.Context, cfg types.Config) {
+	ctx.Data["IsPasswordEnabled"] = !cfg.OIDC.DisablePassword || cfg.LDAP.Enable
+	ctx.Data["IsOIDCEnabled"] = cfg.OIDC.Enable
+	if len(cfg.OIDC.ButtonText) > 0 {
+		ctx.Data["OIDCButtonText"] = cfg.OIDC.ButtonText
+	} else {
+		ctx.Data["OIDCButtonText"] = "单点登录"
+	}
 	ctx.HTML(200, "login")
 }
 
+// GetLoginOIDC redirect to openid connect provider
+func GetLoginOIDC(ctx *web.Context, o *OIDC, sess session.Store, fl *session.Flash, cfg types.Config) {
+	var err error
+	var state, nonce, u string
+	if cfg.OIDC.Enable {
+		if state, err = utils.RandomHex(16); err == nil {
+			if nonce, err = utils.RandomHex(16); err == nil {
+				u, err = o.AuthCodeURL(ctx.Req.Context(), state, nonce)
+			}
+		}
+	} else {
+		err = errors.New("未开启单点登录")
+	}
+	if err != nil {
+		log.Println("OIDC:", err)
+		fl.Error("单点登录失败")
+		ctx.Redirect("/login")
+		return
+	}
+	sess.Set("oidc_state", state)
+	sess.Set("oidc_nonce", nonce)
+	ctx.Redirect(u)
+}
+
+// GetLoginOIDCCallback callback of openid connect provider
+func GetLoginOIDCCallback(ctx *web.Context, o *OIDC, a Auth, db *models.DB, em *events.Emitter, bo *utils.Backoff, sess session.Store, fl *session.Flash, cfg types.Config) {
+	state, _ := sess.Get("oidc_state").(string)
+	nonce, _ := sess.Get("oidc_nonce").(string)
+	sess.Delete("oidc_state")
+	sess.Delete("oidc_nonce")
+	var err error
+	var u models.User
+	if !cfg.OIDC.Enable || len(state) == 0 || ctx.Query("state") != state {
+		err = errors.New("invalid state")
+	} else if len(ctx.Query("error")) > 0 {
+		err = fmt.Errorf("%s: %s", ctx.Query("error"), ctx.Query("error_description"))
+	} else {
+		var claims map[string]interface{}
+		var account string
+		if claims, err = o.Exchange(ctx.Req.Context(), ctx.Query("code"), nonce); err == nil {
+			if account, err = OIDCAccount(cfg.OIDC, claims); err == nil {
+				if !models.NamePattern.MatchString(account) {
+					err = fmt.Errorf("invalid account %s", account)
+				} else {
+					u, err = db.FindOrCreateOIDCUser(account, OIDCIsAdmin(cfg.OIDC, claims))
+				}
+			}
+		}
+	}
+	if err != nil {
+		log.Println("OIDC:", err)
+		emitAuthFailure(ctx, em, u.Account, AuthMethodOIDC, err.Error())
+		fl.Error("单点登录失败")
+		ctx.Redirect("/login")
+		return
+	}
+	signIn(ctx, a, db, em, bo, sess, fl, &u, AuthMethodOIDC)
+}
+
 // LoginForm the login form
 type LoginForm struct {
 	Account  string `form:"account"`
 	Password string `form:"password"`
 }
 
-// Validate validate form
-func (f LoginForm) Validate(db *models.DB) (u *models.User, err error) {
+// Validate validate form, local users are checked first, then ldap if enabled
+func (f LoginForm) Validate(db *models.DB, cfg types.Config) (u *models.User, err error) {
 	if len(f.Account) == 0 {
 		err = errors.New("请输入用户名")
 		return
@@ -72,37 +147,134 @@ func (f LoginForm) Validate(db *models.DB) (u *models.User, err error) {
 		err = errors.New("请输入密码")
 		return
 	}
-	if u, err = db.FindUserByLogin(f.Account, f.Password); err != nil {
-		err = errors.New("请填写正确的用户名和密码")
+	if !cfg.OIDC.DisablePassword {
+		if u, err = db.FindUserByLogin(f.Account, f.Password); err == nil {
+			return
+		}
+	}
+	if cfg.LDAP.Enable {
+		if u, err = loginLDAP(db, cfg.LDAP, f.Account, f.Password); err == nil {
+			return
+		}
+		log.Println("LDAP:", err)
+	}
+	err = errors.New("请填写正确的用户名和密码")
+	return
+}
+
+// loginLDAP authenticate with ldap, user is created or updated from directory on success
+func loginLDAP(db *models.DB, cfg types.LDAPConfig, account, password string) (u *models.User, err error) {
+	var e directory.Entry
+	if e, err = directory.NewLDAP(cfg).Authenticate(account, password); err != nil {
+		return
+	}
+	m := directory.Resolve(cfg, e)
+	var du models.User
+	if du, err = db.SyncDirectoryUser(e.Account, m.IsAdmin, m.Groups, directory.ManagedGroups(cfg)); err != nil {
 		return
 	}
+	u = &du
 	return
 }
 
+// checkLoginLocked check whether web login of account is locked
+func checkLoginLocked(db *models.DB, account string) error {
+	u := models.User{}
+	if db.First(&u, "account = ?", account).Error != nil || !u.IsLocked() {
+		return nil
+	}
+	return fmt.Errorf("登录失败次数过多，账户已被锁定至 %s，请稍后重试或联系管理员解锁", u.LockedUntil.Format("2006-01-02 15:04"))
+}
+
+// recordFailedLogin count failed login against source ip and account, err is replaced if account is locked
+func recordFailedLogin(db *models.DB, bo *utils.Backoff, cfg types.Config, account string, ip string, err error) error {
+	bo.Fail(ip)
+	locked, rerr := db.RecordFailedLogin(account, ip, cfg.Login)
+	if rerr != nil {
+		log.Println("Login:", rerr)
+	}
+	if locked {
+		return fmt.Errorf("登录失败次数过多，账户已被锁定 %d 分钟", int(cfg.Login.LockTime().Minutes()))
+	}
+	return err
+}
+
 // PostLogin get login page
-func PostLogin(ctx *web.Context, f LoginForm, fl *session.Flash, a Auth, db *models.DB, cap *captcha.Captcha, sess session.Store) {
+func PostLogin(ctx *web.Context, f LoginForm, fl *session.Flash, a Auth, db *models.DB, em *events.Emitter, bo *utils.Backoff, cap *captcha.Captcha, sess session.Store, cfg types.Config) {
 	var err error
 	var u *models.User
 
-	if !cap.VerifyReq(ctx.Req) {
+	if w := bo.Wait(ctx.RemoteAddr()); w > 0 {
+		err = fmt.Errorf("登录失败次数过多，请 %d 秒后重试", int(math.Ceil(w.Seconds())))
+	} else if !cap.VerifyReq(ctx.Req) {
 		err = errors.New("请填写正确的验证码")
-	} else {
-		u, err = f.Validate(db)
+	} else if err = checkLoginLocked(db, f.Account); err == nil {
+		if u, err = f.Validate(db, cfg); err != nil {
+			err = recordFailedLogin(db, bo, cfg, f.Account, ctx.RemoteAddr(), err)
+		}
 	}
 
 	if err != nil {
+		emitAuthFailure(ctx, em, f.Account, AuthMethodPassword, err.Error())
 		fl.Error(err.Error())
 		ctx.Redirect("/login")
 		return
 	}
 
+	method := AuthMethodPassword
+	if u.Source == models.UserSourceLDAP {
+		method = AuthMethodLDAP
+	}
+	signIn(ctx, a, db, em, bo, sess, fl, u, method)
+}
+
+// signIn sign in user authenticated by method, or redirect to second factor if enabled, blocked user is rejected
+func signIn(ctx *web.Context, a Auth, db *models.DB, em *events.Emitter, bo *utils.Backoff, sess session.Store, fl *session.Flash, u *models.User, method string) {
+	if utils.ToBool(u.IsBlocked) {
+		emitAuthFailure(ctx, em, u.Account, method, "user is blocked")
+		fl.Error("用户已被封禁")
+		ctx.Redirect("/login")
+		return
+	}
+	if utils.ToBool(u.IsTOTPEnabled) {
+		sess.Set("totp_user_id", fmt.Sprintf("%d", u.ID))
+		sess.Set("totp_method", method)
+		sess.Delete("totp_attempts")
+		ctx.Redirect("/login/totp")
+		return
+	}
 	db.Touch(u)
-	a.SetUser(u)
+	db.ResetFailedLogins(u)
+	bo.Reset(ctx.RemoteAddr())
+	a.SetUser(u, method)
+	emitLogin(ctx, em, u.Account, method)
 	ctx.Redirect("/")
 }
 
+// emitLogin export web login event
+func emitLogin(ctx *web.Context, em *events.Emitter, account string, method string) {
+	em.Emit(events.Event{
+		Type:     events.TypeLogin,
+		User:     account,
+		SourceIP: ctx.RemoteAddr(),
+		Message:  fmt.Sprintf("web login of %s from %s", account, ctx.RemoteAddr()),
+		Fields:   map[string]string{"service": "web", "method": method},
+	})
+}
+
+// emitAuthFailure export web authentication failure event
+func emitAuthFailure(ctx *web.Context, em *events.Emitter, account string, method string, reason string) {
+	em.Emit(events.Event{
+		Type:     events.TypeAuthFailure,
+		User:     account,
+		SourceIP: ctx.RemoteAddr(),
+		Message:  fmt.Sprintf("web authentication failed for %s from %s", account, ctx.RemoteAddr()),
+		Fields:   map[string]string{"service": "web", "method": method, "reason": reason},
+	})
+}
+
 // PostLogout logout
 func PostLogout(ctx *web.Context, a Auth) {
-	a.SetUser(nil)
+	a.SetUser(nil, "")
 	ctx.Redirect("/login")
 }
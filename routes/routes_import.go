@@ -18,7 +18,7 @@ import (
 )
 
 // PostImportSSHConfig post import ssh config
-func PostImportSSHConfig(ctx *web.Context, db *models.DB) {
+func PostImportSSHConfig(ctx *web.Context, db *models.DB, au *Auditor) {
 	c, err := ctx.Req.Body().Bytes()
 	if err != nil {
 		ctx.PlainText(500, []byte(err.Error()))
@@ -27,12 +27,39 @@ func PostImportSSHConfig(ctx *web.Context, db *models.DB) {
 
 	ls := utils.ParseSSHConfig(c)
 	for _, e := range ls {
-		db.Assign(map[string]interface{}{
+		// the last hop of ProxyJump is the proxy of this server
+		var proxy string
+		if len(e.ProxyJump) > 0 {
+			proxy = e.ProxyJump[len(e.ProxyJump)-1]
+		}
+		before, s := models.Server{}, models.Server{}
+		db.First(&before, "name = ?", e.Name)
+		if db.Assign(map[string]interface{}{
 			"is_auto": utils.False,
 			"address": e.Address,
-		}).FirstOrCreate(&models.Server{}, map[string]interface{}{
+			"proxy":   proxy,
+		}).FirstOrCreate(&s, map[string]interface{}{
 			"name": e.Name,
-		})
+		}).Error != nil {
+			continue
+		}
+		if before.ID == 0 {
+			au.Record("server.create", auditTarget("server", s.Name), nil, s)
+		} else {
+			au.Record("server.update", auditTarget("server", s.Name), before, s)
+		}
+	}
+	// chained hops of ProxyJump, fill proxy of intermediate servers without one
+	for _, e := range ls {
+		for i := 1; i < len(e.ProxyJump); i++ {
+			s := models.Server{}
+			if db.First(&s, "name = ? AND is_auto = ?", e.ProxyJump[i], utils.False).Error == nil && s.ID > 0 && len(s.Proxy) == 0 {
+				before := s
+				if db.Model(&s).Update("proxy", e.ProxyJump[i-1]).Error == nil {
+					au.Record("server.update", auditTarget("server", s.Name), before, s)
+				}
+			}
+		}
 	}
 
 	ctx.PlainText(200, []byte(fmt.Sprintf("OK, imported %d", len(ls))))
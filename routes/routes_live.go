@@ -0,0 +1,135 @@
+/**
+ * routes/routes_live.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/utils"
+	"landzero.net/x/net/web"
+	"landzero.net/x/net/web/session"
+)
+
+// liveWriteTimeout watchers failed to receive a frame in time are disconnected
+const liveWriteTimeout = time.Second * 10
+
+var liveUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 32 * 1024,
+}
+
+func liveSession(ctx *web.Context, hub *utils.LiveHub) *utils.LiveSession {
+	id, _ := strconv.Atoi(ctx.Params(":id"))
+	return hub.Get(uint(id))
+}
+
+// GetSessionLive watch a in-progress session
+func GetSessionLive(ctx *web.Context, db *models.DB, hub *utils.LiveHub, fl *session.Flash) {
+	s := models.Session{}
+	if err := db.First(&s, ctx.Params(":id")).Error; err != nil || s.ID == 0 {
+		fl.Error("没有找到操作记录")
+		ctx.Redirect(ctx.URLFor("sessions"))
+		return
+	}
+	if liveSession(ctx, hub) == nil {
+		fl.Error("该会话已经结束")
+		ctx.Redirect(ctx.URLFor("sessions"))
+		return
+	}
+	ctx.Data["Session"] = s
+	ctx.Data["Session_Target"] = SessionTarget(s)
+	ctx.Data["Session_StartedAt"] = PrettyTime(&s.StartedAt)
+	ctx.HTML(http.StatusOK, "sessions/live")
+}
+
+// GetSessionLiveStream stream frames of a in-progress session over websocket, in replay file format
+func GetSessionLiveStream(ctx *web.Context, hub *utils.LiveHub) {
+	l := liveSession(ctx, hub)
+	if l == nil {
+		ctx.PlainText(http.StatusNotFound, []byte("Not Found"))
+		return
+	}
+	conn, err := liveUpgrader.Upgrade(ctx.Resp, ctx.Req.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	ch, cancel := l.Watch()
+	defer cancel()
+	// watcher sends nothing, read until closed
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case f, ok := <-ch:
+			conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "session ended"))
+				return
+			}
+			if err = conn.WriteMessage(websocket.BinaryMessage, f); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// PostSessionTerminate terminate a in-progress session
+func PostSessionTerminate(ctx *web.Context, a Auth, au *Auditor, hub *utils.LiveHub, fl *session.Flash) {
+	defer ctx.Redirect(ctx.URLFor("sessions"))
+	l := liveSession(ctx, hub)
+	if l == nil {
+		fl.Error("该会话已经结束")
+		return
+	}
+	log.Printf("Live: session %d terminated by %s", l.ID, a.User().Account)
+	l.Terminate()
+	au.Record("session.terminate", auditTarget("session", l.ID), nil, nil)
+	fl.Success("会话已终止")
+}
+
+// SessionMessageForm session message form
+type SessionMessageForm struct {
+	Message string `form:"message"`
+}
+
+// PostSessionMessage write a warning message to terminal of a in-progress session
+func PostSessionMessage(ctx *web.Context, f SessionMessageForm, a Auth, au *Auditor, hub *utils.LiveHub, fl *session.Flash) {
+	l := liveSession(ctx, hub)
+	if l == nil {
+		fl.Error("该会话已经结束")
+		ctx.Redirect(ctx.URLFor("sessions"))
+		return
+	}
+	defer ctx.Redirect(ctx.URLFor("session-live", ":id", ctx.Params(":id")))
+	msg := strings.TrimSpace(f.Message)
+	if len(msg) == 0 {
+		fl.Error("消息不能为空")
+		return
+	}
+	log.Printf("Live: message sent to session %d by %s: %s", l.ID, a.User().Account, msg)
+	l.Inject(msg)
+	au.Record("session.message", auditTarget("session", l.ID), nil, map[string]interface{}{"message": msg})
+	fl.Success("消息已发送")
+}
@@ -0,0 +1,134 @@
+/**
+ * routes/routes_sandboxes.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/sandbox"
+	"github.com/yankeguo/bunker/types"
+	"landzero.net/x/net/web"
+	"landzero.net/x/net/web/session"
+)
+
+// SandboxItem sandbox item
+type SandboxItem struct {
+	Account     string
+	Name        string
+	IsRunning   bool
+	Status      string
+	CreatedAt   string
+	Conns       int
+	ActiveAt    string
+	LastSession string
+	Limits      string
+}
+
+// SandboxLimitsText human readable sandbox limits
+func SandboxLimitsText(l types.SandboxLimits) string {
+	out := []string{}
+	if l.CPUs > 0 {
+		out = append(out, fmt.Sprintf("CPU %s", strconv.FormatFloat(l.CPUs, 'f', -1, 64)))
+	}
+	if l.Memory > 0 {
+		out = append(out, fmt.Sprintf("内存 %s", PrettyBytes(l.Memory*1024*1024)))
+	}
+	if l.Pids > 0 {
+		out = append(out, fmt.Sprintf("进程 %d", l.Pids))
+	}
+	if l.Disk > 0 {
+		out = append(out, fmt.Sprintf("磁盘 %s", PrettyBytes(l.Disk*1024*1024)))
+	}
+	if len(out) == 0 {
+		return "无限制"
+	}
+	return strings.Join(out, ", ")
+}
+
+// GetSandboxesIndex show sandboxes
+func GetSandboxesIndex(ctx *web.Context, db *models.DB, m sandbox.Manager, t *sandbox.Tracker, fl *session.Flash, cfg types.Config) {
+	ctx.Data["NavClass_Sandboxes"] = "active"
+	ss, err := m.List()
+	if err != nil {
+		fl.Error(fmt.Sprintf("无法获取沙箱列表: %s", err.Error()))
+	}
+	items := []SandboxItem{}
+	for _, s := range ss {
+		conns, last := t.Activity(s.Account)
+		item := SandboxItem{
+			Account:     s.Account,
+			Name:        s.Name,
+			IsRunning:   s.IsRunning,
+			Status:      s.Status,
+			CreatedAt:   "-",
+			Conns:       conns,
+			ActiveAt:    TimeAgo(&last),
+			LastSession: "-",
+		}
+		if !s.CreatedAt.IsZero() {
+			item.CreatedAt = TimeAgo(&s.CreatedAt)
+		}
+		u := models.User{}
+		db.First(&u, "account = ?", s.Account)
+		item.Limits = SandboxLimitsText(u.SandboxLimits(cfg))
+		// last sandbox session, server_name is empty for sandbox session
+		sess := models.Session{}
+		if db.Order("id DESC").First(&sess, "user_account = ? AND server_name = ?", s.Account, "").Error == nil && sess.ID > 0 {
+			item.LastSession = PrettyTime(&sess.StartedAt)
+		}
+		items = append(items, item)
+	}
+	ctx.Data["Sandboxes"] = items
+	ctx.HTML(200, "sandboxes/index")
+}
+
+// PostSandboxStop stop a sandbox
+func PostSandboxStop(ctx *web.Context, au *Auditor, m sandbox.Manager, fl *session.Flash) {
+	defer ctx.Redirect(ctx.URLFor("sandboxes"))
+	if err := m.Stop(ctx.Params(":account")); err != nil {
+		fl.Error(fmt.Sprintf("停止沙箱失败: %s", err.Error()))
+		return
+	}
+	au.Record("sandbox.stop", auditTarget("sandbox", ctx.Params(":account")), nil, nil)
+	fl.Success("已停止沙箱")
+}
+
+// PostSandboxReset reset a sandbox
+func PostSandboxReset(ctx *web.Context, au *Auditor, db *models.DB, m sandbox.Manager, fl *session.Flash, cfg types.Config) {
+	defer ctx.Redirect(ctx.URLFor("sandboxes"))
+	account := ctx.Params(":account")
+	// defaults are used if user is missing
+	u := models.User{}
+	db.First(&u, "account = ?", account)
+	if err := m.Reset(account, u.SandboxLimits(cfg)); err != nil {
+		fl.Error(fmt.Sprintf("重置沙箱失败: %s", err.Error()))
+		return
+	}
+	au.Record("sandbox.reset", auditTarget("sandbox", account), nil, nil)
+	fl.Success("已重置沙箱")
+}
+
+// SandboxDestroyForm sandbox destroy form
+type SandboxDestroyForm struct {
+	Wipe string `form:"wipe"`
+}
+
+// PostSandboxDestroy destroy a sandbox
+func PostSandboxDestroy(ctx *web.Context, f SandboxDestroyForm, au *Auditor, m sandbox.Manager, fl *session.Flash) {
+	defer ctx.Redirect(ctx.URLFor("sandboxes"))
+	if err := m.Destroy(ctx.Params(":account"), f.Wipe == "on"); err != nil {
+		fl.Error(fmt.Sprintf("删除沙箱失败: %s", err.Error()))
+		return
+	}
+	au.Record("sandbox.destroy", auditTarget("sandbox", ctx.Params(":account")), map[string]interface{}{"wipe": f.Wipe == "on"}, nil)
+	fl.Success("已删除沙箱")
+}
@@ -49,10 +49,15 @@ type ServerItem struct {
 	ID        uint
 	Name      string
 	Address   string
+	Labels    []string
+	Proxy     string
 	CreatedAt string
 	UpdatedAt string
 	IsAuto    bool
 	UsedAt    string
+
+	HostKeyFingerprint        string
+	PendingHostKeyFingerprint string
 }
 
 // ServerItems slice of server item
@@ -77,10 +82,14 @@ func GetServersIndex(ctx *web.Context, cfg types.Config, db *models.DB, sess ses
 			ID:        s.ID,
 			Name:      s.Name,
 			Address:   s.Address,
+			Labels:    splitLabels(s.Labels),
+			Proxy:     s.Proxy,
 			CreatedAt: TimeAgo(&s.CreatedAt),
 			UpdatedAt: TimeAgo(&s.UpdatedAt),
 			IsAuto:    utils.ToBool(s.IsAuto),
 			UsedAt:    TimeAgo(s.UsedAt),
+
+			PendingHostKeyFingerprint: s.PendingHostKeyFingerprint,
 		})
 	}
 
@@ -90,12 +99,84 @@ func GetServersIndex(ctx *web.Context, cfg types.Config, db *models.DB, sess ses
 	ctx.HTML(200, "servers/index")
 }
 
+// GetServerHostKeys list host key fingerprints of all servers
+func GetServerHostKeys(ctx *web.Context, db *models.DB) {
+	ctx.Data["NavClass_Servers"] = "active"
+	ctx.Data["SideClass_HostKeys"] = "active"
+
+	ss := []models.Server{}
+	db.Order("pending_host_key_fingerprint DESC").Order("name ASC").Find(&ss)
+
+	items := []ServerItem{}
+
+	for _, s := range ss {
+		items = append(items, ServerItem{
+			ID:        s.ID,
+			Name:      s.Name,
+			Address:   s.Address,
+			UpdatedAt: TimeAgo(&s.UpdatedAt),
+			IsAuto:    utils.ToBool(s.IsAuto),
+
+			HostKeyFingerprint:        s.HostKeyFingerprint,
+			PendingHostKeyFingerprint: s.PendingHostKeyFingerprint,
+		})
+	}
+
+	ctx.Data["Servers"] = items
+
+	ctx.HTML(200, "servers/host-keys")
+}
+
+// PostServerHostKeyAccept accept the pending host key of a server
+func PostServerHostKeyAccept(ctx *web.Context, au *Auditor, db *models.DB, fl *session.Flash) {
+	defer ctx.Redirect(ctx.URLFor("host-keys"))
+	s := models.Server{}
+	if err := db.First(&s, ctx.Params(":id")).Error; err != nil || s.ID == 0 {
+		fl.Error("没有找到目标服务器")
+		return
+	}
+	before := s
+	if err := db.AcceptServerHostKey(&s); err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	au.Record("server.accept-host-key", auditTarget("server", s.Name), before, s)
+	fl.Success(fmt.Sprintf("已接受服务器 %s 的新主机密钥", s.Name))
+}
+
+// PostServerHostKeyReset clear the pinned host key of a server
+func PostServerHostKeyReset(ctx *web.Context, au *Auditor, db *models.DB, fl *session.Flash) {
+	defer ctx.Redirect(ctx.URLFor("host-keys"))
+	s := models.Server{}
+	if err := db.First(&s, ctx.Params(":id")).Error; err != nil || s.ID == 0 {
+		fl.Error("没有找到目标服务器")
+		return
+	}
+	before := s
+	if err := db.ResetServerHostKey(&s); err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	au.Record("server.reset-host-key", auditTarget("server", s.Name), before, s)
+	fl.Success(fmt.Sprintf("已重置服务器 %s 的主机密钥，下次连接时将自动记录", s.Name))
+}
+
+// splitLabels split formatted labels for display
+func splitLabels(ls string) []string {
+	if len(ls) == 0 {
+		return []string{}
+	}
+	return strings.Split(ls, ",")
+}
+
 // GetServersNew get servers new
 func GetServersNew(ctx *web.Context, sess session.Store) {
 	ctx.Data["NavClass_Servers"] = "active"
 	ctx.Data["Server"] = map[string]string{
 		"Name":    ctx.Query("name"),
 		"Address": ctx.Query("address"),
+		"Labels":  ctx.Query("labels"),
+		"Proxy":   ctx.Query("proxy"),
 	}
 	ctx.HTML(200, "servers/new")
 }
@@ -104,12 +185,15 @@ func GetServersNew(ctx *web.Context, sess session.Store) {
 type ServerCreateForm struct {
 	Name    string `form:"name"`
 	Address string `form:"address"`
+	Labels  string `form:"labels"`
+	Proxy   string `form:"proxy"`
 }
 
 // Validate validate
 func (f ServerCreateForm) Validate() (ServerCreateForm, error) {
 	f.Name = strings.TrimSpace(f.Name)
 	f.Address = strings.TrimSpace(f.Address)
+	f.Proxy = strings.TrimSpace(f.Proxy)
 
 	if len(f.Address) == 0 {
 		return f, errors.New("服务器地址不能为空")
@@ -122,23 +206,47 @@ func (f ServerCreateForm) Validate() (ServerCreateForm, error) {
 	if len(strings.Split(f.Address, ":")) < 2 {
 		f.Address = fmt.Sprintf("%s:22", f.Address)
 	}
+
+	ls, err := utils.ParseLabels(f.Labels)
+	if err != nil {
+		return f, errors.New("服务器标签不符合规则")
+	}
+	f.Labels = utils.FormatLabels(ls)
+
+	if len(f.Proxy) > 0 && (!models.NamePattern.MatchString(f.Proxy) || f.Proxy == f.Name) {
+		return f, errors.New("跳板服务器名称不符合规则")
+	}
 	return f, nil
 }
 
+// ValidateProxy validate proxy chain of the server
+func (f ServerCreateForm) ValidateProxy(db *models.DB) error {
+	if _, err := db.FindProxyChain(models.Server{Name: f.Name, Proxy: f.Proxy}); err != nil {
+		return fmt.Errorf("跳板服务器不正确: %s", err.Error())
+	}
+	return nil
+}
+
 // PostServerCreate post server add
-func PostServerCreate(ctx *web.Context, f ServerCreateForm, fl *session.Flash, db *models.DB, sess session.Store) {
+func PostServerCreate(ctx *web.Context, f ServerCreateForm, au *Auditor, fl *session.Flash, db *models.DB, sess session.Store) {
 	var err error
-	if f, err = f.Validate(); err != nil {
+	if f, err = f.Validate(); err == nil {
+		err = f.ValidateProxy(db)
+	}
+	if err != nil {
 		fl.Error(err.Error())
-		ctx.Redirect(AppendQuery(ctx.URLFor("new-server"), "name", f.Name, "address", f.Address))
+		ctx.Redirect(AppendQuery(ctx.URLFor("new-server"), "name", f.Name, "address", f.Address, "labels", f.Labels, "proxy", f.Proxy))
 		return
 	}
 	s := models.Server{
 		Name:    f.Name,
 		Address: f.Address,
+		Labels:  f.Labels,
+		Proxy:   f.Proxy,
 	}
 	err = db.Create(&s).Error
 	if err == nil {
+		au.Record("server.create", auditTarget("server", s.Name), nil, s)
 		fl.Success(fmt.Sprintf("添加服务器 %s 成功", f.Name))
 	} else {
 		fl.Error(err.Error())
@@ -164,10 +272,13 @@ func GetServerEdit(ctx *web.Context, db *models.DB, fl *session.Flash) {
 }
 
 // PostServerUpdate post server update
-func PostServerUpdate(ctx *web.Context, f ServerCreateForm, fl *session.Flash, db *models.DB, sess session.Store) {
+func PostServerUpdate(ctx *web.Context, f ServerCreateForm, au *Auditor, fl *session.Flash, db *models.DB, sess session.Store) {
 	id := ctx.Params(":id")
 	var err error
-	if f, err = f.Validate(); err != nil {
+	if f, err = f.Validate(); err == nil {
+		err = f.ValidateProxy(db)
+	}
+	if err != nil {
 		fl.Error(err.Error())
 		ctx.Redirect(ctx.URLFor("edit-server", ":id", id))
 		return
@@ -182,25 +293,36 @@ func PostServerUpdate(ctx *web.Context, f ServerCreateForm, fl *session.Flash, d
 		fl.Error("无法编辑自动管理的服务器")
 		ctx.Redirect(ctx.URLFor("servers"))
 	}
-	if err = db.Model(&s).Update(map[string]interface{}{"address": f.Address}).Error; err != nil {
+	before := s
+	if err = db.Model(&s).Update(map[string]interface{}{"address": f.Address, "labels": f.Labels, "proxy": f.Proxy}).Error; err != nil {
 		fl.Error(err.Error())
 		ctx.Redirect(ctx.URLFor("edit-server", ":id", id))
 		return
 	}
+	au.Record("server.update", auditTarget("server", s.Name), before, s)
 	fl.Success(fmt.Sprintf("服务器 %s 更新成功", f.Name))
 	ctx.Redirect(ctx.URLFor("servers"))
 }
 
 // PostServerDestroy post server destroy
-func PostServerDestroy(ctx *web.Context, db *models.DB) {
+func PostServerDestroy(ctx *web.Context, au *Auditor, db *models.DB) {
 	defer ctx.Redirect(ctx.URLFor("servers"))
-	db.Delete(&models.Server{}, "id = ? AND is_auto = ?", ctx.Params(":id"), utils.False)
+	s := models.Server{}
+	if err := db.First(&s, "id = ? AND is_auto = ?", ctx.Params(":id"), utils.False).Error; err != nil || s.ID == 0 {
+		return
+	}
+	if err := db.Delete(&s).Error; err == nil {
+		au.Record("server.destroy", auditTarget("server", s.Name), s, nil)
+	}
 }
 
-// GetMasterKey get master key
-func GetMasterKey(ctx *web.Context, cfg types.Config) {
+// GetMasterKey get master key, and public keys of credentials
+func GetMasterKey(ctx *web.Context, db *models.DB, cfg types.Config) {
 	ctx.Data["NavClass_Servers"] = "active"
 	ctx.Data["SideClass_MasterKey"] = "active"
 	ctx.Data["MasterPublicKey"] = GenerateClientAuthorizedKey(cfg)
+	cs := []models.Credential{}
+	db.Order("priority DESC").Order("id ASC").Find(&cs, "public_key <> ''")
+	ctx.Data["Credentials"] = credentialItems(cs)
 	ctx.HTML(200, "servers/master-key")
 }
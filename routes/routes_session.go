@@ -9,8 +9,11 @@
 package routes
 
 import (
+	"fmt"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/yankeguo/bunker/models"
 	"github.com/yankeguo/bunker/types"
@@ -23,17 +26,26 @@ import (
 type SessionItem struct {
 	ID         uint
 	User       string
+	Target     string
 	Command    string
 	StartedAt  string
 	EndedAt    string
 	IsRecorded bool
+	IsTransfer bool
+	IsAgent    bool
+	Forward    string
+	Traffic    string
+	Duration   string
+	Exit       string
+	IsFailed   bool
+	IsLive     bool
 }
 
 // SessionsPerPage sessions per page
 const SessionsPerPage = 50
 
 // GetSessionsIndex get sessions index
-func GetSessionsIndex(ctx *web.Context, db *models.DB, cfg types.Config) {
+func GetSessionsIndex(ctx *web.Context, db *models.DB, cfg types.Config, hub *utils.LiveHub) {
 	ctx.Data["NavClass_Sessions"] = "active"
 	var err error
 	// calculate page 0 based
@@ -56,16 +68,76 @@ func GetSessionsIndex(ctx *web.Context, db *models.DB, cfg types.Config) {
 		out = append(out, SessionItem{
 			ID:         s.ID,
 			User:       s.UserAccount,
+			Target:     SessionTarget(s),
 			Command:    s.Command,
 			StartedAt:  PrettyTime(&s.StartedAt),
 			EndedAt:    PrettyTime(s.EndedAt),
 			IsRecorded: utils.ToBool(s.IsRecorded),
+			IsTransfer: IsTransferCommand(s.Command),
+			IsAgent:    utils.ToBool(s.IsAgentUsed),
+			Forward:    SessionForward(s),
+			Traffic:    SessionTraffic(s),
+			Duration:   SessionDuration(s),
+			Exit:       SessionExit(s),
+			IsFailed:   s.ExitCode != nil && *s.ExitCode != 0,
+			IsLive:     hub.Get(s.ID) != nil,
 		})
 	}
 	ctx.Data["Sessions"] = out
 	ctx.HTML(200, "sessions/index")
 }
 
+// IsTransferCommand whether the session command is a file transfer
+func IsTransferCommand(cmd string) bool {
+	return cmd == "sftp" || strings.HasPrefix(cmd, "scp ")
+}
+
+// SessionForward forward description of a session, empty for non forward session
+func SessionForward(s models.Session) string {
+	switch s.Type {
+	case models.SessionTypeForward:
+		return fmt.Sprintf("-L %s", s.ForwardAddress)
+	case models.SessionTypeRemoteForward:
+		return fmt.Sprintf("-R %s", s.ForwardAddress)
+	}
+	return ""
+}
+
+// SessionTraffic traffic description of a forward session
+func SessionTraffic(s models.Session) string {
+	if !s.IsForward() || s.EndedAt == nil {
+		return ""
+	}
+	return fmt.Sprintf("↑ %s / ↓ %s", PrettyBytes(s.BytesIn), PrettyBytes(s.BytesOut))
+}
+
+// SessionDuration duration of a ended session
+func SessionDuration(s models.Session) string {
+	if s.EndedAt == nil {
+		return ""
+	}
+	return s.EndedAt.Sub(s.StartedAt).Round(time.Second).String()
+}
+
+// SessionExit exit description of a session, empty if unknown
+func SessionExit(s models.Session) string {
+	if len(s.ExitSignal) > 0 {
+		return fmt.Sprintf("信号 %s", s.ExitSignal)
+	}
+	if s.ExitCode != nil {
+		return fmt.Sprintf("退出码 %d", *s.ExitCode)
+	}
+	return ""
+}
+
+// SessionTarget target description of a session, empty for sandbox session
+func SessionTarget(s models.Session) string {
+	if s.IsSandbox() {
+		return ""
+	}
+	return fmt.Sprintf("%s@%s", s.TargetUser, s.ServerName)
+}
+
 // GetSessionFile get sessions replay
 func GetSessionFile(ctx *web.Context, db *models.DB, cfg types.Config) {
 	var err error
@@ -88,7 +160,71 @@ func GetSessionReplay(ctx *web.Context, db *models.DB, fl *session.Flash) {
 		return
 	}
 	ctx.Data["Session"] = s
+	ctx.Data["Session_Target"] = SessionTarget(s)
 	ctx.Data["Session_StartedAt"] = PrettyTime(&s.StartedAt)
 	ctx.Data["Session_EndedAt"] = PrettyTime(s.EndedAt)
 	ctx.HTML(200, "sessions/replay")
 }
+
+// TransferItem file transfer item
+type TransferItem struct {
+	ID        uint
+	SessionID uint
+	User      string
+	Target    string
+	Operation string
+	Path      string
+	NewPath   string
+	Bytes     string
+	Error     string
+	CreatedAt string
+}
+
+// TransfersPerPage transfers per page
+const TransfersPerPage = 50
+
+// GetTransfersIndex get file transfers index, optionally filtered by session
+func GetTransfersIndex(ctx *web.Context, db *models.DB) {
+	ctx.Data["NavClass_Sessions"] = "active"
+	var err error
+	// calculate page 0 based
+	var page int
+	if page, err = strconv.Atoi(ctx.Query("page")); err != nil || page < 1 {
+		page = 0
+	} else {
+		page = page - 1
+	}
+	// filter by session
+	q := db.Model(&models.FileTransfer{})
+	baseURL := ctx.URLFor("transfers")
+	if sid, _ := strconv.Atoi(ctx.Query("session_id")); sid > 0 {
+		q = q.Where("session_id = ?", sid)
+		baseURL = fmt.Sprintf("%s?session_id=%d", baseURL, sid)
+		ctx.Data["SessionID"] = sid
+	}
+	// total count
+	var count int
+	q.Count(&count)
+	// create pagination
+	ctx.Data["Pagination"] = CreatePagination(count, TransfersPerPage, page, baseURL)
+	// data
+	ts := []models.FileTransfer{}
+	q.Order("id DESC").Offset(page * TransfersPerPage).Limit(TransfersPerPage).Find(&ts)
+	out := []TransferItem{}
+	for _, t := range ts {
+		out = append(out, TransferItem{
+			ID:        t.ID,
+			SessionID: t.SessionID,
+			User:      t.UserAccount,
+			Target:    fmt.Sprintf("%s@%s", t.TargetUser, t.ServerName),
+			Operation: t.Operation,
+			Path:      t.Path,
+			NewPath:   t.NewPath,
+			Bytes:     PrettyBytes(t.Bytes),
+			Error:     t.Error,
+			CreatedAt: PrettyTime(&t.CreatedAt),
+		})
+	}
+	ctx.Data["Transfers"] = out
+	ctx.HTML(200, "sessions/transfers")
+}
@@ -10,8 +10,10 @@ package routes
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/yankeguo/bunker/models"
 	"github.com/yankeguo/bunker/utils"
@@ -25,6 +27,9 @@ func GetSettingsProfile(ctx *web.Context, a Auth) {
 	ctx.Data["SideClass_Profile"] = "active"
 	ctx.Data["CreatedAt"] = TimeAgo(&a.User().CreatedAt)
 	ctx.Data["UsedAt"] = TimeAgo(a.User().UsedAt)
+	if a.User().LastFailedLoginAt != nil {
+		ctx.Data["LastFailedLogin"] = fmt.Sprintf("%s (%s)", TimeAgo(a.User().LastFailedLoginAt), a.User().LastFailedLoginIP)
+	}
 	if utils.ToBool(a.User().IsAdmin) {
 		ctx.Data["UserType"] = "管理员"
 	} else {
@@ -48,6 +53,9 @@ type ChangePasswordForm struct {
 
 // Validate validate form
 func (f ChangePasswordForm) Validate(a Auth) (ChangePasswordForm, error) {
+	if a.User().Source != models.UserSourceLocal {
+		return f, errors.New("该用户由外部身份源管理，请在对应系统中修改密码")
+	}
 	if f.NewPassword != f.RptPassword {
 		return f, errors.New("重复密码不正确")
 	}
@@ -61,7 +69,7 @@ func (f ChangePasswordForm) Validate(a Auth) (ChangePasswordForm, error) {
 }
 
 // PostSettingsChangePassword get change password
-func PostSettingsChangePassword(ctx *web.Context, f ChangePasswordForm, a Auth, fl *session.Flash, db *models.DB) {
+func PostSettingsChangePassword(ctx *web.Context, f ChangePasswordForm, a Auth, au *Auditor, fl *session.Flash, db *models.DB) {
 	defer ctx.Redirect("/settings/change-password")
 	var err error
 	if f, err = f.Validate(a); err != nil {
@@ -71,6 +79,7 @@ func PostSettingsChangePassword(ctx *web.Context, f ChangePasswordForm, a Auth,
 	u := a.User()
 	u.SetPassword(f.NewPassword)
 	db.Model(u).Update("password_digest", u.PasswordDigest)
+	au.Record("user.change-password", auditTarget("user", u.Account), nil, nil)
 	fl.Success("密码修改成功")
 }
 
@@ -145,7 +154,7 @@ func (f SSHKeyCreateForm) Validate(db *models.DB) (SSHKeyCreateForm, error) {
 }
 
 // PostSettingsSSHKeysCreate add a ssh key
-func PostSettingsSSHKeysCreate(ctx *web.Context, a Auth, f SSHKeyCreateForm, fl *session.Flash, db *models.DB) {
+func PostSettingsSSHKeysCreate(ctx *web.Context, a Auth, au *Auditor, f SSHKeyCreateForm, fl *session.Flash, db *models.DB) {
 	// validate form
 	var err error
 	if f, err = f.Validate(db); err != nil {
@@ -154,16 +163,123 @@ func PostSettingsSSHKeysCreate(ctx *web.Context, a Auth, f SSHKeyCreateForm, fl
 		return
 	}
 	// create
-	db.Create(&models.Key{
+	k := models.Key{
 		UserID:      a.User().ID,
 		Name:        f.Name,
 		Fingerprint: f.Fingerprint,
-	})
+	}
+	if err = db.Create(&k).Error; err == nil {
+		au.Record("key.create", auditTarget("key", k.ID), nil, k)
+	}
 	ctx.Redirect("/settings/ssh-keys")
 }
 
 // PostSettingsSSHKeysDestroy destroy a ssh key
-func PostSettingsSSHKeysDestroy(ctx *web.Context, a Auth, db *models.DB) {
+func PostSettingsSSHKeysDestroy(ctx *web.Context, a Auth, au *Auditor, db *models.DB) {
 	defer ctx.Redirect("/settings/ssh-keys")
-	db.Delete(&models.Key{}, "user_id = ? AND id = ? AND is_sandbox = ?", a.User().ID, ctx.Params(":id"), utils.False)
+	k := models.Key{}
+	if err := db.First(&k, "user_id = ? AND id = ? AND is_sandbox = ?", a.User().ID, ctx.Params(":id"), utils.False).Error; err != nil || k.ID == 0 {
+		return
+	}
+	if err := db.Delete(&k).Error; err == nil {
+		au.Record("key.destroy", auditTarget("key", k.ID), k, nil)
+	}
+}
+
+// TokenItem api token item
+type TokenItem struct {
+	ID         uint
+	Name       string
+	Hint       string
+	IsReadOnly bool
+	IsAdmin    bool
+	ExpiresAt  string
+	IsExpired  bool
+	UsedAt     string
+	CreatedAt  string
+}
+
+// GetSettingsTokensIndex get api tokens
+func GetSettingsTokensIndex(ctx *web.Context, a Auth, db *models.DB) {
+	ctx.Data["SideClass_Tokens"] = "active"
+	items := []TokenItem{}
+	ts := []models.Token{}
+	db.Order("id DESC").Find(&ts, "user_id = ?", a.User().ID)
+	for _, t := range ts {
+		items = append(items, TokenItem{
+			ID:         t.ID,
+			Name:       t.Name,
+			Hint:       t.Hint,
+			IsReadOnly: utils.ToBool(t.IsReadOnly),
+			IsAdmin:    utils.ToBool(t.IsAdmin),
+			ExpiresAt:  TimeAgo(t.ExpiresAt),
+			IsExpired:  t.IsExpired(),
+			UsedAt:     TimeAgo(t.UsedAt),
+			CreatedAt:  TimeAgo(&t.CreatedAt),
+		})
+	}
+	ctx.Data["Tokens"] = items
+	ctx.HTML(http.StatusOK, "settings/tokens")
+}
+
+// TokenCreateForm create api token form
+type TokenCreateForm struct {
+	Name       string `form:"name"`
+	IsReadOnly string `form:"is_read_only"`
+	IsAdmin    string `form:"is_admin"`
+	ExpiresIn  int    `form:"expires_in"` // days, 0 for never
+}
+
+// PostSettingsTokensCreate create an api token, secret is shown only once
+func PostSettingsTokensCreate(ctx *web.Context, a Auth, au *Auditor, f TokenCreateForm, fl *session.Flash, db *models.DB) {
+	defer ctx.Redirect("/settings/tokens")
+	f.Name = strings.TrimSpace(f.Name)
+	if len(f.Name) == 0 {
+		fl.Error("令牌名称不能为空")
+		return
+	}
+	if f.ExpiresIn < 0 {
+		fl.Error("输入的时间无效")
+		return
+	}
+	isAdmin := f.IsAdmin == "on"
+	if isAdmin && !a.SignedInAsAdmin() {
+		fl.Error("只有管理员可以创建管理令牌")
+		return
+	}
+	secret, err := models.NewTokenSecret()
+	if err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	t := &models.Token{
+		UserID:     a.User().ID,
+		Name:       f.Name,
+		Digest:     models.TokenDigest(secret),
+		Hint:       secret[:len(models.TokenPrefix)+4],
+		IsReadOnly: utils.ToInt(f.IsReadOnly == "on"),
+		IsAdmin:    utils.ToInt(isAdmin),
+	}
+	if f.ExpiresIn > 0 {
+		e := time.Now().Add(time.Hour * 24 * time.Duration(f.ExpiresIn))
+		t.ExpiresAt = &e
+	}
+	if err = db.Create(t).Error; err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	au.Record("token.create", auditTarget("token", t.ID), nil, t)
+	fl.Success("令牌创建成功，请立即复制保存，该令牌不会再次显示: " + secret)
+}
+
+// PostSettingsTokensDestroy destroy an api token
+func PostSettingsTokensDestroy(ctx *web.Context, a Auth, au *Auditor, db *models.DB) {
+	defer ctx.Redirect("/settings/tokens")
+	t := models.Token{}
+	if err := db.First(&t, "user_id = ? AND id = ?", a.User().ID, ctx.Params(":id")).Error; err != nil || t.ID == 0 {
+		return
+	}
+	if err := db.Delete(&t).Error; err == nil {
+		au.Record("token.destroy", auditTarget("token", t.ID), t, nil)
+	}
 }
@@ -0,0 +1,239 @@
+/**
+ * routes/routes_totp.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yankeguo/bunker/events"
+	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/types"
+	"github.com/yankeguo/bunker/utils"
+	"landzero.net/x/net/web"
+	"landzero.net/x/net/web/session"
+)
+
+// LoginTOTPMaxAttempts max attempts of second factor in a single login
+const LoginTOTPMaxAttempts = 5
+
+// RecoveryCodesCount recovery codes generated for each user
+const RecoveryCodesCount = 10
+
+// TOTPForm form with a verification code
+type TOTPForm struct {
+	Code string `form:"code"`
+}
+
+// pendingLoginUser user passed password check but not the second factor
+func pendingLoginUser(sess session.Store, db *models.DB) *models.User {
+	id, ok := sess.Get("totp_user_id").(string)
+	if !ok || len(id) == 0 {
+		return nil
+	}
+	u := &models.User{}
+	if err := db.First(u, id).Error; err != nil || u.ID == 0 || utils.ToBool(u.IsBlocked) {
+		return nil
+	}
+	return u
+}
+
+// GetLoginTOTP get second factor page of login
+func GetLoginTOTP(ctx *web.Context, sess session.Store, db *models.DB) {
+	if pendingLoginUser(sess, db) == nil {
+		ctx.Redirect("/login")
+		return
+	}
+	ctx.HTML(http.StatusOK, "login-totp")
+}
+
+// PostLoginTOTP verify second factor of login
+func PostLoginTOTP(ctx *web.Context, f TOTPForm, fl *session.Flash, a Auth, db *models.DB, em *events.Emitter, bo *utils.Backoff, sess session.Store, cfg types.Config) {
+	u := pendingLoginUser(sess, db)
+	if u == nil {
+		ctx.Redirect("/login")
+		return
+	}
+	// account may be locked by failures from elsewhere
+	if err := checkLoginLocked(db, u.Account); err != nil {
+		sess.Delete("totp_user_id")
+		sess.Delete("totp_attempts")
+		fl.Error(err.Error())
+		ctx.Redirect("/login")
+		return
+	}
+	// limit attempts, restart from password on too many failures
+	v, _ := sess.Get("totp_attempts").(string)
+	attempts, _ := strconv.Atoi(v)
+	if attempts >= LoginTOTPMaxAttempts {
+		sess.Delete("totp_user_id")
+		sess.Delete("totp_attempts")
+		fl.Error("验证失败次数过多，请重新登录")
+		ctx.Redirect("/login")
+		return
+	}
+	method, _ := sess.Get("totp_method").(string)
+	if !db.VerifyUserTOTP(u, f.Code) {
+		emitAuthFailure(ctx, em, u.Account, method+AuthMethodTOTPSuffix, "invalid verification code")
+		sess.Set("totp_attempts", strconv.Itoa(attempts+1))
+		fl.Error(recordFailedLogin(db, bo, cfg, u.Account, ctx.RemoteAddr(), errors.New("请填写正确的动态验证码或恢复码")).Error())
+		ctx.Redirect("/login/totp")
+		return
+	}
+	sess.Delete("totp_user_id")
+	sess.Delete("totp_method")
+	sess.Delete("totp_attempts")
+	db.Touch(u)
+	db.ResetFailedLogins(u)
+	bo.Reset(ctx.RemoteAddr())
+	a.SetUser(u, method+AuthMethodTOTPSuffix)
+	emitLogin(ctx, em, u.Account, method+AuthMethodTOTPSuffix)
+	ctx.Redirect("/")
+}
+
+// totpIssuer issuer shown in authenticator apps
+func totpIssuer(cfg types.Config) string {
+	if len(cfg.TOTP.Issuer) > 0 {
+		return cfg.TOTP.Issuer
+	}
+	if len(cfg.Title) > 0 {
+		return cfg.Title
+	}
+	return "Bunker"
+}
+
+// GetSettingsTOTP get two-factor authentication settings
+func GetSettingsTOTP(ctx *web.Context, a Auth, sess session.Store, cfg types.Config) {
+	ctx.Data["SideClass_TOTP"] = "active"
+	u := a.User()
+	ctx.Data["IsEnabled"] = utils.ToBool(u.IsTOTPEnabled)
+	ctx.Data["IsEnforced"] = cfg.TOTP.IsEnforced(utils.ToBool(u.IsAdmin))
+	if utils.ToBool(u.IsTOTPEnabled) {
+		ctx.Data["RecoveryCodesLeft"] = u.CountRecoveryCodes()
+	} else {
+		// pending secret is kept in session until confirmed
+		secret, _ := sess.Get("totp_secret").(string)
+		if len(secret) == 0 {
+			var err error
+			if secret, err = utils.NewTOTPSecret(); err != nil {
+				ctx.PlainText(http.StatusInternalServerError, []byte(err.Error()))
+				return
+			}
+			sess.Set("totp_secret", secret)
+		}
+		ctx.Data["Secret"] = secret
+		ctx.Data["URI"] = utils.TOTPURI(totpIssuer(cfg), u.Account, secret)
+	}
+	ctx.HTML(http.StatusOK, "settings/totp")
+}
+
+// PostSettingsTOTPEnable confirm pending secret and enable two-factor authentication
+func PostSettingsTOTPEnable(ctx *web.Context, f TOTPForm, a Auth, au *Auditor, fl *session.Flash, db *models.DB, sess session.Store) {
+	u := a.User()
+	secret, _ := sess.Get("totp_secret").(string)
+	if utils.ToBool(u.IsTOTPEnabled) || len(secret) == 0 {
+		ctx.Redirect("/settings/totp")
+		return
+	}
+	c, ok := utils.ValidateTOTP(secret, strings.TrimSpace(f.Code), time.Now())
+	if !ok {
+		fl.Error("动态验证码不正确，请检查手机时间是否准确")
+		ctx.Redirect("/settings/totp")
+		return
+	}
+	codes, digests, err := models.NewRecoveryCodes(RecoveryCodesCount)
+	if err != nil {
+		fl.Error(err.Error())
+		ctx.Redirect("/settings/totp")
+		return
+	}
+	if err = db.Model(u).Updates(map[string]interface{}{
+		"totp_secret":     secret,
+		"totp_counter":    c,
+		"is_totp_enabled": utils.True,
+		"recovery_codes":  digests,
+	}).Error; err != nil {
+		fl.Error(err.Error())
+		ctx.Redirect("/settings/totp")
+		return
+	}
+	sess.Delete("totp_secret")
+	au.Record("user.enable-totp", auditTarget("user", u.Account), nil, nil)
+	renderRecoveryCodes(ctx, codes, "两步验证已开启")
+}
+
+// checkTOTPForm verify code from a settings form
+func checkTOTPForm(f TOTPForm, a Auth, db *models.DB) error {
+	u := a.User()
+	if !utils.ToBool(u.IsTOTPEnabled) {
+		return errors.New("两步验证未开启")
+	}
+	if !db.VerifyUserTOTP(u, f.Code) {
+		return errors.New("请填写正确的动态验证码或恢复码")
+	}
+	return nil
+}
+
+// PostSettingsTOTPRecoveryCodes regenerate recovery codes
+func PostSettingsTOTPRecoveryCodes(ctx *web.Context, f TOTPForm, a Auth, au *Auditor, fl *session.Flash, db *models.DB) {
+	var err error
+	if err = checkTOTPForm(f, a, db); err != nil {
+		fl.Error(err.Error())
+		ctx.Redirect("/settings/totp")
+		return
+	}
+	var codes []string
+	var digests string
+	if codes, digests, err = models.NewRecoveryCodes(RecoveryCodesCount); err == nil {
+		err = db.Model(a.User()).Update("recovery_codes", digests).Error
+	}
+	if err != nil {
+		fl.Error(err.Error())
+		ctx.Redirect("/settings/totp")
+		return
+	}
+	au.Record("user.regenerate-recovery-codes", auditTarget("user", a.User().Account), nil, nil)
+	renderRecoveryCodes(ctx, codes, "恢复码已重新生成，旧的恢复码已失效")
+}
+
+// PostSettingsTOTPDisable disable two-factor authentication
+func PostSettingsTOTPDisable(ctx *web.Context, f TOTPForm, a Auth, au *Auditor, fl *session.Flash, db *models.DB, cfg types.Config) {
+	defer ctx.Redirect("/settings/totp")
+	var err error
+	if cfg.TOTP.IsEnforced(utils.ToBool(a.User().IsAdmin)) {
+		fl.Error("系统要求开启两步验证，无法关闭")
+		return
+	}
+	if err = checkTOTPForm(f, a, db); err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	if err = db.Model(a.User()).Updates(map[string]interface{}{
+		"totp_secret":     "",
+		"totp_counter":    0,
+		"is_totp_enabled": 0,
+		"recovery_codes":  "",
+	}).Error; err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	au.Record("user.disable-totp", auditTarget("user", a.User().Account), nil, nil)
+	fl.Success("两步验证已关闭")
+}
+
+// renderRecoveryCodes render recovery codes, they are shown only once
+func renderRecoveryCodes(ctx *web.Context, codes []string, msg string) {
+	ctx.Data["SideClass_TOTP"] = "active"
+	ctx.Data["Message"] = msg
+	ctx.Data["RecoveryCodes"] = codes
+	ctx.HTML(http.StatusOK, "settings/totp-recovery-codes")
+}
@@ -0,0 +1,116 @@
+/**
+ * routes/routes_transcripts.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/utils"
+	"landzero.net/x/net/web"
+)
+
+// TranscriptsPerPage transcript lines per page
+const TranscriptsPerPage = 50
+
+// TranscriptItem transcript line item
+type TranscriptItem struct {
+	SessionID uint
+	User      string
+	Target    string
+	At        string
+	Offset    int64
+	IsCommand bool
+	Content   string
+}
+
+// transcriptQuery parse transcript query from "q", "user", "server", "since", "until" and "commands"
+func transcriptQuery(ctx *web.Context) (q models.TranscriptQuery, err error) {
+	q.Phrase = strings.TrimSpace(ctx.Query("q"))
+	q.User = strings.TrimSpace(ctx.Query("user"))
+	q.Server = strings.TrimSpace(ctx.Query("server"))
+	q.CommandsOnly = ctx.Query("commands") == "1"
+	if q.Since, err = ParseQueryTime(ctx.Query("since"), false); err != nil {
+		return
+	}
+	q.Until, err = ParseQueryTime(ctx.Query("until"), true)
+	return
+}
+
+// GetTranscriptsIndex search session transcripts
+func GetTranscriptsIndex(ctx *web.Context, db *models.DB) {
+	ctx.Data["NavClass_Sessions"] = "active"
+	for _, k := range []string{"q", "user", "server", "since", "until", "commands"} {
+		ctx.Data["Query_"+k] = ctx.Query(k)
+	}
+	q, err := transcriptQuery(ctx)
+	if err != nil {
+		ctx.Data["SearchError"] = err.Error()
+		ctx.HTML(http.StatusOK, "sessions/transcripts")
+		return
+	}
+	// calculate page 0 based
+	var page int
+	if page, err = strconv.Atoi(ctx.Query("page")); err != nil || page < 1 {
+		page = 0
+	} else {
+		page = page - 1
+	}
+	ls, count, err := db.SearchTranscripts(q, page*TranscriptsPerPage, TranscriptsPerPage)
+	if err != nil {
+		ctx.Data["SearchError"] = err.Error()
+	}
+	ctx.Data["Pagination"] = CreatePagination(count, TranscriptsPerPage, page, AppendQuery(
+		ctx.URLFor("transcripts"),
+		"q", q.Phrase,
+		"user", q.User,
+		"server", q.Server,
+		"since", ctx.Query("since"),
+		"until", ctx.Query("until"),
+		"commands", ctx.Query("commands"),
+	))
+	ctx.Data["Count"] = count
+	out := []TranscriptItem{}
+	for _, l := range ls {
+		t := ""
+		if len(l.ServerName) > 0 {
+			t = fmt.Sprintf("%s@%s", l.TargetUser, l.ServerName)
+		}
+		out = append(out, TranscriptItem{
+			SessionID: l.SessionID,
+			User:      l.UserAccount,
+			Target:    t,
+			At:        PrettyTime(&l.At),
+			Offset:    l.Offset,
+			IsCommand: utils.ToBool(l.IsCommand),
+			Content:   l.Content,
+		})
+	}
+	ctx.Data["Transcripts"] = out
+	ctx.HTML(http.StatusOK, "sessions/transcripts")
+}
+
+// GetAPITranscripts search session transcripts, newest first, filtered by "q", "user", "server", "since", "until" and "commands"
+func GetAPITranscripts(ctx *web.Context, db *models.DB) {
+	q, err := transcriptQuery(ctx)
+	if err != nil {
+		APIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, perPage := apiPage(ctx)
+	ls, count, err := db.SearchTranscripts(q, page*perPage, perPage)
+	if err != nil {
+		APIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{"transcripts": ls, "total": count})
+}
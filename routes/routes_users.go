@@ -11,9 +11,12 @@ package routes
 import (
 	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/yankeguo/bunker/models"
+	"github.com/yankeguo/bunker/sandbox"
+	"github.com/yankeguo/bunker/types"
 	"github.com/yankeguo/bunker/utils"
 	"landzero.net/x/net/web"
 	"landzero.net/x/net/web/session"
@@ -30,6 +33,12 @@ type UserItem struct {
 	IsAdmin   bool
 	IsBlocked bool
 	IsCurrent bool
+	IsAgent   bool
+	IsTOTP    bool
+	IsPwdOff  bool
+	IsLocked  bool
+	IsOIDC    bool
+	IsLinked  bool
 }
 
 // UserItemTag user item tag
@@ -61,6 +70,48 @@ func GetUsersIndex(ctx *web.Context, db *models.DB, a Auth, sess session.Store)
 				Name:  "已封禁",
 			})
 		}
+		if u.IsLocked() {
+			tags = append(tags, UserItemTag{
+				Style: "warning",
+				Name:  "已锁定",
+			})
+		}
+		if utils.ToBool(u.IsAgentAllowed) {
+			tags = append(tags, UserItemTag{
+				Style: "info",
+				Name:  "代理转发",
+			})
+		}
+		switch u.Source {
+		case models.UserSourceLDAP:
+			tags = append(tags, UserItemTag{
+				Style: "default",
+				Name:  "LDAP",
+			})
+		case models.UserSourceOIDC:
+			tags = append(tags, UserItemTag{
+				Style: "default",
+				Name:  "OIDC",
+			})
+		}
+		if utils.ToBool(u.IsOIDCLinked) && u.Source != models.UserSourceOIDC {
+			tags = append(tags, UserItemTag{
+				Style: "default",
+				Name:  "关联 OIDC",
+			})
+		}
+		if utils.ToBool(u.IsPasswordOff) {
+			tags = append(tags, UserItemTag{
+				Style: "warning",
+				Name:  "禁用密码",
+			})
+		}
+		if utils.ToBool(u.IsTOTPEnabled) {
+			tags = append(tags, UserItemTag{
+				Style: "default",
+				Name:  "两步验证",
+			})
+		}
 		if u.ID == a.User().ID {
 			tags = append(tags, UserItemTag{
 				Style: "primary",
@@ -78,6 +129,12 @@ func GetUsersIndex(ctx *web.Context, db *models.DB, a Auth, sess session.Store)
 			IsAdmin:   utils.ToBool(u.IsAdmin),
 			IsBlocked: utils.ToBool(u.IsBlocked),
 			IsCurrent: u.ID == a.User().ID,
+			IsAgent:   utils.ToBool(u.IsAgentAllowed),
+			IsTOTP:    utils.ToBool(u.IsTOTPEnabled),
+			IsPwdOff:  utils.ToBool(u.IsPasswordOff),
+			IsLocked:  u.IsLocked(),
+			IsOIDC:    u.Source == models.UserSourceOIDC,
+			IsLinked:  utils.ToBool(u.IsOIDCLinked),
 		})
 	}
 	ctx.Data["Users"] = items
@@ -120,7 +177,7 @@ func (f UserAddForm) Validate(db *models.DB) (UserAddForm, error) {
 }
 
 // PostUsersCreate post user add
-func PostUsersCreate(ctx *web.Context, f UserAddForm, db *models.DB, fl *session.Flash, sess session.Store) {
+func PostUsersCreate(ctx *web.Context, f UserAddForm, au *Auditor, db *models.DB, fl *session.Flash, sess session.Store) {
 	var err error
 	if f, err = f.Validate(db); err != nil {
 		fl.Error(err.Error())
@@ -131,7 +188,12 @@ func PostUsersCreate(ctx *web.Context, f UserAddForm, db *models.DB, fl *session
 		Account: f.Account,
 	}
 	u.SetPassword(f.Password)
-	db.Create(u)
+	if err = db.Create(u).Error; err != nil {
+		fl.Error(err.Error())
+		ctx.Redirect(AppendQuery(ctx.URLFor("new-user"), "account", f.Account))
+		return
+	}
+	au.Record("user.create", auditTarget("user", u.Account), nil, u)
 	fl.Success("创建用户成功")
 	ctx.Redirect(ctx.URLFor("users"))
 }
@@ -140,10 +202,15 @@ func PostUsersCreate(ctx *web.Context, f UserAddForm, db *models.DB, fl *session
 type UserUpdateForm struct {
 	IsAdmin   string `form:"is_admin"`
 	IsBlocked string `form:"is_blocked"`
+	IsAgent   string `form:"is_agent_allowed"`
+	ResetTOTP string `form:"reset_totp"`
+	IsPwdOff  string `form:"is_password_off"`
+	Unlock    string `form:"unlock"`
+	IsLinked  string `form:"is_oidc_linked"`
 }
 
 // PostUserUpdate post user update
-func PostUserUpdate(ctx *web.Context, f UserUpdateForm, db *models.DB) {
+func PostUserUpdate(ctx *web.Context, f UserUpdateForm, au *Auditor, db *models.DB, m sandbox.Manager) {
 	defer ctx.Redirect(ctx.URLFor("users"))
 
 	attrs := map[string]interface{}{}
@@ -153,9 +220,129 @@ func PostUserUpdate(ctx *web.Context, f UserUpdateForm, db *models.DB) {
 	}
 	if len(f.IsBlocked) > 0 {
 		attrs["is_blocked"] = utils.ToInt(strings.ToLower(f.IsBlocked) == "y")
+		// block is owned by admin from now on, ldap sync will not clear it
+		attrs["is_dir_blocked"] = 0
+	}
+	if len(f.IsAgent) > 0 {
+		attrs["is_agent_allowed"] = utils.ToInt(strings.ToLower(f.IsAgent) == "y")
+	}
+	if len(f.IsPwdOff) > 0 {
+		attrs["is_password_off"] = utils.ToInt(strings.ToLower(f.IsPwdOff) == "y")
+	}
+	// allow openid connect login for an existing local or ldap user
+	if len(f.IsLinked) > 0 {
+		attrs["is_oidc_linked"] = utils.ToInt(strings.ToLower(f.IsLinked) == "y")
+	}
+	// reset two-factor authentication for lost device, user will enroll again
+	if strings.ToLower(f.ResetTOTP) == "y" {
+		attrs["is_totp_enabled"] = 0
+		attrs["totp_secret"] = ""
+		attrs["totp_counter"] = 0
+		attrs["recovery_codes"] = ""
+	}
+	// unlock web login locked for too many failed logins
+	if strings.ToLower(f.Unlock) == "y" {
+		attrs["failed_logins"] = 0
+		attrs["locked_until"] = nil
 	}
 
 	u := models.User{}
-	db.Find(&u, ctx.Params(":id"))
-	db.Model(&u).Update(attrs)
+	if db.First(&u, ctx.Params(":id")).Error != nil || u.ID == 0 {
+		return
+	}
+	before := u
+	if db.Model(&u).Update(attrs).Error == nil {
+		au.Record("user.update", auditTarget("user", u.Account), before, u)
+	}
+
+	// stop sandbox of blocked user
+	if len(u.Account) > 0 && strings.ToLower(f.IsBlocked) == "y" {
+		m.Stop(u.Account)
+	}
+}
+
+// GetUserSandboxLimits show sandbox limits of user
+func GetUserSandboxLimits(ctx *web.Context, db *models.DB, fl *session.Flash, cfg types.Config) {
+	ctx.Data["NavClass_Users"] = "active"
+	ctx.Data["SideClass_Index"] = "active"
+	u := models.User{}
+	if db.First(&u, ctx.Params(":id")).Error != nil {
+		fl.Error("没有找到目标用户")
+		ctx.Redirect(ctx.URLFor("users"))
+		return
+	}
+	ctx.Data["User"] = u
+	ctx.Data["Defaults"] = SandboxLimitsText(cfg.Sandbox.Limits)
+	ctx.Data["Limits"] = SandboxLimitsText(u.SandboxLimits(cfg))
+	ctx.HTML(http.StatusOK, "users/sandbox-limits")
+}
+
+// UserSandboxLimitsForm user sandbox limits form, empty or 0 for default
+type UserSandboxLimitsForm struct {
+	CPUs   string `form:"cpus"`
+	Memory string `form:"memory"`
+	Pids   string `form:"pids"`
+	Disk   string `form:"disk"`
+}
+
+// Validate validate the form
+func (f UserSandboxLimitsForm) Validate() (l types.SandboxLimits, err error) {
+	if len(strings.TrimSpace(f.CPUs)) > 0 {
+		if l.CPUs, err = strconv.ParseFloat(strings.TrimSpace(f.CPUs), 64); err != nil || l.CPUs < 0 {
+			err = errors.New("CPU 数量不正确")
+			return
+		}
+	}
+	ints := []struct {
+		in   string
+		out  *int64
+		name string
+	}{
+		{f.Memory, &l.Memory, "内存"},
+		{f.Pids, &l.Pids, "进程数"},
+		{f.Disk, &l.Disk, "磁盘"},
+	}
+	for _, i := range ints {
+		if len(strings.TrimSpace(i.in)) == 0 {
+			continue
+		}
+		if *i.out, err = strconv.ParseInt(strings.TrimSpace(i.in), 10, 64); err != nil || *i.out < 0 {
+			err = errors.New(i.name + "限制不正确")
+			return
+		}
+	}
+	return
+}
+
+// PostUserSandboxLimits update sandbox limits of user
+func PostUserSandboxLimits(ctx *web.Context, f UserSandboxLimitsForm, au *Auditor, db *models.DB, m sandbox.Manager, fl *session.Flash) {
+	id := ctx.Params(":id")
+	defer ctx.Redirect(ctx.URLFor("user-sandbox-limits", ":id", id))
+	l, err := f.Validate()
+	if err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	// limits not enforceable by backend are rejected instead of silently ignored
+	if err = m.CheckLimits(l); err != nil {
+		fl.Error("沙箱不支持该资源限制: " + err.Error())
+		return
+	}
+	u := models.User{}
+	if db.First(&u, id).Error != nil {
+		fl.Error("没有找到目标用户")
+		return
+	}
+	before := u
+	if err = db.Model(&u).Update(map[string]interface{}{
+		"sandbox_cpus":   l.CPUs,
+		"sandbox_memory": l.Memory,
+		"sandbox_pids":   l.Pids,
+		"sandbox_disk":   l.Disk,
+	}).Error; err != nil {
+		fl.Error(err.Error())
+		return
+	}
+	au.Record("user.update-sandbox-limits", auditTarget("user", u.Account), before, u)
+	fl.Success("沙箱资源限制已更新，将在下次连接沙箱时生效，磁盘限制需重置沙箱")
 }
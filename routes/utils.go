@@ -11,6 +11,7 @@ package routes
 import (
 	"fmt"
 	"net/url"
+	"strings"
 	"time"
 
 	"landzero.net/x/com"
@@ -41,6 +42,12 @@ func CreatePagination(totalCount int, perPage int, currentPage int, baseurl stri
 	if totalPage <= 1 {
 		return
 	}
+	// baseurl may already have a query string
+	if strings.Contains(baseurl, "?") {
+		baseurl = baseurl + "&"
+	} else {
+		baseurl = baseurl + "?"
+	}
 	// fix current page
 	if currentPage < 0 {
 		currentPage = 0
@@ -52,7 +59,7 @@ func CreatePagination(totalCount int, perPage int, currentPage int, baseurl stri
 	if currentPage > 5 {
 		p.Pages = append(p.Pages, PageItem{
 			Title:      "<<",
-			URL:        fmt.Sprintf("%s?page=%d", baseurl, 1),
+			URL:        fmt.Sprintf("%spage=%d", baseurl, 1),
 			IsDisabled: false,
 			IsCurrent:  false,
 		})
@@ -67,7 +74,7 @@ func CreatePagination(totalCount int, perPage int, currentPage int, baseurl stri
 		}
 		p.Pages = append(p.Pages, PageItem{
 			Title:     fmt.Sprintf("%d", i+1),
-			URL:       fmt.Sprintf("%s?page=%d", baseurl, i+1),
+			URL:       fmt.Sprintf("%spage=%d", baseurl, i+1),
 			IsCurrent: i == currentPage,
 		})
 	}
@@ -75,7 +82,7 @@ func CreatePagination(totalCount int, perPage int, currentPage int, baseurl stri
 	if currentPage+5 < totalPage {
 		p.Pages = append(p.Pages, PageItem{
 			Title: ">>",
-			URL:   fmt.Sprintf("%s?page=%d", baseurl, totalPage),
+			URL:   fmt.Sprintf("%spage=%d", baseurl, totalPage),
 		})
 	}
 	return
@@ -89,6 +96,19 @@ func TimeAgo(t *time.Time) string {
 	return ago.Chinese.Format(*t)
 }
 
+// PrettyBytes pretty bytes count
+func PrettyBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
 // PrettyTime pretty time
 func PrettyTime(t *time.Time) string {
 	if t == nil {
@@ -113,3 +133,26 @@ func AppendQuery(s string, c ...interface{}) string {
 	u.RawQuery = q.Encode()
 	return u.String()
 }
+
+// ParseQueryTime parse "2006-01-02", "2006-01-02 15:04" or RFC3339 time from query, nil if empty,
+// date only time is moved to the next day if end is true, for inclusive end date
+func ParseQueryTime(s string, end bool) (*time.Time, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return nil, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return &t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %s", s)
+	}
+	return &t, nil
+}
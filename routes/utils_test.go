@@ -10,6 +10,7 @@ package routes
 import (
 	"log"
 	"testing"
+	"time"
 )
 
 func TestCreatePagination(t *testing.T) {
@@ -18,3 +19,22 @@ func TestCreatePagination(t *testing.T) {
 		log.Printf("%s, %v = %s", g.Title, g.IsCurrent, g.URL)
 	}
 }
+
+func TestParseQueryTime(t *testing.T) {
+	if tm, err := ParseQueryTime("", false); tm != nil || err != nil {
+		t.Error("empty string should be nil")
+	}
+	tm, err := ParseQueryTime("2018-03-04", true)
+	if err != nil || tm.Day() != 5 || tm.Hour() != 0 {
+		t.Error("end date should be the next day", tm, err)
+	}
+	if tm, err = ParseQueryTime("2018-03-04 12:30", true); err != nil || tm.Day() != 4 || tm.Minute() != 30 {
+		t.Error("invalid minute time", tm, err)
+	}
+	if tm, err = ParseQueryTime("2018-03-04T12:30:00Z", false); err != nil || !tm.Equal(time.Date(2018, 3, 4, 12, 30, 0, 0, time.UTC)) {
+		t.Error("invalid rfc3339 time", tm, err)
+	}
+	if _, err = ParseQueryTime("yesterday", false); err == nil {
+		t.Error("should fail")
+	}
+}
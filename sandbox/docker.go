@@ -0,0 +1,647 @@
+/**
+ * sandbox/docker.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	dtypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/yankeguo/bunker/types"
+)
+
+const (
+	// dockerEgressNetwork network of sandboxes if egress policy is enabled, packets from its bridge are filtered on host
+	dockerEgressNetwork = "bunker-sandbox"
+	// dockerEgressBridge bridge of egress network on host
+	dockerEgressBridge = "bunker0"
+	// dockerEgressChain chain of egress rules in mangle table
+	dockerEgressChain = "BUNKER-SANDBOX"
+	// dockerBridgeNameOption network option of bridge name
+	dockerBridgeNameOption = "com.docker.network.bridge.name"
+)
+
+// dockerExecPidDir directory in container recording pids of exec processes, signals are sent by kill in container
+const dockerExecPidDir = "/tmp/.bunker-exec"
+
+// dockerExecCommand wrap command to record its pid in pidFile before executing it, pid is kept by exec
+func dockerExecCommand(cmd []string, pidFile string) []string {
+	return append([]string{"/bin/sh", "-c", `{ mkdir -p "${0%/*}"; echo $$ > "$0"; } 2>/dev/null; exec "$@"`, pidFile}, cmd...)
+}
+
+type dockerManager struct {
+	Config types.Config
+	mutex  *sync.Mutex
+	client *client.Client
+	egress []EgressRule // nil if egress policy is disabled
+
+	quotaMutex   *sync.Mutex
+	quotaChecked bool
+	quotaErr     error // nil if storage driver supports disk quota
+}
+
+// newDockerManager new docker manager
+func newDockerManager(cfg types.Config) (m Manager, err error) {
+	var c *client.Client
+	if c, err = client.NewEnvClient(); err != nil {
+		return
+	}
+	dm := &dockerManager{
+		Config:     cfg,
+		mutex:      &sync.Mutex{},
+		client:     c,
+		quotaMutex: &sync.Mutex{},
+	}
+	if err = dm.CheckLimits(cfg.Sandbox.Limits); err != nil {
+		return
+	}
+	if cfg.Sandbox.Egress {
+		if dm.egress, err = ParseEgressRules(cfg.Sandbox.EgressAllow); err != nil {
+			return
+		}
+		if err = dm.applyEgress(); err != nil {
+			return
+		}
+	}
+	return dm, nil
+}
+
+// CheckLimits check limits are supported, disk quota depends on storage driver, e.g. overlay2 requires xfs with pquota
+func (m *dockerManager) CheckLimits(l types.SandboxLimits) error {
+	if l.Disk > 0 {
+		return m.checkDiskQuota()
+	}
+	return nil
+}
+
+// checkDiskQuota probe disk quota support by creating a container with size option, result is cached
+func (m *dockerManager) checkDiskQuota() (err error) {
+	m.quotaMutex.Lock()
+	defer m.quotaMutex.Unlock()
+	if m.quotaChecked {
+		return m.quotaErr
+	}
+	var c container.ContainerCreateCreatedBody
+	if c, err = m.client.ContainerCreate(
+		context.Background(),
+		&container.Config{Image: m.Config.Sandbox.Image},
+		&container.HostConfig{StorageOpt: map[string]string{"size": "1024M"}},
+		&network.NetworkingConfig{},
+		"",
+	); err != nil {
+		// docker or image not ready, probe again next time
+		if client.IsErrConnectionFailed(err) || client.IsErrNotFound(err) {
+			return
+		}
+		err = fmt.Errorf("disk limit is not supported by docker storage driver: %s", err.Error())
+	} else {
+		m.client.ContainerRemove(context.Background(), c.ID, dtypes.ContainerRemoveOptions{Force: true})
+	}
+	m.quotaChecked, m.quotaErr = true, err
+	return
+}
+
+// dockerResources convert limits to container resources
+func dockerResources(l types.SandboxLimits) (r container.Resources) {
+	if l.CPUs > 0 {
+		r.CPUPeriod = 100000
+		r.CPUQuota = int64(l.CPUs * 100000)
+	}
+	if l.Memory > 0 {
+		r.Memory = l.Memory * 1024 * 1024
+		// no swap
+		r.MemorySwap = r.Memory
+	}
+	r.PidsLimit = l.Pids
+	return
+}
+
+// FindOrCreate find or create a sandbox, limits of existing container are updated, except disk quota
+func (m *dockerManager) FindOrCreate(account string, l types.SandboxLimits) (s Sandbox, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name := GetContainerName(account)
+	// ensure dir
+	uDir := path.Join(m.Config.Sandbox.DataDir, name)
+	sDir := path.Join(m.Config.Sandbox.DataDir, "shared")
+	if err = os.MkdirAll(uDir, dirPerm); err != nil {
+		return
+	}
+	if err = os.MkdirAll(sDir, dirPerm); err != nil {
+		return
+	}
+	var aDir string
+	if aDir, err = ensureAgentDir(m.Config.Sandbox.DataDir, name); err != nil {
+		return
+	}
+	// find container
+	var c *dtypes.Container
+	if c, err = m.find(name); err != nil {
+		return
+	}
+	var running bool
+	if c != nil {
+		running = c.State == "running"
+	}
+	// egress policy is enforced on host before sandbox is started, a running sandbox is stopped if it can not be enforced
+	if m.egress != nil {
+		if err = m.ensureEgressNetwork(); err == nil {
+			if err = m.applyEgress(); err == nil && c != nil && !dockerOnlyNetwork(c, dockerEgressNetwork) {
+				err = m.moveToEgressNetwork(c)
+				running = false
+			}
+		}
+		if err != nil {
+			if running {
+				m.stop(c.ID)
+			}
+			return
+		}
+	}
+	// containers created before agent directory have no mount for it
+	hasAgentDir := true
+	// create if not found
+	if c == nil {
+		hc := &container.HostConfig{
+			Binds: []string{
+				fmt.Sprintf("%s:/root", uDir),
+				fmt.Sprintf("%s:/shared", sDir),
+				fmt.Sprintf("%s:%s:ro", aDir, agentMountPoint),
+			},
+			RestartPolicy: m.restartPolicy(),
+			Resources:     dockerResources(l),
+		}
+		if m.egress != nil {
+			hc.NetworkMode = container.NetworkMode(dockerEgressNetwork)
+		}
+		if l.Disk > 0 {
+			hc.StorageOpt = map[string]string{"size": fmt.Sprintf("%dM", l.Disk)}
+		}
+		if _, err = m.client.ContainerCreate(
+			context.Background(),
+			&container.Config{
+				Hostname: fmt.Sprintf("%s.sandbox", account),
+				Image:    m.Config.Sandbox.Image,
+			},
+			hc,
+			&network.NetworkingConfig{},
+			name,
+		); err != nil {
+			return
+		}
+	} else {
+		hasAgentDir = false
+		for _, mp := range c.Mounts {
+			if mp.Destination == agentMountPoint {
+				hasAgentDir = true
+			}
+		}
+		// limits and restart policy may be changed since creation, restart policy must be updated with egress policy
+		if _, err = m.client.ContainerUpdate(context.Background(), c.ID, container.UpdateConfig{
+			Resources:     dockerResources(l),
+			RestartPolicy: m.restartPolicy(),
+		}); err != nil {
+			if m.egress != nil {
+				if running {
+					m.stop(c.ID)
+				}
+				return
+			}
+			log.Println("Sandbox:", name, "failed to update limits", err)
+			err = nil
+		}
+	}
+	// create the sandbox
+	s = &dockerSandbox{
+		name:     name,
+		client:   m.client,
+		dir:      uDir,
+		agentDir: aDir,
+		hasAgent: hasAgentDir,
+	}
+	// start if not running
+	if !running {
+		if err = s.Start(); err != nil {
+			return
+		}
+	}
+	// create ssh keys, home directory survives container recreation
+	if !hasSSHKey(uDir) {
+		if err = s.GenerateSSHKey(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// applyEgress filter packets from bridge of egress network on host, mangle table is never changed by docker
+func (m *dockerManager) applyEgress() error {
+	rules := egressRules(dockerEgressChain, m.Config.Sandbox.HostIP, m.Config.SSHD.Port, m.egress, "DROP")
+	return applyEgressChain("mangle", dockerEgressChain, rules, "PREROUTING", "-i", dockerEgressBridge, "-j", dockerEgressChain)
+}
+
+// ensureEgressNetwork create egress network with known bridge name, sandboxes on it can not reach each other
+func (m *dockerManager) ensureEgressNetwork() (err error) {
+	var n dtypes.NetworkResource
+	if n, err = m.client.NetworkInspect(context.Background(), dockerEgressNetwork); err == nil {
+		if n.Options[dockerBridgeNameOption] != dockerEgressBridge {
+			err = fmt.Errorf("docker network %s is not on bridge %s, remove it to enable egress policy", dockerEgressNetwork, dockerEgressBridge)
+		}
+		return
+	}
+	if !client.IsErrNotFound(err) {
+		return
+	}
+	_, err = m.client.NetworkCreate(context.Background(), dockerEgressNetwork, dtypes.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+		Options: map[string]string{
+			dockerBridgeNameOption:                 dockerEgressBridge,
+			"com.docker.network.bridge.enable_icc": "false",
+		},
+	})
+	return
+}
+
+// moveToEgressNetwork stop container created before egress policy, and move it from other networks to egress network
+func (m *dockerManager) moveToEgressNetwork(c *dtypes.Container) (err error) {
+	if err = m.stop(c.ID); err != nil {
+		return
+	}
+	if c.NetworkSettings != nil {
+		for n := range c.NetworkSettings.Networks {
+			if n == dockerEgressNetwork {
+				continue
+			}
+			if err = m.client.NetworkDisconnect(context.Background(), n, c.ID, true); err != nil {
+				return
+			}
+		}
+		if _, ok := c.NetworkSettings.Networks[dockerEgressNetwork]; ok {
+			return
+		}
+	}
+	return m.client.NetworkConnect(context.Background(), dockerEgressNetwork, c.ID, nil)
+}
+
+// restartPolicy sandboxes are never started by docker if egress policy is enabled, rules on host are lost on reboot
+func (m *dockerManager) restartPolicy() container.RestartPolicy {
+	if m.egress != nil {
+		return container.RestartPolicy{Name: "no"}
+	}
+	return container.RestartPolicy{Name: "unless-stopped"}
+}
+
+// dockerOnlyNetwork container is connected to network and nothing else
+func dockerOnlyNetwork(c *dtypes.Container, name string) bool {
+	if c.NetworkSettings == nil || len(c.NetworkSettings.Networks) != 1 {
+		return false
+	}
+	_, ok := c.NetworkSettings.Networks[name]
+	return ok
+}
+
+// find find container with exact name, nil if not found
+func (m *dockerManager) find(name string) (c *dtypes.Container, err error) {
+	var list []dtypes.Container
+	if list, err = m.list(name); err != nil {
+		return
+	}
+	for _, l := range list {
+		for _, n := range l.Names {
+			if strings.TrimPrefix(n, "/") == name {
+				c = &l
+				return
+			}
+		}
+	}
+	return
+}
+
+// list list containers with name filter, docker matches name by substring
+func (m *dockerManager) list(name string) (list []dtypes.Container, err error) {
+	fts := filters.NewArgs()
+	fts.Add("name", name)
+	return m.client.ContainerList(context.Background(), dtypes.ContainerListOptions{All: true, Filters: fts})
+}
+
+// List list all sandbox containers
+func (m *dockerManager) List() (ss []State, err error) {
+	var list []dtypes.Container
+	if list, err = m.list(containerNamePrefix); err != nil {
+		return
+	}
+	ss = make([]State, 0, len(list))
+	for _, l := range list {
+		for _, n := range l.Names {
+			account := getAccountFromContainerName(n)
+			if len(account) == 0 {
+				continue
+			}
+			ss = append(ss, State{
+				Account:   account,
+				Name:      GetContainerName(account),
+				IsRunning: l.State == "running",
+				Status:    l.Status,
+				CreatedAt: time.Unix(l.Created, 0),
+			})
+			break
+		}
+	}
+	return
+}
+
+// Stop stop the sandbox container, it will be started again on next connection
+func (m *dockerManager) Stop(account string) (err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var c *dtypes.Container
+	if c, err = m.find(GetContainerName(account)); err != nil || c == nil {
+		return
+	}
+	if c.State != "running" {
+		return
+	}
+	return m.stop(c.ID)
+}
+
+// stop stop container, killed after 10 seconds
+func (m *dockerManager) stop(id string) error {
+	timeout := time.Second * 10
+	return m.client.ContainerStop(context.Background(), id, &timeout)
+}
+
+// remove force remove the sandbox container if exists
+func (m *dockerManager) remove(account string) (err error) {
+	var c *dtypes.Container
+	if c, err = m.find(GetContainerName(account)); err != nil || c == nil {
+		return
+	}
+	return m.client.ContainerRemove(context.Background(), c.ID, dtypes.ContainerRemoveOptions{Force: true})
+}
+
+// Reset remove the sandbox container and create a fresh one from image, home directory is kept
+func (m *dockerManager) Reset(account string, l types.SandboxLimits) (err error) {
+	m.mutex.Lock()
+	err = m.remove(account)
+	m.mutex.Unlock()
+	if err != nil {
+		return
+	}
+	_, err = m.FindOrCreate(account, l)
+	return
+}
+
+// Destroy remove the sandbox container, and the home directory if wipe is set
+func (m *dockerManager) Destroy(account string, wipe bool) (err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err = m.remove(account); err != nil {
+		return
+	}
+	os.RemoveAll(path.Join(m.Config.Sandbox.DataDir, agentDirName, GetContainerName(account)))
+	if wipe {
+		err = os.RemoveAll(path.Join(m.Config.Sandbox.DataDir, GetContainerName(account)))
+	}
+	return
+}
+
+type dockerSandbox struct {
+	client   *client.Client
+	name     string
+	dir      string
+	agentDir string // directory for ssh agent sockets on host
+	hasAgent bool   // agentDir is mounted in container
+}
+
+func (s *dockerSandbox) GetContainerName() string {
+	return s.name
+}
+
+func (s *dockerSandbox) Start() error {
+	return s.client.ContainerStart(context.Background(), s.name, dtypes.ContainerStartOptions{})
+}
+
+// ListenAgent listen on ssh agent socket, socket is placed in agent directory mounted read-only in container
+func (s *dockerSandbox) ListenAgent(id string) (l net.Listener, p string, err error) {
+	if !s.hasAgent {
+		err = fmt.Errorf("sandbox %s is created without agent directory, reset it to enable agent forwarding", s.name)
+		return
+	}
+	if l, err = listenAgentSocket(s.agentDir, id, -1, -1); err != nil {
+		return
+	}
+	p = path.Join(agentMountPoint, agentSocketName(id))
+	return
+}
+
+func (s *dockerSandbox) GenerateSSHKey() (err error) {
+	_, _, err = s.ExecScript(scriptGenerateSSHKey)
+	return
+}
+
+func (s *dockerSandbox) GetSSHPublicKey() (pkey string, err error) {
+	pkey, _, err = s.ExecScript(`cat /root/.ssh/id_rsa.pub`)
+	pkey = strings.TrimSpace(pkey)
+	return
+}
+
+func (s *dockerSandbox) ExecScript(sc string) (stdout string, stderr string, err error) {
+	// create exec
+	var id dtypes.IDResponse
+	if id, err = s.client.ContainerExecCreate(
+		context.Background(),
+		s.name,
+		dtypes.ExecConfig{
+			AttachStdin:  true,
+			AttachStdout: true,
+			AttachStderr: true,
+			Cmd: []string{
+				"/bin/bash",
+			},
+		},
+	); err != nil {
+		return
+	}
+	// exec attach
+	var hr dtypes.HijackedResponse
+	if hr, err = s.client.ContainerExecAttach(context.Background(), id.ID, dtypes.ExecConfig{}); err != nil {
+		return
+	}
+	defer hr.Close()
+	wg := &sync.WaitGroup{}
+	// pipe stdin
+	wg.Add(1)
+	go func() {
+		sandboxPipeStdin(hr, bytes.NewReader([]byte(sc)), &err)
+		wg.Done()
+	}()
+	// pipe stdout/stderr
+	bout := &bytes.Buffer{}
+	berr := &bytes.Buffer{}
+	wg.Add(1)
+	go func() {
+		sandboxPipeStdoutStderr(hr, bout, berr, false, &err)
+		wg.Done()
+	}()
+	// wait
+	wg.Wait()
+
+	// output as string
+	stdout = string(bout.Bytes())
+	stderr = string(berr.Bytes())
+
+	return
+}
+
+// ExecAttach exec and attach, returns exit code of the process, and the signal if bunker killed it,
+// docker does not tell a process killed by signal from a process exited with 128 + signal
+func (s *dockerSandbox) ExecAttach(opts ExecAttachOptions) (code int, sig syscall.Signal, err error) {
+	execCfg := dtypes.ExecConfig{
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          opts.IsPty,
+		Cmd:          opts.Command,
+		Env:          opts.Env,
+	}
+	// append env if TERM is set
+	if len(opts.Term) > 0 {
+		if execCfg.Env == nil {
+			execCfg.Env = make([]string, 0)
+		}
+		execCfg.Env = append(execCfg.Env, fmt.Sprintf("TERM=%s", opts.Term))
+	}
+	// use /bin/bash if no command specified
+	if len(execCfg.Cmd) == 0 {
+		execCfg.Cmd = []string{"/bin/bash"}
+	}
+	// pid of exec on host may be reused after exit, or invisible if bunker runs in a container,
+	// so pid in container is recorded for signals
+	buf := make([]byte, 8)
+	if _, err = rand.Read(buf); err != nil {
+		return
+	}
+	pidFile := path.Join(dockerExecPidDir, hex.EncodeToString(buf)+".pid")
+	execCfg.Cmd = dockerExecCommand(execCfg.Cmd, pidFile)
+	// create exec
+	var id dtypes.IDResponse
+	if id, err = s.client.ContainerExecCreate(context.Background(), s.name, execCfg); err != nil {
+		return
+	}
+	// exec attach
+	var hr dtypes.HijackedResponse
+	if hr, err = s.client.ContainerExecAttach(context.Background(), id.ID, execCfg); err != nil {
+		return
+	}
+	// pipe window size
+	if opts.IsPty && opts.WindowChan != nil {
+		go sandboxPipeWindowSize(s.client, id.ID, opts.WindowChan)
+	}
+	// pipe signals
+	if opts.SignalChan != nil {
+		go s.pipeSignal(id.ID, pidFile, opts.SignalChan)
+	}
+	// pipe stdin
+	go sandboxPipeStdin(hr, opts.Stdin, &err)
+	// pipe stdout/stderr
+	sandboxPipeStdoutStderr(hr, opts.Stdout, opts.Stderr, opts.IsPty, &err)
+	// close hr
+	hr.Close()
+	// inspect exec
+	var is dtypes.ContainerExecInspect
+	if is, err = s.client.ContainerExecInspect(context.Background(), id.ID); err != nil {
+		return
+	}
+	code = is.ExitCode
+	// send SIGTERM to zombie process
+	if is.Running {
+		log.Println("Exec:", id.ID, "not terminated properly, sending SIGTERM")
+		code, sig = 128+int(syscall.SIGTERM), syscall.SIGTERM
+		if err = s.signal(id.ID, pidFile, syscall.SIGTERM); err != nil {
+			return
+		}
+	}
+	s.ExecScript(fmt.Sprintf("rm -f %s", pidFile))
+	return
+}
+
+// signal send signal to exec process by kill in container, nothing is sent if process is no longer running,
+// a stale pid can only be reused by another process in the same container
+func (s *dockerSandbox) signal(id string, pidFile string, sig syscall.Signal) (err error) {
+	var is dtypes.ContainerExecInspect
+	if is, err = s.client.ContainerExecInspect(context.Background(), id); err != nil || !is.Running {
+		return
+	}
+	var stderr string
+	if _, stderr, err = s.ExecScript(fmt.Sprintf("kill -%d \"$(cat %s)\"", int(sig), pidFile)); err == nil && len(stderr) > 0 {
+		err = fmt.Errorf("failed to send signal %d: %s", int(sig), strings.TrimSpace(stderr))
+	}
+	return
+}
+
+func (s *dockerSandbox) pipeSignal(id string, pidFile string, sch chan syscall.Signal) {
+	for sig := range sch {
+		if err := s.signal(id, pidFile, sig); err != nil {
+			log.Println("Exec:", id, err)
+		}
+	}
+}
+
+func sandboxPipeWindowSize(c *client.Client, id string, wchan chan Window) {
+	for {
+		w, ok := <-wchan
+		if !ok {
+			break
+		}
+		c.ContainerExecResize(context.Background(), id, dtypes.ResizeOptions{Height: w.Height, Width: w.Width})
+	}
+}
+
+func sandboxPipeStdin(hr dtypes.HijackedResponse, stdin io.Reader, errout *error) {
+	var err error
+	_, err = io.Copy(hr.Conn, stdin)
+	hr.CloseWrite()
+	// clear EOF
+	if err != nil && err != io.EOF {
+		*errout = err
+	}
+	return
+}
+
+func sandboxPipeStdoutStderr(hr dtypes.HijackedResponse, stdout, stderr io.Writer, isPty bool, errout *error) {
+	var err error
+	if isPty {
+		_, err = io.Copy(stdout, hr.Reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, hr.Reader)
+	}
+	// clear EOF
+	if err != nil && err != io.EOF {
+		*errout = err
+	}
+	return
+}
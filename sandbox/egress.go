@@ -0,0 +1,95 @@
+/**
+ * sandbox/egress.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package sandbox
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// EgressRule allowed egress destination
+type EgressRule struct {
+	Network *net.IPNet
+	Port    int // 0 for all ports
+}
+
+// ParseEgressRule parse "CIDR", "CIDR:PORT", "IP" or "IP:PORT"
+func ParseEgressRule(s string) (r EgressRule, err error) {
+	s = strings.TrimSpace(s)
+	// split port
+	if i := strings.LastIndex(s, ":"); i > 0 {
+		if r.Port, err = strconv.Atoi(s[i+1:]); err != nil || r.Port < 1 || r.Port > 65535 {
+			err = fmt.Errorf("invalid egress rule %q, bad port", s)
+			return
+		}
+		s = s[:i]
+	}
+	// single ip
+	if !strings.Contains(s, "/") {
+		s = s + "/32"
+	}
+	if _, r.Network, err = net.ParseCIDR(s); err != nil || r.Network.IP.To4() == nil {
+		err = fmt.Errorf("invalid egress rule %q, IPv4 address or CIDR required", s)
+		return
+	}
+	return
+}
+
+// ParseEgressRules parse multiple egress rules
+func ParseEgressRules(ss []string) (rs []EgressRule, err error) {
+	rs = make([]EgressRule, 0, len(ss))
+	for _, s := range ss {
+		var r EgressRule
+		if r, err = ParseEgressRule(s); err != nil {
+			return
+		}
+		rs = append(rs, r)
+	}
+	return
+}
+
+// egressRules render iptables rules of chain, allowing established connections, bunker sshd and rules, others are sent to target
+func egressRules(chain string, hostIP string, port int, rs []EgressRule, target string) string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "-A %s -m state --state ESTABLISHED,RELATED -j ACCEPT\n", chain)
+	if len(hostIP) > 0 {
+		fmt.Fprintf(buf, "-A %s -d %s/32 -p tcp --dport %d -j ACCEPT\n", chain, hostIP, port)
+	}
+	for _, r := range rs {
+		if r.Port == 0 {
+			fmt.Fprintf(buf, "-A %s -d %s -j ACCEPT\n", chain, r.Network.String())
+		} else {
+			fmt.Fprintf(buf, "-A %s -d %s -p tcp --dport %d -j ACCEPT\n", chain, r.Network.String(), r.Port)
+			fmt.Fprintf(buf, "-A %s -d %s -p udp --dport %d -j ACCEPT\n", chain, r.Network.String(), r.Port)
+		}
+	}
+	fmt.Fprintf(buf, "-A %s -j %s\n", chain, target)
+	return buf.String()
+}
+
+// applyEgressChain replace rules of chain in table, and insert jump to it if not exists
+func applyEgressChain(table string, chain string, rules string, jump ...string) (err error) {
+	cmd := exec.Command("iptables-restore", "--noflush")
+	cmd.Stdin = strings.NewReader("*" + table + "\n:" + chain + " - [0:0]\n" + rules + "COMMIT\n")
+	var out []byte
+	if out, err = cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to apply egress policy: %s, %s", err.Error(), strings.TrimSpace(string(out)))
+	}
+	if exec.Command("iptables", append([]string{"-t", table, "-C"}, jump...)...).Run() == nil {
+		return
+	}
+	if out, err = exec.Command("iptables", append([]string{"-t", table, "-I"}, jump...)...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to apply egress policy: %s, %s", err.Error(), strings.TrimSpace(string(out)))
+	}
+	return
+}
@@ -0,0 +1,46 @@
+/**
+ *Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ *This software is released under the MIT License.
+ *https://opensource.org/licenses/MIT
+ */
+
+package sandbox
+
+import (
+	"testing"
+)
+
+func TestParseEgressRule(t *testing.T) {
+	r, err := ParseEgressRule("10.0.0.0/8")
+	if err != nil || r.Network.String() != "10.0.0.0/8" || r.Port != 0 {
+		t.Error("failed to parse cidr", r, err)
+	}
+	r, err = ParseEgressRule("192.168.1.1:443")
+	if err != nil || r.Network.String() != "192.168.1.1/32" || r.Port != 443 {
+		t.Error("failed to parse ip with port", r, err)
+	}
+	for _, s := range []string{"", "example.com", "10.0.0.1:0", "10.0.0.1:abc", "::1"} {
+		if _, err = ParseEgressRule(s); err == nil {
+			t.Error("should fail", s)
+		}
+	}
+}
+
+func TestEgressRules(t *testing.T) {
+	rs, err := ParseEgressRules([]string{"10.0.0.0/8", "8.8.8.8:53"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := egressRules("OUTPUT", "172.17.0.1", 2222, rs, "REJECT")
+	exp := `-A OUTPUT -m state --state ESTABLISHED,RELATED -j ACCEPT
+-A OUTPUT -d 172.17.0.1/32 -p tcp --dport 2222 -j ACCEPT
+-A OUTPUT -d 10.0.0.0/8 -j ACCEPT
+-A OUTPUT -d 8.8.8.8/32 -p tcp --dport 53 -j ACCEPT
+-A OUTPUT -d 8.8.8.8/32 -p udp --dport 53 -j ACCEPT
+-A OUTPUT -j REJECT
+`
+	if out != exp {
+		t.Error("bad rules", out)
+	}
+}
@@ -0,0 +1,476 @@
+/**
+ * sandbox/local.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package sandbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/creack/pty"
+	"github.com/yankeguo/bunker/types"
+)
+
+const localDefaultShell = "/bin/bash"
+
+const localDefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+const localEgressChain = "BUNKER-SANDBOX"
+
+// localRootDir mount point of new root, relative to Sandbox.DataDir
+const localRootDir = ".root"
+
+// localHomePerm home directory is private to the sandbox user
+const localHomePerm = os.FileMode(0700)
+
+// localSharedPerm shared directory is writable by all sandbox users, files are removable by owner only
+const localSharedPerm = os.FileMode(0777) | os.ModeSticky
+
+type localManager struct {
+	Config   types.Config
+	mutex    *sync.Mutex
+	uidFirst int
+	uidLast  int
+	shell    string
+	procs    *localProcs
+}
+
+// localProcs registry of running process groups, keyed by account
+type localProcs struct {
+	mutex *sync.Mutex
+	pids  map[string]map[int]bool
+}
+
+func newLocalProcs() *localProcs {
+	return &localProcs{mutex: &sync.Mutex{}, pids: map[string]map[int]bool{}}
+}
+
+func (p *localProcs) add(account string, pid int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.pids[account] == nil {
+		p.pids[account] = map[int]bool{}
+	}
+	p.pids[account][pid] = true
+}
+
+func (p *localProcs) remove(account string, pid int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.pids[account], pid)
+	if len(p.pids[account]) == 0 {
+		delete(p.pids, account)
+	}
+}
+
+func (p *localProcs) count(account string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.pids[account])
+}
+
+// kill kill all process groups of account
+func (p *localProcs) kill(account string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for pid := range p.pids[account] {
+		syscall.Kill(-pid, syscall.SIGKILL)
+	}
+}
+
+// newLocalManager new local manager, sandboxes are processes on local machine
+func newLocalManager(cfg types.Config) (m Manager, err error) {
+	lm := &localManager{
+		Config: cfg,
+		mutex:  &sync.Mutex{},
+		shell:  cfg.Sandbox.Shell,
+		procs:  newLocalProcs(),
+	}
+	if len(lm.shell) == 0 {
+		lm.shell = localDefaultShell
+	}
+	// sandboxes must never share a uid with each other or with bunker
+	if lm.uidFirst, lm.uidLast = cfg.Sandbox.UIDRange(); lm.uidFirst <= 0 || lm.uidLast < lm.uidFirst {
+		err = errors.New("local sandbox requires sandbox.uid_base, uids reserved for sandbox users")
+		return
+	}
+	if os.Geteuid() != 0 {
+		err = errors.New("local sandbox requires bunker running as root, to run sandboxes as their own uids")
+		return
+	}
+	if !localNamespaceSupported {
+		err = errors.New("local sandbox is only supported on linux")
+		return
+	}
+	var fi os.FileInfo
+	if fi, err = os.Stat(cfg.Sandbox.RootDir); err != nil || len(cfg.Sandbox.RootDir) == 0 || !fi.IsDir() {
+		err = errors.New("local sandbox requires sandbox.root_dir, a directory of root filesystem")
+		return
+	}
+	if err = os.MkdirAll(filepath.Join(cfg.Sandbox.DataDir, localRootDir), 0700); err != nil {
+		return
+	}
+	if err = lm.CheckLimits(cfg.Sandbox.Limits); err != nil {
+		return
+	}
+	if cfg.Sandbox.Egress {
+		var rs []EgressRule
+		if rs, err = ParseEgressRules(cfg.Sandbox.EgressAllow); err != nil {
+			return
+		}
+		// outgoing packets of sandbox users jump to egress chain
+		rules := egressRules(localEgressChain, cfg.Sandbox.HostIP, cfg.SSHD.Port, rs, "REJECT")
+		if err = applyEgressChain("filter", localEgressChain, rules, "OUTPUT", "-m", "owner", "--uid-owner", fmt.Sprintf("%d-%d", lm.uidFirst, lm.uidLast), "-j", localEgressChain); err != nil {
+			return
+		}
+	}
+	return lm, nil
+}
+
+// CheckLimits check limits are supported, cpu, memory and disk limits can not be enforced by local sandbox
+func (m *localManager) CheckLimits(l types.SandboxLimits) error {
+	if l.CPUs > 0 {
+		return errors.New("cpu limit is not supported by local sandbox")
+	}
+	if l.Memory > 0 {
+		return errors.New("memory limit is not supported by local sandbox")
+	}
+	if l.Disk > 0 {
+		return errors.New("disk limit is not supported by local sandbox")
+	}
+	return nil
+}
+
+// FindOrCreate find or create a sandbox, a sandbox is a home directory in Sandbox.DataDir
+func (m *localManager) FindOrCreate(account string, l types.SandboxLimits) (s Sandbox, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name := GetContainerName(account)
+	// ensure dir
+	uDir := filepath.Join(m.Config.Sandbox.DataDir, name)
+	sDir := filepath.Join(m.Config.Sandbox.DataDir, "shared")
+	if err = os.MkdirAll(uDir, localHomePerm); err != nil {
+		return
+	}
+	if err = os.MkdirAll(sDir, localSharedPerm); err != nil {
+		return
+	}
+	if err = os.Chmod(sDir, localSharedPerm); err != nil {
+		return
+	}
+	var aDir string
+	if aDir, err = ensureAgentDir(m.Config.Sandbox.DataDir, name); err != nil {
+		return
+	}
+	var uid int
+	if uid, err = m.homeUID(uDir); err != nil {
+		return
+	}
+	s = &localSandbox{
+		name:    name,
+		account: account,
+		home:    uDir,
+		shared:  sDir,
+		agent:   aDir,
+		root:    filepath.Join(m.Config.Sandbox.DataDir, localRootDir),
+		rootFS:  m.Config.Sandbox.RootDir,
+		shell:   m.shell,
+		cred:    &syscall.Credential{Uid: uint32(uid), Gid: uint32(uid)},
+		procs:   m.procs,
+		limits:  l,
+	}
+	// create ssh keys
+	if !hasSSHKey(uDir) {
+		if err = s.GenerateSSHKey(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// homeUID uid of sandbox, a home directory owned by a uid out of range is assigned a new uid,
+// the uid is recorded as owner of the home directory, gid is the same as uid, must be called with mutex held
+func (m *localManager) homeUID(home string) (uid int, err error) {
+	if uid = localOwner(home); uid >= m.uidFirst && uid <= m.uidLast {
+		return
+	}
+	// next to the largest uid in use, uid of a wiped sandbox may be reused
+	uid = m.uidFirst
+	var fis []os.FileInfo
+	if fis, err = ioutil.ReadDir(m.Config.Sandbox.DataDir); err != nil {
+		return
+	}
+	for _, fi := range fis {
+		if !fi.IsDir() || len(getAccountFromContainerName(fi.Name())) == 0 {
+			continue
+		}
+		if o := localOwner(filepath.Join(m.Config.Sandbox.DataDir, fi.Name())); o >= uid && o <= m.uidLast {
+			uid = o + 1
+		}
+	}
+	if uid > m.uidLast {
+		err = errors.New("no uid available for sandbox, increase sandbox.uid_count")
+		return
+	}
+	// files created before, e.g. by a shared sandbox user, are handed over, symlinks are never followed
+	if err = localChownTree(home, uid); err != nil {
+		return
+	}
+	err = os.Chmod(home, localHomePerm)
+	return
+}
+
+// localOwner uid owning the file, -1 if failed
+func localOwner(name string) int {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return -1
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid)
+	}
+	return -1
+}
+
+// List list sandbox home directories in Sandbox.DataDir, running if any process exists
+func (m *localManager) List() (ss []State, err error) {
+	var fis []os.FileInfo
+	if fis, err = ioutil.ReadDir(m.Config.Sandbox.DataDir); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	ss = make([]State, 0, len(fis))
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		account := getAccountFromContainerName(fi.Name())
+		if len(account) == 0 {
+			continue
+		}
+		n := m.procs.count(account)
+		ss = append(ss, State{
+			Account:   account,
+			Name:      fi.Name(),
+			IsRunning: n > 0,
+			Status:    fmt.Sprintf("%d process(es)", n),
+		})
+	}
+	return
+}
+
+// Stop kill all processes of the sandbox
+func (m *localManager) Stop(account string) error {
+	m.procs.kill(account)
+	return nil
+}
+
+// Reset same as Stop, there is nothing to recreate for a home directory, limits apply to new processes
+func (m *localManager) Reset(account string, l types.SandboxLimits) error {
+	return m.Stop(account)
+}
+
+// Destroy kill all processes of the sandbox, and remove the home directory if wipe is set
+func (m *localManager) Destroy(account string, wipe bool) (err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.procs.kill(account)
+	os.RemoveAll(filepath.Join(m.Config.Sandbox.DataDir, agentDirName, GetContainerName(account)))
+	if wipe {
+		err = os.RemoveAll(filepath.Join(m.Config.Sandbox.DataDir, GetContainerName(account)))
+	}
+	return
+}
+
+type localSandbox struct {
+	name    string
+	account string
+	home    string // home directory on host
+	shared  string // shared directory on host
+	agent   string // directory for ssh agent sockets on host
+	root    string // mount point of new root
+	rootFS  string // root filesystem
+	shell   string
+	cred    *syscall.Credential
+	procs   *localProcs
+	limits  types.SandboxLimits
+}
+
+func (s *localSandbox) GetContainerName() string {
+	return s.name
+}
+
+// Start nothing to start, processes are spawned on exec
+func (s *localSandbox) Start() error {
+	return nil
+}
+
+func (s *localSandbox) GenerateSSHKey() (err error) {
+	_, _, err = s.ExecScript(scriptGenerateSSHKey)
+	return
+}
+
+// GetSSHPublicKey read public key as sandbox user, home directory is controlled by sandbox user and never read by bunker
+func (s *localSandbox) GetSSHPublicKey() (pkey string, err error) {
+	pkey, _, err = s.ExecScript(`cat /root/.ssh/id_rsa.pub`)
+	pkey = strings.TrimSpace(pkey)
+	return
+}
+
+// ListenAgent listen on ssh agent socket in agent directory, socket is owned by sandbox user
+func (s *localSandbox) ListenAgent(id string) (l net.Listener, p string, err error) {
+	if l, err = listenAgentSocket(s.agent, id, int(s.cred.Uid), int(s.cred.Gid)); err != nil {
+		return
+	}
+	p = path.Join(agentMountPoint, agentSocketName(id))
+	return
+}
+
+// command create a command running in new namespaces and root filesystem as sandbox user
+func (s *localSandbox) command(args []string, env []string) *exec.Cmd {
+	if len(args) == 0 {
+		args = []string{s.shell}
+	}
+	// home is mounted at /root, limits are applied by init
+	cmd := localInitCommand(localInitSpec{
+		RootFS:   s.rootFS,
+		Root:     s.root,
+		Home:     s.home,
+		Shared:   s.shared,
+		Agent:    s.agent,
+		Hostname: fmt.Sprintf("%s.sandbox", s.account),
+		Account:  s.account,
+		Shell:    s.shell,
+		UID:      int(s.cred.Uid),
+		GID:      int(s.cred.Gid),
+		Pids:     s.limits.Pids,
+	}, args)
+	cmd.Env = append([]string{
+		"HOME=/root",
+		fmt.Sprintf("USER=%s", s.account),
+		fmt.Sprintf("LOGNAME=%s", s.account),
+		fmt.Sprintf("SHELL=%s", s.shell),
+		fmt.Sprintf("PATH=%s", localDefaultPath),
+	}, env...)
+	return cmd
+}
+
+func (s *localSandbox) ExecScript(sc string) (stdout string, stderr string, err error) {
+	cmd := s.command([]string{s.shell}, nil)
+	bout := &bytes.Buffer{}
+	berr := &bytes.Buffer{}
+	cmd.Stdin = strings.NewReader(sc)
+	cmd.Stdout = bout
+	cmd.Stderr = berr
+	cmd.SysProcAttr.Setpgid = true
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	s.procs.add(s.account, cmd.Process.Pid)
+	err = cmd.Wait()
+	s.procs.remove(s.account, cmd.Process.Pid)
+	// output as string
+	stdout = string(bout.Bytes())
+	stderr = string(berr.Bytes())
+	return
+}
+
+// ExecAttach exec and attach, returns exit code of the process, or 128 + signal and the signal if killed
+func (s *localSandbox) ExecAttach(opts ExecAttachOptions) (code int, sig syscall.Signal, err error) {
+	env := opts.Env
+	// append env if TERM is set
+	if len(opts.Term) > 0 {
+		env = append(env, fmt.Sprintf("TERM=%s", opts.Term))
+	}
+	cmd := s.command(opts.Command, env)
+	if opts.IsPty {
+		var ptmx *os.File
+		// pty.Start starts process in a new session, which is also a new process group
+		if ptmx, err = pty.Start(cmd); err != nil {
+			return
+		}
+		defer ptmx.Close()
+		s.procs.add(s.account, cmd.Process.Pid)
+		defer s.procs.remove(s.account, cmd.Process.Pid)
+		// pipe signals
+		if opts.SignalChan != nil {
+			go localPipeSignal(cmd.Process, opts.SignalChan)
+		}
+		// pipe window size
+		if opts.WindowChan != nil {
+			go localPipeWindowSize(ptmx, opts.WindowChan)
+		}
+		// pipe stdin, not waited, like docker exec
+		go io.Copy(ptmx, opts.Stdin)
+		// pipe stdout, EIO when process exited
+		io.Copy(opts.Stdout, ptmx)
+	} else {
+		var stdin io.WriteCloser
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return
+		}
+		cmd.Stdout = opts.Stdout
+		cmd.Stderr = opts.Stderr
+		cmd.SysProcAttr.Setpgid = true
+		if err = cmd.Start(); err != nil {
+			return
+		}
+		s.procs.add(s.account, cmd.Process.Pid)
+		defer s.procs.remove(s.account, cmd.Process.Pid)
+		// pipe signals
+		if opts.SignalChan != nil {
+			go localPipeSignal(cmd.Process, opts.SignalChan)
+		}
+		// pipe stdin, not waited, exec.Cmd#Wait shall not block on stdin
+		go func() {
+			io.Copy(stdin, opts.Stdin)
+			stdin.Close()
+		}()
+	}
+	return localExitCode(cmd.Wait())
+}
+
+func localExitCode(err error) (int, syscall.Signal, error) {
+	if err == nil {
+		return 0, 0, nil
+	}
+	if ee, ok := err.(*exec.ExitError); ok {
+		if ws, ok := ee.Sys().(syscall.WaitStatus); ok {
+			if ws.Signaled() {
+				return 128 + int(ws.Signal()), ws.Signal(), nil
+			}
+			return ws.ExitStatus(), 0, nil
+		}
+	}
+	return 0, 0, err
+}
+
+func localPipeWindowSize(ptmx *os.File, wchan chan Window) {
+	for w := range wchan {
+		pty.Setsize(ptmx, &pty.Winsize{Rows: uint16(w.Height), Cols: uint16(w.Width)})
+	}
+}
+
+func localPipeSignal(p *os.Process, sch chan syscall.Signal) {
+	for sig := range sch {
+		p.Signal(sig)
+	}
+}
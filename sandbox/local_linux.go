@@ -0,0 +1,332 @@
+/**
+ * sandbox/local_linux.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+const localNamespaceSupported = true
+
+// localInitName argv[0] of bunker re-executed as init of a namespaced sandbox process
+const localInitName = "bunker-sandbox-init"
+
+// localInitSkipped entries of root filesystem not mounted, replaced by init
+var localInitSkipped = map[string]bool{
+	"root":   true,
+	"shared": true,
+	"proc":   true,
+	"sys":    true,
+	"dev":    true,
+	"tmp":    true,
+	"run":    true,
+}
+
+// localInitDevices device nodes bind mounted from host
+var localInitDevices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// localInitSpec instructs init how to set up the sandbox process
+type localInitSpec struct {
+	RootFS   string `json:"rootfs"`   // root filesystem, mounted read-only
+	Root     string `json:"root"`     // mount point of new root
+	Home     string `json:"home"`     // home directory, mounted at /root
+	Shared   string `json:"shared"`   // shared directory, mounted at /shared
+	Agent    string `json:"agent"`    // directory for ssh agent sockets, mounted read-only at agentMountPoint
+	Hostname string `json:"hostname"` // hostname in new uts namespace
+	Account  string `json:"account"`  // name of sandbox user in /etc/passwd
+	Shell    string `json:"shell"`    // shell of sandbox user in /etc/passwd
+	UID      int    `json:"uid"`      // uid of sandbox user
+	GID      int    `json:"gid"`      // gid of sandbox user
+	Pids     int64  `json:"pids"`     // max processes of sandbox user, 0 for unlimited
+}
+
+// localAtSymlinkNofollow AT_SYMLINK_NOFOLLOW, not exported by package syscall
+const localAtSymlinkNofollow = 0x100
+
+// localRlimitNproc RLIMIT_NPROC, not exported by package syscall
+func localRlimitNproc() int {
+	switch runtime.GOARCH {
+	case "mips", "mipsle", "mips64", "mips64le":
+		return 8
+	case "sparc64":
+		return 7
+	}
+	return 6
+}
+
+func init() {
+	if len(os.Args) > 1 && os.Args[0] == localInitName {
+		localInit(os.Args[1], os.Args[2:])
+	}
+}
+
+// localChownTree change owner of dir and everything in it to uid and gid uid, entries are opened relative to
+// their parent without following symlinks, so entries replaced during the walk never lead out of dir
+func localChownTree(dir string, uid int) (err error) {
+	var fd int
+	if fd, err = syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0); err != nil {
+		return
+	}
+	return localChownDir(fd, dir, uid)
+}
+
+// localChownDir change owner of directory fd and its entries, children first, fd is closed
+func localChownDir(fd int, name string, uid int) (err error) {
+	f := os.NewFile(uintptr(fd), name)
+	defer f.Close()
+	var names []string
+	if names, err = f.Readdirnames(-1); err != nil {
+		return
+	}
+	for _, n := range names {
+		var cfd int
+		cfd, err = syscall.Openat(fd, n, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+		if err == syscall.ENOTDIR || err == syscall.ELOOP {
+			// not a directory, or a symlink
+			if err = syscall.Fchownat(fd, n, uid, uid, localAtSymlinkNofollow); err != nil {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if err = localChownDir(cfd, filepath.Join(name, n), uid); err != nil {
+			return
+		}
+	}
+	return syscall.Fchown(fd, uid, uid)
+}
+
+// localInitCommand create a command re-executing bunker as init in new uts, ipc, pid and mount namespaces,
+// init runs as root, builds the root filesystem, pivots into it, drops to sandbox user and executes args
+func localInitCommand(spec localInitSpec, args []string) *exec.Cmd {
+	buf, _ := json.Marshal(spec)
+	cmd := &exec.Cmd{
+		Path: "/proc/self/exe",
+		Args: append([]string{localInitName, string(buf)}, args...),
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS,
+	}
+	return cmd
+}
+
+// localInit init of a namespaced sandbox process, never returns
+func localInit(arg string, args []string) {
+	err := localInitExec(arg, args)
+	fmt.Fprintln(os.Stderr, "sandbox:", err)
+	os.Exit(126)
+}
+
+func localInitExec(arg string, args []string) (err error) {
+	var spec localInitSpec
+	if err = json.Unmarshal([]byte(arg), &spec); err != nil {
+		return
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("missing command")
+	}
+	if err = localInitRoot(spec); err != nil {
+		return
+	}
+	// pivot into new root and detach the old one, host filesystem is no longer reachable
+	if err = os.Chdir(spec.Root); err != nil {
+		return
+	}
+	if err = syscall.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("pivot_root: %s", err.Error())
+	}
+	if err = syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return
+	}
+	if err = os.Chdir("/root"); err != nil {
+		return
+	}
+	if err = syscall.Sethostname([]byte(spec.Hostname)); err != nil {
+		return
+	}
+	// lookup command before limits apply to init itself
+	var name string
+	if name, err = exec.LookPath(args[0]); err != nil {
+		return
+	}
+	if spec.Pids > 0 {
+		if err = syscall.Setrlimit(localRlimitNproc(), &syscall.Rlimit{Cur: uint64(spec.Pids), Max: uint64(spec.Pids)}); err != nil {
+			return
+		}
+	}
+	// drop to sandbox user, supplementary groups of root are cleared
+	if err = syscall.Setgroups([]int{}); err != nil {
+		return
+	}
+	if err = syscall.Setgid(spec.GID); err != nil {
+		return
+	}
+	if err = syscall.Setuid(spec.UID); err != nil {
+		return
+	}
+	return syscall.Exec(name, args, os.Environ())
+}
+
+// localInitRoot build new root on a tmpfs, with read-only entries of root filesystem, home, shared, proc, dev and tmp
+func localInitRoot(spec localInitSpec) (err error) {
+	// mounts below never propagate to host
+	if err = syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return
+	}
+	if err = syscall.Mount("tmpfs", spec.Root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755"); err != nil {
+		return
+	}
+	var fis []os.FileInfo
+	if fis, err = ioutil.ReadDir(spec.RootFS); err != nil {
+		return
+	}
+	for _, fi := range fis {
+		if localInitSkipped[fi.Name()] {
+			continue
+		}
+		src, dst := filepath.Join(spec.RootFS, fi.Name()), filepath.Join(spec.Root, fi.Name())
+		if fi.Mode()&os.ModeSymlink != 0 {
+			// e.g. /bin -> usr/bin
+			var l string
+			if l, err = os.Readlink(src); err != nil {
+				return
+			}
+			if err = os.Symlink(l, dst); err != nil {
+				return
+			}
+		} else if fi.IsDir() {
+			if err = os.Mkdir(dst, 0755); err != nil {
+				return
+			}
+			if err = localInitBind(src, dst, syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV); err != nil {
+				return
+			}
+		}
+	}
+	for _, d := range []string{"root", "shared", "proc", "dev", "tmp", "run"} {
+		if err = os.Mkdir(filepath.Join(spec.Root, d), 0755); err != nil {
+			return
+		}
+	}
+	if err = localInitBind(spec.Home, filepath.Join(spec.Root, "root"), syscall.MS_NOSUID|syscall.MS_NODEV); err != nil {
+		return
+	}
+	if err = localInitBind(spec.Shared, filepath.Join(spec.Root, "shared"), syscall.MS_NOSUID|syscall.MS_NODEV); err != nil {
+		return
+	}
+	if err = syscall.Mount("proc", filepath.Join(spec.Root, "proc"), "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return
+	}
+	if err = syscall.Mount("tmpfs", filepath.Join(spec.Root, "tmp"), "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return
+	}
+	if err = localInitDev(filepath.Join(spec.Root, "dev")); err != nil {
+		return
+	}
+	if err = syscall.Mount("tmpfs", filepath.Join(spec.Root, "run"), "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755"); err != nil {
+		return
+	}
+	if err = localInitPasswd(spec); err != nil {
+		return
+	}
+	// sockets created later by bunker are visible through the bind mount
+	agent := filepath.Join(spec.Root, agentMountPoint)
+	if err = os.MkdirAll(agent, 0755); err != nil {
+		return
+	}
+	if err = localInitBind(spec.Agent, agent, syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC); err != nil {
+		return
+	}
+	// nothing more to create in new root
+	return syscall.Mount("", spec.Root, "", syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, "")
+}
+
+// localInitPasswd add sandbox user to /etc/passwd and /etc/group of root filesystem, ssh refuses to run as unknown uid,
+// files are written to /run/bunker and bind mounted over the originals
+func localInitPasswd(spec localInitSpec) (err error) {
+	dir := filepath.Join(spec.Root, "run", "bunker")
+	if err = os.Mkdir(dir, 0755); err != nil {
+		return
+	}
+	for name, entry := range map[string]string{
+		"passwd": fmt.Sprintf("%s:x:%d:%d::/root:%s\n", spec.Account, spec.UID, spec.GID, spec.Shell),
+		"group":  fmt.Sprintf("%s:x:%d:\n", spec.Account, spec.GID),
+	} {
+		src, dst := filepath.Join(spec.RootFS, "etc", name), filepath.Join(dir, name)
+		var buf []byte
+		if buf, err = ioutil.ReadFile(src); err != nil {
+			if os.IsNotExist(err) {
+				// nothing to bind over, read-only /etc can not be changed
+				err = nil
+				continue
+			}
+			return
+		}
+		if len(buf) > 0 && buf[len(buf)-1] != '\n' {
+			buf = append(buf, '\n')
+		}
+		if err = ioutil.WriteFile(dst, append(buf, entry...), 0644); err != nil {
+			return
+		}
+		if err = localInitBind(dst, filepath.Join(spec.Root, "etc", name), syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// localInitDev create a minimal /dev, with a private devpts for terminals created in sandbox
+func localInitDev(dev string) (err error) {
+	if err = syscall.Mount("tmpfs", dev, "tmpfs", syscall.MS_NOSUID|syscall.MS_NOEXEC, "mode=0755"); err != nil {
+		return
+	}
+	for _, d := range localInitDevices {
+		if err = ioutil.WriteFile(filepath.Join(dev, d), nil, 0644); err != nil {
+			return
+		}
+		if err = localInitBind(filepath.Join("/dev", d), filepath.Join(dev, d), syscall.MS_NOSUID|syscall.MS_NOEXEC); err != nil {
+			return
+		}
+	}
+	if err = os.Mkdir(filepath.Join(dev, "pts"), 0755); err != nil {
+		return
+	}
+	if err = syscall.Mount("devpts", filepath.Join(dev, "pts"), "devpts", syscall.MS_NOSUID|syscall.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620"); err != nil {
+		return
+	}
+	for l, t := range map[string]string{
+		"ptmx":   "pts/ptmx",
+		"fd":     "/proc/self/fd",
+		"stdin":  "/proc/self/fd/0",
+		"stdout": "/proc/self/fd/1",
+		"stderr": "/proc/self/fd/2",
+	} {
+		if err = os.Symlink(t, filepath.Join(dev, l)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// localInitBind bind mount src to dst, non-recursive, flags are applied by remount
+func localInitBind(src, dst string, flags uintptr) (err error) {
+	if err = syscall.Mount(src, dst, "", syscall.MS_BIND, ""); err != nil {
+		return
+	}
+	return syscall.Mount("", dst, "", syscall.MS_BIND|syscall.MS_REMOUNT|flags, "")
+}
@@ -0,0 +1,45 @@
+//go:build !linux
+// +build !linux
+
+/**
+ * sandbox/local_other.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package sandbox
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// localNamespaceSupported linux namespaces are not supported, local sandbox is rejected by newLocalManager
+const localNamespaceSupported = false
+
+// localInitSpec not used without linux namespaces
+type localInitSpec struct {
+	RootFS   string
+	Root     string
+	Home     string
+	Shared   string
+	Agent    string
+	Hostname string
+	Account  string
+	Shell    string
+	UID      int
+	GID      int
+	Pids     int64
+}
+
+// localChownTree never called without linux namespaces
+func localChownTree(dir string, uid int) error {
+	return errors.New("not supported")
+}
+
+// localInitCommand never called without linux namespaces
+func localInitCommand(spec localInitSpec, args []string) *exec.Cmd {
+	return exec.Command("false")
+}
@@ -9,118 +9,78 @@
 package sandbox
 
 import (
-	"context"
 	"fmt"
 	"os"
-	"path"
-	"sync"
+	"path/filepath"
+	"strings"
+	"time"
 
-	"github.com/docker/docker/client"
-
-	dtypes "github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/api/types/network"
 	"github.com/yankeguo/bunker/types"
 )
 
 const dirPerm = os.FileMode(0750)
 
+const (
+	// BackendDocker sandbox as docker container, default
+	BackendDocker = "docker"
+	// BackendLocal sandbox as local process in linux namespaces, running as dedicated uid of account
+	BackendLocal = "local"
+)
+
+const containerNamePrefix = "sandbox-"
+
 // GetContainerName get container name for account
 func GetContainerName(account string) string {
-	return fmt.Sprintf("sandbox-%s", account)
+	return containerNamePrefix + account
 }
 
-// Manager manager interface
-type Manager interface {
-	FindOrCreate(account string) (Sandbox, error)
+// getAccountFromContainerName get account from container name, empty if not a sandbox
+func getAccountFromContainerName(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if !strings.HasPrefix(name, containerNamePrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(name, containerNamePrefix)
 }
 
-type manager struct {
-	Config types.Config
-	mutex  *sync.Mutex
-	client *client.Client
+// hasSSHKey check whether ssh key is already generated in home directory on host
+func hasSSHKey(home string) bool {
+	_, err := os.Stat(filepath.Join(home, ".ssh", "id_rsa"))
+	return err == nil
 }
 
-// NewManager new manager
-func NewManager(cfg types.Config) (m Manager, err error) {
-	var c *client.Client
-	if c, err = client.NewEnvClient(); err != nil {
-		return
-	}
-	return &manager{
-		Config: cfg,
-		mutex:  &sync.Mutex{},
-		client: c,
-	}, nil
+// State state of a sandbox
+type State struct {
+	Account   string
+	Name      string
+	IsRunning bool
+	Status    string    // human readable status from backend
+	CreatedAt time.Time // zero if unknown
 }
 
-// FindOrCreate find or create a sandbox
-func (m *manager) FindOrCreate(account string) (s Sandbox, err error) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-	name := GetContainerName(account)
-	// ensure dir
-	uDir := path.Join(m.Config.Sandbox.DataDir, name)
-	sDir := path.Join(m.Config.Sandbox.DataDir, "shared")
-	if err = os.MkdirAll(uDir, dirPerm); err != nil {
-		return
-	}
-	if err = os.MkdirAll(sDir, dirPerm); err != nil {
-		return
-	}
-	// find containers
-	fts := filters.NewArgs()
-	fts.Add("name", name)
-	var list []dtypes.Container
-	if list, err = m.client.ContainerList(context.Background(), dtypes.ContainerListOptions{All: true, Filters: fts}); err != nil {
-		return
-	}
-	var running bool
-	var created bool
-	// create if not found
-	if len(list) == 0 {
-		if _, err = m.client.ContainerCreate(
-			context.Background(),
-			&container.Config{
-				Hostname: fmt.Sprintf("%s.sandbox", account),
-				Image:    m.Config.Sandbox.Image,
-			},
-			&container.HostConfig{
-				Binds: []string{
-					fmt.Sprintf("%s:/root", uDir),
-					fmt.Sprintf("%s:/shared", sDir),
-				},
-				RestartPolicy: container.RestartPolicy{
-					Name: "always",
-				},
-			},
-			&network.NetworkingConfig{},
-			name,
-		); err != nil {
-			return
-		}
-	} else {
-		created = true
-		running = list[0].State == "running"
-	}
-	// create the sandbox
-	s = &sandbox{
-		name:   name,
-		client: m.client,
-	}
-	// start if not running
-	if !running {
-		if err = s.Start(); err != nil {
-			return
-		}
-	}
-	// create ssh keys
-	if !created {
-		if err = s.GenerateSSHKey(); err != nil {
-			return
-		}
+// Manager manager interface
+type Manager interface {
+	// FindOrCreate find or create a sandbox with limits, start it if not running
+	FindOrCreate(account string, l types.SandboxLimits) (Sandbox, error)
+	// List list all sandboxes
+	List() ([]State, error)
+	// Stop stop a sandbox, terminating all processes
+	Stop(account string) error
+	// Reset recreate a sandbox with limits, home directory is kept
+	Reset(account string, l types.SandboxLimits) error
+	// Destroy remove a sandbox, home directory is removed if wipe is set
+	Destroy(account string, wipe bool) error
+	// CheckLimits check limits can be enforced by backend
+	CheckLimits(l types.SandboxLimits) error
+}
+
+// NewManager new manager, backend is chosen by config
+func NewManager(cfg types.Config) (m Manager, err error) {
+	switch cfg.Sandbox.Backend {
+	case BackendDocker, "":
+		return newDockerManager(cfg)
+	case BackendLocal:
+		return newLocalManager(cfg)
 	}
-	// get ssh keys
-	return
+	return nil, fmt.Errorf("unknown sandbox backend \"%s\"", cfg.Sandbox.Backend)
 }
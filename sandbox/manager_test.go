@@ -8,20 +8,28 @@
 package sandbox
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/yankeguo/bunker/types"
 )
 
-func TestManagerFindOrCreate(t *testing.T) {
+func testManagerFindOrCreate(t *testing.T, cfg types.SandboxConfig) Sandbox {
 	var m Manager
 	var err error
-	if m, err = NewManager(types.Config{Sandbox: types.SandboxConfig{Image: "ireul/sandbox", DataDir: "/tmp/sandboxdata"}}); err != nil {
+	if m, err = NewManager(types.Config{Sandbox: cfg}); err != nil {
 		t.Fatal(err)
 	}
 	var s Sandbox
-	if s, err = m.FindOrCreate("test2"); err != nil {
+	if s, err = m.FindOrCreate("test2", cfg.Limits); err != nil {
 		t.Fatal(err)
 	}
 	var out1 string
@@ -29,4 +37,270 @@ func TestManagerFindOrCreate(t *testing.T) {
 		t.Fatal(err)
 	}
 	fmt.Println(out1)
+	return s
+}
+
+func TestManagerFindOrCreate(t *testing.T) {
+	if len(os.Getenv("DOCKER_HOST")) == 0 {
+		if _, err := os.Stat("/var/run/docker.sock"); err != nil {
+			t.Skip("docker is not available")
+		}
+	}
+	testManagerFindOrCreate(t, types.SandboxConfig{Image: "ireul/sandbox", DataDir: "/tmp/sandboxdata"})
+}
+
+func TestDockerExecCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sandboxexec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "exec", "a.pid")
+	args := dockerExecCommand([]string{"/bin/sh", "-c", "echo $$; echo \"$1\"", "sh", "a b"}, pidFile)
+	var out []byte
+	if out, err = exec.Command(args[0], args[1:]...).Output(); err != nil {
+		t.Fatal(err)
+	}
+	var pid []byte
+	if pid, err = ioutil.ReadFile(pidFile); err != nil {
+		t.Fatal(err)
+	}
+	// pid is kept by exec
+	if ls := strings.Split(strings.TrimSpace(string(out)), "\n"); len(ls) != 2 || ls[0] != strings.TrimSpace(string(pid)) || ls[1] != "a b" {
+		t.Errorf("invalid output %q, pid %q", out, pid)
+	}
+}
+
+// testLocalUIDBase uids of sandbox users in tests
+const testLocalUIDBase = 290000
+
+func testLocalRequireRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("local sandbox requires root")
+	}
+}
+
+func TestLocalManagerFindOrCreate(t *testing.T) {
+	testLocalRequireRoot(t)
+	dir, err := ioutil.TempDir("", "sandboxdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := testManagerFindOrCreate(t, types.SandboxConfig{Backend: BackendLocal, DataDir: dir, UIDBase: testLocalUIDBase, RootDir: "/"})
+	out := &bytes.Buffer{}
+	code, sig, err := s.ExecAttach(ExecAttachOptions{
+		Command: []string{"/bin/sh", "-c", "echo $HOME; exit 3"},
+		Stdin:   strings.NewReader(""),
+		Stdout:  out,
+		Stderr:  ioutil.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 3 || sig != 0 {
+		t.Errorf("invalid exit code %d, signal %d", code, sig)
+	}
+	if strings.TrimSpace(out.String()) != "/root" {
+		t.Errorf("invalid home %s", out.String())
+	}
+	// exit code above 128 is not a signal
+	if code, sig, err = s.ExecAttach(ExecAttachOptions{
+		Command: []string{"/bin/sh", "-c", "exit 130"},
+		Stdin:   strings.NewReader(""),
+		Stdout:  ioutil.Discard,
+		Stderr:  ioutil.Discard,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if code != 130 || sig != 0 {
+		t.Errorf("invalid exit code %d, signal %d", code, sig)
+	}
+}
+
+func TestLocalChownTree(t *testing.T) {
+	testLocalRequireRoot(t)
+	dir, err := ioutil.TempDir("", "sandboxchown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outside := filepath.Join(dir, "outside")
+	home := filepath.Join(dir, "home")
+	for _, d := range []string{outside, filepath.Join(home, "a", "b")} {
+		if err = os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ioutil.WriteFile(filepath.Join(outside, "f"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(home, "a", "f"), nil, 0644)
+	os.Symlink(outside, filepath.Join(home, "a", "l"))
+	if err = localChownTree(home, testLocalUIDBase); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{home, filepath.Join(home, "a", "b"), filepath.Join(home, "a", "f")} {
+		if o := localOwner(p); o != testLocalUIDBase {
+			t.Errorf("invalid owner %d of %s", o, p)
+		}
+	}
+	// symlink itself is changed, target is not
+	if fi, err := os.Lstat(filepath.Join(home, "a", "l")); err != nil || fi.Sys().(*syscall.Stat_t).Uid != testLocalUIDBase {
+		t.Error("invalid owner of symlink")
+	}
+	if o := localOwner(filepath.Join(outside, "f")); o != 0 {
+		t.Errorf("symlink followed, owner %d", o)
+	}
+}
+
+func TestLocalManagerRequiresUID(t *testing.T) {
+	if _, err := NewManager(types.Config{Sandbox: types.SandboxConfig{Backend: BackendLocal, DataDir: os.TempDir()}}); err == nil {
+		t.Error("local sandbox without uid_base should be rejected")
+	}
+}
+
+func TestLocalManagerCheckLimits(t *testing.T) {
+	m := &localManager{}
+	if err := m.CheckLimits(types.SandboxLimits{Pids: 512}); err != nil {
+		t.Error(err)
+	}
+	if err := m.CheckLimits(types.SandboxLimits{Memory: 1024}); err == nil {
+		t.Error("memory limit should be rejected")
+	}
+	if err := m.CheckLimits(types.SandboxLimits{CPUs: 1}); err == nil {
+		t.Error("cpu limit should be rejected")
+	}
+	if err := m.CheckLimits(types.SandboxLimits{Disk: 1024}); err == nil {
+		t.Error("disk limit should be rejected")
+	}
+}
+
+func TestLocalManagerIsolation(t *testing.T) {
+	testLocalRequireRoot(t)
+	dir, err := ioutil.TempDir("", "sandboxdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var m Manager
+	if m, err = NewManager(types.Config{Sandbox: types.SandboxConfig{
+		Backend: BackendLocal,
+		DataDir: dir,
+		UIDBase: testLocalUIDBase,
+		RootDir: "/",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	run := func(s Sandbox, sc string) string {
+		out := &bytes.Buffer{}
+		code, _, err := s.ExecAttach(ExecAttachOptions{
+			Command: []string{"/bin/sh", "-c", sc},
+			Stdin:   strings.NewReader(""),
+			Stdout:  out,
+			Stderr:  out,
+		})
+		if err != nil || code != 0 {
+			t.Fatalf("%s failed with %d: %v %s", sc, code, err, out.String())
+		}
+		return strings.TrimSpace(out.String())
+	}
+	var s1, s2 Sandbox
+	if s1, err = m.FindOrCreate("test4", types.SandboxLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	if s2, err = m.FindOrCreate("test5", types.SandboxLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	// each account runs as its own uid, known to /etc/passwd
+	if out := run(s1, "id -u; id -g; id -un; echo $HOME; pwd"); out != fmt.Sprintf("%d\n%d\ntest4\n/root\n/root", testLocalUIDBase, testLocalUIDBase) {
+		t.Errorf("invalid identity %q", out)
+	}
+	if out := run(s2, "id -u"); out != fmt.Sprintf("%d", testLocalUIDBase+1) {
+		t.Errorf("invalid uid %q", out)
+	}
+	// homes are private, host data dir is not reachable, root filesystem is read-only
+	for _, home := range []string{filepath.Join(dir, "sandbox-test4"), filepath.Join(dir, "sandbox-test5")} {
+		if fi, err := os.Stat(home); err != nil || fi.Mode().Perm() != 0700 {
+			t.Errorf("invalid mode of home %s", home)
+		}
+	}
+	if out := run(s1, "test -e "+dir+" && echo visible; touch /etc/bunker-test 2>/dev/null && echo writable; touch /root/a /tmp/a /shared/a && echo ok"); out != "ok" {
+		t.Errorf("invalid isolation %q", out)
+	}
+	// agent socket is only accessible by sandbox user
+	l, p, err := s1.ListenAgent("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if p != "/run/bunker/agent/agent.test.sock" {
+		t.Errorf("invalid agent socket %s", p)
+	}
+	if out := run(s1, "stat -c %a:%u "+p); out != fmt.Sprintf("600:%d", testLocalUIDBase) {
+		t.Errorf("invalid agent socket mode %q", out)
+	}
+	// agent directory is not writable in sandbox, a symlink in home is never followed
+	if out := run(s1, "touch /run/bunker/agent/a 2>/dev/null && echo writable; ln -s /etc /root/.bunker && echo ok"); out != "ok" {
+		t.Errorf("invalid agent directory %q", out)
+	}
+	if l, _, err = s1.ListenAgent("test2"); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if _, err = os.Stat("/etc/agent.test2.sock"); err == nil {
+		t.Error("agent socket created through symlink in home")
+	}
+}
+
+func TestLocalManagerStop(t *testing.T) {
+	testLocalRequireRoot(t)
+	dir, err := ioutil.TempDir("", "sandboxdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var m Manager
+	if m, err = NewManager(types.Config{Sandbox: types.SandboxConfig{Backend: BackendLocal, DataDir: dir, UIDBase: testLocalUIDBase, RootDir: "/"}}); err != nil {
+		t.Fatal(err)
+	}
+	var s Sandbox
+	if s, err = m.FindOrCreate("test3", types.SandboxLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	codes := make(chan int, 1)
+	sigs := make(chan syscall.Signal, 1)
+	go func() {
+		code, sig, _ := s.ExecAttach(ExecAttachOptions{
+			Command: []string{"/bin/sh", "-c", "sleep 30"},
+			Stdin:   strings.NewReader(""),
+			Stdout:  ioutil.Discard,
+			Stderr:  ioutil.Discard,
+		})
+		codes <- code
+		sigs <- sig
+	}()
+	time.Sleep(time.Millisecond * 200)
+	var ss []State
+	if ss, err = m.List(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ss) != 1 || ss[0].Account != "test3" || !ss[0].IsRunning {
+		t.Fatalf("invalid states %v", ss)
+	}
+	if err = m.Stop("test3"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case code := <-codes:
+		if sig := <-sigs; code != 128+int(syscall.SIGKILL) || sig != syscall.SIGKILL {
+			t.Errorf("invalid exit code %d, signal %d", code, sig)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("sandbox not stopped")
+	}
+	if ss, err = m.List(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ss) != 1 || ss[0].IsRunning {
+		t.Errorf("invalid states %v", ss)
+	}
 }
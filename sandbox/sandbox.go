@@ -9,21 +9,20 @@
 package sandbox
 
 import (
-	"bytes"
-	"context"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"os"
-	"strings"
-	"sync"
+	"path/filepath"
 	"syscall"
-
-	dtypes "github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
 )
 
+// agentDirName directory for ssh agent sockets of all sandboxes, relative to Sandbox.DataDir
+const agentDirName = ".agent"
+
+// agentMountPoint directory for ssh agent sockets in sandbox, mounted read-only
+const agentMountPoint = "/run/bunker/agent"
+
 // Window pty window size
 type Window struct {
 	Width  uint
@@ -46,6 +45,7 @@ type ExecAttachOptions struct {
 	IsPty      bool
 	Term       string
 	WindowChan chan Window
+	SignalChan chan syscall.Signal
 }
 
 // Sandbox interface
@@ -55,172 +55,52 @@ type Sandbox interface {
 	GenerateSSHKey() error
 	GetSSHPublicKey() (string, error)
 	ExecScript(sc string) (string, string, error)
-	ExecAttach(opts ExecAttachOptions) error
-}
-
-type sandbox struct {
-	client *client.Client
-	name   string
-}
-
-func (s *sandbox) GetContainerName() string {
-	return s.name
-}
-
-func (s *sandbox) Start() error {
-	return s.client.ContainerStart(context.Background(), s.name, dtypes.ContainerStartOptions{})
+	ExecAttach(opts ExecAttachOptions) (int, syscall.Signal, error)
+	ListenAgent(id string) (net.Listener, string, error)
 }
 
-func (s *sandbox) GenerateSSHKey() (err error) {
-	_, _, err = s.ExecScript(scriptGenerateSSHKey)
-	return
-}
-
-func (s *sandbox) GetSSHPublicKey() (pkey string, err error) {
-	pkey, _, err = s.ExecScript(`cat /root/.ssh/id_rsa.pub`)
-	pkey = strings.TrimSpace(pkey)
-	return
-}
-
-func (s *sandbox) ExecScript(sc string) (stdout string, stderr string, err error) {
-	// create exec
-	var id dtypes.IDResponse
-	if id, err = s.client.ContainerExecCreate(
-		context.Background(),
-		s.name,
-		dtypes.ExecConfig{
-			AttachStdin:  true,
-			AttachStdout: true,
-			AttachStderr: true,
-			Cmd: []string{
-				"/bin/bash",
-			},
-		},
-	); err != nil {
+// ensureAgentDir create directory for ssh agent sockets of sandbox, owned by bunker and outside of home directory,
+// which is controlled by sandbox user, it is mounted read-only at agentMountPoint in sandbox
+func ensureAgentDir(dataDir string, name string) (dir string, err error) {
+	if err = os.MkdirAll(filepath.Join(dataDir, agentDirName), 0700); err != nil {
 		return
 	}
-	// exec attach
-	var hr dtypes.HijackedResponse
-	if hr, err = s.client.ContainerExecAttach(context.Background(), id.ID, dtypes.ExecConfig{}); err != nil {
+	dir = filepath.Join(dataDir, agentDirName, name)
+	if err = os.MkdirAll(dir, 0755); err != nil {
 		return
 	}
-	defer hr.Close()
-	wg := &sync.WaitGroup{}
-	// pipe stdin
-	wg.Add(1)
-	go func() {
-		sandboxPipeStdin(hr, bytes.NewReader([]byte(sc)), &err)
-		wg.Done()
-	}()
-	// pipe stdout/stderr
-	bout := &bytes.Buffer{}
-	berr := &bytes.Buffer{}
-	wg.Add(1)
-	go func() {
-		sandboxPipeStdoutStderr(hr, bout, berr, false, &err)
-		wg.Done()
-	}()
-	// wait
-	wg.Wait()
-
-	// output as string
-	stdout = string(bout.Bytes())
-	stderr = string(berr.Bytes())
-
+	err = os.Chmod(dir, 0755)
 	return
 }
 
-func (s *sandbox) ExecAttach(opts ExecAttachOptions) (err error) {
-	execCfg := dtypes.ExecConfig{
-		AttachStdin:  true,
-		AttachStdout: true,
-		AttachStderr: true,
-		Tty:          opts.IsPty,
-		Cmd:          opts.Command,
-		Env:          opts.Env,
-	}
-	// append env if TERM is set
-	if len(opts.Term) > 0 {
-		if execCfg.Env == nil {
-			execCfg.Env = make([]string, 0)
-		}
-		execCfg.Env = append(execCfg.Env, fmt.Sprintf("TERM=%s", opts.Term))
-	}
-	// use /bin/bash if no command specified
-	if len(execCfg.Cmd) == 0 {
-		execCfg.Cmd = []string{"/bin/bash"}
-	}
-	// create exec
-	var id dtypes.IDResponse
-	if id, err = s.client.ContainerExecCreate(context.Background(), s.name, execCfg); err != nil {
+// listenAgentSocket listen on agent socket in directory created by ensureAgentDir, only accessible by uid and gid, -1 for no change
+func listenAgentSocket(dir string, id string, uid, gid int) (l net.Listener, err error) {
+	// never follow a symlink or touch a directory writable by others, bunker runs as root
+	var fi os.FileInfo
+	if fi, err = os.Lstat(dir); err != nil {
 		return
 	}
-	// exec attach
-	var hr dtypes.HijackedResponse
-	if hr, err = s.client.ContainerExecAttach(context.Background(), id.ID, execCfg); err != nil {
+	if st, ok := fi.Sys().(*syscall.Stat_t); !ok || !fi.IsDir() || int(st.Uid) != os.Geteuid() || fi.Mode().Perm()&0022 != 0 {
+		err = fmt.Errorf("agent directory %s is not owned by bunker", dir)
 		return
 	}
-	// pipe window size
-	if opts.IsPty && opts.WindowChan != nil {
-		go sandboxPipeWindowSize(s.client, id.ID, opts.WindowChan)
-	}
-	// pipe stdin
-	go sandboxPipeStdin(hr, opts.Stdin, &err)
-	// pipe stdout/stderr
-	sandboxPipeStdoutStderr(hr, opts.Stdout, opts.Stderr, opts.IsPty, &err)
-	// close hr
-	hr.Close()
-	// inspect exec
-	var is dtypes.ContainerExecInspect
-	if is, err = s.client.ContainerExecInspect(context.Background(), id.ID); err != nil {
+	file := filepath.Join(dir, agentSocketName(id))
+	// remove stale socket
+	os.Remove(file)
+	if l, err = net.Listen("unix", file); err != nil {
 		return
 	}
-	// send SIGTERM to zombie process
-	if is.Running == true && is.Pid > 0 {
-		log.Println("Exec:", id.ID, "not terminated properly, sending SIGTERM")
-		var p *os.Process
-		if p, err = os.FindProcess(is.Pid); err != nil {
-			return
-		}
-		p.Signal(syscall.SIGTERM)
-	}
-	return
-}
-
-func sandboxPipeWindowSize(c *client.Client, id string, wchan chan Window) {
-	for {
-		w, ok := <-wchan
-		if !ok {
-			break
-		}
-		c.ContainerExecResize(context.Background(), id, dtypes.ResizeOptions{Height: w.Height, Width: w.Width})
-	}
-}
-
-func sandboxPipeStdin(hr dtypes.HijackedResponse, stdin io.Reader, errout *error) {
-	var err error
-	_, err = io.Copy(hr.Conn, stdin)
-	hr.CloseWrite()
-	// clear EOF
-	if err != nil && err != io.EOF {
-		*errout = err
-	}
-	return
-}
-
-func sandboxPipeStdoutStderr(hr dtypes.HijackedResponse, stdout, stderr io.Writer, isPty bool, errout *error) {
-	var err error
-	if isPty {
-		_, err = io.Copy(stdout, hr.Reader)
-	} else {
-		_, err = stdcopy.StdCopy(stdout, stderr, hr.Reader)
+	if err = os.Chmod(file, 0600); err != nil {
+		l.Close()
+		return
 	}
-	// clear EOF
-	if err != nil && err != io.EOF {
-		*errout = err
+	if err = os.Chown(file, uid, gid); err != nil {
+		l.Close()
+		return
 	}
 	return
 }
 
-func sandboxPipeSignal() {
+func agentSocketName(id string) string {
+	return fmt.Sprintf("agent.%s.sock", id)
 }
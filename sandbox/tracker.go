@@ -0,0 +1,65 @@
+/**
+ * sandbox/tracker.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package sandbox
+
+import (
+	"sync"
+	"time"
+)
+
+// Tracker tracks active connections and last activity of sandboxes
+type Tracker struct {
+	mutex *sync.Mutex
+	conns map[string]int
+	lasts map[string]time.Time
+	since time.Time
+}
+
+// NewTracker create a new tracker
+func NewTracker() *Tracker {
+	return &Tracker{
+		mutex: &sync.Mutex{},
+		conns: map[string]int{},
+		lasts: map[string]time.Time{},
+		since: time.Now(),
+	}
+}
+
+// Acquire mark a connection to sandbox of account started
+func (t *Tracker) Acquire(account string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.conns[account]++
+	t.lasts[account] = time.Now()
+}
+
+// Release mark a connection to sandbox of account ended
+func (t *Tracker) Release(account string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.conns[account] > 0 {
+		t.conns[account]--
+	}
+	if t.conns[account] == 0 {
+		delete(t.conns, account)
+	}
+	t.lasts[account] = time.Now()
+}
+
+// Activity returns active connections and last activity of sandbox of account, last activity defaults to creation time of tracker
+func (t *Tracker) Activity(account string) (conns int, last time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	conns = t.conns[account]
+	var ok bool
+	if last, ok = t.lasts[account]; !ok {
+		last = t.since
+	}
+	return
+}
@@ -14,11 +14,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"net"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/yankeguo/bunker/events"
 	"github.com/yankeguo/bunker/models"
 	"github.com/yankeguo/bunker/sandbox"
 	"github.com/yankeguo/bunker/types"
@@ -33,6 +36,8 @@ const (
 	sshdBunkerUserAccount   = "bunker-user-account"
 	sshdBunkerTargetUser    = "bunker-target-user"
 	sshdBunkerTargetAddress = "bunker-target-address"
+	sshdBunkerTargetServer  = "bunker-target-server"
+	sshdBunkerCertSerial    = "bunker-cert-serial"
 )
 
 var (
@@ -47,8 +52,16 @@ type SSHD struct {
 	sshServerConfig *ssh.ServerConfig
 	clientSigner    ssh.Signer
 	hostSigner      ssh.Signer
+	caPublicKey     ssh.PublicKey // public key of certificate authority, nil if disabled
 	listener        net.Listener
 	sandboxManager  sandbox.Manager
+	sandboxTracker  *sandbox.Tracker
+	liveHub         *utils.LiveHub
+	events          *events.Emitter
+	limiter         *utils.Limiter // failed logins by "ip:" and "account:", for temporary bans
+	authAttempts    sync.Map       // rejected attempts of connections in handshake, keyed by remote address
+	graceLock       sync.Mutex
+	grace           map[string]time.Time // last second factor verification, keyed by account and account@ip
 }
 
 // NewSSHD create a SSHD instance
@@ -58,62 +71,327 @@ func NewSSHD(config types.Config) *SSHD {
 
 func (s *SSHD) createHostKeyCallback(r models.Server) ssh.HostKeyCallback {
 	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-		return nil
+		return s.db.VerifyServerHostKey(r.ID, ssh.FingerprintSHA256(key))
+	}
+}
+
+// createClientConfig create client config for target server, master key is used if no credential matches,
+// direct is true if logged in as target user and no sudo is needed
+func (s *SSHD) createClientConfig(r models.Server, targetUser string) (ccfg *ssh.ClientConfig, direct bool, err error) {
+	ccfg = &ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: s.createHostKeyCallback(r),
+	}
+	c, ok := s.db.FindCredential(r)
+	if !ok {
+		ccfg.Auth = []ssh.AuthMethod{ssh.PublicKeys(s.clientSigner)}
+		return
+	}
+	direct = utils.ToBool(c.IsDirect)
+	ccfg.User = c.GetLoginUser(targetUser)
+	var k string
+	if k, err = utils.DecryptString(s.Config.Secret, c.PrivateKey); err != nil {
+		err = fmt.Errorf("failed to decrypt credential %s: %s", c.Name, err.Error())
+		return
+	}
+	if len(k) > 0 {
+		var signer ssh.Signer
+		if signer, err = ssh.ParsePrivateKey([]byte(k)); err != nil {
+			err = fmt.Errorf("invalid private key of credential %s: %s", c.Name, err.Error())
+			return
+		}
+		ccfg.Auth = append(ccfg.Auth, ssh.PublicKeys(signer))
+	}
+	var pw string
+	if pw, err = utils.DecryptString(s.Config.Secret, c.Password); err != nil {
+		err = fmt.Errorf("failed to decrypt credential %s: %s", c.Name, err.Error())
+		return
+	}
+	if len(pw) > 0 {
+		ccfg.Auth = append(ccfg.Auth, ssh.Password(pw), ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			as := make([]string, len(questions))
+			for i := range as {
+				as[i] = pw
+			}
+			return as, nil
+		}))
+	}
+	return
+}
+
+// dialTarget connect to target server through its jump hosts, closer closes target and all jump hosts
+func (s *SSHD) dialTarget(r models.Server, targetAddress string, targetUser string) (client *ssh.Client, direct bool, closer func(), err error) {
+	var chain []models.Server
+	if chain, err = s.db.FindProxyChain(r); err != nil {
+		return
+	}
+	r.Address = targetAddress
+	chain = append(chain, r)
+	clients := []*ssh.Client{}
+	closer = func() {
+		for i := len(clients) - 1; i >= 0; i-- {
+			clients[i].Close()
+		}
+	}
+	for i, h := range chain {
+		// jump hosts are logged in with credential login user, not target user
+		var hu string
+		if i == len(chain)-1 {
+			hu = targetUser
+		}
+		var ccfg *ssh.ClientConfig
+		if ccfg, direct, err = s.createClientConfig(h, hu); err != nil {
+			break
+		}
+		var conn net.Conn
+		if client == nil {
+			conn, err = net.Dial("tcp", h.Address)
+		} else {
+			conn, err = client.Dial("tcp", h.Address)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to dial %s: %s", h.Name, err.Error())
+			break
+		}
+		var c ssh.Conn
+		var chans <-chan ssh.NewChannel
+		var reqs <-chan *ssh.Request
+		if c, chans, reqs, err = ssh.NewClientConn(conn, h.Address, ccfg); err != nil {
+			conn.Close()
+			err = fmt.Errorf("failed to connect %s: %s", h.Name, err.Error())
+			break
+		}
+		client = ssh.NewClient(c, chans, reqs)
+		clients = append(clients, client)
+	}
+	if err != nil {
+		closer()
+		client = nil
+	}
+	return
+}
+
+// sshdRemoteIP ip of remote address
+func sshdRemoteIP(conn ssh.ConnMetadata) string {
+	return sshdAddrIP(conn.RemoteAddr())
+}
+
+// sshdAddrIP ip of a network address
+func sshdAddrIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// sshdAuthError rejected authentication, with details recorded as models.AuthFailure
+type sshdAuthError struct {
+	fingerprint string
+	account     string
+	targetUser  string
+	serverName  string
+	isDenied    bool
+	err         error
+}
+
+func (e *sshdAuthError) Error() string {
+	return e.err.Error()
+}
+
+// isGraced check whether second factor was verified within grace window
+func (s *SSHD) isGraced(key string) bool {
+	s.graceLock.Lock()
+	defer s.graceLock.Unlock()
+	t, ok := s.grace[key]
+	return ok && time.Now().Sub(t) < s.Config.TOTP.Grace()
+}
+
+// markGraced record second factor verification of account from ip
+func (s *SSHD) markGraced(account, ip string) {
+	s.graceLock.Lock()
+	defer s.graceLock.Unlock()
+	if s.grace == nil {
+		s.grace = map[string]time.Time{}
+	}
+	now := time.Now()
+	// clean expired entries
+	for k, t := range s.grace {
+		if now.Sub(t) >= s.Config.TOTP.Grace() {
+			delete(s.grace, k)
+		}
+	}
+	s.grace[account] = now
+	s.grace[account+"@"+ip] = now
+}
+
+// checkSecondFactor require keyboard-interactive totp after publickey if needed
+func (s *SSHD) checkSecondFactor(conn ssh.ConnMetadata, u models.User, fromSandbox bool, perms *ssh.Permissions) (*ssh.Permissions, error) {
+	ip := sshdRemoteIP(conn)
+	if !utils.ToBool(u.IsTOTPEnabled) {
+		if !u.IsTOTPRequired(s.Config) {
+			return perms, nil
+		}
+		// enforced but not enrolled, explain and reject
+		return nil, &ssh.PartialSuccessError{
+			Next: ssh.ServerAuthCallbacks{
+				KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+					client("", fmt.Sprintf("two-factor authentication is required, please enroll at %s/settings/totp", s.Config.Domain), nil, nil)
+					return nil, &sshdAuthError{account: u.Account, err: fmt.Errorf("two-factor authentication not enrolled")}
+				},
+			},
+		}
+	}
+	if fromSandbox {
+		// hops from sandbox are verified when connecting to sandbox
+		if conns, _ := s.sandboxTracker.Activity(u.Account); conns > 0 || s.isGraced(u.Account) {
+			return perms, nil
+		}
+	} else if s.isGraced(u.Account + "@" + ip) {
+		return perms, nil
+	}
+	return nil, &ssh.PartialSuccessError{
+		Next: ssh.ServerAuthCallbacks{
+			KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+				for i := 0; i < 3; i++ {
+					ans, err := client("", "", []string{"Verification code: "}, []bool{false})
+					if err != nil {
+						return nil, err
+					}
+					if len(ans) == 1 && s.db.VerifyUserTOTP(&u, ans[0]) {
+						s.markGraced(u.Account, ip)
+						return perms, nil
+					}
+				}
+				return nil, &sshdAuthError{account: u.Account, err: fmt.Errorf("invalid verification code")}
+			},
+		},
 	}
 }
 
 func (s *SSHD) createPublicKeyCallback() func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
 	return func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
-		var err error
-		// fetch target information
-		tu, th := utils.SSHDDecodeTargetServer(conn.User())
-		// find Key
-		k := models.Key{}
-		fp := ssh.FingerprintSHA256(key)
-		if err = s.db.First(&k, "fingerprint = ?", fp).Error; err != nil || k.ID == 0 {
-			return nil, fmt.Errorf("unknown key with fingerprint %s", fp)
-		}
-		// find User
-		u := models.User{}
-		if err = s.db.First(&u, k.UserID).Error; err != nil || u.ID == 0 || utils.ToBool(u.IsBlocked) {
-			return nil, fmt.Errorf("unknown user or blocked user")
-		}
-		s.db.Touch(&k, &u)
-		// check connection source
-		if utils.CheckSSHLocalIP(conn, s.Config.Sandbox.HostIP) {
-			// connection from sandbox
-			if len(tu) == 0 || len(th) == 0 || !utils.ToBool(k.IsSandbox) {
-				return nil, fmt.Errorf("invalid target or invalid key")
-			}
-			// find Server
-			r := models.Server{}
-			if err = s.db.First(&r, "name = ?", th).Error; err != nil || r.ID == 0 {
-				return nil, fmt.Errorf("target host not found with name \"%s\"", th)
-			}
-			// check Grant
-			if err = s.db.CheckGrant(u, r, tu); err != nil {
-				return nil, fmt.Errorf("no permission to connect %s@%s", tu, th)
+		ae := &sshdAuthError{fingerprint: ssh.FingerprintSHA256(key)}
+		if c, ok := key.(*ssh.Certificate); ok {
+			ae.fingerprint = ssh.FingerprintSHA256(c.Key)
+		}
+		perms, err := s.checkPublicKey(conn, key, ae)
+		if err != nil {
+			if _, ok := err.(*ssh.PartialSuccessError); !ok {
+				ae.err = err
+				return nil, ae
 			}
-			s.db.Touch(&r)
-			return &ssh.Permissions{
-				Extensions: map[string]string{
-					sshdBunkerUserAccount:   u.Account,
-					sshdBunkerTargetUser:    tu,
-					sshdBunkerTargetAddress: r.Address,
-				},
-			}, nil
 		}
-		// connection from public
-		if utils.ToBool(k.IsSandbox) {
-			return nil, fmt.Errorf("shall never use sandbox key to connect sandbox")
+		return perms, err
+	}
+}
+
+// checkPublicKey check public key or certificate, details of rejection are filled into ae
+func (s *SSHD) checkPublicKey(conn ssh.ConnMetadata, key ssh.PublicKey, ae *sshdAuthError) (*ssh.Permissions, error) {
+	var err error
+	// certificate issued by bunker
+	if c, ok := key.(*ssh.Certificate); ok {
+		return s.checkCertificate(conn, c, ae)
+	}
+	// fetch target information
+	tu, th := utils.SSHDDecodeTargetServer(conn.User())
+	// find Key
+	k := models.Key{}
+	fp := ssh.FingerprintSHA256(key)
+	if err = s.db.First(&k, "fingerprint = ?", fp).Error; err != nil || k.ID == 0 {
+		return nil, fmt.Errorf("unknown key with fingerprint %s", fp)
+	}
+	// find User
+	u := models.User{}
+	if err = s.db.First(&u, k.UserID).Error; err != nil || u.ID == 0 || utils.ToBool(u.IsBlocked) {
+		return nil, fmt.Errorf("unknown user or blocked user")
+	}
+	ae.account = u.Account
+	if s.limiter.IsBanned(sshdLimiterAccount(u.Account)) {
+		return nil, fmt.Errorf("too many failed logins, try again later")
+	}
+	s.db.Touch(&k, &u)
+	// check connection source
+	if utils.CheckSSHLocalIP(conn, s.Config.Sandbox.HostIP) {
+		// connection from sandbox
+		if len(tu) == 0 || len(th) == 0 || !utils.ToBool(k.IsSandbox) {
+			return nil, fmt.Errorf("invalid target or invalid key")
+		}
+		ae.targetUser, ae.serverName = tu, th
+		// find Server
+		r := models.Server{}
+		if err = s.db.First(&r, "name = ?", th).Error; err != nil || r.ID == 0 {
+			return nil, fmt.Errorf("target host not found with name \"%s\"", th)
 		}
-		return &ssh.Permissions{
+		// check Grant
+		if err = s.db.CheckGrant(u, r, tu); err != nil {
+			ae.isDenied = true
+			return nil, fmt.Errorf("no permission to connect %s@%s", tu, th)
+		}
+		s.db.Touch(&r)
+		return s.checkSecondFactor(conn, u, true, &ssh.Permissions{
 			Extensions: map[string]string{
-				sshdBunkerUserAccount: u.Account,
-				sshdBunkerSandboxMode: "YES",
+				sshdBunkerUserAccount:   u.Account,
+				sshdBunkerTargetUser:    tu,
+				sshdBunkerTargetAddress: r.Address,
+				sshdBunkerTargetServer:  r.Name,
 			},
-		}, nil
+		})
+	}
+	// connection from public
+	if utils.ToBool(k.IsSandbox) {
+		return nil, fmt.Errorf("shall never use sandbox key to connect sandbox")
+	}
+	if s.Config.CA.Enable && s.Config.CA.RequireCert {
+		return nil, fmt.Errorf("only certificates are accepted, please sign in to get one")
+	}
+	return s.checkSecondFactor(conn, u, false, sandboxPermissions(u))
+}
+
+// sandboxPermissions permissions of public connection into sandbox
+func sandboxPermissions(u models.User) *ssh.Permissions {
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			sshdBunkerUserAccount: u.Account,
+			sshdBunkerSandboxMode: "YES",
+		},
+	}
+}
+
+// checkCertificate check user certificate issued by bunker, only accepted from public
+func (s *SSHD) checkCertificate(conn ssh.ConnMetadata, c *ssh.Certificate, ae *sshdAuthError) (*ssh.Permissions, error) {
+	var err error
+	if s.caPublicKey == nil {
+		return nil, fmt.Errorf("certificate authority is not enabled")
+	}
+	if utils.CheckSSHLocalIP(conn, s.Config.Sandbox.HostIP) {
+		return nil, fmt.Errorf("certificate is not accepted from sandbox")
+	}
+	// find Certificate by serial
+	mc := models.Certificate{}
+	if err = s.db.First(&mc, c.Serial).Error; err != nil || mc.ID == 0 {
+		return nil, fmt.Errorf("unknown certificate serial %d", c.Serial)
+	}
+	if err = utils.CheckUserCertificate(s.caPublicKey, c, mc.UserAccount, func(c *ssh.Certificate) bool {
+		return s.db.IsCertificateRevoked(c.Serial)
+	}); err != nil {
+		return nil, err
+	}
+	// find User
+	u := models.User{}
+	if err = s.db.First(&u, mc.UserID).Error; err != nil || u.ID == 0 || utils.ToBool(u.IsBlocked) {
+		return nil, fmt.Errorf("unknown user or blocked user")
+	}
+	ae.account = u.Account
+	if s.limiter.IsBanned(sshdLimiterAccount(u.Account)) {
+		return nil, fmt.Errorf("too many failed logins, try again later")
 	}
+	s.db.Touch(&mc, &u)
+	log.Printf("SSHD: user %s authenticated with certificate serial %d", u.Account, c.Serial)
+	// certificates are only issued after second factor
+	perms := sandboxPermissions(u)
+	perms.Extensions[sshdBunkerCertSerial] = fmt.Sprintf("%d", c.Serial)
+	return perms, nil
 }
 
 // ListenAndServe invoke internal sshd.Server#ListenAndServe, sshd.ErrServerClosed will be muted
@@ -124,6 +402,12 @@ func (s *SSHD) ListenAndServe() (err error) {
 			return
 		}
 	}
+	if s.sandboxTracker == nil {
+		s.sandboxTracker = sandbox.NewTracker()
+	}
+	if s.liveHub == nil {
+		s.liveHub = utils.NewLiveHub()
+	}
 	if s.clientSigner == nil {
 		if k, err = ioutil.ReadFile(s.Config.SSH.PrivateKey); err != nil {
 			return
@@ -145,9 +429,29 @@ func (s *SSHD) ListenAndServe() (err error) {
 			return
 		}
 	}
+	if s.events == nil {
+		if s.events, err = events.NewEmitter(s.Config.Events); err != nil {
+			return
+		}
+		s.db.Events = s.events
+	}
+	if s.caPublicKey == nil && s.Config.CA.Enable {
+		var ca ssh.Signer
+		if k, err = ioutil.ReadFile(s.Config.CA.PrivateKey); err != nil {
+			return
+		}
+		if ca, err = ssh.ParsePrivateKey(k); err != nil {
+			return
+		}
+		s.caPublicKey = ca.PublicKey()
+	}
+	if s.limiter == nil {
+		s.limiter = utils.NewLimiter(s.Config.SSHD.BanThreshold(), s.Config.SSHD.BanWindow(), s.Config.SSHD.BanTime())
+	}
 	if s.sshServerConfig == nil {
 		s.sshServerConfig = &ssh.ServerConfig{
 			PublicKeyCallback: s.createPublicKeyCallback(),
+			AuthLogCallback:   s.logAuthAttempt,
 		}
 		s.sshServerConfig.AddHostKey(s.hostSigner)
 	}
@@ -205,40 +509,81 @@ func (s *SSHD) updateSandboxSSHConfig(sb sandbox.Sandbox, account string) (err e
 
 func (s *SSHD) handleRawConn(c net.Conn) {
 	var err error
+	// reject banned ip, hops from sandbox share the same ip and are limited by account only
+	fromSandbox := utils.CheckLocalIP(c.LocalAddr(), s.Config.Sandbox.HostIP)
+	if !fromSandbox && s.limiter.IsBanned(sshdLimiterIP(sshdAddrIP(c.RemoteAddr()))) {
+		c.Close()
+		return
+	}
 	// upgrade connection
 	var sconn *ssh.ServerConn
 	var cchan <-chan ssh.NewChannel
 	var rchan <-chan *ssh.Request
-	if sconn, cchan, rchan, err = ssh.NewServerConn(c, s.sshServerConfig); err != nil {
+	sconn, cchan, rchan, err = ssh.NewServerConn(c, s.sshServerConfig)
+	if v, ok := s.authAttempts.Load(c.RemoteAddr().String()); ok {
+		s.authAttempts.Delete(c.RemoteAddr().String())
+		if err != nil {
+			s.reportAuthFailures(v.([]models.AuthFailure), fromSandbox)
+		}
+	}
+	if err != nil {
 		return
 	}
 	defer sconn.Close()
-	// discard global requests
-	go ssh.DiscardRequests(rchan)
 	// extract parameters
 	var userAccount = sconn.Permissions.Extensions[sshdBunkerUserAccount]
 	var targetUser = sconn.Permissions.Extensions[sshdBunkerTargetUser]
 	var sandboxMode = sconn.Permissions.Extensions[sshdBunkerSandboxMode]
 	var targetAddress = sconn.Permissions.Extensions[sshdBunkerTargetAddress]
+	var targetServer = sconn.Permissions.Extensions[sshdBunkerTargetServer]
+	var certSerial, _ = strconv.ParseUint(sconn.Permissions.Extensions[sshdBunkerCertSerial], 10, 64)
+	s.emitLogin(sconn, userAccount, targetUser, targetServer, certSerial)
 	// $SANDBOX SUPPORT$
 	if len(sandboxMode) > 0 {
+		// discard global requests
+		go ssh.DiscardRequests(rchan)
+		// load user
+		u := models.User{}
+		if err = s.db.First(&u, "account = ?", userAccount).Error; err != nil {
+			return
+		}
 		// ensure sandbox
 		var sb sandbox.Sandbox
-		if sb, err = s.sandboxManager.FindOrCreate(userAccount); err != nil {
+		if sb, err = s.sandboxManager.FindOrCreate(userAccount, u.SandboxLimits(s.Config)); err != nil {
 			return
 		}
+		// track connection for idle shutdown
+		s.sandboxTracker.Acquire(userAccount)
+		defer s.sandboxTracker.Release(userAccount)
 		// update database from sandbox public key, ignore error
 		s.updateSandboxPublicKey(sb, userAccount)
 		// update sandbox .ssh/config
 		s.updateSandboxSSHConfig(sb, userAccount)
+		// check agent forwarding permission
+		isAgentAllowed := utils.ToBool(u.IsAgentAllowed)
+		// target of "direct-tcpip", dialed on first use
+		var tunnel *sshdTunnel
 		// range channels
 		wg := &sync.WaitGroup{}
 		for nchn := range cchan {
 			// shadow var err error
 			var err error
-			// 'session' only
-			if nchn.ChannelType() != "session" {
-				nchn.Reject(ssh.UnknownChannelType, "only channel type \"session\" is allowed")
+			// 'session' only, 'direct-tcpip' is forwarded via target in ssh user if granted
+			switch nchn.ChannelType() {
+			case "session":
+			case "direct-tcpip":
+				if tunnel == nil {
+					tunnel = s.dialTunnel(sconn, u)
+					defer tunnel.Close()
+				}
+				if tunnel.err != nil {
+					nchn.Reject(ssh.Prohibited, tunnel.err.Error())
+					continue
+				}
+				s.handleDirectTCPIP(wg, nchn, sconn, tunnel.client, u, tunnel.server, tunnel.targetUser)
+				continue
+			default:
+				nchn.Reject(ssh.UnknownChannelType, "only channel type \"session\" and \"direct-tcpip\" are allowed")
 				continue
 			}
 			// accept channel
@@ -249,51 +594,84 @@ func (s *SSHD) handleRawConn(c net.Conn) {
 			}
 			// create a session
 			var sess *models.Session
-			if sess, err = s.db.CreateSession(userAccount); err != nil {
+			if sess, err = s.db.CreateSession(userAccount, "", ""); err != nil {
 				schn.Close()
 				continue
 			}
+			if certSerial > 0 {
+				s.db.Model(sess).UpdateColumn("certificate_id", certSerial)
+			}
+			s.emitSession(events.TypeSessionStart, sess, sconn)
 			// forward
-			utils.NewSandboxForwarder(
+			f := utils.NewSandboxForwarder(
 				sb,
 				schn,
 				sreq,
-				createReplayFileWriter(filepath.Join(s.Config.SSHD.ReplayDir, sess.ReplayFile)),
-			).SetCommandCallback(func(cmd string) {
+				s.createLiveReplayWriter(sess, sconn, schn),
+			)
+			if isAgentAllowed {
+				f.SetAgentForwarding(sconn, fmt.Sprintf("%d", sess.ID))
+			}
+			f.SetCommandCallback(func(cmd string) {
 				s.db.Model(sess).Update(map[string]interface{}{
 					"command": cmd,
 				})
+			}).SetAgentCallback(func() {
+				s.db.Model(sess).Update(map[string]interface{}{
+					"is_agent_used": utils.True,
+				})
+			}).SetExitCallback(func(code int, sig string) {
+				s.db.Model(sess).Update(map[string]interface{}{
+					"exit_code":   code,
+					"exit_signal": sig,
+				})
 			}).SetDoneCallback(func(a bool) {
+				s.liveHub.Remove(sess.ID)
 				s.db.Model(sess).Update(map[string]interface{}{
 					"is_recorded": utils.ToInt(a),
 					"ended_at":    time.Now(),
 				})
+				s.emitSession(events.TypeSessionEnd, sess, sconn)
 			}).Start(wg)
 		}
 		wg.Wait()
 		return
 	}
-	// build client
-	var ccfg = &ssh.ClientConfig{
-		User: "root",
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(s.clientSigner),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	// find user and target server
+	var u = models.User{}
+	if err = s.db.First(&u, "account = ?", userAccount).Error; err != nil || u.ID == 0 {
+		return
+	}
+	var r = models.Server{}
+	if err = s.db.First(&r, "name = ?", targetServer).Error; err != nil || r.ID == 0 {
+		return
 	}
+	// build client, through jump hosts if any
 	var client *ssh.Client
-	if client, err = ssh.Dial("tcp", targetAddress, ccfg); err != nil {
+	var direct bool
+	var closeClient func()
+	if client, direct, closeClient, err = s.dialTarget(r, targetAddress, targetUser); err != nil {
+		log.Println("SSHD:", err)
 		return
 	}
-	defer client.Close()
+	defer closeClient()
+	// handle global requests, "tcpip-forward" is allowed only if granted
+	go s.handleTargetRequests(rchan, client, u, r)
+	// "forwarded-tcpip" channels are opened by target only after a granted "tcpip-forward"
+	go s.handleForwardedTCPIP(client.HandleChannelOpen("forwarded-tcpip"), sconn, userAccount, targetUser, targetServer)
 	// bridge channels
 	wg := &sync.WaitGroup{}
 	for nchn := range cchan {
 		// shadow var err error
 		var err error
-		// filter-out non 'session' channel
-		if nchn.ChannelType() != "session" {
-			nchn.Reject(ssh.UnknownChannelType, "only channel type \"session\" is allowed")
+		// filter-out non 'session' channel, 'direct-tcpip' is allowed only if granted
+		switch nchn.ChannelType() {
+		case "session":
+		case "direct-tcpip":
+			s.handleDirectTCPIP(wg, nchn, sconn, client, u, r, targetUser)
+			continue
+		default:
+			nchn.Reject(ssh.UnknownChannelType, "only channel type \"session\" and \"direct-tcpip\" are allowed")
 			continue
 		}
 		// bridge channel
@@ -313,6 +691,15 @@ func (s *SSHD) handleRawConn(c net.Conn) {
 			continue
 		}
 
+		// create a session
+		var sess *models.Session
+		if sess, err = s.db.CreateSession(userAccount, targetUser, targetServer); err != nil {
+			schn.Close()
+			tchn.Close()
+			continue
+		}
+		s.emitSession(events.TypeSessionStart, sess, sconn)
+
 		// forward ssh channel
 		utils.NewSSHForwarder(
 			schn,
@@ -320,11 +707,336 @@ func (s *SSHD) handleRawConn(c net.Conn) {
 			tchn,
 			treq,
 			targetUser,
-		).Start(wg)
+			s.createLiveReplayWriter(sess, sconn, schn),
+		).SetDirect(direct).SetCommandCallback(func(cmd string) {
+			s.db.Model(sess).Update(map[string]interface{}{
+				"command": cmd,
+			})
+		}).SetSFTPCallback(func(e utils.SFTPEvent) {
+			t := models.FileTransfer{
+				SessionID:   sess.ID,
+				UserAccount: userAccount,
+				TargetUser:  targetUser,
+				ServerName:  targetServer,
+				Operation:   e.Operation,
+				Path:        e.Path,
+				NewPath:     e.NewPath,
+				Bytes:       e.Bytes,
+			}
+			if e.Error != nil {
+				t.Error = e.Error.Error()
+			}
+			s.db.Create(&t)
+		}).SetExitCallback(func(code int, sig string) {
+			s.db.Model(sess).Update(map[string]interface{}{
+				"exit_code":   code,
+				"exit_signal": sig,
+			})
+		}).SetDoneCallback(func(a bool) {
+			s.liveHub.Remove(sess.ID)
+			s.db.Model(sess).Update(map[string]interface{}{
+				"is_recorded": utils.ToInt(a),
+				"ended_at":    time.Now(),
+			})
+			s.emitSession(events.TypeSessionEnd, sess, sconn)
+		}).Start(wg)
+	}
+	wg.Wait()
+}
+
+// sshdTunnel target of "direct-tcpip" from public connection
+type sshdTunnel struct {
+	client     *ssh.Client
+	server     models.Server
+	targetUser string
+	closer     func()
+	err        error // reason to reject all "direct-tcpip"
+}
+
+// Close close client to target
+func (t *sshdTunnel) Close() {
+	if t.closer != nil {
+		t.closer()
+	}
+}
+
+// dialTunnel dial target for "direct-tcpip" from public connection, target is given as ssh user,
+// e.g. "ssh -N -L 5432:db:5432 -l root@server bunker", login to target must be granted
+func (s *SSHD) dialTunnel(sconn *ssh.ServerConn, u models.User) (t *sshdTunnel) {
+	t = &sshdTunnel{}
+	tu, th := utils.SSHDDecodeTargetServer(sconn.User())
+	if len(tu) == 0 || len(th) == 0 {
+		t.err = errors.New("port forwarding requires target as ssh user, e.g. root@server")
+		return
+	}
+	if s.db.First(&t.server, "name = ?", th).Error != nil || t.server.ID == 0 {
+		t.err = fmt.Errorf("target host not found with name \"%s\"", th)
+		return
+	}
+	if s.db.CheckGrant(u, t.server, tu) != nil {
+		t.err = fmt.Errorf("no permission to connect %s@%s", tu, th)
+		return
+	}
+	t.targetUser = tu
+	var err error
+	if t.client, _, t.closer, err = s.dialTarget(t.server, t.server.Address, tu); err != nil {
+		log.Println("SSHD:", err)
+		t.err = fmt.Errorf("failed to connect %s@%s", tu, th)
+	}
+	return
+}
+
+func (s *SSHD) handleTargetRequests(rchan <-chan *ssh.Request, client *ssh.Client, u models.User, r models.Server) {
+	for req := range rchan {
+		switch req.Type {
+		case "tcpip-forward", "cancel-tcpip-forward":
+			var pl utils.TCPIPForwardPayload
+			if err := ssh.Unmarshal(req.Payload, &pl); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			if req.Type == "tcpip-forward" {
+				if err := s.db.CheckForwardGrant(u, r, models.GrantTypeRemoteForward, pl.BindAddr, pl.BindPort); err != nil {
+					log.Printf("SSHD: %s is not permitted to listen on %s via %s", u.Account, utils.JoinHostPort(pl.BindAddr, pl.BindPort), r.Name)
+					req.Reply(false, nil)
+					continue
+				}
+			}
+			ok, payload, _ := client.SendRequest(req.Type, req.WantReply, req.Payload)
+			req.Reply(ok, payload)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *SSHD) handleDirectTCPIP(wg *sync.WaitGroup, nchn ssh.NewChannel, sconn *ssh.ServerConn, client *ssh.Client, u models.User, r models.Server, targetUser string) {
+	var err error
+	var pl utils.DirectTCPIPPayload
+	if err = ssh.Unmarshal(nchn.ExtraData(), &pl); err != nil {
+		nchn.Reject(ssh.ConnectionFailed, "invalid \"direct-tcpip\" payload")
+		return
+	}
+	addr := utils.JoinHostPort(pl.Host, pl.Port)
+	// check grant
+	if err = s.db.CheckForwardGrant(u, r, models.GrantTypeForward, pl.Host, pl.Port); err != nil {
+		nchn.Reject(ssh.Prohibited, fmt.Sprintf("no permission to forward to %s via %s", addr, r.Name))
+		return
+	}
+	// bridge channel
+	var schn ssh.Channel
+	var sreq <-chan *ssh.Request
+	var tchn ssh.Channel
+	var treq <-chan *ssh.Request
+	if tchn, treq, err = client.OpenChannel(nchn.ChannelType(), nchn.ExtraData()); err != nil {
+		if jerr, ok := err.(*ssh.OpenChannelError); ok {
+			nchn.Reject(jerr.Reason, jerr.Message)
+		} else {
+			nchn.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	if schn, sreq, err = nchn.Accept(); err != nil {
+		tchn.Close()
+		return
+	}
+	// create a session
+	var sess *models.Session
+	if sess, err = s.db.CreateTypedSession(models.SessionTypeForward, u.Account, targetUser, r.Name, addr); err != nil {
+		schn.Close()
+		tchn.Close()
+		return
+	}
+	s.startTunnelForwarder(wg, sess, sconn, schn, sreq, tchn, treq)
+}
+
+func (s *SSHD) handleForwardedTCPIP(nchans <-chan ssh.NewChannel, sconn *ssh.ServerConn, userAccount, targetUser, targetServer string) {
+	wg := &sync.WaitGroup{}
+	for nchn := range nchans {
+		var err error
+		var pl utils.ForwardedTCPIPPayload
+		if err = ssh.Unmarshal(nchn.ExtraData(), &pl); err != nil {
+			nchn.Reject(ssh.ConnectionFailed, "invalid \"forwarded-tcpip\" payload")
+			continue
+		}
+		// bridge channel, source is the user side
+		var schn ssh.Channel
+		var sreq <-chan *ssh.Request
+		var tchn ssh.Channel
+		var treq <-chan *ssh.Request
+		if schn, sreq, err = sconn.OpenChannel(nchn.ChannelType(), nchn.ExtraData()); err != nil {
+			nchn.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		if tchn, treq, err = nchn.Accept(); err != nil {
+			schn.Close()
+			continue
+		}
+		// create a session
+		var sess *models.Session
+		if sess, err = s.db.CreateTypedSession(models.SessionTypeRemoteForward, userAccount, targetUser, targetServer, utils.JoinHostPort(pl.Addr, pl.Port)); err != nil {
+			schn.Close()
+			tchn.Close()
+			continue
+		}
+		s.startTunnelForwarder(wg, sess, sconn, schn, sreq, tchn, treq)
 	}
 	wg.Wait()
 }
 
+func (s *SSHD) startTunnelForwarder(wg *sync.WaitGroup, sess *models.Session, sconn *ssh.ServerConn, schn ssh.Channel, sreq <-chan *ssh.Request, tchn ssh.Channel, treq <-chan *ssh.Request) {
+	s.emitSession(events.TypeSessionStart, sess, sconn)
+	utils.NewTunnelForwarder(
+		schn,
+		sreq,
+		tchn,
+		treq,
+	).SetDoneCallback(func(in int64, out int64) {
+		s.db.Model(sess).Update(map[string]interface{}{
+			"bytes_in":  in,
+			"bytes_out": out,
+			"ended_at":  time.Now(),
+		})
+		s.emitSession(events.TypeSessionEnd, sess, sconn)
+	}).Start(wg)
+}
+
+// sshdLimiterIP limiter key of source ip
+func sshdLimiterIP(ip string) string {
+	return "ip:" + ip
+}
+
+// sshdLimiterAccount limiter key of user account
+func sshdLimiterAccount(account string) string {
+	return "account:" + account
+}
+
+// logAuthAttempt collect rejected authentication attempts of connection, they are reported only if handshake fails,
+// keys offered before the right one are not failures
+func (s *SSHD) logAuthAttempt(conn ssh.ConnMetadata, method string, err error) {
+	if err == nil || method == "none" {
+		return
+	}
+	if _, ok := err.(*ssh.PartialSuccessError); ok {
+		return
+	}
+	f := models.AuthFailure{
+		SourceIP:  sshdRemoteIP(conn),
+		LoginUser: conn.User(),
+		Method:    method,
+		Reason:    err.Error(),
+	}
+	if ae, ok := err.(*sshdAuthError); ok {
+		f.Fingerprint = ae.fingerprint
+		f.UserAccount = ae.account
+		f.TargetUser = ae.targetUser
+		f.ServerName = ae.serverName
+		f.IsDenied = utils.ToInt(ae.isDenied)
+	}
+	key := conn.RemoteAddr().String()
+	var fs []models.AuthFailure
+	if v, ok := s.authAttempts.Load(key); ok {
+		fs = v.([]models.AuthFailure)
+	}
+	// cached result is reported again if client offers the same key twice
+	for _, o := range fs {
+		if o.Method == f.Method && o.Fingerprint == f.Fingerprint && o.Reason == f.Reason {
+			return
+		}
+	}
+	s.authAttempts.Store(key, append(fs, f))
+}
+
+// reportAuthFailures persist rejected attempts of a connection failed to login, export a event and count towards temporary bans
+func (s *SSHD) reportAuthFailures(fs []models.AuthFailure, fromSandbox bool) {
+	accounts := map[string]bool{}
+	for _, f := range fs {
+		if err := s.db.Create(&f).Error; err != nil {
+			log.Println("SSHD:", err)
+		}
+		// missing grants are not counted against account
+		if len(f.UserAccount) > 0 && !utils.ToBool(f.IsDenied) {
+			accounts[f.UserAccount] = true
+		}
+	}
+	last := fs[len(fs)-1]
+	user := last.LoginUser
+	if len(last.UserAccount) > 0 {
+		user = last.UserAccount
+	}
+	s.events.Emit(events.Event{
+		Type:       events.TypeAuthFailure,
+		User:       user,
+		SourceIP:   last.SourceIP,
+		Server:     last.ServerName,
+		TargetUser: last.TargetUser,
+		Message:    fmt.Sprintf("ssh authentication failed for %s from %s", user, last.SourceIP),
+		Fields: map[string]string{
+			"service":     "ssh",
+			"method":      last.Method,
+			"loginUser":   last.LoginUser,
+			"fingerprint": last.Fingerprint,
+			"reason":      last.Reason,
+			"attempts":    strconv.Itoa(len(fs)),
+		},
+	})
+	if !fromSandbox && s.limiter.Fail(sshdLimiterIP(last.SourceIP)) {
+		log.Printf("SSHD: %s is banned for too many failed logins", last.SourceIP)
+	}
+	for account := range accounts {
+		if s.limiter.Fail(sshdLimiterAccount(account)) {
+			log.Printf("SSHD: account %s is banned for too many failed logins", account)
+		}
+	}
+}
+
+// emitLogin export login event of a established connection
+func (s *SSHD) emitLogin(sconn *ssh.ServerConn, userAccount, targetUser, targetServer string, certSerial uint64) {
+	method := "publickey"
+	if certSerial > 0 {
+		method = "certificate"
+	}
+	target := "sandbox"
+	if len(targetServer) > 0 {
+		target = targetUser + "@" + targetServer
+	}
+	ip := sshdRemoteIP(sconn)
+	s.events.Emit(events.Event{
+		Type:       events.TypeLogin,
+		User:       userAccount,
+		SourceIP:   ip,
+		Server:     targetServer,
+		TargetUser: targetUser,
+		Message:    fmt.Sprintf("ssh login of %s from %s to %s", userAccount, ip, target),
+		Fields:     map[string]string{"service": "ssh", "method": method},
+	})
+}
+
+// emitSession export session start or end event
+func (s *SSHD) emitSession(typ string, sess *models.Session, sconn *ssh.ServerConn) {
+	target := "sandbox"
+	if !sess.IsSandbox() {
+		target = sess.TargetUser + "@" + sess.ServerName
+	}
+	verb := "started"
+	if typ == events.TypeSessionEnd {
+		verb = "ended"
+	}
+	e := events.Event{
+		Type:       typ,
+		User:       sess.UserAccount,
+		SourceIP:   sshdRemoteIP(sconn),
+		Server:     sess.ServerName,
+		TargetUser: sess.TargetUser,
+		SessionID:  sess.ID,
+		Message:    fmt.Sprintf("session %d of %s %s, %s", sess.ID, sess.UserAccount, verb, target),
+	}
+	if sess.IsForward() {
+		e.Fields = map[string]string{"forwardAddress": sess.ForwardAddress}
+	}
+	s.events.Emit(e)
+}
+
 // Shutdown shutdown the sshd instance
 func (s *SSHD) Shutdown() (err error) {
 	if s.listener != nil {
@@ -333,6 +1045,18 @@ func (s *SSHD) Shutdown() (err error) {
 	return
 }
 
+// createLiveReplayWriter create replay writer of session, registered for live monitoring,
+// terminating the live session closes the whole connection
+func (s *SSHD) createLiveReplayWriter(sess *models.Session, sconn *ssh.ServerConn, schn ssh.Channel) rec.Writer {
+	l := s.liveHub.Add(sess.ID, func() {
+		schn.Close()
+		sconn.Close()
+	}, func(buf []byte) {
+		schn.Write(buf)
+	})
+	return l.Wrap(createReplayFileWriter(filepath.Join(s.Config.SSHD.ReplayDir, sess.ReplayFile)))
+}
+
 func createReplayFileWriter(filename string) rec.Writer {
 	return rec.NewWriter(gzip.NewWriter(ioext.NewLazyFileWriter(filename)), rec.WriterOption{
 		SqueezeFrame: 150,
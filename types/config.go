@@ -8,6 +8,8 @@
 
 package types
 
+import "time"
+
 // Config config struct for Bunker, mapped to config.yaml
 type Config struct {
 	Env     string        `toml:"env"`     // application environment
@@ -20,6 +22,16 @@ type Config struct {
 	SSH     SSHConfig     `toml:"ssh"`     // ssh config
 	Sandbox SandboxConfig `toml:"sandbox"` // sandbox config
 	Consul  ConsulConfig  `toml:"consul"`  // consul config
+	TOTP    TOTPConfig    `toml:"totp"`    // two-factor authentication config
+	Login   LoginConfig   `toml:"login"`   // web login brute-force protection config
+	LDAP    LDAPConfig    `toml:"ldap"`    // ldap authentication and user sync config
+	OIDC    OIDCConfig    `toml:"oidc"`    // openid connect single sign-on config
+	CA      CAConfig      `toml:"ca"`      // ssh certificate authority config
+
+	Transcript TranscriptConfig `toml:"transcript"` // session transcript full-text index config
+	Events     EventsConfig     `toml:"events"`     // security event export config
+
+	GrantRequest GrantRequestConfig `toml:"grant_request"` // grant request and approval workflow config
 }
 
 // DBConfig config for DB
@@ -40,6 +52,37 @@ type SSHDConfig struct {
 	Port       int    `toml:"port"`        // port for sshd
 	PrivateKey string `toml:"private_key"` // private key file, for sshd host key
 	ReplayDir  string `toml:"replay_dir"`  // dir for replayfiles
+
+	MaxFailures   int `toml:"max_failures"`   // failed logins within failure window before a temporary ban, counted per ip and per account, defaults to 10, -1 to disable
+	FailureWindow int `toml:"failure_window"` // minutes, defaults to 10
+	BanDuration   int `toml:"ban_duration"`   // minutes, defaults to 30
+}
+
+// BanThreshold failed logins before a temporary ban, 0 if disabled
+func (c SSHDConfig) BanThreshold() int {
+	if c.MaxFailures < 0 {
+		return 0
+	}
+	if c.MaxFailures == 0 {
+		return 10
+	}
+	return c.MaxFailures
+}
+
+// BanWindow window failed logins are counted in
+func (c SSHDConfig) BanWindow() time.Duration {
+	if c.FailureWindow <= 0 {
+		return time.Minute * 10
+	}
+	return time.Minute * time.Duration(c.FailureWindow)
+}
+
+// BanTime duration of temporary ban
+func (c SSHDConfig) BanTime() time.Duration {
+	if c.BanDuration <= 0 {
+		return time.Minute * 30
+	}
+	return time.Minute * time.Duration(c.BanDuration)
 }
 
 // SSHConfig config for ssh
@@ -49,12 +92,291 @@ type SSHConfig struct {
 
 // SandboxConfig sandbox config
 type SandboxConfig struct {
-	Image   string `toml:"image"`
-	DataDir string `toml:"data_dir"`
-	HostIP  string `toml:"host_ip"`
+	Backend     string `toml:"backend"`      // sandbox backend, "docker" or "local", defaults to "docker"
+	Image       string `toml:"image"`        // docker image, for "docker" backend
+	DataDir     string `toml:"data_dir"`     // dir for sandbox home directories
+	HostIP      string `toml:"host_ip"`      // ip of bunker sshd seen from sandbox, connections to it are treated as from sandbox
+	UIDBase     int    `toml:"uid_base"`     // first uid of sandbox users, each account runs as its own uid and gid, required by "local" backend
+	UIDCount    int    `toml:"uid_count"`    // number of uids reserved for sandbox users from uid_base, defaults to 10000
+	Shell       string `toml:"shell"`        // shell for sandbox, for "local" backend, defaults to "/bin/bash"
+	RootDir     string `toml:"root_dir"`     // root filesystem mounted read-only, required by "local" backend, e.g. exported from the sandbox image
+	IdleTimeout int    `toml:"idle_timeout"` // minutes without connections before a sandbox is stopped, 0 to disable
+
+	Limits      SandboxLimits `toml:"limits"`       // default resource limits of each sandbox
+	Egress      bool          `toml:"egress"`       // restrict egress of sandbox to bunker sshd and EgressAllow
+	EgressAllow []string      `toml:"egress_allow"` // allowed egress destinations, "CIDR" or "CIDR:PORT", e.g. "10.0.0.0/8", "10.0.0.1:443"
+}
+
+// UIDRange uids reserved for sandbox users, [first, last]
+func (c SandboxConfig) UIDRange() (first int, last int) {
+	n := c.UIDCount
+	if n <= 0 {
+		n = 10000
+	}
+	return c.UIDBase, c.UIDBase + n - 1
+}
+
+// SandboxLimits resource limits of sandbox, 0 for unlimited
+type SandboxLimits struct {
+	CPUs   float64 `toml:"cpus"`   // number of cpus, "docker" backend only, rejected by "local" backend
+	Memory int64   `toml:"memory"` // memory in MB, "docker" backend only, rejected by "local" backend
+	Pids   int64   `toml:"pids"`   // max number of processes, processes of sandbox user for "local" backend
+	Disk   int64   `toml:"disk"`   // disk quota of container in MB, "docker" backend only, storage driver is probed at startup, e.g. overlay2 requires xfs with pquota
+}
+
+// Merge returns limits with non-zero fields of o overriding l
+func (l SandboxLimits) Merge(o SandboxLimits) SandboxLimits {
+	if o.CPUs > 0 {
+		l.CPUs = o.CPUs
+	}
+	if o.Memory > 0 {
+		l.Memory = o.Memory
+	}
+	if o.Pids > 0 {
+		l.Pids = o.Pids
+	}
+	if o.Disk > 0 {
+		l.Disk = o.Disk
+	}
+	return l
 }
 
 // ConsulConfig consul config
 type ConsulConfig struct {
 	Enable bool `toml:"enable"`
 }
+
+// TOTPConfig two-factor authentication config
+type TOTPConfig struct {
+	Enforce     string `toml:"enforce"`      // enforce two-factor authentication, "admin" for admins only, "all" for everyone, empty for optional
+	Issuer      string `toml:"issuer"`       // issuer shown in authenticator apps, defaults to title
+	GraceWindow int    `toml:"grace_window"` // minutes not to re-challenge ssh from the same ip or from sandbox, defaults to 60
+}
+
+// IsEnforced check whether two-factor authentication is enforced for user
+func (c TOTPConfig) IsEnforced(isAdmin bool) bool {
+	switch c.Enforce {
+	case "all":
+		return true
+	case "admin":
+		return isAdmin
+	}
+	return false
+}
+
+// Grace grace window of ssh second factor, defaults to 60 minutes
+func (c TOTPConfig) Grace() time.Duration {
+	if c.GraceWindow <= 0 {
+		return time.Minute * 60
+	}
+	return time.Minute * time.Duration(c.GraceWindow)
+}
+
+// LoginConfig web login brute-force protection config
+type LoginConfig struct {
+	MaxFailures   int `toml:"max_failures"`   // failed logins of an account within failure window before it is locked, defaults to 5, -1 to disable
+	FailureWindow int `toml:"failure_window"` // minutes, defaults to 15
+	LockDuration  int `toml:"lock_duration"`  // minutes an account is locked, defaults to 30
+	BackoffBase   int `toml:"backoff_base"`   // seconds a source ip waits after a failed login, doubled after each further failure, defaults to 1, -1 to disable
+	BackoffMax    int `toml:"backoff_max"`    // max seconds a source ip waits, defaults to 300
+}
+
+// LockThreshold failed logins before account is locked, 0 if disabled
+func (c LoginConfig) LockThreshold() int {
+	if c.MaxFailures < 0 {
+		return 0
+	}
+	if c.MaxFailures == 0 {
+		return 5
+	}
+	return c.MaxFailures
+}
+
+// LockWindow window failed logins are counted in
+func (c LoginConfig) LockWindow() time.Duration {
+	if c.FailureWindow <= 0 {
+		return time.Minute * 15
+	}
+	return time.Minute * time.Duration(c.FailureWindow)
+}
+
+// LockTime duration of account lock
+func (c LoginConfig) LockTime() time.Duration {
+	if c.LockDuration <= 0 {
+		return time.Minute * 30
+	}
+	return time.Minute * time.Duration(c.LockDuration)
+}
+
+// BackoffInitial wait after the first failed login of a source ip, 0 if disabled
+func (c LoginConfig) BackoffInitial() time.Duration {
+	if c.BackoffBase < 0 {
+		return 0
+	}
+	if c.BackoffBase == 0 {
+		return time.Second
+	}
+	return time.Second * time.Duration(c.BackoffBase)
+}
+
+// BackoffLimit max wait of a source ip
+func (c LoginConfig) BackoffLimit() time.Duration {
+	if c.BackoffMax <= 0 {
+		return time.Minute * 5
+	}
+	return time.Second * time.Duration(c.BackoffMax)
+}
+
+// LDAPConfig ldap / active directory config
+type LDAPConfig struct {
+	Enable       bool              `toml:"enable"`        // enable ldap authentication
+	URL          string            `toml:"url"`           // ldap://host:389 or ldaps://host:636
+	StartTLS     bool              `toml:"start_tls"`     // upgrade ldap:// connection with StartTLS
+	SkipVerify   bool              `toml:"skip_verify"`   // skip tls certificate verification
+	BindDN       string            `toml:"bind_dn"`       // dn of service account for searching
+	BindPassword string            `toml:"bind_password"` // password of service account
+	BaseDN       string            `toml:"base_dn"`       // base dn for searching users
+	UserFilter   string            `toml:"user_filter"`   // filter of active users, defaults to (objectClass=person)
+	AccountAttr  string            `toml:"account_attr"`  // attribute of account name, defaults to uid, sAMAccountName for active directory
+	GroupAttr    string            `toml:"group_attr"`    // attribute of group dns, defaults to memberOf
+	AdminGroups  []string          `toml:"admin_groups"`  // members of these groups are admins, admin status is not synced if empty
+	Groups       map[string]string `toml:"groups"`        // ldap group dn to bunker group name, membership is synced
+	SyncInterval int               `toml:"sync_interval"` // minutes between user sync, 0 to disable
+}
+
+// OIDCConfig openid connect single sign-on config
+type OIDCConfig struct {
+	Enable          bool     `toml:"enable"`           // enable openid connect login
+	Issuer          string   `toml:"issuer"`           // issuer url, discovery document is fetched from issuer/.well-known/openid-configuration
+	ClientID        string   `toml:"client_id"`        // client id
+	ClientSecret    string   `toml:"client_secret"`    // client secret
+	RedirectURL     string   `toml:"redirect_url"`     // redirect url, defaults to domain/login/oidc/callback
+	Scopes          []string `toml:"scopes"`           // extra scopes besides openid, e.g. ["profile", "email", "groups"]
+	AccountClaim    string   `toml:"account_claim"`    // claim mapped to account, defaults to preferred_username
+	StripDomain     bool     `toml:"strip_domain"`     // strip "@domain" from account claim, for email claims
+	GroupsClaim     string   `toml:"groups_claim"`     // claim of groups, defaults to groups
+	AdminGroups     []string `toml:"admin_groups"`     // members of these groups are admins, admin status is not synced if empty
+	ButtonText      string   `toml:"button_text"`      // text of login button
+	DisablePassword bool     `toml:"disable_password"` // disable local password login for everyone
+}
+
+// CAConfig ssh certificate authority config
+type CAConfig struct {
+	Enable      bool   `toml:"enable"`       // enable issuing ssh user certificates
+	PrivateKey  string `toml:"private_key"`  // private key file of certificate authority
+	TTL         int    `toml:"ttl"`          // hours a certificate is valid by default, defaults to 8
+	MaxTTL      int    `toml:"max_ttl"`      // max hours a certificate can be valid, defaults to 24
+	RequireCert bool   `toml:"require_cert"` // reject uploaded keys, only certificates are accepted from public
+}
+
+// DefaultTTL default validity of certificate
+func (c CAConfig) DefaultTTL() time.Duration {
+	if c.TTL <= 0 {
+		return time.Hour * 8
+	}
+	return time.Hour * time.Duration(c.TTL)
+}
+
+// MaxValidity max validity of certificate
+func (c CAConfig) MaxValidity() time.Duration {
+	if c.MaxTTL <= 0 {
+		return time.Hour * 24
+	}
+	return time.Hour * time.Duration(c.MaxTTL)
+}
+
+// TranscriptConfig session transcript full-text index config
+type TranscriptConfig struct {
+	Enable   bool `toml:"enable"`    // extract transcripts of ended sessions into full-text index
+	Interval int  `toml:"interval"`  // seconds between indexing runs, defaults to 60
+	MaxLines int  `toml:"max_lines"` // max lines indexed per session, defaults to 50000
+}
+
+// IndexInterval interval between indexing runs
+func (c TranscriptConfig) IndexInterval() time.Duration {
+	if c.Interval <= 0 {
+		return time.Minute
+	}
+	return time.Second * time.Duration(c.Interval)
+}
+
+// MaxLinesPerSession max lines indexed per session
+func (c TranscriptConfig) MaxLinesPerSession() int {
+	if c.MaxLines <= 0 {
+		return 50000
+	}
+	return c.MaxLines
+}
+
+// EventsConfig security event export config, events are delivered to each enabled sink
+type EventsConfig struct {
+	Buffer  int `toml:"buffer"`  // events buffered for each sink, new events are dropped when full, defaults to 1024
+	Retries int `toml:"retries"` // attempts to deliver an event before it is dropped, defaults to 5
+
+	Syslog  SyslogSinkConfig  `toml:"syslog"`  // rfc 5424 syslog sink
+	File    FileSinkConfig    `toml:"file"`    // json lines file sink
+	Webhook WebhookSinkConfig `toml:"webhook"` // json lines http webhook sink
+}
+
+// BufferSize events buffered for each sink
+func (c EventsConfig) BufferSize() int {
+	if c.Buffer <= 0 {
+		return 1024
+	}
+	return c.Buffer
+}
+
+// MaxAttempts attempts to deliver an event
+func (c EventsConfig) MaxAttempts() int {
+	if c.Retries <= 0 {
+		return 5
+	}
+	return c.Retries
+}
+
+// SyslogSinkConfig rfc 5424 syslog sink config
+type SyslogSinkConfig struct {
+	Enable   bool   `toml:"enable"`   // enable syslog sink
+	Network  string `toml:"network"`  // "udp" or "tcp", defaults to "udp"
+	Address  string `toml:"address"`  // host:port of syslog server
+	Facility string `toml:"facility"` // syslog facility, e.g. "auth", "authpriv", "local0", defaults to "auth"
+	AppName  string `toml:"app_name"` // APP-NAME of messages, defaults to "bunker"
+}
+
+// FileSinkConfig json lines file sink config
+type FileSinkConfig struct {
+	Enable bool   `toml:"enable"` // enable file sink
+	Path   string `toml:"path"`   // file events are appended to, reopened if moved by log rotation
+}
+
+// WebhookSinkConfig json lines http webhook sink config
+type WebhookSinkConfig struct {
+	Enable  bool              `toml:"enable"`  // enable webhook sink
+	URL     string            `toml:"url"`     // url events are posted to, as json lines
+	Headers map[string]string `toml:"headers"` // extra headers, e.g. Authorization
+	Timeout int               `toml:"timeout"` // seconds, defaults to 10
+}
+
+// RequestTimeout timeout of each webhook request
+func (c WebhookSinkConfig) RequestTimeout() time.Duration {
+	if c.Timeout <= 0 {
+		return time.Second * 10
+	}
+	return time.Second * time.Duration(c.Timeout)
+}
+
+// GrantRequestConfig grant request and approval workflow config, admins can always review requests
+type GrantRequestConfig struct {
+	Approvers      []string          `toml:"approvers"`       // accounts allowed to approve or deny requests
+	ApproverGroups []string          `toml:"approver_groups"` // members of these groups are allowed to approve or deny requests
+	MaxDuration    int               `toml:"max_duration"`    // max hours a request may ask for, defaults to 168
+	Notify         WebhookSinkConfig `toml:"notify"`          // json lines webhook notified when requests are created or reviewed
+}
+
+// MaxHours max hours a request may ask for
+func (c GrantRequestConfig) MaxHours() int {
+	if c.MaxDuration <= 0 {
+		return 168
+	}
+	return c.MaxDuration
+}
@@ -0,0 +1,75 @@
+/**
+ * utils/audit.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// auditIgnoredFields fields changed on every save, not worth auditing
+var auditIgnoredFields = map[string]bool{
+	"createdAt": true,
+	"updatedAt": true,
+	"usedAt":    true,
+}
+
+func auditFields(v interface{}) (s string, m map[string]interface{}) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	s = string(buf)
+	json.Unmarshal(buf, &m)
+	return
+}
+
+// AuditDiff marshal states before and after a change to json, and describe changed fields as "field: old -> new",
+// one per line, nil state is marshaled to empty string and produces no diff
+func AuditDiff(before interface{}, after interface{}) (b string, a string, diff string) {
+	var bm, am map[string]interface{}
+	b, bm = auditFields(before)
+	a, am = auditFields(after)
+	if bm == nil || am == nil {
+		return
+	}
+	ks := []string{}
+	for k := range bm {
+		ks = append(ks, k)
+	}
+	for k := range am {
+		if _, ok := bm[k]; !ok {
+			ks = append(ks, k)
+		}
+	}
+	sort.Strings(ks)
+	ls := []string{}
+	for _, k := range ks {
+		if auditIgnoredFields[k] || reflect.DeepEqual(bm[k], am[k]) {
+			continue
+		}
+		ls = append(ls, fmt.Sprintf("%s: %s -> %s", k, auditValue(bm[k]), auditValue(am[k])))
+	}
+	diff = strings.Join(ls, "\n")
+	return
+}
+
+func auditValue(v interface{}) string {
+	if v == nil {
+		return "null"
+	}
+	buf, _ := json.Marshal(v)
+	return string(buf)
+}
@@ -0,0 +1,37 @@
+/**
+ *Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ *This software is released under the MIT License.
+ *https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"testing"
+)
+
+type testAuditState struct {
+	Name      string `json:"name"`
+	IsAdmin   int    `json:"isAdmin"`
+	Secret    string `json:"-"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+func TestAuditDiff(t *testing.T) {
+	b, a, d := AuditDiff(
+		testAuditState{Name: "alice", Secret: "x", UpdatedAt: "1"},
+		&testAuditState{Name: "alice", IsAdmin: 1, Secret: "y", UpdatedAt: "2"},
+	)
+	if b != `{"name":"alice","isAdmin":0,"updatedAt":"1"}` || len(a) == 0 {
+		t.Error("invalid state", b, a)
+	}
+	if d != "isAdmin: 0 -> 1" {
+		t.Error("invalid diff", d)
+	}
+	var n *testAuditState
+	b, a, d = AuditDiff(n, testAuditState{Name: "bob"})
+	if b != "" || a != `{"name":"bob","isAdmin":0,"updatedAt":""}` || d != "" {
+		t.Error("invalid create", b, a, d)
+	}
+}
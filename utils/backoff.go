@@ -0,0 +1,107 @@
+/**
+ * utils/backoff.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+type backoffEntry struct {
+	failures int
+	until    time.Time
+}
+
+// Backoff exponential backoff by key, wait after a failure doubles after each further failure,
+// failures are forgotten after key stays quiet for max past its wait
+type Backoff struct {
+	base time.Duration
+	max  time.Duration
+	now  func() time.Time
+
+	lock      sync.Mutex
+	entries   map[string]*backoffEntry
+	lastPrune time.Time
+}
+
+// NewBackoff create a backoff, base <= 0 to disable
+func NewBackoff(base time.Duration, max time.Duration) *Backoff {
+	return &Backoff{
+		base:    base,
+		max:     max,
+		now:     time.Now,
+		entries: map[string]*backoffEntry{},
+	}
+}
+
+// prune remove forgotten entries, at most once per max, lock must be held
+func (b *Backoff) prune(now time.Time) {
+	if now.Sub(b.lastPrune) < b.max {
+		return
+	}
+	b.lastPrune = now
+	for k, e := range b.entries {
+		if now.Sub(e.until) >= b.max {
+			delete(b.entries, k)
+		}
+	}
+}
+
+// Fail record a failure of key, returns wait before next attempt
+func (b *Backoff) Fail(key string) time.Duration {
+	if b == nil || b.base <= 0 {
+		return 0
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := b.now()
+	b.prune(now)
+	e := b.entries[key]
+	if e == nil || now.Sub(e.until) >= b.max {
+		e = &backoffEntry{}
+		b.entries[key] = e
+	}
+	e.failures++
+	d := b.base
+	for i := 1; i < e.failures && d < b.max; i++ {
+		d = d * 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	e.until = now.Add(d)
+	return d
+}
+
+// Wait remaining wait of key before next attempt
+func (b *Backoff) Wait(key string) time.Duration {
+	if b == nil || b.base <= 0 {
+		return 0
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	e := b.entries[key]
+	if e == nil {
+		return 0
+	}
+	if d := e.until.Sub(b.now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Reset forget failures of key
+func (b *Backoff) Reset(key string) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.entries, key)
+}
@@ -0,0 +1,47 @@
+/**
+ *Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ *This software is released under the MIT License.
+ *https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewBackoff(time.Second, time.Second*10)
+	b.now = func() time.Time { return now }
+	for i, w := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		if d := b.Fail("10.0.0.1"); d != w*time.Second {
+			t.Error("invalid wait", i, d)
+		}
+	}
+	if d := b.Wait("10.0.0.1"); d != time.Second*10 {
+		t.Error("invalid remaining wait", d)
+	}
+	now = now.Add(time.Second * 4)
+	if d := b.Wait("10.0.0.1"); d != time.Second*6 {
+		t.Error("invalid remaining wait", d)
+	}
+	if d := b.Wait("10.0.0.2"); d != 0 {
+		t.Error("other keys should not wait", d)
+	}
+	// forgotten after quiet for max past the wait
+	now = now.Add(time.Second * 16)
+	if d := b.Fail("10.0.0.1"); d != time.Second {
+		t.Error("failures should be forgotten", d)
+	}
+	b.Reset("10.0.0.1")
+	if d := b.Wait("10.0.0.1"); d != 0 {
+		t.Error("should not wait after reset", d)
+	}
+	// disabled
+	if d := NewBackoff(0, time.Second).Fail("10.0.0.1"); d != 0 {
+		t.Error("disabled backoff should never wait", d)
+	}
+}
@@ -0,0 +1,58 @@
+/**
+ * utils/cert.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SignUserCertificate sign a user certificate of pub, valid for principal only
+func SignUserCertificate(ca ssh.Signer, pub ssh.PublicKey, serial uint64, keyID string, principal string, validAfter time.Time, validBefore time.Time) (*ssh.Certificate, error) {
+	c := &ssh.Certificate{
+		Key:             pub,
+		Serial:          serial,
+		CertType:        ssh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: []string{principal},
+		ValidAfter:      uint64(validAfter.Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-agent-forwarding": "",
+				"permit-port-forwarding":  "",
+				"permit-pty":              "",
+			},
+		},
+	}
+	if err := c.SignCert(rand.Reader, ca); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// CheckUserCertificate check a user certificate is signed by ca, not revoked, and valid for principal now
+func CheckUserCertificate(ca ssh.PublicKey, c *ssh.Certificate, principal string, isRevoked func(*ssh.Certificate) bool) error {
+	if c.CertType != ssh.UserCert {
+		return errors.New("not a user certificate")
+	}
+	if !bytes.Equal(c.SignatureKey.Marshal(), ca.Marshal()) {
+		return errors.New("certificate not signed by bunker")
+	}
+	// certificate without principals is valid for any principal, never accept it
+	if len(c.ValidPrincipals) == 0 {
+		return errors.New("certificate without principals")
+	}
+	cc := &ssh.CertChecker{IsRevoked: isRevoked}
+	return cc.CheckCert(principal, c)
+}
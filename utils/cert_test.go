@@ -0,0 +1,60 @@
+/**
+ *Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ *This software is released under the MIT License.
+ *https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	_, k, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ssh.NewSignerFromKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestUserCertificate(t *testing.T) {
+	ca, other, user := newTestSigner(t), newTestSigner(t), newTestSigner(t)
+	now := time.Now()
+	c, err := SignUserCertificate(ca, user.PublicKey(), 42, "bunker:alice:42", "alice", now.Add(-time.Minute), now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// round trip through authorized_keys format
+	pk, _, _, _, err := ssh.ParseAuthorizedKey(ssh.MarshalAuthorizedKey(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = pk.(*ssh.Certificate)
+	if err = CheckUserCertificate(ca.PublicKey(), c, "alice", nil); err != nil {
+		t.Error("should pass", err)
+	}
+	if err = CheckUserCertificate(ca.PublicKey(), c, "bob", nil); err == nil {
+		t.Error("should fail on wrong principal")
+	}
+	if err = CheckUserCertificate(other.PublicKey(), c, "alice", nil); err == nil {
+		t.Error("should fail on wrong ca")
+	}
+	if err = CheckUserCertificate(ca.PublicKey(), c, "alice", func(c *ssh.Certificate) bool { return c.Serial == 42 }); err == nil {
+		t.Error("should fail on revoked")
+	}
+	c, _ = SignUserCertificate(ca, user.PublicKey(), 43, "bunker:alice:43", "alice", now.Add(-time.Hour), now.Add(-time.Minute))
+	if err = CheckUserCertificate(ca.PublicKey(), c, "alice", nil); err == nil {
+		t.Error("should fail on expired")
+	}
+}
@@ -0,0 +1,69 @@
+/**
+ * utils/crypto.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+func newSecretAEAD(secret string) (cipher.AEAD, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("secret is required for encryption")
+	}
+	k := sha256.Sum256([]byte(secret))
+	b, err := aes.NewCipher(k[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+// EncryptString encrypt plain text with key derived from secret, empty string stays empty
+func EncryptString(secret string, plain string) (string, error) {
+	if len(plain) == 0 {
+		return "", nil
+	}
+	a, err := newSecretAEAD(secret)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, a.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(a.Seal(nonce, nonce, []byte(plain), nil)), nil
+}
+
+// DecryptString decrypt text encrypted by EncryptString
+func DecryptString(secret string, enc string) (string, error) {
+	if len(enc) == 0 {
+		return "", nil
+	}
+	a, err := newSecretAEAD(secret)
+	if err != nil {
+		return "", err
+	}
+	buf, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return "", err
+	}
+	if len(buf) < a.NonceSize() {
+		return "", errors.New("invalid encrypted text")
+	}
+	out, err := a.Open(nil, buf[:a.NonceSize()], buf[a.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
@@ -0,0 +1,31 @@
+/**
+ *Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ *This software is released under the MIT License.
+ *https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"testing"
+)
+
+func TestEncryptString(t *testing.T) {
+	enc, err := EncryptString("secret", "hello world")
+	if err != nil || len(enc) == 0 || enc == "hello world" {
+		t.Fatal("failed to encrypt", enc, err)
+	}
+	if out, err := DecryptString("secret", enc); err != nil || out != "hello world" {
+		t.Error("failed to decrypt", out, err)
+	}
+	if _, err := DecryptString("other", enc); err == nil {
+		t.Error("should fail with wrong secret")
+	}
+	if enc, err = EncryptString("secret", ""); err != nil || enc != "" {
+		t.Error("empty string should stay empty", enc, err)
+	}
+	if _, err = EncryptString("", "hello"); err == nil {
+		t.Error("should fail without secret")
+	}
+}
@@ -0,0 +1,147 @@
+/**
+ * utils/labels.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LabelKeyPattern pattern for label key
+var LabelKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9\._\-/]*$`)
+
+// LabelValuePattern pattern for label value, empty value is allowed
+var LabelValuePattern = regexp.MustCompile(`^[a-zA-Z0-9\._\-/]*$`)
+
+// ParseLabels parse labels in format "k1=v1,k2=v2"
+func ParseLabels(s string) (ls map[string]string, err error) {
+	ls = map[string]string{}
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if len(p) == 0 {
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			err = fmt.Errorf("invalid label \"%s\"", p)
+			return
+		}
+		k, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if !LabelKeyPattern.MatchString(k) || !LabelValuePattern.MatchString(v) {
+			err = fmt.Errorf("invalid label \"%s\"", p)
+			return
+		}
+		ls[k] = v
+	}
+	return
+}
+
+// FormatLabels format labels to "k1=v1,k2=v2", sorted by key
+func FormatLabels(ls map[string]string) string {
+	ks := make([]string, 0, len(ls))
+	for k := range ls {
+		if LabelKeyPattern.MatchString(k) && LabelValuePattern.MatchString(ls[k]) {
+			ks = append(ks, k)
+		}
+	}
+	sort.Strings(ks)
+	ps := make([]string, 0, len(ks))
+	for _, k := range ks {
+		ps = append(ps, fmt.Sprintf("%s=%s", k, ls[k]))
+	}
+	return strings.Join(ps, ",")
+}
+
+// LabelRequirement a single requirement in label selector
+type LabelRequirement struct {
+	Key   string
+	Value string
+	Not   bool
+}
+
+// LabelSelector label selector, all requirements must be satisfied
+type LabelSelector []LabelRequirement
+
+// ParseLabelSelector parse label selector in format "k1=v1,k2!=v2"
+func ParseLabelSelector(s string) (sel LabelSelector, err error) {
+	sel = LabelSelector{}
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if len(p) == 0 {
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			err = fmt.Errorf("invalid label requirement \"%s\"", p)
+			return
+		}
+		r := LabelRequirement{Key: strings.TrimSpace(kv[0]), Value: strings.TrimSpace(kv[1])}
+		if strings.HasSuffix(r.Key, "!") {
+			r.Not = true
+			r.Key = strings.TrimSpace(strings.TrimSuffix(r.Key, "!"))
+		}
+		if !LabelKeyPattern.MatchString(r.Key) || !LabelValuePattern.MatchString(r.Value) {
+			err = fmt.Errorf("invalid label requirement \"%s\"", p)
+			return
+		}
+		sel = append(sel, r)
+	}
+	if len(sel) == 0 {
+		err = fmt.Errorf("empty label selector")
+	}
+	return
+}
+
+// Match check labels against selector, empty selector matches nothing
+func (sel LabelSelector) Match(ls map[string]string) bool {
+	if len(sel) == 0 {
+		return false
+	}
+	for _, r := range sel {
+		v, ok := ls[r.Key]
+		if r.Not {
+			if ok && v == r.Value {
+				return false
+			}
+		} else {
+			if !ok || v != r.Value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String format selector to "k1=v1,k2!=v2"
+func (sel LabelSelector) String() string {
+	ps := make([]string, 0, len(sel))
+	for _, r := range sel {
+		if r.Not {
+			ps = append(ps, fmt.Sprintf("%s!=%s", r.Key, r.Value))
+		} else {
+			ps = append(ps, fmt.Sprintf("%s=%s", r.Key, r.Value))
+		}
+	}
+	return strings.Join(ps, ",")
+}
+
+// MatchLabelSelector check formatted labels against formatted selector
+func MatchLabelSelector(selector string, labels string) bool {
+	sel, err := ParseLabelSelector(selector)
+	if err != nil {
+		return false
+	}
+	ls, err := ParseLabels(labels)
+	if err != nil {
+		return false
+	}
+	return sel.Match(ls)
+}
@@ -0,0 +1,61 @@
+/**
+ * utils/labels_test.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	ls, err := ParseLabels(" role=web, env=staging,,empty=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != 3 || ls["env"] != "staging" || ls["role"] != "web" || ls["empty"] != "" {
+		t.Errorf("invalid labels %v", ls)
+	}
+	if FormatLabels(ls) != "empty=,env=staging,role=web" {
+		t.Errorf("invalid format %s", FormatLabels(ls))
+	}
+	if _, err = ParseLabels("env"); err == nil {
+		t.Errorf("should fail on missing value")
+	}
+	if _, err = ParseLabels("e nv=a"); err == nil {
+		t.Errorf("should fail on invalid key")
+	}
+}
+
+func TestMatchLabelSelector(t *testing.T) {
+	ls := "env=staging,role=web"
+	if !MatchLabelSelector("env=staging", ls) {
+		t.Errorf("should match single requirement")
+	}
+	if !MatchLabelSelector("env=staging,role=web", ls) {
+		t.Errorf("should match all requirements")
+	}
+	if MatchLabelSelector("env=staging,role=db", ls) {
+		t.Errorf("should not match partial requirements")
+	}
+	if !MatchLabelSelector("env!=production", ls) {
+		t.Errorf("should match not equal requirement")
+	}
+	if MatchLabelSelector("role!=web", ls) {
+		t.Errorf("should not match not equal requirement")
+	}
+	if MatchLabelSelector("", ls) {
+		t.Errorf("empty selector should match nothing")
+	}
+	sel, err := ParseLabelSelector(" env = staging , role!=db ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sel.String() != "env=staging,role!=db" {
+		t.Errorf("invalid selector string %s", sel.String())
+	}
+}
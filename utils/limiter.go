@@ -0,0 +1,140 @@
+/**
+ * utils/limiter.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ban a key temporarily banned by Limiter
+type Ban struct {
+	Key      string
+	Failures int
+	Until    time.Time
+}
+
+type limiterEntry struct {
+	failures []time.Time
+	until    time.Time
+}
+
+// Limiter count failures by key, a key with too many failures within window is banned for a while
+type Limiter struct {
+	max    int
+	window time.Duration
+	ban    time.Duration
+	now    func() time.Time
+
+	lock      sync.Mutex
+	entries   map[string]*limiterEntry
+	lastPrune time.Time
+}
+
+// NewLimiter create a limiter, key is banned for ban after max failures within window, max <= 0 to disable
+func NewLimiter(max int, window time.Duration, ban time.Duration) *Limiter {
+	return &Limiter{
+		max:     max,
+		window:  window,
+		ban:     ban,
+		now:     time.Now,
+		entries: map[string]*limiterEntry{},
+	}
+}
+
+// prune remove entries without recent failures or active ban, at most once per window, lock must be held
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.window {
+		return
+	}
+	l.lastPrune = now
+	for k, e := range l.entries {
+		if now.After(e.until) && (len(e.failures) == 0 || now.Sub(e.failures[len(e.failures)-1]) >= l.window) {
+			delete(l.entries, k)
+		}
+	}
+}
+
+// Fail record a failure of key, returns true if key is banned
+func (l *Limiter) Fail(key string) bool {
+	if l == nil || l.max <= 0 {
+		return false
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	l.prune(now)
+	e := l.entries[key]
+	if e == nil {
+		e = &limiterEntry{}
+		l.entries[key] = e
+	}
+	// failures during a ban do not extend it
+	if now.Before(e.until) {
+		return true
+	}
+	// drop failures out of window
+	i := 0
+	for i < len(e.failures) && now.Sub(e.failures[i]) >= l.window {
+		i++
+	}
+	e.failures = append(e.failures[i:], now)
+	if len(e.failures) >= l.max {
+		e.until = now.Add(l.ban)
+		return true
+	}
+	return false
+}
+
+// IsBanned check whether key is banned
+func (l *Limiter) IsBanned(key string) bool {
+	if l == nil || l.max <= 0 {
+		return false
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	e := l.entries[key]
+	return e != nil && l.now().Before(e.until)
+}
+
+// Unban lift ban of key and forget its failures, returns false if key is not banned
+func (l *Limiter) Unban(key string) bool {
+	if l == nil {
+		return false
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	e := l.entries[key]
+	if e == nil {
+		return false
+	}
+	delete(l.entries, key)
+	return l.now().Before(e.until)
+}
+
+// Bans list active bans, sorted by key
+func (l *Limiter) Bans() []Ban {
+	bs := []Ban{}
+	if l == nil {
+		return bs
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	for k, e := range l.entries {
+		if now.Before(e.until) {
+			bs = append(bs, Ban{Key: k, Failures: len(e.failures), Until: e.until})
+		}
+	}
+	sort.Slice(bs, func(i, j int) bool {
+		return bs[i].Key < bs[j].Key
+	})
+	return bs
+}
@@ -0,0 +1,60 @@
+/**
+ *Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ *This software is released under the MIT License.
+ *https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(3, time.Minute, time.Minute*10)
+	l.now = func() time.Time { return now }
+	// failures out of window are forgotten
+	l.Fail("ip:10.0.0.1")
+	l.Fail("ip:10.0.0.1")
+	now = now.Add(time.Minute * 2)
+	if l.Fail("ip:10.0.0.1") || l.IsBanned("ip:10.0.0.1") {
+		t.Error("should not be banned with failures out of window")
+	}
+	l.Fail("ip:10.0.0.1")
+	if !l.Fail("ip:10.0.0.1") || !l.IsBanned("ip:10.0.0.1") {
+		t.Error("should be banned")
+	}
+	if l.IsBanned("ip:10.0.0.2") {
+		t.Error("other keys should not be banned")
+	}
+	if bs := l.Bans(); len(bs) != 1 || bs[0].Key != "ip:10.0.0.1" || !bs[0].Until.Equal(now.Add(time.Minute*10)) {
+		t.Error("invalid bans", bs)
+	}
+	// failures during ban do not extend it
+	now = now.Add(time.Minute * 5)
+	l.Fail("ip:10.0.0.1")
+	now = now.Add(time.Minute * 5)
+	if l.IsBanned("ip:10.0.0.1") {
+		t.Error("ban should expire")
+	}
+	// unban
+	l.Fail("account:alice")
+	l.Fail("account:alice")
+	l.Fail("account:alice")
+	if !l.Unban("account:alice") || l.IsBanned("account:alice") {
+		t.Error("should be unbanned")
+	}
+	if l.Fail("account:alice") {
+		t.Error("failures should be forgotten after unban")
+	}
+	// disabled
+	d := NewLimiter(0, time.Minute, time.Minute)
+	for i := 0; i < 10; i++ {
+		if d.Fail("ip:10.0.0.1") {
+			t.Error("disabled limiter should never ban")
+		}
+	}
+}
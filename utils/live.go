@@ -0,0 +1,279 @@
+/**
+ * utils/live.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"landzero.net/x/encoding/rec"
+)
+
+const (
+	// LiveFrameStdout frame type of stdout, same as replay file
+	LiveFrameStdout = 1
+	// LiveFrameStderr frame type of stderr, same as replay file
+	LiveFrameStderr = 2
+	// LiveFrameWindowSize frame type of window size, same as replay file
+	LiveFrameWindowSize = 3
+
+	// liveBacklogSize max bytes of recent frames sent to a new watcher
+	liveBacklogSize = 64 * 1024
+	// liveWatcherBuffer frames buffered for a watcher, slow watchers are dropped
+	liveWatcherBuffer = 256
+)
+
+// EncodeLiveFrame encode a frame in replay file format, 4 bytes timestamp in milliseconds, 1 byte type, 4 bytes length and payload
+func EncodeLiveFrame(ts time.Duration, typ byte, payload []byte) []byte {
+	buf := make([]byte, 9+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(ts/time.Millisecond))
+	buf[4] = typ
+	binary.BigEndian.PutUint32(buf[5:], uint32(len(payload)))
+	copy(buf[9:], payload)
+	return buf
+}
+
+// LiveSession a in-progress session, replay frames are broadcasted to watchers
+type LiveSession struct {
+	ID        uint
+	StartedAt time.Time
+
+	lock        sync.Mutex
+	rw          rec.Writer
+	backlog     [][]byte
+	backlogSize int
+	window      []byte
+	watchers    map[chan []byte]bool
+	closed      bool
+	terminate   func()
+	inject      func([]byte)
+}
+
+// Wrap wrap the replay writer, frames written are also broadcasted to watchers
+func (l *LiveSession) Wrap(rw rec.Writer) rec.Writer {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.rw = rw
+	return &liveWriter{l: l}
+}
+
+// broadcast append frame to backlog and send to watchers, lock must be held
+func (l *LiveSession) broadcast(typ byte, payload []byte) {
+	f := EncodeLiveFrame(time.Since(l.StartedAt), typ, payload)
+	if typ == LiveFrameWindowSize {
+		l.window = f
+	} else {
+		l.backlog = append(l.backlog, f)
+		l.backlogSize += len(f)
+		for l.backlogSize > liveBacklogSize && len(l.backlog) > 1 {
+			l.backlogSize -= len(l.backlog[0])
+			l.backlog = l.backlog[1:]
+		}
+	}
+	for w := range l.watchers {
+		select {
+		case w <- f:
+		default:
+			// drop slow watcher instead of blocking the session
+			delete(l.watchers, w)
+			close(w)
+		}
+	}
+}
+
+// Watch watch frames of the session, recent frames are sent first, channel is closed when session ends
+func (l *LiveSession) Watch() (<-chan []byte, func()) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	w := make(chan []byte, liveWatcherBuffer+len(l.backlog)+1)
+	if l.window != nil {
+		w <- l.window
+	}
+	for _, f := range l.backlog {
+		w <- f
+	}
+	if l.closed {
+		close(w)
+		return w, func() {}
+	}
+	l.watchers[w] = true
+	return w, func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		if l.watchers[w] {
+			delete(l.watchers, w)
+			close(w)
+		}
+	}
+}
+
+// Terminate terminate the session
+func (l *LiveSession) Terminate() {
+	if l.terminate != nil {
+		l.terminate()
+	}
+}
+
+// Inject write a message to terminal of the session, message is recorded as well
+func (l *LiveSession) Inject(msg string) {
+	buf := []byte("\r\n\x1b[1;31m[Bunker] " + msg + "\x1b[0m\r\n")
+	// write to channel without lock, it may block on slow client
+	if l.inject != nil {
+		l.inject(buf)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return
+	}
+	if l.rw != nil && l.rw.IsActivated() {
+		l.rw.Stdout().Write(buf)
+		l.broadcast(LiveFrameStdout, buf)
+	}
+}
+
+// close close all watchers
+func (l *LiveSession) close() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.closed = true
+	for w := range l.watchers {
+		close(w)
+	}
+	l.watchers = map[chan []byte]bool{}
+}
+
+// liveWriter rec.Writer broadcasting frames to watchers, writes are serialized with injected messages
+type liveWriter struct {
+	l *LiveSession
+}
+
+type liveStreamWriter struct {
+	l   *LiveSession
+	typ byte
+}
+
+func (w *liveStreamWriter) Write(p []byte) (n int, err error) {
+	w.l.lock.Lock()
+	defer w.l.lock.Unlock()
+	var o io.Writer
+	if w.typ == LiveFrameStderr {
+		o = w.l.rw.Stderr()
+	} else {
+		o = w.l.rw.Stdout()
+	}
+	if n, err = o.Write(p); err != nil {
+		return
+	}
+	if w.l.rw.IsActivated() {
+		w.l.broadcast(w.typ, p)
+	}
+	return
+}
+
+func (w *liveWriter) Activate() {
+	w.l.lock.Lock()
+	defer w.l.lock.Unlock()
+	w.l.rw.Activate()
+}
+
+func (w *liveWriter) IsActivated() bool {
+	w.l.lock.Lock()
+	defer w.l.lock.Unlock()
+	return w.l.rw.IsActivated()
+}
+
+func (w *liveWriter) Close() error {
+	w.l.lock.Lock()
+	defer w.l.lock.Unlock()
+	return w.l.rw.Close()
+}
+
+func (w *liveWriter) Stdout() io.Writer {
+	return &liveStreamWriter{l: w.l, typ: LiveFrameStdout}
+}
+
+func (w *liveWriter) Stderr() io.Writer {
+	return &liveStreamWriter{l: w.l, typ: LiveFrameStderr}
+}
+
+func (w *liveWriter) WriteWindowSize(width, height uint32) (err error) {
+	w.l.lock.Lock()
+	defer w.l.lock.Unlock()
+	if err = w.l.rw.WriteWindowSize(width, height); err != nil {
+		return
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint32(buf, width)
+	binary.BigEndian.PutUint32(buf[4:], height)
+	w.l.broadcast(LiveFrameWindowSize, buf)
+	return
+}
+
+// LiveHub tracks in-progress sessions for live monitoring
+type LiveHub struct {
+	mutex    *sync.Mutex
+	sessions map[uint]*LiveSession
+}
+
+// NewLiveHub create a new live hub
+func NewLiveHub() *LiveHub {
+	return &LiveHub{
+		mutex:    &sync.Mutex{},
+		sessions: map[uint]*LiveSession{},
+	}
+}
+
+// Add register a in-progress session, terminate closes the session, inject writes to terminal of the session
+func (h *LiveHub) Add(id uint, terminate func(), inject func([]byte)) *LiveSession {
+	l := &LiveSession{
+		ID:        id,
+		StartedAt: time.Now(),
+		watchers:  map[chan []byte]bool{},
+		terminate: terminate,
+		inject:    inject,
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.sessions[id] = l
+	return l
+}
+
+// Remove unregister a session, all watchers are closed
+func (h *LiveHub) Remove(id uint) {
+	h.mutex.Lock()
+	l := h.sessions[id]
+	delete(h.sessions, id)
+	h.mutex.Unlock()
+	if l != nil {
+		l.close()
+	}
+}
+
+// Get get a in-progress session, nil if not found
+func (h *LiveHub) Get(id uint) *LiveSession {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.sessions[id]
+}
+
+// IDs ids of all in-progress sessions, sorted
+func (h *LiveHub) IDs() []uint {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	ids := []uint{}
+	for id := range h.sessions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
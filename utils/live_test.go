@@ -0,0 +1,74 @@
+/**
+ *Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ *This software is released under the MIT License.
+ *https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+type testRecWriter struct {
+	activated bool
+	out       bytes.Buffer
+}
+
+func (w *testRecWriter) Activate()                           { w.activated = true }
+func (w *testRecWriter) IsActivated() bool                   { return w.activated }
+func (w *testRecWriter) Close() error                        { return nil }
+func (w *testRecWriter) Stdout() io.Writer                   { return &w.out }
+func (w *testRecWriter) Stderr() io.Writer                   { return &w.out }
+func (w *testRecWriter) WriteWindowSize(w1, h1 uint32) error { return nil }
+
+func TestEncodeLiveFrame(t *testing.T) {
+	f := EncodeLiveFrame(time.Millisecond*258, LiveFrameStdout, []byte("hi"))
+	if !bytes.Equal(f, []byte{0, 0, 1, 2, 1, 0, 0, 0, 2, 'h', 'i'}) {
+		t.Error("invalid frame", f)
+	}
+}
+
+func TestLiveHub(t *testing.T) {
+	h := NewLiveHub()
+	var injected []byte
+	l := h.Add(1, nil, func(b []byte) { injected = b })
+	rw := &testRecWriter{}
+	w := l.Wrap(rw)
+	w.Stdout().Write([]byte("before"))
+	w.Activate()
+	w.WriteWindowSize(80, 24)
+	w.Stdout().Write([]byte("hello"))
+	ch, cancel := l.Watch()
+	if f := <-ch; f[4] != LiveFrameWindowSize {
+		t.Error("window size should be sent first")
+	}
+	if f := <-ch; string(f[9:]) != "hello" {
+		t.Error("backlog should be sent, inactivated output should be skipped", string(f[9:]))
+	}
+	l.Inject("warning")
+	if f := <-ch; !bytes.Contains(f[9:], []byte("warning")) || !bytes.Equal(f[9:], injected) {
+		t.Error("injected message should be broadcasted")
+	}
+	if !bytes.Contains(rw.out.Bytes(), []byte("warning")) {
+		t.Error("injected message should be recorded")
+	}
+	if ids := h.IDs(); len(ids) != 1 || ids[0] != 1 {
+		t.Error("invalid ids", ids)
+	}
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Error("channel should be closed after cancel")
+	}
+	ch, _ = l.Watch()
+	h.Remove(1)
+	for range ch {
+	}
+	if h.Get(1) != nil {
+		t.Error("session should be removed")
+	}
+}
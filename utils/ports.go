@@ -0,0 +1,100 @@
+/**
+ * utils/ports.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PortRange inclusive port range
+type PortRange struct {
+	From uint32
+	To   uint32
+}
+
+// Contains check whether port is in range
+func (r PortRange) Contains(port uint32) bool {
+	return port >= r.From && port <= r.To
+}
+
+// String format port range as "80" or "8000-8100"
+func (r PortRange) String() string {
+	if r.From == r.To {
+		return strconv.FormatUint(uint64(r.From), 10)
+	}
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
+
+func parsePort(s string) (port uint32, err error) {
+	var p uint64
+	if p, err = strconv.ParseUint(strings.TrimSpace(s), 10, 16); err != nil || p == 0 {
+		err = fmt.Errorf("invalid port \"%s\"", s)
+		return
+	}
+	port = uint32(p)
+	return
+}
+
+// ParsePortRanges parse port ranges in format "22,80,8000-8100", empty string is an error
+func ParsePortRanges(s string) (rs []PortRange, err error) {
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if len(p) == 0 {
+			continue
+		}
+		var r PortRange
+		if ft := strings.SplitN(p, "-", 2); len(ft) == 2 {
+			if r.From, err = parsePort(ft[0]); err != nil {
+				return
+			}
+			if r.To, err = parsePort(ft[1]); err != nil {
+				return
+			}
+			if r.From > r.To {
+				err = fmt.Errorf("invalid port range \"%s\"", p)
+				return
+			}
+		} else {
+			if r.From, err = parsePort(p); err != nil {
+				return
+			}
+			r.To = r.From
+		}
+		rs = append(rs, r)
+	}
+	if len(rs) == 0 {
+		err = fmt.Errorf("empty port ranges")
+	}
+	return
+}
+
+// FormatPortRanges format port ranges as "22,80,8000-8100"
+func FormatPortRanges(rs []PortRange) string {
+	ps := make([]string, 0, len(rs))
+	for _, r := range rs {
+		ps = append(ps, r.String())
+	}
+	return strings.Join(ps, ",")
+}
+
+// MatchPortRanges check whether port is in port ranges, invalid port ranges match nothing
+func MatchPortRanges(s string, port uint32) bool {
+	rs, err := ParsePortRanges(s)
+	if err != nil {
+		return false
+	}
+	for _, r := range rs {
+		if r.Contains(port) {
+			return true
+		}
+	}
+	return false
+}
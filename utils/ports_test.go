@@ -0,0 +1,37 @@
+/**
+ * utils/ports_test.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"testing"
+)
+
+func TestParsePortRanges(t *testing.T) {
+	rs, err := ParsePortRanges(" 22, 8000-8100,,443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FormatPortRanges(rs) != "22,8000-8100,443" {
+		t.Errorf("invalid format %s", FormatPortRanges(rs))
+	}
+	for _, s := range []string{"", "0", "65536", "ssh", "100-10", "1-2-3"} {
+		if _, err = ParsePortRanges(s); err == nil {
+			t.Errorf("should fail on \"%s\"", s)
+		}
+	}
+}
+
+func TestMatchPortRanges(t *testing.T) {
+	if !MatchPortRanges("22,8000-8100", 22) || !MatchPortRanges("22,8000-8100", 8050) || !MatchPortRanges("22,8000-8100", 8100) {
+		t.Errorf("should match")
+	}
+	if MatchPortRanges("22,8000-8100", 80) || MatchPortRanges("invalid", 22) {
+		t.Errorf("should not match")
+	}
+}
@@ -0,0 +1,255 @@
+/**
+ * utils/sftp.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// sftpServerScript locate and exec sftp-server on target server
+const sftpServerScript = `for p in /usr/lib/openssh/sftp-server /usr/libexec/openssh/sftp-server /usr/lib/ssh/sftp-server /usr/libexec/sftp-server; do if [ -x "$p" ]; then exec "$p"; fi; done; echo "sftp-server not found" >&2; exit 127`
+
+// SFTPEvent a file operation in sftp session
+type SFTPEvent struct {
+	Operation string // "read", "write", "open", "rename", "remove", "mkdir", "rmdir", "setstat", "symlink", "link", "scp"
+	Path      string // file path
+	NewPath   string // new file path, for "rename", "symlink" and "link"
+	Bytes     int64  // bytes transferred, for "read", "write" and "open"
+	Error     error  // error if operation failed
+}
+
+// SFTPCallback sftp event callback
+type SFTPCallback func(SFTPEvent)
+
+// SSHDModifySFTPCommand sshd create command running sftp-server as target user
+func SSHDModifySFTPCommand(user string) string {
+	return SSHDModifyCommand(user, sftpServerScript)
+}
+
+// ServeSFTPProxy serve sftp on source channel, forwarding all file operations to target sftp client
+func ServeSFTPProxy(src io.ReadWriteCloser, client *sftp.Client, cb SFTPCallback) error {
+	opts := []sftp.RequestServerOption{}
+	// start at home directory of target user
+	if wd, err := client.Getwd(); err == nil {
+		opts = append(opts, sftp.WithStartDirectory(wd))
+	}
+	p := &sftpProxy{client: client, cb: cb}
+	s := sftp.NewRequestServer(src, sftp.Handlers{
+		FileGet:  p,
+		FilePut:  p,
+		FileCmd:  p,
+		FileList: p,
+	}, opts...)
+	err := s.Serve()
+	s.Close()
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+type sftpProxy struct {
+	client *sftp.Client
+	cb     SFTPCallback
+}
+
+func (p *sftpProxy) emit(e SFTPEvent) {
+	if p.cb != nil {
+		p.cb(e)
+	}
+}
+
+func (p *sftpProxy) open(op string, path string, flags int) (f *sftpProxyFile, err error) {
+	var sf *sftp.File
+	if sf, err = p.client.OpenFile(path, flags); err != nil {
+		p.emit(SFTPEvent{Operation: op, Path: path, Error: err})
+		return
+	}
+	f = &sftpProxyFile{File: sf, op: op, path: path, proxy: p}
+	return
+}
+
+// Fileread implements sftp.FileReader
+func (p *sftpProxy) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	return p.open("read", r.Filepath, os.O_RDONLY)
+}
+
+// Filewrite implements sftp.FileWriter
+func (p *sftpProxy) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return p.open("write", r.Filepath, sftpOpenFlags(r.Pflags()))
+}
+
+// OpenFile implements sftp.OpenFileWriter
+func (p *sftpProxy) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	return p.open("open", r.Filepath, sftpOpenFlags(r.Pflags()))
+}
+
+// Filecmd implements sftp.FileCmder
+func (p *sftpProxy) Filecmd(r *sftp.Request) (err error) {
+	e := SFTPEvent{Path: r.Filepath}
+	switch r.Method {
+	case "Setstat":
+		e.Operation = "setstat"
+		err = p.setstat(r)
+	case "Rename":
+		e.Operation = "rename"
+		e.NewPath = r.Target
+		err = p.client.Rename(r.Filepath, r.Target)
+	case "PosixRename":
+		e.Operation = "rename"
+		e.NewPath = r.Target
+		err = p.client.PosixRename(r.Filepath, r.Target)
+	case "Rmdir":
+		e.Operation = "rmdir"
+		err = p.client.RemoveDirectory(r.Filepath)
+	case "Mkdir":
+		e.Operation = "mkdir"
+		err = p.client.Mkdir(r.Filepath)
+	case "Link":
+		e.Operation = "link"
+		e.NewPath = r.Target
+		err = p.client.Link(r.Filepath, r.Target)
+	case "Symlink":
+		e.Operation = "symlink"
+		e.NewPath = r.Target
+		err = p.client.Symlink(r.Filepath, r.Target)
+	case "Remove":
+		e.Operation = "remove"
+		err = p.client.Remove(r.Filepath)
+	default:
+		return errors.New("unsupported operation " + r.Method)
+	}
+	e.Error = err
+	p.emit(e)
+	return
+}
+
+func (p *sftpProxy) setstat(r *sftp.Request) (err error) {
+	flags := r.AttrFlags()
+	attrs := r.Attributes()
+	if flags.Size {
+		if err = p.client.Truncate(r.Filepath, int64(attrs.Size)); err != nil {
+			return
+		}
+	}
+	if flags.Permissions {
+		if err = p.client.Chmod(r.Filepath, attrs.FileMode()); err != nil {
+			return
+		}
+	}
+	if flags.UidGid {
+		if err = p.client.Chown(r.Filepath, int(attrs.UID), int(attrs.GID)); err != nil {
+			return
+		}
+	}
+	if flags.Acmodtime {
+		if err = p.client.Chtimes(r.Filepath, time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Filelist implements sftp.FileLister
+func (p *sftpProxy) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		fis, err := p.client.ReadDir(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return sftpListerAt(fis), nil
+	case "Stat":
+		fi, err := p.client.Stat(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return sftpListerAt{fi}, nil
+	case "Lstat":
+		fi, err := p.client.Lstat(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return sftpListerAt{fi}, nil
+	}
+	return nil, errors.New("unsupported operation " + r.Method)
+}
+
+// Readlink implements sftp.ReadlinkFileLister
+func (p *sftpProxy) Readlink(path string) (string, error) {
+	return p.client.ReadLink(path)
+}
+
+func sftpOpenFlags(pf sftp.FileOpenFlags) (flags int) {
+	if pf.Read && pf.Write {
+		flags = os.O_RDWR
+	} else if pf.Write || pf.Append {
+		flags = os.O_WRONLY
+	} else {
+		flags = os.O_RDONLY
+	}
+	// O_APPEND conflicts with WriteAt, offsets are always provided by client
+	if pf.Creat {
+		flags |= os.O_CREATE
+	}
+	if pf.Trunc {
+		flags |= os.O_TRUNC
+	}
+	if pf.Excl {
+		flags |= os.O_EXCL
+	}
+	return
+}
+
+type sftpListerAt []os.FileInfo
+
+// ListAt implements sftp.ListerAt
+func (l sftpListerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// sftpProxyFile a remote file counting transferred bytes, emits event on close
+type sftpProxyFile struct {
+	*sftp.File
+	op    string
+	path  string
+	bytes int64
+	proxy *sftpProxy
+}
+
+func (f *sftpProxyFile) ReadAt(b []byte, off int64) (n int, err error) {
+	n, err = f.File.ReadAt(b, off)
+	atomic.AddInt64(&f.bytes, int64(n))
+	return
+}
+
+func (f *sftpProxyFile) WriteAt(b []byte, off int64) (n int, err error) {
+	n, err = f.File.WriteAt(b, off)
+	atomic.AddInt64(&f.bytes, int64(n))
+	return
+}
+
+func (f *sftpProxyFile) Close() (err error) {
+	err = f.File.Close()
+	f.proxy.emit(SFTPEvent{Operation: f.op, Path: f.path, Bytes: atomic.LoadInt64(&f.bytes)})
+	return
+}
@@ -0,0 +1,49 @@
+/**
+ * utils/signal.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"syscall"
+)
+
+// sshSignals signal names defined in RFC 4254 6.10
+var sshSignals = map[string]syscall.Signal{
+	"ABRT": syscall.SIGABRT,
+	"ALRM": syscall.SIGALRM,
+	"FPE":  syscall.SIGFPE,
+	"HUP":  syscall.SIGHUP,
+	"ILL":  syscall.SIGILL,
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"PIPE": syscall.SIGPIPE,
+	"QUIT": syscall.SIGQUIT,
+	"SEGV": syscall.SIGSEGV,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// ExitCallback exit callback, with exit code and name of the signal which killed the process
+type ExitCallback func(code int, signal string)
+
+// ParseSSHSignal parse ssh signal name, "INT" -> SIGINT
+func ParseSSHSignal(name string) (sig syscall.Signal, ok bool) {
+	sig, ok = sshSignals[name]
+	return
+}
+
+// SSHSignalName ssh signal name of signal, SIGINT -> "INT", empty if not defined in RFC 4254
+func SSHSignalName(sig syscall.Signal) string {
+	for n, s := range sshSignals {
+		if s == sig {
+			return n
+		}
+	}
+	return ""
+}
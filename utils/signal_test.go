@@ -0,0 +1,26 @@
+/**
+ * utils/signal_test.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSSHSignal(t *testing.T) {
+	if s, ok := ParseSSHSignal("INT"); !ok || s != syscall.SIGINT {
+		t.Errorf("should parse INT")
+	}
+	if _, ok := ParseSSHSignal("SIGINT"); ok {
+		t.Errorf("should not parse SIGINT")
+	}
+	if SSHSignalName(syscall.SIGTERM) != "TERM" {
+		t.Errorf("invalid name for SIGTERM")
+	}
+}
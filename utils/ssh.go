@@ -14,10 +14,13 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"strings"
 	"sync"
+	"syscall"
 
+	"github.com/pkg/sftp"
 	"github.com/yankeguo/bunker/sandbox"
 	"golang.org/x/crypto/ssh"
 	"landzero.net/x/encoding/rec"
@@ -31,10 +34,18 @@ type DoneCallback func(bool)
 // CommandCallback command callback
 type CommandCallback func(string)
 
+// AgentCallback agent forwarding callback
+type AgentCallback func()
+
 // CheckSSHLocalIP check ssh local ip
 func CheckSSHLocalIP(conn ssh.ConnMetadata, ip string) bool {
+	return CheckLocalIP(conn.LocalAddr(), ip)
+}
+
+// CheckLocalIP check whether local address of a connection is ip
+func CheckLocalIP(addr net.Addr, ip string) bool {
 	hostIP := net.ParseIP(ip)
-	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+	if addr, ok := addr.(*net.TCPAddr); ok {
 		return addr.IP.Equal(hostIP)
 	}
 	return false
@@ -52,8 +63,30 @@ func shouldCommandBeRecorded(cmd []string) bool {
 
 // SSHConfigEntry ssh config entry
 type SSHConfigEntry struct {
-	Name    string
-	Address string
+	Name      string
+	Address   string
+	ProxyJump []string // host names of jump hosts, from the first hop to the last one
+}
+
+// parseProxyJump parse ProxyJump value to host names, user and port are ignored
+func parseProxyJump(v string) []string {
+	ret := []string{}
+	if strings.ToLower(strings.TrimSpace(v)) == "none" {
+		return ret
+	}
+	for _, h := range strings.Split(v, ",") {
+		h = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(h), "ssh://"))
+		if i := strings.LastIndex(h, "@"); i >= 0 {
+			h = h[i+1:]
+		}
+		if i := strings.LastIndex(h, ":"); i >= 0 {
+			h = h[:i]
+		}
+		if len(h) > 0 {
+			ret = append(ret, h)
+		}
+	}
+	return ret
 }
 
 // ParseSSHConfig parse ssh config
@@ -63,6 +96,7 @@ func ParseSSHConfig(c []byte) []SSHConfigEntry {
 	var host string
 	var hostname string
 	var port string
+	var proxyJump []string
 	var add = func() {
 		// already has a host recorded
 		if len(host) > 0 {
@@ -71,14 +105,16 @@ func ParseSSHConfig(c []byte) []SSHConfigEntry {
 					port = "22"
 				}
 				ret = append(ret, SSHConfigEntry{
-					Name:    host,
-					Address: fmt.Sprintf("%s:%s", hostname, port),
+					Name:      host,
+					Address:   fmt.Sprintf("%s:%s", hostname, port),
+					ProxyJump: proxyJump,
 				})
 			}
 		}
 		host = ""
 		hostname = ""
 		port = ""
+		proxyJump = nil
 	}
 	for _, l := range ls {
 		if l[0] == "host" {
@@ -91,6 +127,9 @@ func ParseSSHConfig(c []byte) []SSHConfigEntry {
 		if l[0] == "port" {
 			port = l[1]
 		}
+		if l[0] == "proxyjump" {
+			proxyJump = parseProxyJump(l[1])
+		}
 	}
 	add()
 	return ret
@@ -215,31 +254,112 @@ func SSHDModifyCommand(user string, input string) string {
 	return shellquote.Join("sudo", "-S", "-n", "-u", user, "-i")
 }
 
+const (
+	sshForwardNone = iota // channel closed before "exec", "shell" or "subsystem"
+	sshForwardRaw         // forward stdin, stdout and stderr as is
+	sshForwardSFTP        // terminate sftp on source channel, proxy to sftp-server on target
+)
+
 // SSHForwarder forward two ssh connection
 type SSHForwarder struct {
-	schn  ssh.Channel
-	sreq  <-chan *ssh.Request
-	tchn  ssh.Channel
-	treq  <-chan *ssh.Request
-	tuser string
+	schn      ssh.Channel
+	sreq      <-chan *ssh.Request
+	tchn      ssh.Channel
+	treq      <-chan *ssh.Request
+	tuser     string
+	direct    bool // logged in as target user, no sudo needed
+	pty       *sandbox.Pty
+	rw        rec.Writer
+	dcb       DoneCallback
+	ccb       CommandCallback
+	scb       SFTPCallback
+	ecb       ExitCallback
+	mode      int
+	modeOnce  sync.Once
+	modeReady chan bool
 }
 
 // NewSSHForwarder new ssh forwarder
-func NewSSHForwarder(schn ssh.Channel, sreq <-chan *ssh.Request, tchn ssh.Channel, treq <-chan *ssh.Request, tuser string) *SSHForwarder {
+func NewSSHForwarder(schn ssh.Channel, sreq <-chan *ssh.Request, tchn ssh.Channel, treq <-chan *ssh.Request, tuser string, rw rec.Writer) *SSHForwarder {
 	return &SSHForwarder{
-		schn:  schn,
-		sreq:  sreq,
-		tchn:  tchn,
-		treq:  treq,
-		tuser: tuser,
+		schn:      schn,
+		sreq:      sreq,
+		tchn:      tchn,
+		treq:      treq,
+		tuser:     tuser,
+		rw:        rw,
+		modeReady: make(chan bool),
 	}
 }
 
+// SetSFTPCallback set sftp event callback
+func (f *SSHForwarder) SetSFTPCallback(scb SFTPCallback) *SSHForwarder {
+	f.scb = scb
+	return f
+}
+
+// SetDirect set direct mode, commands are not wrapped with sudo if target connection is logged in as target user
+func (f *SSHForwarder) SetDirect(direct bool) *SSHForwarder {
+	f.direct = direct
+	return f
+}
+
+// SetExitCallback set exit callback
+func (f *SSHForwarder) SetExitCallback(ecb ExitCallback) *SSHForwarder {
+	f.ecb = ecb
+	return f
+}
+
+// setMode decide forwarding mode, only the first call takes effect
+func (f *SSHForwarder) setMode(mode int) {
+	f.modeOnce.Do(func() {
+		f.mode = mode
+		close(f.modeReady)
+	})
+}
+
+// waitMode wait until forwarding mode decided
+func (f *SSHForwarder) waitMode() int {
+	<-f.modeReady
+	return f.mode
+}
+
+// SetDoneCallback set done callback
+func (f *SSHForwarder) SetDoneCallback(dcb DoneCallback) *SSHForwarder {
+	f.dcb = dcb
+	return f
+}
+
+// SetCommandCallback set command callback
+func (f *SSHForwarder) SetCommandCallback(ccb CommandCallback) *SSHForwarder {
+	f.ccb = ccb
+	return f
+}
+
 // Start start forwarding with sync.WaitGroup
 func (f *SSHForwarder) Start(gwg *sync.WaitGroup) {
-	gwg.Add(2)
-	go f.ForwardTarget(gwg)
-	go f.ForwardSource(gwg)
+	gwg.Add(1)
+	go f.Run(gwg)
+}
+
+// Run run forwarding on sync.WaitGroup
+func (f *SSHForwarder) Run(gwg *sync.WaitGroup) {
+	// ensure both directions are finished
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go f.ForwardTarget(wg)
+	go f.ForwardSource(wg)
+	wg.Wait()
+	// close replay writer
+	if f.rw.IsActivated() {
+		f.rw.Close()
+	}
+	// call done callback
+	if f.dcb != nil {
+		f.dcb(f.rw.IsActivated())
+	}
+	// done global WaitGroup
+	gwg.Done()
 }
 
 // ForwardTarget forward target connection to source connection
@@ -269,32 +389,111 @@ func (f *SSHForwarder) ForwardSource(gwg *sync.WaitGroup) {
 	f.tchn.Close()
 }
 
+func (f *SSHForwarder) startRecording(cmd string) {
+	// record command
+	if f.ccb != nil {
+		go f.ccb(cmd)
+	}
+	args, _ := shellquote.Split(cmd)
+	if !shouldCommandBeRecorded(args) {
+		// legacy scp is not replayable, record it as a file transfer
+		if f.scb != nil {
+			go f.scb(SFTPEvent{Operation: "scp", Path: cmd})
+		}
+		return
+	}
+	// activate the replay writer
+	f.rw.Activate()
+	// send initial window size
+	if f.pty != nil && f.pty.Window.Height > 0 && f.pty.Window.Width > 0 {
+		f.rw.WriteWindowSize(uint32(f.pty.Window.Width), uint32(f.pty.Window.Height))
+	}
+}
+
+func (f *SSHForwarder) forwardSFTPRequest(req *ssh.Request) {
+	var pl = struct{ Name string }{}
+	ssh.Unmarshal(req.Payload, &pl)
+	if pl.Name != "sftp" {
+		req.Reply(false, nil)
+		return
+	}
+	// run sftp-server as target user, instead of the "sftp" subsystem running as root
+	var epl = struct{ Value string }{
+		Value: SSHDModifySFTPCommand(f.tuser),
+	}
+	if f.direct {
+		epl.Value = sftpServerScript
+	}
+	ok, _ := f.tchn.SendRequest("exec", true, ssh.Marshal(&epl))
+	if ok {
+		if f.ccb != nil {
+			go f.ccb("sftp")
+		}
+		f.setMode(sshForwardSFTP)
+	}
+	req.Reply(ok, nil)
+}
+
 func (f *SSHForwarder) forwardSourceRequests(wg *sync.WaitGroup) {
 	defer wg.Done()
+	// release data forwarding if channel closed without "exec", "shell" or "subsystem"
+	defer f.setMode(sshForwardNone)
 	for req := range f.sreq {
+		// bunker terminates "subsystem" request, only "sftp" is supported
+		if req.Type == "subsystem" {
+			f.forwardSFTPRequest(req)
+			continue
+		}
 		// transform exec, shell request with targetUser
 		switch req.Type {
 		case "exec":
 			// transform "exec" with sudo prefix
 			var pl = struct{ Value string }{}
 			ssh.Unmarshal(req.Payload, &pl)
+			f.startRecording(pl.Value)
+			if f.direct {
+				break
+			}
 			pl.Value = SSHDModifyCommand(f.tuser, pl.Value)
 			req.Payload = ssh.Marshal(&pl)
 		case "shell":
+			if f.direct {
+				// login shell of target user
+				f.startRecording("")
+				break
+			}
 			// transform "shell" to "exec" with sudo prefix
 			var pl = struct{ Value string }{
 				Value: SSHDModifyCommand(f.tuser, ""),
 			}
+			f.startRecording("")
 			req.Type = "exec"
 			req.Payload = ssh.Marshal(&pl)
+		case "pty-req":
+			// remember pty for initial window size
+			if pty, ok := ParsePtyRequest(req.Payload); ok {
+				f.pty = &pty
+			}
+		case "window-change":
+			// notify window size
+			if w, ok := ParseWchanRequest(req.Payload); ok {
+				if f.pty != nil {
+					f.pty.Window = w
+				}
+				f.rw.WriteWindowSize(uint32(w.Width), uint32(w.Height))
+			}
 		}
-		// ban "x11-req", "subsystem" requests, cause they may escape from sudo
+		// ban "x11-req" requests, cause they may escape from sudo
 		switch req.Type {
-		case "x11-req", "subsystem":
+		case "x11-req":
 			req.Reply(false, nil)
 		default:
 			ok, _ := f.tchn.SendRequest(req.Type, req.WantReply, req.Payload)
 			req.Reply(ok, nil)
+			// start forwarding data after "exec", or "shell" in direct mode
+			if req.Type == "exec" || req.Type == "shell" {
+				f.setMode(sshForwardRaw)
+			}
 		}
 	}
 }
@@ -302,6 +501,30 @@ func (f *SSHForwarder) forwardSourceRequests(wg *sync.WaitGroup) {
 func (f *SSHForwarder) forwardTargetRequests(wg *sync.WaitGroup) {
 	defer wg.Done()
 	for req := range f.treq {
+		if f.ecb != nil {
+			switch req.Type {
+			case "exit-status":
+				var pl struct{ Status uint32 }
+				if ssh.Unmarshal(req.Payload, &pl) == nil {
+					go f.ecb(int(pl.Status), "")
+				}
+			case "exit-signal":
+				var pl struct {
+					Signal     string
+					CoreDumped bool
+					Error      string
+					Lang       string
+				}
+				if ssh.Unmarshal(req.Payload, &pl) == nil {
+					// shell style exit code for killed process
+					code := -1
+					if sig, ok := ParseSSHSignal(pl.Signal); ok {
+						code = 128 + int(sig)
+					}
+					go f.ecb(code, pl.Signal)
+				}
+			}
+		}
 		ok, _ := f.schn.SendRequest(req.Type, req.WantReply, req.Payload)
 		req.Reply(ok, nil)
 	}
@@ -309,19 +532,40 @@ func (f *SSHForwarder) forwardTargetRequests(wg *sync.WaitGroup) {
 
 func (f *SSHForwarder) forwardStdin(wg *sync.WaitGroup) {
 	defer wg.Done()
-	io.Copy(f.tchn, f.schn)
-	f.tchn.CloseWrite()
+	switch f.waitMode() {
+	case sshForwardRaw:
+		io.Copy(f.tchn, f.schn)
+		f.tchn.CloseWrite()
+	case sshForwardSFTP:
+		f.forwardSFTP()
+	}
+}
+
+func (f *SSHForwarder) forwardSFTP() {
+	client, err := sftp.NewClientPipe(f.tchn, f.tchn)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	ServeSFTPProxy(f.schn, client, f.scb)
 }
 
 func (f *SSHForwarder) forwardStdout(wg *sync.WaitGroup) {
 	defer wg.Done()
-	io.Copy(f.schn, f.tchn)
+	// stdout of target is consumed by sftp client in sftp mode
+	if f.waitMode() != sshForwardRaw {
+		return
+	}
+	io.Copy(io.MultiWriter(f.schn, ioext.NewSilentWriter(f.rw.Stdout())), f.tchn)
 	f.schn.CloseWrite()
 }
 
 func (f *SSHForwarder) forwardStderr(wg *sync.WaitGroup) {
 	defer wg.Done()
-	io.Copy(f.schn.Stderr(), f.tchn.Stderr())
+	if f.waitMode() == sshForwardNone {
+		return
+	}
+	io.Copy(io.MultiWriter(f.schn.Stderr(), ioext.NewSilentWriter(f.rw.Stderr())), f.tchn.Stderr())
 }
 
 // SandboxForwarder sandbox ssh forwarder
@@ -334,9 +578,16 @@ type SandboxForwarder struct {
 	pty       *sandbox.Pty
 	isHandled bool
 	wch       chan sandbox.Window
+	sch       chan syscall.Signal
 	rw        rec.Writer
 	dcb       DoneCallback
 	ccb       CommandCallback
+	ecb       ExitCallback
+	conn      ssh.Conn     // source connection, for opening agent channels
+	agentID   string       // agent socket id, empty if agent forwarding is not allowed
+	agentSock string       // agent socket path in sandbox
+	agentL    net.Listener // agent socket listener on host
+	acb       AgentCallback
 }
 
 // NewSandboxForwarder new sandbox forwarder
@@ -361,6 +612,25 @@ func (f *SandboxForwarder) SetCommandCallback(ccb CommandCallback) *SandboxForwa
 	return f
 }
 
+// SetExitCallback set exit callback
+func (f *SandboxForwarder) SetExitCallback(ecb ExitCallback) *SandboxForwarder {
+	f.ecb = ecb
+	return f
+}
+
+// SetAgentForwarding allow "auth-agent-req@openssh.com", agent socket is identified by id
+func (f *SandboxForwarder) SetAgentForwarding(conn ssh.Conn, id string) *SandboxForwarder {
+	f.conn = conn
+	f.agentID = id
+	return f
+}
+
+// SetAgentCallback set agent callback, invoked when agent forwarding is accepted
+func (f *SandboxForwarder) SetAgentCallback(acb AgentCallback) *SandboxForwarder {
+	f.acb = acb
+	return f
+}
+
 // Start start on sync.WaitGroup
 func (f *SandboxForwarder) Start(gwg *sync.WaitGroup) {
 	gwg.Add(1)
@@ -369,6 +639,9 @@ func (f *SandboxForwarder) Start(gwg *sync.WaitGroup) {
 
 // Run run on sync.WaitGroup
 func (f *SandboxForwarder) Run(gwg *sync.WaitGroup) {
+	// signals to exec process
+	f.sch = make(chan syscall.Signal, 1)
+	defer close(f.sch)
 	// range ssh requests
 	for req := range f.sreq {
 		switch req.Type {
@@ -385,6 +658,10 @@ func (f *SandboxForwarder) Run(gwg *sync.WaitGroup) {
 				var pl struct{ Value string }
 				ssh.Unmarshal(req.Payload, &pl)
 				f.cmd, _ = shellquote.Split(pl.Value)
+				// expose agent socket
+				if len(f.agentSock) > 0 {
+					f.env = append(f.env, fmt.Sprintf("SSH_AUTH_SOCK=%s", f.agentSock))
+				}
 				// start recording
 				if shouldCommandBeRecorded(f.cmd) {
 					// activate the replay writer
@@ -435,6 +712,40 @@ func (f *SandboxForwarder) Run(gwg *sync.WaitGroup) {
 				defer close(f.wch)
 				req.Reply(true, nil)
 			}
+		case "signal":
+			{
+				var pl struct{ Signal string }
+				ssh.Unmarshal(req.Payload, &pl)
+				sig, ok := ParseSSHSignal(pl.Signal)
+				if !f.isHandled || !ok {
+					req.Reply(false, nil)
+					continue
+				}
+				// never block on a exec not yet started or already finished
+				select {
+				case f.sch <- sig:
+					req.Reply(true, nil)
+				default:
+					req.Reply(false, nil)
+				}
+			}
+		case "auth-agent-req@openssh.com":
+			{
+				if f.isHandled || f.agentL != nil || f.conn == nil || len(f.agentID) == 0 {
+					req.Reply(false, nil)
+					continue
+				}
+				if err := f.listenAgent(); err != nil {
+					log.Println("Agent:", err)
+					req.Reply(false, nil)
+					continue
+				}
+				defer f.agentL.Close()
+				req.Reply(true, nil)
+				if f.acb != nil {
+					go f.acb()
+				}
+			}
 		case "window-change":
 			{
 				if f.pty == nil {
@@ -464,23 +775,77 @@ func (f *SandboxForwarder) Run(gwg *sync.WaitGroup) {
 	gwg.Done()
 }
 
+// listenAgent listen on agent socket, which is accessible in sandbox
+func (f *SandboxForwarder) listenAgent() (err error) {
+	if f.agentL, f.agentSock, err = f.sb.ListenAgent(f.agentID); err != nil {
+		return
+	}
+	go f.serveAgent(f.agentL)
+	return
+}
+
+func (f *SandboxForwarder) serveAgent(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go forwardAgentConn(f.conn, c)
+	}
+}
+
+// forwardAgentConn forward a agent socket connection to a "auth-agent@openssh.com" channel on source
+func forwardAgentConn(conn ssh.Conn, c net.Conn) {
+	defer c.Close()
+	chn, reqs, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		return
+	}
+	defer chn.Close()
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		io.Copy(chn, c)
+		chn.CloseWrite()
+	}()
+	io.Copy(c, chn)
+}
+
 func (f *SandboxForwarder) handle() {
 	var opts = sandbox.ExecAttachOptions{
-		Env:     f.env,
-		Command: f.cmd,
-		Stdin:   f.schn,
-		Stdout:  io.MultiWriter(f.schn, ioext.NewSilentWriter(f.rw.Stdout())),
-		Stderr:  io.MultiWriter(f.schn.Stderr(), ioext.NewSilentWriter(f.rw.Stderr())),
+		Env:        f.env,
+		Command:    f.cmd,
+		Stdin:      f.schn,
+		Stdout:     io.MultiWriter(f.schn, ioext.NewSilentWriter(f.rw.Stdout())),
+		Stderr:     io.MultiWriter(f.schn.Stderr(), ioext.NewSilentWriter(f.rw.Stderr())),
+		SignalChan: f.sch,
 	}
 	if f.pty != nil {
 		opts.IsPty = true
 		opts.Term = f.pty.Term
 		opts.WindowChan = f.wch
 	}
-	pl := make([]byte, 4)
-	if err := f.sb.ExecAttach(opts); err != nil {
-		binary.BigEndian.PutUint32(pl, 1)
+	var sig string
+	code, s, err := f.sb.ExecAttach(opts)
+	if err != nil {
+		code = 1
+	} else if s != 0 {
+		// signals not defined in RFC 4254 are reported as 128 + signal exit status
+		sig = SSHSignalName(s)
+	}
+	if len(sig) > 0 {
+		f.schn.SendRequest("exit-signal", false, ssh.Marshal(&struct {
+			Signal     string
+			CoreDumped bool
+			Error      string
+			Lang       string
+		}{Signal: sig}))
+	} else {
+		pl := make([]byte, 4)
+		binary.BigEndian.PutUint32(pl, uint32(code))
+		f.schn.SendRequest("exit-status", false, pl)
+	}
+	if f.ecb != nil {
+		f.ecb(code, sig)
 	}
-	f.schn.SendRequest("exit-status", false, pl)
 	f.schn.Close()
 }
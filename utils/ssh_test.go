@@ -50,6 +50,19 @@ User root
 ServerAliveInterval 30
 `
 
+const tcftJump = `Host bastion
+Hostname 10.0.0.1
+
+Host inner
+Hostname 10.1.0.1
+ProxyJump bastion
+
+Host deep
+Hostname 10.2.0.1
+Port 2222
+ProxyJump root@bastion:22,inner
+`
+
 func TestParseSSHConfig(t *testing.T) {
 	ls := ParseSSHConfig([]byte(tcft))
 	if len(ls) != 6 {
@@ -62,3 +75,22 @@ func TestParseSSHConfig(t *testing.T) {
 		t.Errorf("invalid name")
 	}
 }
+
+func TestParseSSHConfigProxyJump(t *testing.T) {
+	ls := ParseSSHConfig([]byte(tcftJump))
+	if len(ls) != 3 {
+		t.Fatalf("invalid len %d", len(ls))
+	}
+	if len(ls[0].ProxyJump) != 0 {
+		t.Errorf("bastion should have no proxy jump")
+	}
+	if len(ls[1].ProxyJump) != 1 || ls[1].ProxyJump[0] != "bastion" {
+		t.Errorf("invalid proxy jump %v", ls[1].ProxyJump)
+	}
+	if ls[2].Address != "10.2.0.1:2222" || len(ls[2].ProxyJump) != 2 || ls[2].ProxyJump[0] != "bastion" || ls[2].ProxyJump[1] != "inner" {
+		t.Errorf("invalid entry %v", ls[2])
+	}
+	if len(parseProxyJump("none")) != 0 {
+		t.Errorf("none should be empty")
+	}
+}
@@ -0,0 +1,84 @@
+/**
+ * utils/totp.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTPPeriod time step of totp in seconds
+const TOTPPeriod = 30
+
+// TOTPDigits number of digits of totp code
+const TOTPDigits = 6
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret generate a random base32 encoded totp secret
+func NewTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// TOTPCounter time step counter of t
+func TOTPCounter(t time.Time) int64 {
+	return t.Unix() / TOTPPeriod
+}
+
+// HOTP compute hotp code of base32 secret and counter, RFC 4226
+func HOTP(secret string, counter int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	h := hmac.New(sha1.New, key)
+	h.Write(msg)
+	sum := h.Sum(nil)
+	o := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[o:o+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTPDigits, code%1000000), nil
+}
+
+// ValidateTOTP validate totp code at t, allowing one step of clock skew, returns matched counter
+func ValidateTOTP(secret string, code string, t time.Time) (counter int64, ok bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return
+	}
+	c := TOTPCounter(t)
+	for _, i := range []int64{c, c - 1, c + 1} {
+		if exp, err := HOTP(secret, i); err == nil && hmac.Equal([]byte(exp), []byte(code)) {
+			return i, true
+		}
+	}
+	return
+}
+
+// TOTPURI otpauth uri for authenticator apps
+func TOTPURI(issuer string, account string, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("period", fmt.Sprintf("%d", TOTPPeriod))
+	v.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(issuer), url.PathEscape(account), v.Encode())
+}
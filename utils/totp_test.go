@@ -0,0 +1,53 @@
+/**
+ *Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ *This software is released under the MIT License.
+ *https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// secret of RFC 6238 test vectors, "12345678901234567890"
+var testTOTPSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestHOTP(t *testing.T) {
+	// RFC 6238 SHA1 vectors, last 6 digits
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for ts, exp := range cases {
+		out, err := HOTP(testTOTPSecret, ts/TOTPPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != exp {
+			t.Errorf("%d: expected %s, got %s", ts, exp, out)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	if c, ok := ValidateTOTP(testTOTPSecret, "081804", now); !ok || c != TOTPCounter(now) {
+		t.Error("should validate current code")
+	}
+	if _, ok := ValidateTOTP(testTOTPSecret, "081804", now.Add(time.Second*TOTPPeriod)); !ok {
+		t.Error("should allow one step of skew")
+	}
+	if _, ok := ValidateTOTP(testTOTPSecret, "081804", now.Add(time.Second*TOTPPeriod*3)); ok {
+		t.Error("should reject old code")
+	}
+	if _, ok := ValidateTOTP(testTOTPSecret, "81804", now); ok {
+		t.Error("should reject short code")
+	}
+}
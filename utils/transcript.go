@@ -0,0 +1,194 @@
+/**
+ * utils/transcript.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// transcriptMaxLineLength lines longer than this are truncated
+	transcriptMaxLineLength = 1024
+	// transcriptMaxFrameLength frames larger than this are treated as corrupted
+	transcriptMaxFrameLength = 16 * 1024 * 1024
+)
+
+// transcriptPromptPattern shell prompt like "root@db01:~# ", "[root@db01 ~]$ " or "(venv) user@host:/tmp$ ", the typed command is captured
+var transcriptPromptPattern = regexp.MustCompile(`^(?:\([^)]*\)\s*)?(?:\[[^\]]+\]|[\w.-]+@[\w.-]+(?::\S*)?)\s?[$#%>]\s+(\S.*?)\s*$`)
+
+// TranscriptLine a line of terminal output in replay
+type TranscriptLine struct {
+	Offset  time.Duration // offset since start of replay, when the line was finished
+	Text    string        // text with escape sequences stripped
+	Command string        // command typed after a shell prompt, empty if the line is not a prompt
+}
+
+// ReadReplayFrames read frames from a gzipped replay file
+func ReadReplayFrames(r io.Reader, fn func(ts time.Duration, typ byte, payload []byte)) (err error) {
+	var gr *gzip.Reader
+	if gr, err = gzip.NewReader(r); err != nil {
+		return
+	}
+	defer gr.Close()
+	br := bufio.NewReader(gr)
+	head := make([]byte, 9)
+	for {
+		if _, err = io.ReadFull(br, head); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		l := binary.BigEndian.Uint32(head[5:])
+		if l > transcriptMaxFrameLength {
+			return errors.New("invalid frame length in replay")
+		}
+		payload := make([]byte, l)
+		if _, err = io.ReadFull(br, payload); err != nil {
+			return
+		}
+		fn(time.Duration(binary.BigEndian.Uint32(head))*time.Millisecond, head[4], payload)
+	}
+}
+
+const (
+	transcriptStateText = iota
+	transcriptStateEscape
+	transcriptStateCSI
+	transcriptStateOSC
+	transcriptStateOSCEscape
+	transcriptStateCharset
+)
+
+// transcriptBuilder a minimal terminal, tracks the current line, escape sequences are dropped
+type transcriptBuilder struct {
+	state int
+	cur   []byte
+	cr    bool
+	lines []TranscriptLine
+}
+
+func (b *transcriptBuilder) endLine(ts time.Duration) {
+	text := strings.TrimSpace(strings.ToValidUTF8(string(b.cur), ""))
+	b.cur = b.cur[:0]
+	b.cr = false
+	if len(text) == 0 {
+		return
+	}
+	if len(text) > transcriptMaxLineLength {
+		text = strings.ToValidUTF8(text[:transcriptMaxLineLength], "")
+	}
+	l := TranscriptLine{Offset: ts, Text: text}
+	if m := transcriptPromptPattern.FindStringSubmatch(text); m != nil {
+		l.Command = m[1]
+	}
+	b.lines = append(b.lines, l)
+}
+
+func (b *transcriptBuilder) write(ts time.Duration, p []byte) {
+	for _, c := range p {
+		switch b.state {
+		case transcriptStateEscape:
+			switch c {
+			case '[':
+				b.state = transcriptStateCSI
+			case ']':
+				b.state = transcriptStateOSC
+			case '(', ')', '*', '+', '#', '%':
+				b.state = transcriptStateCharset
+			default:
+				b.state = transcriptStateText
+			}
+			continue
+		case transcriptStateCSI:
+			if c >= 0x40 && c <= 0x7e {
+				b.state = transcriptStateText
+			}
+			continue
+		case transcriptStateOSC:
+			if c == 0x07 {
+				b.state = transcriptStateText
+			} else if c == 0x1b {
+				b.state = transcriptStateOSCEscape
+			}
+			continue
+		case transcriptStateOSCEscape:
+			b.state = transcriptStateText
+			continue
+		case transcriptStateCharset:
+			b.state = transcriptStateText
+			continue
+		}
+		switch c {
+		case 0x1b:
+			b.state = transcriptStateEscape
+		case '\n':
+			b.endLine(ts)
+		case '\r':
+			b.cr = true
+		case '\b':
+			// remove last rune
+			if len(b.cur) > 0 {
+				_, n := utf8.DecodeLastRune(b.cur)
+				b.cur = b.cur[:len(b.cur)-n]
+			}
+		case '\t':
+			b.cur = append(b.cur, ' ')
+		default:
+			if c < 0x20 || c == 0x7f {
+				continue
+			}
+			// carriage return without line feed, the line is redrawn
+			if b.cr {
+				b.cur = b.cur[:0]
+				b.cr = false
+			}
+			if len(b.cur) < transcriptMaxLineLength*2 {
+				b.cur = append(b.cur, c)
+			}
+		}
+	}
+}
+
+// ExtractTranscript extract text lines and typed commands from a gzipped replay file
+func ExtractTranscript(r io.Reader, maxLines int) ([]TranscriptLine, error) {
+	b := &transcriptBuilder{}
+	var last time.Duration
+	err := ReadReplayFrames(r, func(ts time.Duration, typ byte, payload []byte) {
+		last = ts
+		if typ != LiveFrameStdout && typ != LiveFrameStderr {
+			return
+		}
+		if maxLines > 0 && len(b.lines) >= maxLines {
+			return
+		}
+		b.write(ts, payload)
+	})
+	if maxLines <= 0 || len(b.lines) < maxLines {
+		b.endLine(last)
+	}
+	return b.lines, err
+}
+
+// FTSPhrase quote user input as a sqlite full-text search phrase
+func FTSPhrase(q string) string {
+	q = strings.Join(strings.Fields(strings.Replace(q, `"`, " ", -1)), " ")
+	if len(q) == 0 {
+		return ""
+	}
+	return `"` + q + `"`
+}
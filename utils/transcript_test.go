@@ -0,0 +1,59 @@
+/**
+ *Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ *This software is released under the MIT License.
+ *https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+	"time"
+)
+
+func TestExtractTranscript(t *testing.T) {
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	gw.Write(EncodeLiveFrame(0, LiveFrameWindowSize, []byte{0, 0, 0, 80, 0, 0, 0, 24}))
+	gw.Write(EncodeLiveFrame(time.Millisecond*10, LiveFrameStdout, []byte("\x1b]0;root@db01: ~\x07\x1b[01;32mroot@db01\x1b[00m:~# ")))
+	gw.Write(EncodeLiveFrame(time.Millisecond*500, LiveFrameStdout, []byte("rm -rf /tmp/cache")))
+	gw.Write(EncodeLiveFrame(time.Millisecond*900, LiveFrameStdout, []byte("\r\n")))
+	gw.Write(EncodeLiveFrame(time.Millisecond*1000, LiveFrameStderr, []byte("rm: cannot remove\r\n\r\n")))
+	gw.Write(EncodeLiveFrame(time.Millisecond*1200, LiveFrameStdout, []byte("[root@db01 ~]$ lx\bs -al\r\n")))
+	gw.Write(EncodeLiveFrame(time.Millisecond*1300, LiveFrameStdout, []byte("50%\r100%")))
+	gw.Close()
+	ls, err := ExtractTranscript(bytes.NewReader(buf.Bytes()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != 4 {
+		t.Fatalf("invalid lines %v", ls)
+	}
+	if ls[0].Text != "root@db01:~# rm -rf /tmp/cache" || ls[0].Command != "rm -rf /tmp/cache" || ls[0].Offset != time.Millisecond*900 {
+		t.Errorf("invalid line 0 %v", ls[0])
+	}
+	if ls[1].Text != "rm: cannot remove" || len(ls[1].Command) != 0 {
+		t.Errorf("invalid line 1 %v", ls[1])
+	}
+	if ls[2].Command != "ls -al" {
+		t.Errorf("invalid line 2 %v", ls[2])
+	}
+	if ls[3].Text != "100%" {
+		t.Errorf("invalid line 3 %v", ls[3])
+	}
+	if ls, _ = ExtractTranscript(bytes.NewReader(buf.Bytes()), 2); len(ls) != 2 {
+		t.Errorf("max lines not respected %d", len(ls))
+	}
+}
+
+func TestFTSPhrase(t *testing.T) {
+	if FTSPhrase(` rm  "-rf" `) != `"rm -rf"` {
+		t.Error("invalid phrase", FTSPhrase(` rm  "-rf" `))
+	}
+	if FTSPhrase(`"`) != "" {
+		t.Error("empty phrase expected")
+	}
+}
@@ -0,0 +1,106 @@
+/**
+ * utils/tunnel.go
+ * Copyright (c) 2018 Yanke Guo <guoyk.cn@gmail.com>
+ *
+ * This software is released under the MIT License.
+ * https://opensource.org/licenses/MIT
+ */
+
+package utils
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DirectTCPIPPayload payload of "direct-tcpip" channel, RFC 4254 7.2
+type DirectTCPIPPayload struct {
+	Host     string
+	Port     uint32
+	OrigHost string
+	OrigPort uint32
+}
+
+// ForwardedTCPIPPayload payload of "forwarded-tcpip" channel, RFC 4254 7.2
+type ForwardedTCPIPPayload struct {
+	Addr     string
+	Port     uint32
+	OrigAddr string
+	OrigPort uint32
+}
+
+// TCPIPForwardPayload payload of "tcpip-forward" and "cancel-tcpip-forward" request, RFC 4254 7.1
+type TCPIPForwardPayload struct {
+	BindAddr string
+	BindPort uint32
+}
+
+// JoinHostPort join host and uint32 port
+func JoinHostPort(host string, port uint32) string {
+	return net.JoinHostPort(host, strconv.FormatUint(uint64(port), 10))
+}
+
+// TunnelDoneCallback tunnel done callback, with bytes from source and bytes to source
+type TunnelDoneCallback func(in int64, out int64)
+
+// TunnelForwarder forward a port forwarding channel, counting bytes in both directions
+type TunnelForwarder struct {
+	schn ssh.Channel
+	sreq <-chan *ssh.Request
+	tchn ssh.Channel
+	treq <-chan *ssh.Request
+	dcb  TunnelDoneCallback
+}
+
+// NewTunnelForwarder new tunnel forwarder
+func NewTunnelForwarder(schn ssh.Channel, sreq <-chan *ssh.Request, tchn ssh.Channel, treq <-chan *ssh.Request) *TunnelForwarder {
+	return &TunnelForwarder{
+		schn: schn,
+		sreq: sreq,
+		tchn: tchn,
+		treq: treq,
+	}
+}
+
+// SetDoneCallback set done callback
+func (f *TunnelForwarder) SetDoneCallback(dcb TunnelDoneCallback) *TunnelForwarder {
+	f.dcb = dcb
+	return f
+}
+
+// Start start the forwarder in a goroutine
+func (f *TunnelForwarder) Start(gwg *sync.WaitGroup) {
+	gwg.Add(1)
+	go f.Run(gwg)
+}
+
+// Run run the forwarder, blocking
+func (f *TunnelForwarder) Run(gwg *sync.WaitGroup) {
+	// there is no channel request defined for tunnels
+	go ssh.DiscardRequests(f.sreq)
+	go ssh.DiscardRequests(f.treq)
+	var in, out int64
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		in, _ = io.Copy(f.tchn, f.schn)
+		f.tchn.CloseWrite()
+		wg.Done()
+	}()
+	go func() {
+		out, _ = io.Copy(f.schn, f.tchn)
+		f.schn.CloseWrite()
+		wg.Done()
+	}()
+	wg.Wait()
+	f.schn.Close()
+	f.tchn.Close()
+	if f.dcb != nil {
+		f.dcb(in, out)
+	}
+	gwg.Done()
+}